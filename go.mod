@@ -1,3 +1,20 @@
 module github.com/likang/RepoArk
 
 go 1.22.2
+
+require (
+	filippo.io/age v1.2.1
+	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/pgzip v1.2.6
+	github.com/pierrec/lz4/v4 v4.1.22
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.28.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+)
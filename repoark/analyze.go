@@ -0,0 +1,274 @@
+package repoark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// analyzeSampleSize is the size of each of the up to three samples read from a file, at its
+	// start, middle and end, so that the entropy of large files is estimated without reading them
+	analyzeSampleSize = 16 << 10
+	// analyzeCompressLimit caps the sampled data compressed to predict the ratio of each level
+	analyzeCompressLimit = 8 << 20
+	// incompressibleEntropy is the entropy in bits per byte above which a file is counted as not
+	// compressible: already compressed or encrypted data is close to the maximum of 8
+	incompressibleEntropy = 7.5
+)
+
+// mediaExtensions are formats that are compressed already, reported as media
+var mediaExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".ogg": true, ".flac": true, ".m4a": true, ".aac": true, ".opus": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".webm": true, ".avi": true,
+	".pdf": true, ".woff": true, ".woff2": true,
+}
+
+// archiveExtensions are archive and compressed formats, reported as archives
+var archiveExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true,
+	".7z": true, ".rar": true, ".jar": true, ".whl": true, ".apk": true, ".docx": true, ".xlsx": true,
+}
+
+// analyzedFile is a file of the repository with the entropy of its samples
+type analyzedFile struct {
+	name    string
+	size    int64
+	entropy float64 // bits per byte
+}
+
+// compressibilityReport is what analyze found out about the files of a repository
+type compressibilityReport struct {
+	files          int
+	total          int64
+	sampled        int64
+	incompressible int64
+	kinds          map[string]int64 // incompressible bytes by kind: packfiles, media, archives, other
+	largest        []analyzedFile   // incompressible files, largest first
+	compressible   bytes.Buffer     // samples of the compressible files, compressed at each level
+}
+
+// analyzeRepo samples the files an archive of repoPath would contain and reports how much of it
+// does not compress, and the archive size to expect at each level of the given compressors
+func analyzeRepo(repoPath string, compressorNames []string, opts ArchiveOptions) error {
+	opts = opts.withDefaults()
+	repoPath, err := canonicalRoot(repoPath, opts.NoDereferenceRoot)
+	if err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	}
+	if info, err := os.Stat(repoPath); err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", repoPath)
+	}
+	if opts.VCS, err = detectVCS(repoPath); err != nil {
+		return err
+	}
+	var comps []compressor
+	for _, name := range compressorNames {
+		comp, err := compressorByName(name)
+		if err != nil {
+			return err
+		}
+		comps = append(comps, comp)
+	}
+
+	opts.FeatureLoss = &featureLoss{}
+	sources, err := collectSources([]RootDir{{Prefix: "", Dir: repoPath}}, opts)
+	if err != nil {
+		return err
+	}
+	report := &compressibilityReport{kinds: make(map[string]int64)}
+	for _, source := range sources {
+		if source.Symlink != "" {
+			continue
+		}
+		if err := report.sample(source); err != nil {
+			opts.Logger.Warnf("could not sample %s: %v", source.Name, err)
+		}
+	}
+	if report.files == 0 {
+		return fmt.Errorf("%s has no files to analyze", repoPath)
+	}
+	return report.print(comps)
+}
+
+// sample reads the samples of one file and adds it to the report
+func (r *compressibilityReport) sample(source archiveSource) error {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	offsets := []int64{0}
+	if size > 3*analyzeSampleSize {
+		offsets = append(offsets, size/2-analyzeSampleSize/2, size-analyzeSampleSize)
+	}
+	var data []byte
+	for _, offset := range offsets {
+		length := min(size-offset, int64(analyzeSampleSize))
+		if len(offsets) == 1 {
+			length = size
+		}
+		buf := make([]byte, length)
+		n, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		data = append(data, buf[:n]...)
+	}
+
+	r.files++
+	r.total += size
+	r.sampled += int64(len(data))
+	entropy := byteEntropy(data)
+	if entropy < incompressibleEntropy {
+		if r.compressible.Len() < analyzeCompressLimit {
+			r.compressible.Write(data)
+		}
+		return nil
+	}
+	r.incompressible += size
+	r.kinds[incompressibleKind(source.Name)] += size
+	r.largest = append(r.largest, analyzedFile{name: source.Name, size: size, entropy: entropy})
+	return nil
+}
+
+// byteEntropy returns the Shannon entropy of data in bits per byte, from 0 for a repeated byte
+// to 8 for random data
+func byteEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// incompressibleKind names the kind of an incompressible file by its path
+func incompressibleKind(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case strings.Contains(name, ".git/objects/pack/") || strings.Contains(name, ".git/lfs/"):
+		return "packfiles"
+	case mediaExtensions[ext]:
+		return "media"
+	case archiveExtensions[ext]:
+		return "archives"
+	}
+	return "other"
+}
+
+// print writes the report, predicting the size of the archive at the lowest, default and highest
+// level of each compressor from how well the samples of compressible files compress
+func (r *compressibilityReport) print(comps []compressor) error {
+	compressible := r.total - r.incompressible
+	fmt.Printf("Analyzed %d files, %s (sampled %s)\n", r.files, formatSize(r.total), formatSize(r.sampled))
+	fmt.Printf("Incompressible: %s (%.0f%%)", formatSize(r.incompressible), percent(r.incompressible, r.total))
+	var kinds []string
+	for _, kind := range []string{"packfiles", "media", "archives", "other"} {
+		if r.kinds[kind] > 0 {
+			kinds = append(kinds, fmt.Sprintf("%s %s", kind, formatSize(r.kinds[kind])))
+		}
+	}
+	if len(kinds) > 0 {
+		fmt.Printf(": %s", strings.Join(kinds, ", "))
+	}
+	fmt.Println()
+
+	sort.Slice(r.largest, func(i, j int) bool { return r.largest[i].size > r.largest[j].size })
+	if len(r.largest) > 0 {
+		fmt.Println("Largest incompressible files:")
+		for _, file := range r.largest[:min(len(r.largest), 5)] {
+			fmt.Printf("  %-50s %10s  entropy %.2f\n", file.name, formatSize(file.size), file.entropy)
+		}
+	}
+
+	sample := r.compressible.Bytes()
+	for _, comp := range comps {
+		fmt.Printf("Predicted archive size with %s:\n", comp.Name)
+		var smallest, largest int64
+		var levels []int
+		if comp.MaxLevel == 0 {
+			levels = []int{0}
+		} else {
+			levels = []int{comp.MinLevel, 0, comp.MaxLevel}
+		}
+		for _, level := range levels {
+			ratio, elapsed, err := compressionRatio(comp, level, sample)
+			if err != nil {
+				return err
+			}
+			// Incompressible files are stored at their size whatever the level
+			predicted := r.incompressible + int64(float64(compressible)*ratio)
+			if level == levels[0] {
+				largest = predicted
+			}
+			smallest = predicted
+			name := fmt.Sprintf("level %d", level)
+			if level == 0 {
+				name = "default"
+			}
+			speed := ""
+			if elapsed > 0 && len(sample) > 0 {
+				speed = fmt.Sprintf(", %s/s", formatSize(int64(float64(len(sample))/elapsed.Seconds())))
+			}
+			fmt.Printf("  %-9s %10s  ratio %.2f%s\n", name, formatSize(predicted), float64(r.total)/float64(max(predicted, 1)), speed)
+		}
+		if len(levels) > 1 && largest > 0 && percent(largest-smallest, largest) < 5 {
+			fmt.Printf("  level %d saves only %.1f%% over level %d, the higher level costs CPU for little gain\n",
+				comp.MaxLevel, percent(largest-smallest, largest), comp.MinLevel)
+		}
+	}
+	return nil
+}
+
+// compressionRatio compresses sample with comp at level and returns the compressed size relative
+// to the original, and how long compressing it took
+func compressionRatio(comp compressor, level int, sample []byte) (float64, time.Duration, error) {
+	if len(sample) == 0 {
+		return 1, 0, nil
+	}
+	counter := &countingWriter{w: io.Discard}
+	start := time.Now()
+	writer, err := comp.NewWriter(counter, level)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := writer.Write(sample); err != nil {
+		return 0, 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, 0, err
+	}
+	return float64(counter.Count()) / float64(len(sample)), time.Since(start), nil
+}
+
+// percent returns part as a percentage of total
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
@@ -0,0 +1,104 @@
+package repoark
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// annexObjectsDir is where git-annex keeps the content its work tree symlinks point to
+const annexObjectsDir = ".git/annex/objects/"
+
+// isAnnexRepo reports whether dir is a git-annex repository
+func isAnnexRepo(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, ".git", "annex"))
+	return err == nil && info.IsDir()
+}
+
+// annexLinkTarget returns the target of path if it is a git-annex symlink into the object store
+func annexLinkTarget(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := os.Readlink(path)
+	if err != nil || !strings.Contains(filepath.ToSlash(target), annexObjectsDir) {
+		return "", false
+	}
+	return target, true
+}
+
+// addSymlinkToArchive stores sourcePath as a symlink entry pointing to target
+func addSymlinkToArchive(writer entryWriter, sourcePath, archivePath, target string, opts ArchiveOptions) error {
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return &unreadableError{Err: err}
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     archivePath,
+		Linkname: filepath.ToSlash(target),
+		Mode:     0777,
+		ModTime:  info.ModTime(),
+	}
+	recordOwner(header, info, opts.NumericOwner)
+	opts.Annotations.annotate(header)
+	if opts.Xattrs {
+		if err := recordXattrs(header, sourcePath); err != nil {
+			return err
+		}
+	}
+	if opts.Reproducible {
+		makeReproducible(header, opts.SourceDateEpoch)
+	}
+	opts.Progress.Entry("add", archivePath, 0)
+	return writer.WriteEntry(header, nil)
+}
+
+// restoreSymlink creates the symlink entry at targetPath. Links must stay inside the repository,
+// unless the archive is trusted, so that later entries cannot be written elsewhere through them.
+func restoreSymlink(targetPath string, header *tar.Header, guard *restoreGuard, opts RestoreOptions, stats *restoreStats) error {
+	// An identical symlink already in place is left alone, whatever it points to
+	if current, err := os.Readlink(targetPath); err == nil && current == filepath.FromSlash(header.Linkname) {
+		opts.Progress.Entry("skip", targetPath, 0)
+		stats.Skipped++
+		return restoreOwner(targetPath, header, opts)
+	}
+	if guard.leavesRoot(header.Name, header.Linkname) {
+		return fmt.Errorf("refusing symlink %s pointing outside the repository: %s, use --trust-archive to restore it anyway", header.Name, header.Linkname)
+	}
+	if _, err := os.Lstat(targetPath); err == nil {
+		if err := removeExistingPath(targetPath); err != nil {
+			return err
+		}
+	}
+	if err := ensureParentDir(targetPath); err != nil {
+		return err
+	}
+	opts.Progress.Entry("restore", targetPath, 0)
+	if err := os.Symlink(filepath.FromSlash(header.Linkname), targetPath); err != nil {
+		return fmt.Errorf("error creating symlink: %v", explainPathError(targetPath, err))
+	}
+	stats.Restored++
+	restoreXattrs(targetPath, header, opts, stats)
+	return restoreOwner(targetPath, header, opts)
+}
+
+// checkAnnexContent reports annexed files of a restored repository whose content is not present.
+// git-annex does not keep every file's content in every repository, so these are not errors.
+func checkAnnexContent(repoPath string, links []string, opts RestoreOptions) {
+	var missing []string
+	for _, link := range links {
+		if _, err := os.Stat(filepath.Join(repoPath, link)); err != nil {
+			missing = append(missing, link)
+		}
+	}
+	if len(missing) == 0 {
+		opts.Logger.Infof("Content of all %d annexed files is present", len(links))
+		return
+	}
+	opts.Logger.Warnf("%d of %d annexed files have no content in this archive (e.g. %s), `git annex get` can fetch them from other remotes",
+		len(missing), len(links), missing[0])
+}
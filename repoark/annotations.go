@@ -0,0 +1,166 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// annotationsFile assigns annotations to the files of a repository, read from its root
+const annotationsFile = ".repoarkattributes"
+
+// paxAnnotationPrefix namespaces annotations among the PAX records of an entry
+const paxAnnotationPrefix = "REPOARK.annotation."
+
+// annotationKey restricts keys to what survives as a PAX record name and on a command line
+var annotationKey = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// annotationRule sets (or with an empty value, unsets) annotations on the entries matching Patterns
+type annotationRule struct {
+	Patterns pathPatterns
+	Values   map[string]string
+}
+
+// annotationRules are the lines of a .repoarkattributes file, later lines win
+type annotationRules []annotationRule
+
+// loadAnnotationRules reads the .repoarkattributes file of repoPath; a missing file has no rules.
+// Each line is a path glob as for --path followed by key=value pairs, or !key to unset one:
+//
+//	secrets/**  classification=confidential retention=7y
+//	secrets/README.md  !classification
+func loadAnnotationRules(repoPath string) (annotationRules, error) {
+	path := filepath.Join(repoPath, annotationsFile)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", annotationsFile, err)
+	}
+	defer file.Close()
+
+	var rules annotationRules
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected a path pattern followed by key=value annotations", annotationsFile, lineNo)
+		}
+		rule := annotationRule{Values: make(map[string]string)}
+		if err := rule.Patterns.Set(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", annotationsFile, lineNo, err)
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				if key, ok = strings.CutPrefix(field, "!"); !ok {
+					return nil, fmt.Errorf("%s:%d: expected key=value or !key, got %s", annotationsFile, lineNo, field)
+				}
+			} else if value == "" {
+				return nil, fmt.Errorf("%s:%d: empty value for %s, use !%s to unset it", annotationsFile, lineNo, key, key)
+			}
+			if !annotationKey.MatchString(key) {
+				return nil, fmt.Errorf("%s:%d: invalid annotation key %q", annotationsFile, lineNo, key)
+			}
+			rule.Values[key] = value
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", annotationsFile, err)
+	}
+	return rules, nil
+}
+
+// forPath returns the annotations of the archive entry called name, nil when it has none
+func (r annotationRules) forPath(name string) map[string]string {
+	var values map[string]string
+	for _, rule := range r {
+		if !rule.Patterns.matches(name) {
+			continue
+		}
+		for key, value := range rule.Values {
+			if value == "" {
+				delete(values, key)
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// annotate stores the annotations of the entry as PAX records of its header
+func (r annotationRules) annotate(header *tar.Header) {
+	for key, value := range r.forPath(header.Name) {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords[paxAnnotationPrefix+key] = value
+	}
+}
+
+// entryAnnotations returns the annotations recorded in an archive entry, nil when it has none
+func entryAnnotations(header *tar.Header) map[string]string {
+	var values map[string]string
+	for record, value := range header.PAXRecords {
+		if key, ok := strings.CutPrefix(record, paxAnnotationPrefix); ok {
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// formatAnnotations renders annotations as sorted key=value pairs separated by commas
+func formatAnnotations(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for _, key := range sortedKeys(values) {
+		pairs = append(pairs, key+"="+values[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// annotationFilter is a repeatable command-line flag selecting entries by annotation:
+// key=value requires that value, key alone any value. Every filter has to match.
+type annotationFilter []string
+
+func (f *annotationFilter) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *annotationFilter) Set(value string) error {
+	key, _, _ := strings.Cut(value, "=")
+	if !annotationKey.MatchString(key) {
+		return fmt.Errorf("invalid annotation filter %s, expected key or key=value", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// matches reports whether an entry with the given annotations is selected; no filters select everything
+func (f annotationFilter) matches(values map[string]string) bool {
+	for _, filter := range f {
+		key, want, hasValue := strings.Cut(filter, "=")
+		got, ok := values[key]
+		if !ok || (hasValue && got != want) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// maxEntrySize bounds header sizes; anything larger is treated as a corrupt header
+const maxEntrySize = 1 << 44 // 16 TiB
+
+// archiveError identifies the entry and the offset in the tar stream where reading an archive failed
+type archiveError struct {
+	Entry  string // entry name, empty when the header itself could not be read
+	Offset int64  // offset of the entry header in the uncompressed tar stream
+	Err    error
+}
+
+func (e *archiveError) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("malformed archive at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("malformed archive entry %q at offset %d: %v", e.Entry, e.Offset, e.Err)
+}
+
+func (e *archiveError) Unwrap() error {
+	return e.Err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	// Atomic, progress reporting reads the count from another goroutine
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// archiveReader wraps tar.Reader with header validation and positioned errors
+type archiveReader struct {
+	Compressor compressor // compression detected by openArchive
+	InputSize  int64      // size of the archive file(s), 0 when reading from stdin
+	input      *countingReader
+	tr         *tar.Reader
+	counter    *countingReader
+	header     *tar.Header
+	offset     int64
+	closers    []io.Closer
+	started    bool           // the first entry was read
+	legacy     *legacyArchive // set for archives written by older versions
+}
+
+func newArchiveReader(r io.Reader) *archiveReader {
+	counter := &countingReader{r: r}
+	return &archiveReader{tr: tar.NewReader(counter), counter: counter}
+}
+
+// openArchive opens a tar archive file, detecting its compression from the header
+// and falling back to the file extension. decompressCmd overrides the detection.
+// Encrypted archives are decrypted with the identity named by REPOARK_AGE_IDENTITY, or the
+// password from REPOARK_PASSWORD or the terminal.
+func openArchive(archiveName, decompressCmd string) (*archiveReader, error) {
+	return openDecryptedArchive(archiveName, decompressCmd, nil)
+}
+
+// openDecryptedArchive is openArchive decrypting with the given identity files when there are any
+func openDecryptedArchive(archiveName, decompressCmd string, identityFiles []string) (*archiveReader, error) {
+	var archiveFile io.ReadCloser
+	if archiveName == "-" {
+		// The archive is read strictly front to back, so a pipe works as well as a file
+		if isTerminal(os.Stdin) {
+			return nil, fmt.Errorf("refusing to read the archive from a terminal, pipe it into stdin")
+		}
+		archiveFile = io.NopCloser(os.Stdin)
+	} else {
+		volumes, err := openVolumes(archiveName)
+		if err != nil {
+			return nil, err
+		}
+		archiveFile = volumes
+	}
+	input := &countingReader{r: archiveFile}
+
+	archiveStream := bufio.NewReader(input)
+	if head, _ := archiveStream.Peek(len(ageArmorHeader)); isAgeEncrypted(head) {
+		var err error
+		if archiveStream, err = decryptArchiveStream(archiveStream, archiveName, identityFiles); err != nil {
+			archiveFile.Close()
+			return nil, err
+		}
+	}
+	head, _ := archiveStream.Peek(tarMagicOffset + 5)
+	comp, detected := detectCompressor(head)
+	if !detected {
+		if isZipArchive(head) {
+			archiveFile.Close()
+			return nil, fmt.Errorf("%s is a zip archive, extract it with an unzip tool", archiveName)
+		}
+		comp = compressorForPath(strings.TrimSuffix(archiveBaseName(archiveName), ageExtension))
+	}
+	if decompressCmd != "" {
+		var err error
+		if comp, err = commandCompressor("", decompressCmd); err != nil {
+			archiveFile.Close()
+			return nil, err
+		}
+	}
+
+	var compReader io.ReadCloser
+	var err error
+	if comp.NewParallelReader != nil {
+		compReader, err = comp.NewParallelReader(archiveStream, runtime.NumCPU())
+	} else {
+		compReader, err = comp.NewReader(archiveStream)
+	}
+	if err != nil {
+		archiveFile.Close()
+		return nil, fmt.Errorf("error creating %s reader: %v", comp.Name, err)
+	}
+
+	ar := newArchiveReader(compReader)
+	ar.Compressor = comp
+	ar.input = input
+	if archiveName != "-" {
+		for _, volume := range archiveVolumes(archiveName) {
+			if info, err := os.Stat(volume); err == nil {
+				ar.InputSize += info.Size()
+			}
+		}
+	}
+	ar.closers = []io.Closer{compReader, archiveFile}
+	return ar, nil
+}
+
+// InputPosition returns how many bytes of the (compressed) archive have been read
+func (ar *archiveReader) InputPosition() int64 {
+	return atomic.LoadInt64(&ar.input.n)
+}
+
+// Close releases the decompressor and the archive file
+func (ar *archiveReader) Close() error {
+	for _, c := range ar.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// Next advances to the next entry, returning io.EOF at the end of the archive
+func (ar *archiveReader) Next() (*tar.Header, error) {
+	ar.offset = ar.counter.n
+	header, err := ar.tr.Next()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &archiveError{Offset: ar.offset, Err: err}
+	}
+	if err := validateHeader(header); err != nil {
+		return nil, &archiveError{Entry: strings.ToValidUTF8(header.Name, "?"), Offset: ar.offset, Err: err}
+	}
+	if !ar.started {
+		ar.started = true
+		if !isCurrentFormat(header) {
+			ar.legacy = newLegacyArchive()
+		}
+	}
+	if ar.legacy != nil {
+		ar.legacy.fix(header)
+	}
+	ar.header = header
+	return header, nil
+}
+
+// Read reads the content of the current entry
+func (ar *archiveReader) Read(p []byte) (int, error) {
+	n, err := ar.tr.Read(p)
+	if err != nil && err != io.EOF {
+		err = ar.errorf(err)
+	}
+	return n, err
+}
+
+// errorf attributes err to the current entry
+func (ar *archiveReader) errorf(err error) error {
+	var archiveErr *archiveError
+	if errors.As(err, &archiveErr) {
+		return err
+	}
+	name := ""
+	if ar.header != nil {
+		name = ar.header.Name
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = errors.New("archive is truncated")
+	}
+	return &archiveError{Entry: name, Offset: ar.offset, Err: err}
+}
+
+// validateHeader rejects header fields that no archive written by repoark can contain
+func validateHeader(header *tar.Header) error {
+	switch {
+	case header.Name == "":
+		return errors.New("empty entry name")
+	case strings.ContainsRune(header.Name, 0):
+		return errors.New("entry name contains a NUL byte")
+	case header.Size < 0:
+		return fmt.Errorf("negative size %d", header.Size)
+	case header.Size > maxEntrySize:
+		return fmt.Errorf("size %d exceeds the supported maximum", header.Size)
+	case header.Mode < 0 || header.Mode > 1<<32-1:
+		return fmt.Errorf("invalid mode %o", header.Mode)
+	case header.ModTime.Year() < 1 || header.ModTime.Year() > 9999:
+		return fmt.Errorf("invalid modification time %v", header.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntry is a regular file of a tar stream built by buildTar
+type testEntry struct {
+	Name    string
+	Content string
+}
+
+// buildTar returns an uncompressed tar stream holding entries
+func buildTar(t testing.TB, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Content)), ModTime: time.Unix(1700000000, 0), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAllEntries reads every entry of ar, returning the names read and the first error other than io.EOF
+func readAllEntries(ar *archiveReader) ([]string, error) {
+	var names []string
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, header.Name)
+		if _, err := io.Copy(io.Discard, ar); err != nil {
+			return names, err
+		}
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	valid := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		header  tar.Header
+		wantErr string
+	}{
+		{"regular file", tar.Header{Name: "a.txt", Size: 3, Mode: 0644, ModTime: valid}, ""},
+		{"non-UTF-8 name", tar.Header{Name: "caf\xe9.txt", Mode: 0644, ModTime: valid}, ""},
+		{"empty name", tar.Header{Name: "", Mode: 0644, ModTime: valid}, "empty entry name"},
+		{"NUL in name", tar.Header{Name: "a\x00b", Mode: 0644, ModTime: valid}, "NUL byte"},
+		{"negative size", tar.Header{Name: "a", Size: -1, Mode: 0644, ModTime: valid}, "negative size"},
+		{"huge size", tar.Header{Name: "a", Size: maxEntrySize + 1, Mode: 0644, ModTime: valid}, "exceeds the supported maximum"},
+		{"negative mode", tar.Header{Name: "a", Mode: -1, ModTime: valid}, "invalid mode"},
+		{"huge mode", tar.Header{Name: "a", Mode: 1 << 33, ModTime: valid}, "invalid mode"},
+		{"year zero", tar.Header{Name: "a", Mode: 0644, ModTime: time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)}, "invalid modification time"},
+		{"year 10000", tar.Header{Name: "a", Mode: 0644, ModTime: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}, "invalid modification time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHeader(&tt.header)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateHeader() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateHeader() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArchiveReaderNonUTF8Name(t *testing.T) {
+	data := buildTar(t, testEntry{"caf\xe9.txt", "x"}, testEntry{"b.txt", "y"})
+	names, err := readAllEntries(newArchiveReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	if len(names) != 2 || names[0] != "caf\xe9.txt" {
+		t.Fatalf("names = %q, want the non-UTF-8 name unchanged", names)
+	}
+}
+
+func TestArchiveReaderErrors(t *testing.T) {
+	data := buildTar(t, testEntry{"a.txt", strings.Repeat("a", 1024)}, testEntry{"b.txt", strings.Repeat("b", 1024)})
+	negative := buildTar(t, testEntry{"a.txt", "a"})
+	// The size field of the first header, base-256 encoded -1
+	copy(negative[124:136], bytes.Repeat([]byte{0xff}, 12))
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantEntry string
+		wantErr   string
+	}{
+		{"truncated content", data[:1024], "a.txt", "truncated"},
+		{"truncated header", data[:2048+100], "", ""},
+		{"negative size", negative, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readAllEntries(newArchiveReader(bytes.NewReader(tt.data)))
+			var archiveErr *archiveError
+			if !errors.As(err, &archiveErr) {
+				t.Fatalf("error = %v, want an archiveError", err)
+			}
+			if tt.wantEntry != "" && archiveErr.Entry != tt.wantEntry {
+				t.Errorf("entry = %q, want %q", archiveErr.Entry, tt.wantEntry)
+			}
+			if tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// FuzzArchiveReader feeds arbitrary tar streams to the reader, which must fail with an
+// archiveError rather than panic
+func FuzzArchiveReader(f *testing.F) {
+	valid := buildTar(f, testEntry{"a.txt", "hello"}, testEntry{".git/HEAD", "ref: refs/heads/main\n"})
+	f.Add(valid)
+	f.Add(valid[:700])
+	f.Add(buildTar(f, testEntry{strings.Repeat("d/", 200) + "deep.txt", "x"}))
+	f.Add(buildTar(f, testEntry{"caf\xe9.txt", "x"}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := readAllEntries(newArchiveReader(bytes.NewReader(data)))
+		var archiveErr *archiveError
+		if err != nil && !errors.As(err, &archiveErr) {
+			t.Fatalf("error %v (%T) is not an archiveError", err, err)
+		}
+	})
+}
+
+// FuzzOpenArchive feeds arbitrary archive files, compressed or not, through compression
+// detection and decompression
+func FuzzOpenArchive(f *testing.F) {
+	valid := buildTar(f, testEntry{"a.txt", "hello"})
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(valid)
+	zw.Close()
+	f.Add(valid)
+	f.Add(gz.Bytes())
+	f.Add(gz.Bytes()[:gz.Len()/2])
+	f.Add([]byte("\x28\xb5\x2f\xfd garbage"))
+	f.Add([]byte("\xfd7zXZ\x00 garbage"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if isAgeEncrypted(data) {
+			t.Skip("decrypting asks for a password")
+		}
+		path := filepath.Join(t.TempDir(), "archive.tar")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ar, err := openArchive(path, "")
+		if err != nil {
+			return
+		}
+		defer ar.Close()
+		_, err = readAllEntries(ar)
+		var archiveErr *archiveError
+		if err != nil && !errors.As(err, &archiveErr) {
+			t.Fatalf("error %v (%T) is not an archiveError", err, err)
+		}
+	})
+}
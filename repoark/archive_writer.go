@@ -0,0 +1,108 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// entryWriter is implemented by every output archive format. Entries are described
+// with tar headers, formats that cannot represent a field simply ignore it.
+type entryWriter interface {
+	// WriteEntry adds an entry, reading the content of regular files from r
+	WriteEntry(header *tar.Header, r io.Reader) error
+	Close() error
+}
+
+// tarEntryWriter writes entries to a (possibly compressed) tar stream
+type tarEntryWriter struct {
+	tw *tar.Writer
+}
+
+func newTarEntryWriter(w io.Writer) *tarEntryWriter {
+	return &tarEntryWriter{tw: tar.NewWriter(w)}
+}
+
+func (w *tarEntryWriter) WriteEntry(header *tar.Header, r io.Reader) error {
+	header.Name = filepath.ToSlash(header.Name)
+	// PAX lifts the ustar limits of 8GB per file and 256 bytes per path, and keeps sub-second mtimes
+	header.Format = tar.FormatPAX
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarEntryWriter) Close() error {
+	return w.tw.Close()
+}
+
+// zipEntryWriter writes entries to a deflate compressed zip file
+type zipEntryWriter struct {
+	zw *zip.Writer
+}
+
+func newZipEntryWriter(w io.Writer, level int) *zipEntryWriter {
+	zw := zip.NewWriter(w)
+	if level != 0 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return &zipEntryWriter{zw: zw}
+}
+
+func (w *zipEntryWriter) WriteEntry(header *tar.Header, r io.Reader) error {
+	fileHeader := &zip.FileHeader{
+		Name:     filepath.ToSlash(header.Name),
+		Method:   zip.Deflate,
+		Modified: header.ModTime,
+	}
+	fileHeader.SetMode(header.FileInfo().Mode())
+	if header.Typeflag == tar.TypeSymlink {
+		// Info-ZIP stores the link target as the content of a symlink entry
+		fileHeader.SetMode(os.ModeSymlink | 0777)
+		r = strings.NewReader(header.Linkname)
+	}
+	entry, err := w.zw.CreateHeader(fileHeader)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+func (w *zipEntryWriter) Close() error {
+	return w.zw.Close()
+}
+
+// archiveFormats lists the container formats selectable with --format
+var archiveFormats = []string{"tar", "zip"}
+
+// resolveFormat honours an explicit --format value and falls back to the output extension
+func resolveFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.HasSuffix(strings.ToLower(path), ".zip") {
+			return "zip", nil
+		}
+		return "tar", nil
+	}
+	for _, f := range archiveFormats {
+		if f == format {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format: %s", format)
+}
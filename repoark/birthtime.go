@@ -0,0 +1,77 @@
+package repoark
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paxCreationTime is the PAX record libarchive (bsdtar) uses for file creation times
+const paxCreationTime = "LIBARCHIVE.creationtime"
+
+// errBirthTimeUnsupported is returned where creation times cannot be set
+var errBirthTimeUnsupported = errors.New("creation times are not supported on this platform")
+
+// recordBirthTime stores the creation time of sourcePath in the header when the platform reports one
+func recordBirthTime(header *tar.Header, sourcePath string) {
+	birthTime, ok := fileBirthTime(sourcePath)
+	if !ok {
+		return
+	}
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string)
+	}
+	header.PAXRecords[paxCreationTime] = formatPAXTime(birthTime)
+}
+
+// restoreBirthTime applies the creation time recorded in the header, if any
+func restoreBirthTime(targetPath string, header *tar.Header) error {
+	value, ok := header.PAXRecords[paxCreationTime]
+	if !ok {
+		return nil
+	}
+	birthTime, err := parsePAXTime(value)
+	if err != nil {
+		return fmt.Errorf("invalid creation time for %s: %v", header.Name, err)
+	}
+	if err := setFileBirthTime(targetPath, birthTime); err != nil && err != errBirthTimeUnsupported {
+		return fmt.Errorf("error setting file creation time: %v", err)
+	}
+	return nil
+}
+
+// formatPAXTime renders t as decimal seconds, the way PAX time records are written
+func formatPAXTime(t time.Time) string {
+	seconds, nanos := t.Unix(), t.Nanosecond()
+	if nanos == 0 {
+		return strconv.FormatInt(seconds, 10)
+	}
+	if seconds < 0 {
+		// The fraction always counts forwards, so negative times need a borrow
+		return fmt.Sprintf("-%d.%09d", -(seconds + 1), 1e9-nanos)
+	}
+	return fmt.Sprintf("%d.%09d", seconds, nanos)
+}
+
+// parsePAXTime parses decimal seconds with an optional fraction
+func parsePAXTime(value string) (time.Time, error) {
+	secondsText, fractionText, _ := strings.Cut(value, ".")
+	seconds, err := strconv.ParseInt(secondsText, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nanos int64
+	if fractionText != "" {
+		fractionText = (fractionText + "000000000")[:9]
+		if nanos, err = strconv.ParseInt(fractionText, 10, 64); err != nil || nanos < 0 {
+			return time.Time{}, fmt.Errorf("invalid fraction %q", fractionText)
+		}
+	}
+	if strings.HasPrefix(secondsText, "-") {
+		nanos = -nanos
+	}
+	return time.Unix(seconds, nanos), nil
+}
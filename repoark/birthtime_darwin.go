@@ -0,0 +1,40 @@
+//go:build darwin
+
+package repoark
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileBirthTime returns the creation time kept by APFS and HFS+
+func fileBirthTime(path string) (time.Time, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Birthtimespec.Sec, stat.Birthtimespec.Nsec), true
+}
+
+// setFileBirthTime sets the creation time with setattrlist
+func setFileBirthTime(path string, t time.Time) error {
+	attrs := unix.Attrlist{
+		Bitmapcount: unix.ATTR_BIT_MAP_COUNT,
+		Commonattr:  unix.ATTR_CMN_CRTIME,
+	}
+	timespec := unix.NsecToTimespec(t.UnixNano())
+	buf := (*[unsafe.Sizeof(timespec)]byte)(unsafe.Pointer(&timespec))[:]
+	err := unix.Setattrlist(path, &attrs, buf, unix.FSOPT_NOFOLLOW)
+	if err == unix.ENOTSUP {
+		return errBirthTimeUnsupported
+	}
+	return err
+}
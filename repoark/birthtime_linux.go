@@ -0,0 +1,26 @@
+//go:build linux
+
+package repoark
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileBirthTime reads the creation time with statx, which not every filesystem provides
+func fileBirthTime(path string) (time.Time, bool) {
+	var stat unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stat); err != nil {
+		return time.Time{}, false
+	}
+	if stat.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Btime.Sec, int64(stat.Btime.Nsec)), true
+}
+
+// setFileBirthTime is not possible on Linux, the kernel sets creation times itself
+func setFileBirthTime(path string, t time.Time) error {
+	return errBirthTimeUnsupported
+}
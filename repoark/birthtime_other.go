@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package repoark
+
+import "time"
+
+// fileBirthTime is not available on this platform
+func fileBirthTime(path string) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// setFileBirthTime is not available on this platform
+func setFileBirthTime(path string, t time.Time) error {
+	return errBirthTimeUnsupported
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package repoark
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileBirthTime returns the NTFS creation time
+func fileBirthTime(path string) (time.Time, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, data.CreationTime.Nanoseconds()), true
+}
+
+// setFileBirthTime sets the creation time, leaving access and modification times alone
+func setFileBirthTime(path string, t time.Time) error {
+	pathp, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return err
+	}
+	handle, err := syscall.CreateFile(pathp, syscall.FILE_WRITE_ATTRIBUTES, syscall.FILE_SHARE_WRITE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	creationTime := syscall.NsecToFiletime(t.UnixNano())
+	return syscall.SetFileTime(handle, &creationTime, nil, nil)
+}
@@ -0,0 +1,314 @@
+package repoark
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// repoarkIgnoreFile lists untracked paths that are never archived, using gitignore syntax
+const repoarkIgnoreFile = ".repoarkignore"
+
+// maxExcludeCandidates limits how many exclusion proposals are shown at once
+const maxExcludeCandidates = 10
+
+// excludeCandidate is an untracked file or directory that could be left out of the archive
+type excludeCandidate struct {
+	Path  string // slash separated, relative to the repository root
+	Size  int64
+	IsDir bool
+}
+
+// repoarkIgnoreArgs returns the ls-files arguments honouring dir's .repoarkignore, if any
+func repoarkIgnoreArgs(dir string) []string {
+	ignorePath := filepath.Join(dir, repoarkIgnoreFile)
+	if _, err := os.Stat(ignorePath); err != nil {
+		return nil
+	}
+	return []string{"--exclude-from=" + ignorePath}
+}
+
+// listRepoFiles runs git ls-files in dir with the given selection flags plus .repoarkignore
+func listRepoFiles(dir string, flags ...string) ([]string, error) {
+	args := append([]string{"ls-files", "-z"}, flags...)
+	args = append(args, repoarkIgnoreArgs(dir)...)
+	output, err := runGit(dir, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in %s: %v", dir, err)
+	}
+	// The stages of a path with merge conflicts are listed one after the other, it is one file
+	files := splitNul(output)
+	unique := files[:0]
+	for i, file := range files {
+		if i == 0 || files[i-1] != file {
+			unique = append(unique, file)
+		}
+	}
+	return unique, nil
+}
+
+// estimateArchiveSize sums the uncompressed size of the files an archive of repoPath would contain.
+// Submodule contents are not included in the estimate.
+func estimateArchiveSize(repoPath string) (int64, error) {
+	repoVCS, err := detectVCS(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := repoVCS.ListTracked(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	untracked, err := repoVCS.ListUntracked(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	entries = append(entries, untracked...)
+
+	var total int64
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(repoPath, entry)); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	total += directorySize(filepath.Join(repoPath, repoVCS.MetadataDir()))
+	return total, nil
+}
+
+// directorySize sums the sizes of the regular files below dir
+func directorySize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// largestUntracked proposes the largest untracked files and directories, never two that nest
+func largestUntracked(repoPath string) ([]excludeCandidate, error) {
+	entries, err := listRepoFiles(repoPath, "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	// Aggregate every untracked file into itself and all its parent directories
+	sizes := make(map[string]*excludeCandidate)
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(repoPath, entry))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		sizes[entry] = &excludeCandidate{Path: entry, Size: info.Size()}
+		for dir := filepath.ToSlash(filepath.Dir(entry)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			if sizes[dir] == nil {
+				sizes[dir] = &excludeCandidate{Path: dir, IsDir: true}
+			}
+			sizes[dir].Size += info.Size()
+		}
+	}
+
+	all := make([]excludeCandidate, 0, len(sizes))
+	for _, candidate := range sizes {
+		all = append(all, *candidate)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Size != all[j].Size {
+			return all[i].Size > all[j].Size
+		}
+		// Prefer the directory over its only child of the same size
+		return len(all[i].Path) < len(all[j].Path)
+	})
+
+	var chosen []excludeCandidate
+	for _, candidate := range all {
+		if len(chosen) == maxExcludeCandidates {
+			break
+		}
+		nested := false
+		for _, c := range chosen {
+			if isPathWithin(candidate.Path, c.Path) || isPathWithin(c.Path, candidate.Path) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			chosen = append(chosen, candidate)
+		}
+	}
+	return chosen, nil
+}
+
+// isPathWithin reports whether slash separated path equals dir or lies below it
+func isPathWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// appendRepoarkIgnore adds anchored patterns for the excluded paths to .repoarkignore
+func appendRepoarkIgnore(repoPath string, excluded []excludeCandidate) error {
+	file, err := os.OpenFile(filepath.Join(repoPath, repoarkIgnoreFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", repoarkIgnoreFile, err)
+	}
+	defer file.Close()
+
+	for _, candidate := range excluded {
+		pattern := "/" + escapeIgnorePattern(candidate.Path)
+		if candidate.IsDir {
+			pattern += "/"
+		}
+		if _, err := fmt.Fprintln(file, pattern); err != nil {
+			return fmt.Errorf("error writing %s: %v", repoarkIgnoreFile, err)
+		}
+	}
+	return file.Close()
+}
+
+// escapeIgnorePattern makes a literal path safe to use as a gitignore pattern
+func escapeIgnorePattern(path string) string {
+	var sb strings.Builder
+	for i, c := range path {
+		if strings.ContainsRune(`\*?[`, c) || (i == 0 && (c == '#' || c == '!')) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	// Trailing spaces are ignored by git unless escaped
+	escaped := sb.String()
+	if trimmed := strings.TrimRight(escaped, " "); trimmed != escaped {
+		escaped = trimmed + strings.Repeat(`\ `, len(escaped)-len(trimmed))
+	}
+	return escaped
+}
+
+// enforceSizeBudget makes sure the archive of repoPath fits opts.SizeBudget, excluding
+// untracked content interactively or automatically (--auto-exclude-largest) when it does not.
+// Interactive answers are kept in the returned session until the caller completes it.
+func enforceSizeBudget(repoPath string, opts ArchiveOptions) (*interactiveSession, error) {
+	stdin := bufio.NewReader(os.Stdin)
+	var session *interactiveSession
+	autoRemaining := opts.AutoExcludeLargest
+	for {
+		estimate, err := estimateArchiveSize(repoPath)
+		if err != nil {
+			return session, err
+		}
+		if estimate <= opts.SizeBudget {
+			return session, nil
+		}
+		opts.Logger.Infof("Estimated archive size %s exceeds the budget of %s", formatSize(estimate), formatSize(opts.SizeBudget))
+
+		candidates, err := largestUntracked(repoPath)
+		if err != nil {
+			return session, err
+		}
+		if len(candidates) == 0 || (opts.AutoExcludeLargest > 0 && autoRemaining == 0) {
+			return session, fmt.Errorf("estimated archive size %s exceeds the budget of %s and no untracked content is left to exclude",
+				formatSize(estimate), formatSize(opts.SizeBudget))
+		}
+
+		var excluded []excludeCandidate
+		if opts.AutoExcludeLargest > 0 {
+			n := min(autoRemaining, len(candidates))
+			excluded = candidates[:n]
+			autoRemaining -= n
+		} else {
+			if !isTerminal(os.Stdin) {
+				return session, fmt.Errorf("estimated archive size %s exceeds the budget of %s; use --auto-exclude-largest to exclude content non-interactively",
+					formatSize(estimate), formatSize(opts.SizeBudget))
+			}
+			if session == nil {
+				session = openSession(stdin, "archive", repoPath, formatSize(opts.SizeBudget))
+			}
+			excluded, err = promptExcludes(stdin, candidates, session)
+			if err != nil {
+				return session, err
+			}
+			if excluded == nil {
+				opts.Logger.Infof("Archiving over budget")
+				return session, nil
+			}
+		}
+
+		for _, candidate := range excluded {
+			opts.Logger.Infof("exclude %s (%s)", candidate.Path, formatSize(candidate.Size))
+		}
+		if err := appendRepoarkIgnore(repoPath, excluded); err != nil {
+			return session, err
+		}
+	}
+}
+
+// promptExcludes asks which candidates to exclude; it returns nil to archive anyway.
+// An answer recorded in a resumed session for the same candidates is used without asking.
+func promptExcludes(stdin *bufio.Reader, candidates []excludeCandidate, session *interactiveSession) ([]excludeCandidate, error) {
+	fmt.Println("Largest untracked content:")
+	for i, candidate := range candidates {
+		suffix := ""
+		if candidate.IsDir {
+			suffix = "/"
+		}
+		fmt.Printf("  %2d) %10s  %s%s\n", i+1, formatSize(candidate.Size), candidate.Path, suffix)
+	}
+
+	// The question is identified by the candidates it offers, answers to a different list do not apply
+	var question strings.Builder
+	question.WriteString("exclude")
+	for _, candidate := range candidates {
+		question.WriteString("\x00" + candidate.Path)
+	}
+
+	for {
+		fmt.Print("Exclude which items (e.g. 1,3)? Empty to archive anyway, q to abort: ")
+		line, resumed := session.recall(question.String())
+		if resumed {
+			fmt.Printf("%s (from the interrupted session)\n", line)
+			delete(session.Answers, question.String())
+		} else {
+			read, err := stdin.ReadString('\n')
+			if err != nil && read == "" {
+				return nil, fmt.Errorf("aborted")
+			}
+			line = strings.TrimSpace(read)
+		}
+		switch line {
+		case "":
+			session.remember(question.String(), line)
+			return nil, nil
+		case "q", "Q":
+			return nil, fmt.Errorf("aborted")
+		}
+
+		var excluded []excludeCandidate
+		valid := true
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(candidates) {
+				fmt.Printf("Invalid selection: %s\n", field)
+				valid = false
+				break
+			}
+			excluded = append(excluded, candidates[n-1])
+		}
+		if valid {
+			session.remember(question.String(), line)
+			return excluded, nil
+		}
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,148 @@
+package repoark
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// historyBundleName is the entry holding the history of archives written with --history bundle
+const historyBundleName = archiveMetaDir + "history.bundle"
+
+// bundledGitPaths are replaced in the archive by the bundle, relative to the root repository
+var bundledGitPaths = map[string]bool{
+	filepath.Join(".git", "objects"):     true,
+	filepath.Join(".git", "refs"):        true,
+	filepath.Join(".git", "packed-refs"): true,
+}
+
+// historyBundle is a git bundle of every ref of the root repository, stored in the archive
+// instead of its objects and refs
+type historyBundle struct {
+	dir  string
+	Path string
+	Refs int
+}
+
+// Close removes the temporary bundle
+func (b *historyBundle) Close() error {
+	if b == nil {
+		return nil
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// createHistoryBundle bundles the history of the repository at repoPath with git bundle --all.
+// Unlike copying .git/objects file by file, which may catch a pack while git gc rewrites it,
+// git writes the bundle from one consistent view of the refs, and only with reachable objects.
+func createHistoryBundle(repoPath string, opts ArchiveOptions) (*historyBundle, error) {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", "shallow")); err == nil {
+		return nil, fmt.Errorf("--history bundle cannot be used for shallow clones, whose history git cannot bundle completely")
+	}
+	objectsDir := filepath.Join(repoPath, ".git", "objects")
+	dir, err := stagingDir(opts.TmpDir, "repoark-bundle-", directorySize(objectsDir), objectsDir, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &historyBundle{dir: dir, Path: filepath.Join(dir, "history.bundle")}
+	var args []string
+	if opts.Reproducible {
+		// Delta search with several threads does not always produce the same pack
+		args = append(args, "-c", "pack.threads=1")
+	}
+	args = append(args, "bundle", "create", "--quiet", bundle.Path, "--all")
+	if _, err := runGit(repoPath, nil, args...); err != nil {
+		bundle.Close()
+		return nil, fmt.Errorf("error bundling history: %v", err)
+	}
+	heads, err := runGit(repoPath, nil, "bundle", "list-heads", bundle.Path)
+	if err != nil {
+		bundle.Close()
+		return nil, fmt.Errorf("error reading history bundle: %v", err)
+	}
+	for _, line := range strings.Split(string(heads), "\n") {
+		// <object> <ref>, HEAD is archived with the rest of .git
+		if _, ref, ok := strings.Cut(line, " "); ok && ref != "HEAD" {
+			bundle.Refs++
+		}
+	}
+	return bundle, nil
+}
+
+// write adds the bundle to the archive. It is metadata like the manifest: restore unpacks it
+// rather than writing it out, so it is left out of the manifest.
+func (b *historyBundle) write(writer entryWriter, opts ArchiveOptions) error {
+	file, err := os.Open(b.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := metaEntryHeader(historyBundleName, nil, opts)
+	header.Size = info.Size()
+	if err := writer.WriteEntry(header, file); err != nil {
+		return fmt.Errorf("error adding history bundle: %v", err)
+	}
+	return nil
+}
+
+// extractHistoryBundle writes the bundle entry of an archive into the .git directory of the
+// restored repository, on the filesystem the history is unpacked to
+func extractHistoryBundle(repoPath string, content io.Reader) (string, error) {
+	gitDir := filepath.Join(repoPath, ".git")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(gitDir, "repoark-history-*.bundle")
+	if err != nil {
+		return "", fmt.Errorf("error extracting history bundle: %v", err)
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", &archiveError{Err: fmt.Errorf("error extracting history bundle: %v", err)}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// applyHistoryBundle unpacks the objects of the bundle into the restored repository and sets its
+// refs to the bundled ones. HEAD is restored from the archive like the rest of .git.
+func applyHistoryBundle(repoPath, bundlePath string, logger Logger) error {
+	for _, dir := range []string{"objects/pack", "objects/info", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(repoPath, ".git", filepath.FromSlash(dir)), 0755); err != nil {
+			return err
+		}
+	}
+	output, err := runGit(repoPath, nil, "bundle", "unbundle", bundlePath)
+	if err != nil {
+		return fmt.Errorf("error unpacking history bundle: %v", err)
+	}
+	var updates bytes.Buffer
+	refs := 0
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// <object> <ref>
+		object, ref, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || ref == "HEAD" {
+			continue
+		}
+		fmt.Fprintf(&updates, "update %s %s\n", ref, object)
+		refs++
+	}
+	if _, err := runGit(repoPath, &updates, "update-ref", "--stdin"); err != nil {
+		return fmt.Errorf("error restoring refs from history bundle: %v", err)
+	}
+	logger.Infof("Restored history with %d refs from the bundle", refs)
+	return nil
+}
@@ -0,0 +1,136 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// caseCollisionStrategies are the accepted --case-collisions values
+var caseCollisionStrategies = map[string]bool{"rename": true, "skip": true, "overwrite": true}
+
+// caseCollisions finds the entries of an archive whose names differ only in case, such as
+// README.md and Readme.md written on Linux, which a case-insensitive filesystem stores as one
+// file. The first entry keeps its name, later ones are renamed, skipped or overwrite it.
+type caseCollisions struct {
+	strategy string
+	seen     map[string]string // case-folded restored path to the archived name of the entry holding it
+	renames  map[string]string // archived name to the name it was restored as, for entries stored twice
+	found    []caseCollision
+}
+
+// caseCollision is an entry whose name collided with one restored before it
+type caseCollision struct {
+	Name      string // archived name
+	Existing  string // name of the entry it collided with
+	RenamedTo string // name it was restored as with rename, empty otherwise
+}
+
+// newCaseCollisions returns the collision check for the filesystem holding repoPath, nil when
+// that filesystem tells names apart by case or the strategy is to overwrite
+func newCaseCollisions(strategy, repoPath string) (*caseCollisions, error) {
+	if strategy == "" {
+		strategy = "rename"
+	}
+	if !caseCollisionStrategies[strategy] {
+		return nil, fmt.Errorf("unsupported case collision strategy %s, expected rename, skip or overwrite", strategy)
+	}
+	if strategy == "overwrite" || !isCaseInsensitive(repoPath) {
+		return nil, nil
+	}
+	return &caseCollisions{strategy: strategy, seen: make(map[string]string), renames: make(map[string]string)}, nil
+}
+
+// isCaseInsensitive probes whether dir finds a file by a name differing from it only in case
+func isCaseInsensitive(dir string) bool {
+	probe := filepath.Join(dir, ".repoark-probe-Case")
+	file, err := os.OpenFile(probe, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	defer os.Remove(probe)
+	_, err = os.Lstat(filepath.Join(dir, ".repoark-probe-case"))
+	return err == nil
+}
+
+// foldCase returns the key two names share when a case-insensitive filesystem stores them as one,
+// such filesystems tell apart neither case nor normalization forms
+func foldCase(name string) string {
+	return strings.ToLower(strings.ToUpper(pathKey(name)))
+}
+
+// resolve returns the name to restore the entry called name as, and false when it is skipped.
+// name is the archived name after mangling, original the archived name itself.
+func (c *caseCollisions) resolve(name, original string) (string, bool) {
+	if c == nil {
+		return name, true
+	}
+	if renamed, ok := c.renames[original]; ok {
+		return renamed, true
+	}
+	key := foldCase(name)
+	existing, ok := c.seen[key]
+	if !ok || existing == original {
+		c.seen[key] = original
+		return name, true
+	}
+	collision := caseCollision{Name: original, Existing: existing}
+	if c.strategy == "skip" {
+		c.found = append(c.found, collision)
+		return "", false
+	}
+	// Numbered before the extension, the first free number keeps the names stable across restores
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if ext == base {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(base, ext)
+	for i := 2; ; i++ {
+		renamed := fmt.Sprintf("%s%s~%d%s", dir, stem, i, ext)
+		if _, taken := c.seen[foldCase(renamed)]; !taken {
+			c.seen[foldCase(renamed)] = original
+			c.renames[original] = renamed
+			collision.RenamedTo = renamed
+			c.found = append(c.found, collision)
+			return renamed, true
+		}
+	}
+}
+
+// renamed returns the number of entries restored under another name
+func (c *caseCollisions) renamed() int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	for _, collision := range c.found {
+		if collision.RenamedTo != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// report lists the collisions found, what became of each and how to get the names back
+func (c *caseCollisions) report(logger Logger) {
+	if c == nil || len(c.found) == 0 {
+		return
+	}
+	sort.Slice(c.found, func(i, j int) bool { return c.found[i].Name < c.found[j].Name })
+	logger.Warnf("%d names differ only in case from another entry, which this filesystem cannot tell apart:", len(c.found))
+	for _, collision := range c.found {
+		if collision.RenamedTo != "" {
+			logger.Warnf("  %s collides with %s, restored as %s", collision.Name, collision.Existing, collision.RenamedTo)
+		} else {
+			logger.Warnf("  %s collides with %s, skipped", collision.Name, collision.Existing)
+		}
+	}
+	if c.strategy == "rename" {
+		logger.Warnf("Run 'repoark unmangle' on a case-sensitive filesystem to restore the archived names")
+	}
+}
@@ -0,0 +1,66 @@
+package repoark
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// normalizeEntryName makes user supplied and archived names comparable
+func normalizeEntryName(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// catArchiveFile copies the content of one archive entry to w
+func catArchiveFile(archivePath, entryPath string, w io.Writer) error {
+	want := normalizeEntryName(entryPath)
+
+	if zr, err := zip.OpenReader(archivePath); err == nil {
+		defer zr.Close()
+		for _, f := range zr.File {
+			if normalizeEntryName(f.Name) != want || !f.Mode().IsRegular() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			_, err = io.Copy(w, r)
+			return err
+		}
+		return fmt.Errorf("%s is not in %s", entryPath, archivePath)
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s is not in %s", entryPath, archivePath)
+		}
+		if err != nil {
+			return err
+		}
+		if normalizeEntryName(header.Name) != want {
+			continue
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", entryPath)
+		}
+		_, err = io.Copy(w, ar)
+		return err
+	}
+}
+
+// catArchive writes one file of the archive to stdout
+func catArchive(archivePath, entryPath string) error {
+	return catArchiveFile(archivePath, entryPath, os.Stdout)
+}
@@ -0,0 +1,220 @@
+package repoark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// catalogLockTimeout bounds how long a run waits for another machine updating the catalog
+const catalogLockTimeout = 30 * time.Second
+
+// catalogKeyEnv holds the key the catalog is encrypted with: an age identity (AGE-SECRET-KEY-...)
+// or a passphrase, either of them usually a secret:<name> reference
+const catalogKeyEnv = "REPOARK_CATALOG_KEY"
+
+// catalogRecord describes one archive known to the catalog
+type catalogRecord struct {
+	Repo        string        `json:"repo"`
+	Destination string        `json:"destination"`
+	Host        string        `json:"host"`
+	Created     time.Time     `json:"created"`
+	Size        int64         `json:"size"`
+	Compression string        `json:"compression"`
+	Encrypted   bool          `json:"encrypted"`
+	Signed      bool          `json:"signed"`
+	Verified    time.Time     `json:"verified,omitempty"` // last successful verification
+	Imported    bool          `json:"imported,omitempty"` // registered by import rather than created by repoark
+	Manifest    string        `json:"manifest,omitempty"` // sidecar manifest written by import
+	Missed      []missedEntry `json:"missed,omitempty"`   // files --keep-going left out of the archive
+}
+
+// catalog is the index of archives shared by every machine pointing at the same catalog file.
+// Revision increases with every update so concurrent writers can detect lost updates.
+type catalog struct {
+	Revision int64           `json:"revision"`
+	Records  []catalogRecord `json:"records"`
+}
+
+// catalogPath returns the catalog location from --catalog or REPOARK_CATALOG, empty when disabled
+func catalogPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv("REPOARK_CATALOG")
+}
+
+// catalogKeys caches the key of REPOARK_CATALOG_KEY, reading a secret store may ask for a passphrase
+var catalogKeys struct {
+	sync.Mutex
+	env       string
+	identity  age.Identity
+	recipient age.Recipient
+}
+
+// catalogKey returns the identity decrypting and the recipient encrypting the catalog,
+// both nil when REPOARK_CATALOG_KEY is not set
+func catalogKey() (age.Identity, age.Recipient, error) {
+	env := os.Getenv(catalogKeyEnv)
+	if env == "" {
+		return nil, nil, nil
+	}
+	catalogKeys.Lock()
+	defer catalogKeys.Unlock()
+	if catalogKeys.env == env {
+		return catalogKeys.identity, catalogKeys.recipient, nil
+	}
+	key, err := resolveSecret(env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", catalogKeyEnv, err)
+	}
+	var identity age.Identity
+	var recipient age.Recipient
+	if strings.HasPrefix(strings.TrimSpace(key), "AGE-SECRET-KEY-") {
+		x25519, err := age.ParseX25519Identity(strings.TrimSpace(key))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", catalogKeyEnv, err)
+		}
+		identity, recipient = x25519, x25519.Recipient()
+	} else {
+		if identity, err = age.NewScryptIdentity(key); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", catalogKeyEnv, err)
+		}
+		if recipient, err = age.NewScryptRecipient(key); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", catalogKeyEnv, err)
+		}
+	}
+	catalogKeys.env, catalogKeys.identity, catalogKeys.recipient = env, identity, recipient
+	return identity, recipient, nil
+}
+
+// loadCatalog reads the catalog at path; a missing file is an empty catalog
+func loadCatalog(path string) (*catalog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &catalog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog: %v", err)
+	}
+	if isAgeEncrypted(data) {
+		identity, _, err := catalogKey()
+		if err != nil {
+			return nil, err
+		}
+		if identity == nil {
+			return nil, fmt.Errorf("catalog %s is encrypted, set %s to its key", path, catalogKeyEnv)
+		}
+		plaintext, err := age.Decrypt(bytes.NewReader(data), identity)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting catalog %s: %v", path, err)
+		}
+		if data, err = io.ReadAll(plaintext); err != nil {
+			return nil, fmt.Errorf("error decrypting catalog %s: %v", path, err)
+		}
+	}
+
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing catalog %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// updateCatalog applies update to the catalog at path while holding its lock.
+// The new content is written to a temporary file and renamed into place, so readers
+// never see a partial catalog, and the revision is re-checked before the rename in
+// case the lock was broken as stale while this update was in progress.
+func updateCatalog(path string, update func(*catalog) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating catalog directory: %v", err)
+	}
+	release, err := acquireLock(path+".lock", catalogLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	c, err := loadCatalog(path)
+	if err != nil {
+		return err
+	}
+	baseRevision := c.Revision
+	if err := update(c); err != nil {
+		return err
+	}
+	c.Revision = baseRevision + 1
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A plain catalog is encrypted by its first update once a key is set
+	_, recipient, err := catalogKey()
+	if err != nil {
+		return err
+	}
+	if recipient != nil {
+		var encrypted bytes.Buffer
+		w, err := age.Encrypt(&encrypted, recipient)
+		if err != nil {
+			return fmt.Errorf("error encrypting catalog: %v", err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error encrypting catalog: %v", err)
+		}
+		data = encrypted.Bytes()
+	}
+	hostname, _ := os.Hostname()
+	tmpPath := fmt.Sprintf("%s.%s-%d.tmp", path, hostname, os.Getpid())
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing catalog: %v", err)
+	}
+
+	if current, err := loadCatalog(path); err != nil || current.Revision != baseRevision {
+		os.Remove(tmpPath)
+		return fmt.Errorf("catalog %s was modified concurrently, please retry", path)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing catalog: %v", err)
+	}
+	return nil
+}
+
+// newCatalogRecord describes an archive just written to outputPath
+func newCatalogRecord(repoPath, outputPath string, comp compressor) catalogRecord {
+	record := catalogRecord{
+		Repo:        repoPath,
+		Destination: outputPath,
+		Created:     time.Now().UTC(),
+		Compression: comp.Name,
+	}
+	record.Host, _ = os.Hostname()
+	if absPath, err := filepath.Abs(outputPath); err == nil {
+		record.Destination = absPath
+	}
+	for _, volume := range archiveVolumes(outputPath) {
+		if info, err := os.Stat(volume); err == nil {
+			record.Size += info.Size()
+		}
+	}
+	return record
+}
+
+// registerArchive records a newly created archive in the catalog
+func registerArchive(path string, record catalogRecord) error {
+	return updateCatalog(path, func(c *catalog) error {
+		c.Records = append(c.Records, record)
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package repoark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportRow summarizes the backup posture of one repository at one destination
+type reportRow struct {
+	Repo         string     `json:"repo"`
+	Destination  string     `json:"destination"`
+	Archives     int        `json:"archives"`
+	LastBackup   time.Time  `json:"last_backup"`
+	Encrypted    bool       `json:"encrypted"`
+	Signed       bool       `json:"signed"`
+	LastVerified *time.Time `json:"last_verified"`
+	LastMissed   int        `json:"last_missed"` // files --keep-going left out of the most recent archive
+	Retention    string     `json:"retention"`   // compliant, overdue or unknown without --max-age
+}
+
+// buildReport groups catalog records by repository and destination directory,
+// reporting the status of the most recent archive of each group
+func buildReport(c *catalog, maxAge time.Duration, now time.Time) []reportRow {
+	groups := make(map[[2]string]*reportRow)
+	for _, record := range c.Records {
+		key := [2]string{record.Repo, filepath.Dir(record.Destination)}
+		row := groups[key]
+		if row == nil {
+			row = &reportRow{Repo: key[0], Destination: key[1]}
+			groups[key] = row
+		}
+		row.Archives++
+		if !record.Created.Before(row.LastBackup) {
+			row.LastBackup = record.Created
+			row.Encrypted = record.Encrypted
+			row.Signed = record.Signed
+			row.LastMissed = len(record.Missed)
+		}
+		if !record.Verified.IsZero() && (row.LastVerified == nil || record.Verified.After(*row.LastVerified)) {
+			verified := record.Verified
+			row.LastVerified = &verified
+		}
+	}
+
+	rows := make([]reportRow, 0, len(groups))
+	for _, row := range groups {
+		switch {
+		case maxAge == 0:
+			row.Retention = "unknown"
+		case now.Sub(row.LastBackup) <= maxAge:
+			row.Retention = "compliant"
+		default:
+			row.Retention = "overdue"
+		}
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Repo != rows[j].Repo {
+			return rows[i].Repo < rows[j].Repo
+		}
+		return rows[i].Destination < rows[j].Destination
+	})
+	return rows
+}
+
+// writeReport renders the report as csv or json
+func writeReport(w io.Writer, rows []reportRow, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "csv", "":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"repo", "destination", "archives", "last_backup", "encrypted", "signed", "last_verified", "retention", "last_missed"})
+		for _, row := range rows {
+			verified := ""
+			if row.LastVerified != nil {
+				verified = row.LastVerified.Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				row.Repo,
+				row.Destination,
+				strconv.Itoa(row.Archives),
+				row.LastBackup.Format(time.RFC3339),
+				strconv.FormatBool(row.Encrypted),
+				strconv.FormatBool(row.Signed),
+				verified,
+				row.Retention,
+				strconv.Itoa(row.LastMissed),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// reportCatalog prints the backup posture of every repository in the catalog
+func reportCatalog(path, format, maxAge string) error {
+	if path == "" {
+		return fmt.Errorf("no catalog configured, use --catalog or REPOARK_CATALOG")
+	}
+	var age time.Duration
+	if maxAge != "" {
+		var err error
+		if age, err = parseAge(maxAge); err != nil {
+			return err
+		}
+	}
+
+	c, err := loadCatalog(path)
+	if err != nil {
+		return err
+	}
+	return writeReport(os.Stdout, buildReport(c, age, time.Now()), format)
+}
+
+// parseAge parses durations like 36h, 7d or 2w
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
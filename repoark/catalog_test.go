@@ -0,0 +1,52 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptedCatalog(t *testing.T) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFileSecretStore(t, map[string]string{"catalog-key": key.String()})
+	path := filepath.Join(t.TempDir(), "catalog.json")
+
+	// A plain catalog is encrypted by the first update after the key is set
+	t.Setenv(catalogKeyEnv, "")
+	if err := registerArchive(path, catalogRecord{Repo: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(catalogKeyEnv, "secret:catalog-key")
+	if err := registerArchive(path, catalogRecord{Repo: "encrypted"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAgeEncrypted(data) || strings.Contains(string(data), "encrypted") {
+		t.Fatal("catalog was written in plain text with a key set")
+	}
+	c, err := loadCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Records) != 2 || c.Records[0].Repo != "plain" || c.Records[1].Repo != "encrypted" || c.Revision != 2 {
+		t.Errorf("catalog = %+v, want both records at revision 2", c)
+	}
+
+	t.Setenv(catalogKeyEnv, "")
+	if _, err := loadCatalog(path); err == nil || !strings.Contains(err.Error(), catalogKeyEnv) {
+		t.Errorf("loading without the key = %v, want an error naming %s", err, catalogKeyEnv)
+	}
+	t.Setenv(catalogKeyEnv, "a passphrase that is not the key")
+	if _, err := loadCatalog(path); err == nil {
+		t.Error("catalog decrypted with a wrong key")
+	}
+}
@@ -0,0 +1,77 @@
+package repoark
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumSidecarPath returns where the SHA-256 sums of the archive at archivePath are stored,
+// one file for all volumes of a split archive
+func checksumSidecarPath(archivePath string) string {
+	return archiveBaseName(archivePath) + ".sha256"
+}
+
+// writeChecksumSidecar reads the archive at archivePath back and writes the sums of its volumes
+// in the format of sha256sum, so that `sha256sum -c` can check them as well
+func writeChecksumSidecar(archivePath string) error {
+	var sums strings.Builder
+	for _, volume := range archiveVolumes(archivePath) {
+		sum, err := fileSHA256(volume)
+		if err != nil {
+			return fmt.Errorf("error computing archive checksum: %v", err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, filepath.Base(volume))
+	}
+	if err := os.WriteFile(checksumSidecarPath(archivePath), []byte(sums.String()), 0644); err != nil {
+		return fmt.Errorf("error writing archive checksum: %v", err)
+	}
+	return nil
+}
+
+// verifyChecksumSidecar checks the archive at archivePath against its .sha256 sidecar, if there is one,
+// before anything is extracted from it. It reports whether a sidecar was found.
+func verifyChecksumSidecar(archivePath string) (bool, error) {
+	sidecar := checksumSidecarPath(archivePath)
+	file, err := os.Open(sidecar)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading archive checksum: %v", err)
+	}
+	defer file.Close()
+
+	checked := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// <sum>  <name>, sha256sum marks binary mode with * in front of the name
+		want, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		if !ok || len(want) != sha256.Size*2 || name == "" {
+			return true, fmt.Errorf("%s: invalid line %q", sidecar, line)
+		}
+		got, err := fileSHA256(filepath.Join(filepath.Dir(sidecar), name))
+		if err != nil {
+			return true, &exitError{Code: exitCorrupt, Err: fmt.Errorf("error checking %s against %s: %v", name, sidecar, err)}
+		}
+		if !strings.EqualFold(got, want) {
+			return true, &exitError{Code: exitCorrupt, Err: fmt.Errorf("%s does not match its checksum in %s, the archive is damaged", name, sidecar)}
+		}
+		checked++
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("error reading archive checksum: %v", err)
+	}
+	if checked == 0 {
+		return true, fmt.Errorf("%s lists no files", sidecar)
+	}
+	return true, nil
+}
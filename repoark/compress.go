@@ -0,0 +1,225 @@
+package repoark
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// compressor describes a supported archive compression format
+type compressor struct {
+	Name       string
+	Extensions []string // recognised file name suffixes, the first one is used for new archives
+	MinLevel   int      // accepted --level range, both 0 when levels are not supported
+	MaxLevel   int
+	NewWriter  func(w io.Writer, level int) (io.WriteCloser, error) // level 0 selects the default
+	// NewParallelWriter compresses on up to jobs goroutines, nil when the format has no parallel encoder
+	NewParallelWriter func(w io.Writer, level, jobs int) (io.WriteCloser, error)
+	NewReader         func(r io.Reader) (io.ReadCloser, error)
+	// NewParallelReader decompresses on up to jobs goroutines, nil when the format has no parallel decoder
+	NewParallelReader func(r io.Reader, jobs int) (io.ReadCloser, error)
+}
+
+// pgzipBlockSize is the amount of input each parallel gzip job compresses at a time
+const pgzipBlockSize = 1 << 20
+
+// lz4Levels maps --level 1-9 onto the lz4 compression levels
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// compressors lists the supported formats, the first one is the default
+var compressors = []compressor{
+	{
+		Name:       "gzip",
+		Extensions: []string{".tar.gz", ".tgz"},
+		MinLevel:   gzip.BestSpeed,
+		MaxLevel:   gzip.BestCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		NewParallelWriter: func(w io.Writer, level, jobs int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			gzipWriter, err := pgzip.NewWriterLevel(w, level)
+			if err != nil {
+				return nil, err
+			}
+			if err := gzipWriter.SetConcurrency(pgzipBlockSize, jobs); err != nil {
+				return nil, err
+			}
+			return gzipWriter, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		Name:       "lz4",
+		Extensions: []string{".tar.lz4"},
+		MinLevel:   1,
+		MaxLevel:   len(lz4Levels),
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			lz4Writer := lz4.NewWriter(w)
+			if level > 0 {
+				if err := lz4Writer.Apply(lz4.CompressionLevelOption(lz4Levels[level-1])); err != nil {
+					return nil, err
+				}
+			}
+			return lz4Writer, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(lz4.NewReader(r)), nil
+		},
+	},
+	{
+		Name:       "zstd",
+		Extensions: []string{".tar.zst", ".tar.zstd", ".tzst"},
+		MinLevel:   1,
+		MaxLevel:   19,
+		// Archives are written as independent frames, so -j does not change the output
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return newZstdFrameWriter(w, level, 1)
+		},
+		NewParallelWriter: func(w io.Writer, level, jobs int) (io.WriteCloser, error) {
+			return newZstdFrameWriter(w, level, jobs)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+		NewParallelReader: func(r io.Reader, jobs int) (io.ReadCloser, error) {
+			return newZstdFrameReader(r, jobs)
+		},
+	},
+	{
+		Name:       "xz",
+		Extensions: []string{".tar.xz", ".txz"},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			xzReader, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(xzReader), nil
+		},
+	},
+	{
+		Name:       "none",
+		Extensions: []string{".tar"},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	},
+}
+
+// nopWriteCloser writes an uncompressed stream, Close leaves the underlying writer open
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// checkLevel validates a --level value against the compressor's range
+func (c compressor) checkLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+	if c.MaxLevel == 0 {
+		return fmt.Errorf("%s does not support compression levels", c.Name)
+	}
+	if level < c.MinLevel || level > c.MaxLevel {
+		return fmt.Errorf("%s compression level must be between %d and %d", c.Name, c.MinLevel, c.MaxLevel)
+	}
+	return nil
+}
+
+// compressorMagic lists the leading bytes identifying each compressed format
+var compressorMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}},
+}
+
+// tarMagicOffset is where the "ustar" magic of a tar header starts
+const tarMagicOffset = 257
+
+// detectCompressor identifies the compression of an archive from its first bytes
+func detectCompressor(head []byte) (compressor, bool) {
+	for _, m := range compressorMagic {
+		if bytes.HasPrefix(head, m.magic) {
+			c, err := compressorByName(m.name)
+			return c, err == nil
+		}
+	}
+	if len(head) >= tarMagicOffset+5 && string(head[tarMagicOffset:tarMagicOffset+5]) == "ustar" {
+		c, err := compressorByName("none")
+		return c, err == nil
+	}
+	return compressor{}, false
+}
+
+// isZipArchive reports whether head starts like a zip file
+func isZipArchive(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04"))
+}
+
+// compressorByName returns the compressor selected with --compress
+func compressorByName(name string) (compressor, error) {
+	for _, c := range compressors {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return compressor{}, fmt.Errorf("unsupported compression: %s", name)
+}
+
+// compressorForPath picks the compressor matching the archive's file extension, defaulting to gzip
+func compressorForPath(path string) compressor {
+	lower := strings.ToLower(path)
+	for _, c := range compressors {
+		for _, ext := range c.Extensions {
+			if strings.HasSuffix(lower, ext) {
+				return c
+			}
+		}
+	}
+	return compressors[0]
+}
+
+// resolveCompressor honours --compress-cmd, then an explicit --compress value, and falls back to the output extension
+func resolveCompressor(name, compressCmd, path string) (compressor, error) {
+	if compressCmd != "" {
+		return commandCompressor(compressCmd, "")
+	}
+	if name != "" {
+		return compressorByName(name)
+	}
+	return compressorForPath(path), nil
+}
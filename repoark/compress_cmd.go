@@ -0,0 +1,183 @@
+package repoark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// commandExtensions maps well known compressor programs to archive extensions
+var commandExtensions = map[string]string{
+	"gzip":   ".tar.gz",
+	"pigz":   ".tar.gz",
+	"xz":     ".tar.xz",
+	"pixz":   ".tar.xz",
+	"zstd":   ".tar.zst",
+	"pzstd":  ".tar.zst",
+	"bzip2":  ".tar.bz2",
+	"pbzip2": ".tar.bz2",
+	"lz4":    ".tar.lz4",
+	"brotli": ".tar.br",
+}
+
+// commandCompressor pipes the tar stream through an external program such as "xz -9 -T0"
+func commandCompressor(compressCmd, decompressCmd string) (compressor, error) {
+	var program string
+	for _, cmdline := range []string{compressCmd, decompressCmd} {
+		if cmdline == "" {
+			continue
+		}
+		args, err := splitCommandLine(cmdline)
+		if err != nil {
+			return compressor{}, err
+		}
+		if len(args) == 0 {
+			return compressor{}, fmt.Errorf("empty compressor command")
+		}
+		program = filepath.Base(args[0])
+	}
+
+	ext, ok := commandExtensions[program]
+	if !ok {
+		ext = ".tar." + program
+	}
+	return compressor{
+		Name:       program,
+		Extensions: []string{ext},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return startCommandWriter(compressCmd, w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return startCommandReader(decompressCmd, r)
+		},
+	}, nil
+}
+
+// commandWriter feeds written data to the stdin of a compressor process
+type commandWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	closed bool
+}
+
+func startCommandWriter(cmdline string, w io.Writer) (*commandWriter, error) {
+	args, err := splitCommandLine(cmdline)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting %s: %v", args[0], err)
+	}
+	return &commandWriter{cmd: cmd, stdin: stdin}, nil
+}
+
+func (c *commandWriter) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close ends the input and waits for the compressor to flush its output
+func (c *commandWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v", c.cmd.Path, err)
+	}
+	return nil
+}
+
+// commandReader reads the stdout of a decompressor process fed with the archive
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	done   bool
+}
+
+func startCommandReader(cmdline string, r io.Reader) (*commandReader, error) {
+	if cmdline == "" {
+		return nil, fmt.Errorf("archive was written with an external compressor, use --decompress-cmd to read it")
+	}
+	args, err := splitCommandLine(cmdline)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting %s: %v", args[0], err)
+	}
+	return &commandReader{cmd: cmd, stdout: stdout}, nil
+}
+
+// Read returns the decompressed data, surfacing a failed decompressor at end of stream
+func (c *commandReader) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		if waitErr := c.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("%s failed: %v", c.cmd.Path, waitErr)
+		}
+	}
+	return n, err
+}
+
+func (c *commandReader) Close() error {
+	if !c.done {
+		c.done = true
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	}
+	return nil
+}
+
+// splitCommandLine splits a command line into arguments, honouring single and double quotes
+func splitCommandLine(cmdline string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, c := range cmdline {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command: %s", cmdline)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
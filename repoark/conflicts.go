@@ -0,0 +1,79 @@
+package repoark
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// unmergedEntry is one stage of a path with merge conflicts in the git index: 1 is the common
+// ancestor, 2 our side and 3 theirs
+type unmergedEntry struct {
+	Path   string `json:"path"`
+	Mode   string `json:"mode"` // octal, as git prints it
+	Object string `json:"object"`
+	Stage  int    `json:"stage"`
+}
+
+// listUnmerged returns the unmerged index entries of the git repository at repoPath, the paths
+// left with conflicts by a merge, rebase, cherry-pick or stash pop
+func listUnmerged(repoPath string) ([]unmergedEntry, error) {
+	output, err := runGit(repoPath, nil, "ls-files", "-z", "--unmerged")
+	if err != nil {
+		return nil, fmt.Errorf("error listing conflicted files: %v", err)
+	}
+	var entries []unmergedEntry
+	for _, record := range splitNul(output) {
+		// <mode> <object> <stage>\t<path>
+		info, path, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git ls-files output %q", record)
+		}
+		stage, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git ls-files output %q", record)
+		}
+		entries = append(entries, unmergedEntry{Path: path, Mode: fields[0], Object: fields[1], Stage: stage})
+	}
+	return entries, nil
+}
+
+// conflictedPaths returns the paths of entries, each once
+func conflictedPaths(entries []unmergedEntry) []string {
+	var paths []string
+	for i, entry := range entries {
+		// ls-files lists the stages of a path one after the other
+		if i == 0 || entries[i-1].Path != entry.Path {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths
+}
+
+// restoreUnmerged puts the archived unmerged entries back into the index of the restored
+// repository when the index lacks them, so that the conflicts resolve as they would have
+// where the archive was written. The index archived with .git normally carries them already.
+func restoreUnmerged(repoPath string, archived []unmergedEntry, logger Logger) error {
+	current, err := listUnmerged(repoPath)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(current) == fmt.Sprint(archived) {
+		return nil
+	}
+	// A mode 0 entry removes every stage of the path before its archived stages are added
+	var info bytes.Buffer
+	for _, path := range conflictedPaths(archived) {
+		fmt.Fprintf(&info, "0 %s\t%s\x00", strings.Repeat("0", len(archived[0].Object)), path)
+	}
+	for _, entry := range archived {
+		fmt.Fprintf(&info, "%s %s %d\t%s\x00", entry.Mode, entry.Object, entry.Stage, entry.Path)
+	}
+	if _, err := runGit(repoPath, &info, "update-index", "-z", "--index-info"); err != nil {
+		return fmt.Errorf("error restoring the conflicts of the index: %v", err)
+	}
+	logger.Infof("Restored the merge conflicts of %d paths in the index", len(conflictedPaths(archived)))
+	return nil
+}
@@ -0,0 +1,323 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxContentDiffSize bounds the files --content renders as unified diffs
+const maxContentDiffSize = 1 << 20
+
+// diffContextLines is the number of unchanged lines shown around each change
+const diffContextLines = 3
+
+// diffArchives lists the paths added, removed and changed between two archives,
+// optionally with unified diffs of changed text files
+func diffArchives(oldPath, newPath string, content bool) error {
+	oldScan, err := scanArchive(oldPath)
+	if err != nil {
+		return err
+	}
+	newScan, err := scanArchive(newPath)
+	if err != nil {
+		return err
+	}
+
+	oldEntries := make(map[string]manifestEntry)
+	for _, entry := range oldScan.Manifest.Entries {
+		oldEntries[strings.TrimPrefix(entry.Path, oldScan.RootPrefix)] = entry
+	}
+	newEntries := make(map[string]manifestEntry)
+	for _, entry := range newScan.Manifest.Entries {
+		newEntries[strings.TrimPrefix(entry.Path, newScan.RootPrefix)] = entry
+	}
+
+	var names []string
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var added, removed, changed int
+	contentChanged := make(map[string]bool)
+	for _, name := range names {
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+		switch {
+		case !inOld:
+			added++
+			fmt.Printf("added    %s\n", name)
+		case !inNew:
+			removed++
+			fmt.Printf("removed  %s\n", name)
+		default:
+			var reasons []string
+			if oldEntry.Size != newEntry.Size {
+				reasons = append(reasons, fmt.Sprintf("size %d -> %d", oldEntry.Size, newEntry.Size))
+			}
+			if oldEntry.SHA256 != newEntry.SHA256 {
+				reasons = append(reasons, "content")
+				contentChanged[name] = true
+			}
+			if oldEntry.Mode != newEntry.Mode {
+				reasons = append(reasons, fmt.Sprintf("mode %o -> %o", oldEntry.Mode, newEntry.Mode))
+			}
+			// Whole seconds, as in isUpToDate: zip and ustar headers drop the fraction
+			if !oldEntry.ModTime.Round(time.Second).Equal(newEntry.ModTime.Round(time.Second)) {
+				reasons = append(reasons, "mtime")
+			}
+			if len(reasons) > 0 {
+				changed++
+				fmt.Printf("changed  %s (%s)\n", name, strings.Join(reasons, ", "))
+			}
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed\n", added, removed, changed)
+
+	if !content || len(contentChanged) == 0 {
+		return nil
+	}
+	oldFiles, err := readArchiveFiles(oldPath, oldScan.RootPrefix, contentChanged)
+	if err != nil {
+		return err
+	}
+	newFiles, err := readArchiveFiles(newPath, newScan.RootPrefix, contentChanged)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !contentChanged[name] {
+			continue
+		}
+		oldData, newData := oldFiles[name], newFiles[name]
+		switch {
+		case oldData == nil || newData == nil:
+			fmt.Printf("\n%s: too large for a content diff\n", name)
+		case isBinary(oldData) || isBinary(newData):
+			fmt.Printf("\nBinary files a/%s and b/%s differ\n", name, name)
+		default:
+			fmt.Println()
+			writeUnifiedDiff(os.Stdout, name, splitLines(string(oldData)), splitLines(string(newData)))
+		}
+	}
+	return nil
+}
+
+// readArchiveFiles returns the content of the named files of an archive, skipping files over maxContentDiffSize
+func readArchiveFiles(archivePath, rootPrefix string, names map[string]bool) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	keep := func(name string, size int64, r io.Reader) error {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "./"), rootPrefix)
+		if !names[name] || size > maxContentDiffSize {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("error reading %s from %s: %v", name, archivePath, err)
+		}
+		files[name] = data
+		return nil
+	}
+
+	if zr, err := zip.OpenReader(archivePath); err == nil {
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !names[strings.TrimPrefix(f.Name, rootPrefix)] {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = keep(f.Name, int64(f.UncompressedSize64), r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := keep(header.Name, header.Size, ar); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isBinary uses git's heuristic: a NUL byte in the first 8000 bytes
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// splitLines splits text into lines, keeping the line terminators
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes a line based edit script from a longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// Trim the common prefix and suffix, which keeps the table small for typical edits
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	lcs := make([][]int32, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i]})
+			i++
+			j++
+		case i < len(midA) && (j == len(midB) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', midA[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// writeUnifiedDiff prints the changes between a and b in unified diff format
+func writeUnifiedDiff(w io.Writer, name string, a, b []string) {
+	if int64(len(a))*int64(len(b)) > 50_000_000 {
+		fmt.Fprintf(w, "%s: too many lines for a content diff\n", name)
+		return
+	}
+	ops := diffLines(a, b)
+	fmt.Fprintf(w, "--- a/%s\n+++ b/%s\n", name, name)
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk until a run of unchanged lines is long enough to separate hunks
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				break
+			}
+			end = run
+		}
+		from := max(start-diffContextLines, 0)
+		to := min(end+diffContextLines, len(ops))
+
+		// Line numbers of the hunk start in a and b
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, op := range ops[from:to] {
+			line := op.line
+			fmt.Fprintf(w, "%c%s", op.kind, line)
+			if !strings.HasSuffix(line, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package repoark
+
+// freeSpace cannot determine the free space on this platform
+func freeSpace(path string) (free int64, ok bool) {
+	return 0, false
+}
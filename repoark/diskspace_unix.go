@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package repoark
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to unprivileged users on the filesystem holding path,
+// ok is false when it cannot be determined
+func freeSpace(path string) (free int64, ok bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), true
+}
@@ -0,0 +1,157 @@
+package repoark
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dryRunRestore reports what restoring tarReader into repoPath would do without touching the filesystem
+func dryRunRestore(repoPath string, tarReader *archiveReader, opts RestoreOptions) error {
+	exists := true
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		exists = false
+	} else if err != nil {
+		return fmt.Errorf("error accessing repository directory: %v", err)
+	}
+	if exists {
+		var err error
+		if repoPath, err = canonicalRoot(repoPath, opts.NoDereferenceRoot); err != nil {
+			return fmt.Errorf("error resolving repository directory: %v", err)
+		}
+	}
+
+	extractedPaths := make(map[string]string) // by pathKey
+	var index, config []byte
+	created, overwritten, skipped, deleted, refused := 0, 0, 0, 0, 0
+	guard := newRestoreGuard(repoPath, opts.TrustArchive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeFifo) || isMetaEntry(header.Name) || !opts.Paths.matches(header.Name) ||
+			!opts.Annotations.matches(entryAnnotations(header)) {
+			continue
+		}
+		targetPath, err := guard.target(header.Name)
+		if err == nil && header.Typeflag == tar.TypeSymlink && guard.leavesRoot(header.Name, header.Linkname) {
+			if link, linkErr := os.Readlink(targetPath); linkErr != nil || link != filepath.FromSlash(header.Linkname) {
+				err = fmt.Errorf("symlink leads outside the target directory")
+			}
+		}
+		if err != nil {
+			fmt.Printf("%-9s %s\n", "refuse", header.Name)
+			refused++
+			continue
+		}
+		extractedPaths[pathKey(header.Name)] = header.Name
+
+		// The archived index and config decide which files the cleanup step would delete
+		switch header.Name {
+		case ".git/index":
+			if index, err = io.ReadAll(tarReader); err != nil {
+				return err
+			}
+		case ".git/config":
+			if config, err = io.ReadAll(tarReader); err != nil {
+				return err
+			}
+		}
+
+		action := "create"
+		if header.Typeflag == tar.TypeSymlink {
+			if _, err := os.Lstat(targetPath); err == nil {
+				action = "overwrite"
+				if link, err := os.Readlink(targetPath); err == nil && link == filepath.FromSlash(header.Linkname) {
+					action = "skip"
+				}
+			}
+		} else if header.Typeflag == tar.TypeFifo {
+			if stat, err := os.Lstat(targetPath); err == nil {
+				action = "overwrite"
+				if stat.Mode()&os.ModeNamedPipe != 0 {
+					action = "skip"
+				}
+			}
+		} else if stat, err := os.Stat(targetPath); err == nil {
+			action = "overwrite"
+			if isUpToDate(stat, header) {
+				action = "skip"
+			}
+		}
+		switch action {
+		case "create":
+			created++
+		case "overwrite":
+			overwritten++
+		case "skip":
+			skipped++
+		}
+		fmt.Printf("%-9s %s\n", action, header.Name)
+	}
+
+	// A partial restore leaves everything else alone, a new directory has nothing to clean up
+	if len(opts.Paths) == 0 && len(opts.Annotations) == 0 && exists {
+		untracked, err := untrackedAfterRestore(repoPath, index, config)
+		if err != nil {
+			return err
+		}
+		for _, entry := range untracked {
+			if name, ok := extractedPaths[pathKey(entry)]; ok && isSamePath(repoPath, entry, name) {
+				continue
+			}
+			fmt.Printf("%-9s %s\n", "delete", entry)
+			deleted++
+		}
+	}
+
+	if refused > 0 {
+		opts.Logger.Warnf("%d entries lead outside %s and would be refused, use --trust-archive to restore them anyway", refused, repoPath)
+	}
+	opts.Logger.Infof("Dry run: %d to create, %d to overwrite, %d to skip, %d to delete, nothing was changed",
+		created, overwritten, skipped, deleted)
+	return nil
+}
+
+// untrackedAfterRestore lists the files of repoPath that git would report as untracked once the
+// archived index is in place, which is what the cleanup step of a restore removes
+func untrackedAfterRestore(repoPath string, index, config []byte) ([]string, error) {
+	gitDir, args := repoPath, []string{"ls-files", "-z", "--others", "--exclude-standard"}
+	if index != nil {
+		// Ask git with the archived index through a scratch repository, leaving the target untouched
+		scratch, err := os.MkdirTemp("", "repoark-dryrun-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(scratch)
+		initArgs := []string{"init", "-q"}
+		if sha256ObjectFormat.Match(config) {
+			initArgs = append(initArgs, "--object-format=sha256")
+		}
+		initArgs = append(initArgs, "--", scratch)
+		if _, err := runGit("", nil, initArgs...); err != nil {
+			return nil, fmt.Errorf("error preparing dry run: %v", err)
+		}
+		indexFile := filepath.Join(scratch, ".git", "index")
+		if err := os.WriteFile(indexFile, index, 0644); err != nil {
+			return nil, err
+		}
+		gitDir = ""
+		args = append([]string{"--git-dir", filepath.Join(scratch, ".git"), "--work-tree", repoPath}, args...)
+	} else if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		// Neither the archive nor the target is a git repository, restore would fail before cleaning up
+		return nil, nil
+	}
+
+	output, err := runGit(gitDir, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in %s: %v", repoPath, err)
+	}
+	return splitNul(output), nil
+}
@@ -0,0 +1,287 @@
+package repoark
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/term"
+)
+
+// ageExtension is appended to the names of encrypted archives
+const ageExtension = ".age"
+
+// ageIdentityEnv names an age identity file, or holds an AGE-SECRET-KEY-1... key itself,
+// used to decrypt archives when no --identity is given
+const ageIdentityEnv = "REPOARK_AGE_IDENTITY"
+
+// passwordEnv holds the password of password encrypted archives, which is asked for otherwise
+const passwordEnv = "REPOARK_PASSWORD"
+
+// ageHeader starts every binary age file, ageArmorHeader the ASCII armored ones
+var (
+	ageHeader      = []byte("age-encryption.org/")
+	ageArmorHeader = []byte(armor.Header)
+)
+
+// stringList is a repeatable command-line flag collecting its values in order
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseAgeRecipients turns --encrypt-recipient values into recipients: age1... public keys,
+// SSH public keys, or the path of a file listing either, one per line
+func parseAgeRecipients(values []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, value := range values {
+		if strings.HasPrefix(value, "age1") || strings.HasPrefix(value, "ssh-") {
+			recipient, err := parseAgeRecipient(value)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither an age recipient nor a readable recipients file: %v", value, err)
+		}
+		for lineNo, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			recipient, err := parseAgeRecipient(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", value, lineNo+1, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients, nil
+}
+
+// parseAgeRecipient parses one age or SSH public key
+func parseAgeRecipient(text string) (age.Recipient, error) {
+	if strings.HasPrefix(text, "ssh-") {
+		recipient, err := agessh.ParseRecipient(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH recipient: %v", err)
+		}
+		return recipient, nil
+	}
+	recipient, err := age.ParseX25519Recipient(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient %q: %v", text, err)
+	}
+	return recipient, nil
+}
+
+// passwordRecipients returns the recipient encrypting an archive with a password, taken from
+// REPOARK_PASSWORD or asked for twice on the terminal
+func passwordRecipients() ([]age.Recipient, error) {
+	password, err := readPassword("Archive password: ", true)
+	if err != nil {
+		return nil, err
+	}
+	// age derives the key with scrypt and encrypts with ChaCha20-Poly1305
+	recipient, err := age.NewScryptRecipient(password)
+	if err != nil {
+		return nil, err
+	}
+	return []age.Recipient{recipient}, nil
+}
+
+// readPassword returns REPOARK_PASSWORD, a secret:<name> in it read from the secret store,
+// or reads a password from the terminal
+func readPassword(prompt string, confirm bool) (string, error) {
+	if password := os.Getenv(passwordEnv); password != "" {
+		return resolveSecret(password)
+	}
+	return askPassword(prompt, confirm, passwordEnv)
+}
+
+// passwordPrompt serializes terminal prompts, restore-all decrypts several archives at once
+var passwordPrompt sync.Mutex
+
+// askPassword reads a password from the terminal without echoing it, env names the variable
+// to set instead when there is none. The terminal is opened directly, stdin and stdout may
+// carry the archive.
+func askPassword(prompt string, confirm bool, env string) (string, error) {
+	passwordPrompt.Lock()
+	defer passwordPrompt.Unlock()
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !isTerminal(os.Stdin) {
+			return "", fmt.Errorf("no terminal to ask for the password, set %s", env)
+		}
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error reading password: %v", err)
+		}
+		return string(password), nil
+	}
+
+	password, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	if confirm {
+		again, err := read("Repeat the password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", fmt.Errorf("the passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+// passwordIdentity decrypts password encrypted archives, asking for the password only when
+// the archive is one
+type passwordIdentity struct {
+	archive string // named in the prompt, "-" for stdin
+}
+
+func (p passwordIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	if len(stanzas) != 1 || stanzas[0].Type != "scrypt" {
+		return nil, age.ErrIncorrectIdentity
+	}
+	prompt := "Archive password: "
+	if p.archive != "-" {
+		prompt = fmt.Sprintf("Password of %s: ", filepath.Base(p.archive))
+	}
+	password, err := readPassword(prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(password)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := identity.Unwrap(stanzas)
+	if errors.Is(err, age.ErrIncorrectIdentity) {
+		return nil, fmt.Errorf("wrong password")
+	}
+	return fileKey, err
+}
+
+// loadAgeIdentities reads the identity files given with --identity, or the one named by
+// REPOARK_AGE_IDENTITY. Both accept age identity files and unencrypted SSH private keys.
+// There are none when neither is set.
+func loadAgeIdentities(files []string) ([]age.Identity, error) {
+	if len(files) == 0 {
+		env := strings.TrimSpace(os.Getenv(ageIdentityEnv))
+		if env == "" {
+			return nil, nil
+		}
+		if strings.HasPrefix(env, "AGE-SECRET-KEY-") {
+			return age.ParseIdentities(strings.NewReader(env))
+		}
+		files = []string{env}
+	}
+	var identities []age.Identity
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading identity: %v", err)
+		}
+		if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			identity, err := agessh.ParseIdentity(data)
+			if err != nil {
+				return nil, fmt.Errorf("error reading SSH identity %s: %v", file, err)
+			}
+			identities = append(identities, identity)
+			continue
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading identity %s: %v", file, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
+
+// isAgeEncrypted reports whether the archive stream starting with head is age encrypted
+func isAgeEncrypted(head []byte) bool {
+	return bytes.HasPrefix(head, ageHeader) || bytes.HasPrefix(head, ageArmorHeader)
+}
+
+// decryptArchiveStream returns the plaintext of the age encrypted stream r of archiveName
+func decryptArchiveStream(r *bufio.Reader, archiveName string, identityFiles []string) (*bufio.Reader, error) {
+	keys, err := loadAgeIdentities(identityFiles)
+	if err != nil {
+		return nil, err
+	}
+	identities := append([]age.Identity{passwordIdentity{archive: archiveName}}, keys...)
+	var ciphertext io.Reader = r
+	if head, _ := r.Peek(len(ageArmorHeader)); bytes.Equal(head, ageArmorHeader) {
+		ciphertext = armor.NewReader(r)
+	}
+	plaintext, err := age.Decrypt(ciphertext, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) && len(keys) == 0 {
+		return nil, fmt.Errorf("the archive is encrypted, pass --identity <file> or set %s", ageIdentityEnv)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting archive: %v", err)
+	}
+	return bufio.NewReader(plaintext), nil
+}
+
+// encryptedWriter encrypts the archive stream into the archive file; Close finishes the
+// encryption before it closes the file
+type encryptedWriter struct {
+	io.WriteCloser // age encryption writer
+	file           io.WriteCloser
+	closed         bool
+}
+
+// newEncryptedWriter encrypts what is written to it for recipients into file
+func newEncryptedWriter(file io.WriteCloser, recipients []age.Recipient) (*encryptedWriter, error) {
+	w, err := age.Encrypt(file, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("error starting encryption: %v", err)
+	}
+	return &encryptedWriter{WriteCloser: w, file: file}, nil
+}
+
+func (w *encryptedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.WriteCloser.Close(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error finishing encryption: %v", err)
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,107 @@
+package repoark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// executableEntry is an executable file brought in by a restore
+type executableEntry struct {
+	Path   string // slash separated, relative to the repository root
+	Mode   os.FileMode
+	Reason string
+}
+
+// isExecutableMode reports whether any execute bit is set in a tar header mode
+func isExecutableMode(mode int64) bool {
+	return mode&0111 != 0
+}
+
+// trackedExecutables returns the paths git records with mode 100755, including submodules
+func trackedExecutables(repoPath string) map[string]bool {
+	tracked := make(map[string]bool)
+	output, err := runGit(repoPath, nil, "ls-files", "-z", "--stage", "--recurse-submodules")
+	if err != nil {
+		return tracked
+	}
+	for _, record := range splitNul(output) {
+		// <mode> <object> <stage>\t<path>
+		meta, entryPath, found := strings.Cut(record, "\t")
+		if found && strings.HasPrefix(meta, "100755 ") {
+			tracked[entryPath] = true
+		}
+	}
+	return tracked
+}
+
+// unexpectedExecutables filters the restored executables down to the ones not tracked as
+// executables by git. Inactive *.sample hooks created by git init are not reported.
+func unexpectedExecutables(repoPath string, restored []executableEntry) []executableEntry {
+	tracked := trackedExecutables(repoPath)
+	var unexpected []executableEntry
+	for _, entry := range restored {
+		if tracked[entry.Path] {
+			continue
+		}
+		dir, name := path.Split(entry.Path)
+		switch {
+		case strings.HasSuffix(dir, ".git/hooks/") && strings.HasSuffix(name, ".sample"):
+			continue
+		case strings.HasSuffix(dir, ".git/hooks/"):
+			entry.Reason = "active git hook"
+		case strings.HasPrefix(entry.Path, ".git/") || strings.Contains(entry.Path, "/.git/"):
+			entry.Reason = "executable inside .git"
+		default:
+			entry.Reason = "untracked executable"
+		}
+		unexpected = append(unexpected, entry)
+	}
+	sort.Slice(unexpected, func(i, j int) bool {
+		return unexpected[i].Path < unexpected[j].Path
+	})
+	return unexpected
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file's content
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// printExecutableReport lists the executables a restore brought in that git does not expect
+func printExecutableReport(w io.Writer, repoPath string, restored []executableEntry) error {
+	unexpected := unexpectedExecutables(repoPath, restored)
+	if len(unexpected) == 0 {
+		fmt.Fprintln(w, "No unexpected executables were restored")
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d unexpected executable(s) were restored, review them before running git commands:\n", len(unexpected))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHA256\tMODE\tREASON\tPATH")
+	for _, entry := range unexpected {
+		sum, err := fileSHA256(filepath.Join(repoPath, entry.Path))
+		if err != nil {
+			sum = "unreadable: " + err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", sum, entry.Mode.Perm(), entry.Reason, entry.Path)
+	}
+	return tw.Flush()
+}
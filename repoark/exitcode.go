@@ -0,0 +1,44 @@
+package repoark
+
+import "errors"
+
+// Exit codes, so that scripts can tell failures apart
+const (
+	exitUsage         = 1 // invalid command line
+	exitNotRepository = 2 // the path is not a git (or Mercurial) repository
+	exitPartial       = 3 // finished, but some files or repositories failed
+	exitCorrupt       = 4 // the archive is damaged or truncated
+	exitFailure       = 5 // any other error
+	exitMismatch      = 6 // verify found differences between the repository and the archive
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	Code int
+	Err  error
+}
+
+func (e *exitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.Err
+}
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	var partial *partialError
+	if errors.As(err, &partial) {
+		return exitPartial
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var archiveErr *archiveError
+	if errors.As(err, &archiveErr) {
+		return exitCorrupt
+	}
+	return exitFailure
+}
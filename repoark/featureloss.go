@@ -0,0 +1,127 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// featureLoss collects the file attributes an archive cannot represent, so they are
+// reported when the archive is written instead of being discovered at restore time
+type featureLoss struct {
+	symlinkCopies  []string // symlinks to files, stored as copies of their targets with --dereference
+	symlinkDirs    []string // symlinks to directories, not archived with --dereference
+	brokenSymlinks []string // symlinks without a target, not archived with --dereference
+	outsideLinks   []string // symlinks pointing out of the repository, which restore refuses by default
+	xattrs         []string // files with extended attributes, which are not archived
+	xattrExample   string
+	xattrsStored   bool     // --xattrs with a tar archive, extended attributes are archived
+	specialFiles   []string // sockets, FIFOs without --fifos and device nodes, not archived
+	specialExample string
+	zipBirthTimes  bool // --birthtime with a zip archive
+	zipAnnotations bool // a .repoarkattributes file with a zip archive
+}
+
+// ignoredXattrs are set by the system on nearly every file and are not worth reporting
+var ignoredXattrs = map[string]bool{
+	"security.selinux":          true,
+	"com.apple.provenance":      true,
+	"com.apple.quarantine":      true,
+	"com.apple.lastuseddate#PS": true,
+}
+
+// checkSymlink records path if it is a symlink and reports whether its target is archived
+func (l *featureLoss) checkSymlink(path, archivePath string, target os.FileInfo, targetErr error) {
+	if l == nil {
+		return
+	}
+	link, err := os.Lstat(path)
+	if err != nil || link.Mode()&os.ModeSymlink == 0 {
+		return
+	}
+	switch {
+	case targetErr != nil:
+		l.brokenSymlinks = append(l.brokenSymlinks, archivePath)
+	case target.IsDir():
+		l.symlinkDirs = append(l.symlinkDirs, archivePath)
+	default:
+		l.symlinkCopies = append(l.symlinkCopies, archivePath)
+	}
+}
+
+// checkSymlinkTarget records the symlink archived as archivePath if it points out of the repository
+func (l *featureLoss) checkSymlinkTarget(archivePath, target string) {
+	if l != nil && symlinkLeavesRoot(archivePath, target) {
+		l.outsideLinks = append(l.outsideLinks, archivePath)
+	}
+}
+
+// checkXattrs records path if it carries extended attributes
+func (l *featureLoss) checkXattrs(path, archivePath string) {
+	if l == nil || l.xattrsStored {
+		return
+	}
+	for _, name := range listXattrs(path) {
+		if ignoredXattrs[name] {
+			continue
+		}
+		if len(l.xattrs) == 0 {
+			l.xattrExample = fmt.Sprintf("%s: %s", archivePath, name)
+		}
+		l.xattrs = append(l.xattrs, archivePath)
+		return
+	}
+}
+
+// checkSpecial records the special file archived as archivePath, which is left out
+func (l *featureLoss) checkSpecial(archivePath, kind string) {
+	if l == nil {
+		return
+	}
+	if len(l.specialFiles) == 0 {
+		l.specialExample = fmt.Sprintf("%s: %s", archivePath, kind)
+	}
+	l.specialFiles = append(l.specialFiles, archivePath)
+}
+
+// warnings describes everything the archive could not store, one line per kind of loss
+func (l *featureLoss) warnings() []string {
+	var warnings []string
+	describe := func(paths []string, singular, plural string) {
+		if len(paths) == 0 {
+			return
+		}
+		sort.Strings(paths)
+		what := plural
+		if len(paths) == 1 {
+			what = singular
+		}
+		warnings = append(warnings, fmt.Sprintf("%d %s (e.g. %s)", len(paths), what, paths[0]))
+	}
+	describe(l.symlinkCopies, "symlink is stored as a copy of its target", "symlinks are stored as copies of their targets")
+	describe(l.symlinkDirs, "symlink to a directory is not archived", "symlinks to directories are not archived")
+	describe(l.brokenSymlinks, "broken symlink is not archived", "broken symlinks are not archived")
+	describe(l.outsideLinks, "symlink points outside the repository, restore recreates it only with --trust-archive",
+		"symlinks point outside the repository, restore recreates them only with --trust-archive")
+	if len(l.xattrs) > 0 {
+		what := "files have extended attributes, which are only archived with --xattrs in tar archives"
+		if len(l.xattrs) == 1 {
+			what = "file has extended attributes, which are only archived with --xattrs in tar archives"
+		}
+		warnings = append(warnings, fmt.Sprintf("%d %s (e.g. %s)", len(l.xattrs), what, l.xattrExample))
+	}
+	if len(l.specialFiles) > 0 {
+		what := "sockets, FIFOs or device nodes are not archived, FIFOs only with --fifos"
+		if len(l.specialFiles) == 1 {
+			what = "socket, FIFO or device node is not archived, FIFOs only with --fifos"
+		}
+		warnings = append(warnings, fmt.Sprintf("%d %s (e.g. %s)", len(l.specialFiles), what, l.specialExample))
+	}
+	if l.zipBirthTimes {
+		warnings = append(warnings, "zip archives cannot store creation times, --birthtime has no effect")
+	}
+	if l.zipAnnotations {
+		warnings = append(warnings, "zip archives cannot store annotations, "+annotationsFile+" has no effect")
+	}
+	return warnings
+}
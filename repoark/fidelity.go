@@ -0,0 +1,81 @@
+package repoark
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// fidelityEntry is a restored or skipped entry and the header it was restored from
+type fidelityEntry struct {
+	Target string // where the entry was restored
+	Header tar.Header
+}
+
+// fidelityDiff is one attribute of a restored entry that differs from the archive
+type fidelityDiff struct {
+	What, Archived, Restored, Path string
+}
+
+// fidelityModeBits are the parts of a mode restore sets
+const fidelityModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// fidelityDiffs compares the mode, modification time and, with --preserve-owner, owner of every
+// entry on disk with the archived values. Restore does not set the mode or time of symlinks,
+// which most platforms cannot change, so only their owner is compared.
+func fidelityDiffs(entries []fidelityEntry, opts RestoreOptions) []fidelityDiff {
+	var diffs []fidelityDiff
+	for _, entry := range entries {
+		header := &entry.Header
+		info, err := os.Lstat(entry.Target)
+		if err != nil {
+			diffs = append(diffs, fidelityDiff{"file", "present", "missing", header.Name})
+			continue
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			archived, restored := header.FileInfo().Mode(), info.Mode()
+			if archived&fidelityModeBits != restored&fidelityModeBits {
+				diffs = append(diffs, fidelityDiff{"mode", archived.String(), restored.String(), header.Name})
+			}
+			if !info.ModTime().Equal(header.ModTime) {
+				diffs = append(diffs, fidelityDiff{"mtime", formatFidelityTime(header.ModTime), formatFidelityTime(info.ModTime()), header.Name})
+			}
+		}
+		if uid, gid, ok, err := restoredOwner(header, opts); err == nil && ok {
+			if restoredUID, restoredGID, known := fileOwner(info); known && (restoredUID != uid || restoredGID != gid) {
+				diffs = append(diffs, fidelityDiff{"owner", fmt.Sprintf("%d:%d", uid, gid), fmt.Sprintf("%d:%d", restoredUID, restoredGID), header.Name})
+			}
+		}
+	}
+	return diffs
+}
+
+// formatFidelityTime shows a modification time with the nanoseconds, which is often all that differs
+func formatFidelityTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05.000000000")
+}
+
+// printFidelityReport lists the entries whose mode, modification time or owner on disk differ
+// from the archive, because the filesystem or platform cannot represent them or setting them failed
+func printFidelityReport(w io.Writer, entries []fidelityEntry, opts RestoreOptions) error {
+	diffs := fidelityDiffs(entries, opts)
+	if len(diffs) == 0 {
+		what := "mode and modification time"
+		if opts.PreserveOwner {
+			what = "mode, modification time and owner"
+		}
+		fmt.Fprintf(w, "All %d restored entries have the archived %s\n", len(entries), what)
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d attribute(s) differ from the archive after the restore:\n", len(diffs))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ATTRIBUTE\tARCHIVED\tRESTORED\tPATH")
+	for _, diff := range diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", diff.What, diff.Archived, diff.Restored, diff.Path)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,94 @@
+package repoark
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fsProfiles are the accepted --fs-profile values, the first one is the default
+var fsProfiles = []string{"auto", "local", "network"}
+
+// networkReadSize is the read size used on network filesystems, where every read is a round trip
+const networkReadSize = 1 << 20
+
+// staleRetries bounds how often a file is reopened after ESTALE
+const staleRetries = 3
+
+// resolveFSProfile validates profile and turns auto into local or network for the filesystem holding path
+func resolveFSProfile(profile, path string, logger Logger) (string, error) {
+	switch profile {
+	case "", "auto":
+		if fsType, remote := networkFilesystem(path); remote {
+			logger.Infof("%s is on a network filesystem (%s), using the network profile", path, fsType)
+			return "network", nil
+		}
+		return "local", nil
+	case "local", "network":
+		return profile, nil
+	}
+	return "", fmt.Errorf("unsupported filesystem profile %s, expected one of %s", profile, strings.Join(fsProfiles, ", "))
+}
+
+// isUNCPath reports whether path is a Windows network share path such as \\server\share
+func isUNCPath(path string) bool {
+	return strings.HasPrefix(filepath.VolumeName(path), `\\`)
+}
+
+// isStale reports whether err is a stale NFS file handle, which reopening the file resolves
+func isStale(err error) bool {
+	return errors.Is(err, syscall.ESTALE)
+}
+
+// openRetryingStale opens path, retrying with a short backoff while the server reports a stale handle
+func openRetryingStale(path string) (*os.File, error) {
+	for attempt := 0; ; attempt++ {
+		file, err := os.Open(path)
+		if err == nil || !isStale(err) || attempt == staleRetries {
+			return file, err
+		}
+		time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+	}
+}
+
+// staleRetryReader reads a file and, when the handle goes stale mid-read, reopens it and continues at the same offset
+type staleRetryReader struct {
+	file     *os.File
+	path     string
+	offset   int64
+	attempts int
+}
+
+func (r *staleRetryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF || !isStale(err) || r.attempts == staleRetries {
+			return n, err
+		}
+		r.attempts++
+		if n > 0 {
+			return n, nil
+		}
+		file, openErr := openRetryingStale(r.path)
+		if openErr != nil {
+			return 0, err
+		}
+		if _, seekErr := file.Seek(r.offset, io.SeekStart); seekErr != nil {
+			file.Close()
+			return 0, err
+		}
+		r.file.Close()
+		r.file = file
+	}
+}
+
+// Close closes the currently open handle
+func (r *staleRetryReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package repoark
+
+import "golang.org/x/sys/unix"
+
+// networkFilesystem reports whether path is on a network filesystem, and its type
+func networkFilesystem(path string) (string, bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false
+	}
+	name := unix.ByteSliceToString(stat.Fstypename[:])
+	switch name {
+	case "nfs", "smbfs", "afpfs", "webdav", "cifs":
+		return name, true
+	}
+	return name, false
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package repoark
+
+import "golang.org/x/sys/unix"
+
+// networkMagics maps the statfs magic numbers of network filesystems to their names
+var networkMagics = map[uint32]string{
+	unix.NFS_SUPER_MAGIC:  "nfs",
+	unix.SMB_SUPER_MAGIC:  "smb",
+	unix.SMB2_SUPER_MAGIC: "smb2",
+	unix.CIFS_SUPER_MAGIC: "cifs",
+	unix.CEPH_SUPER_MAGIC: "ceph",
+	unix.CODA_SUPER_MAGIC: "coda",
+}
+
+// networkFilesystem reports whether path is on a network filesystem, and its type
+func networkFilesystem(path string) (string, bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false
+	}
+	name, remote := networkMagics[uint32(stat.Type)]
+	return name, remote
+}
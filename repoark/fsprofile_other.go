@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package repoark
+
+// networkFilesystem only recognises Windows network share paths on this platform
+func networkFilesystem(path string) (string, bool) {
+	if isUNCPath(path) {
+		return "unc", true
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package repoark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gitLocationEnv are the variables git sets for hooks and aliases, and users for scripts, that
+// make git use another repository, index or object store than the one in the directory given
+// with -C. repoark may run inside a hook, so they are never passed on.
+var gitLocationEnv = []string{
+	"GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE", "GIT_OBJECT_DIRECTORY",
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES", "GIT_COMMON_DIR", "GIT_NAMESPACE", "GIT_PREFIX",
+	"GIT_INDEX_VERSION", "GIT_QUARANTINE_PATH",
+}
+
+// gitEnv returns the environment git runs in: the caller's, without the variables relocating
+// the repository, with messages in English so they read the same in every error report, no
+// credential prompts, and no optional locks, so that reading a repository never rewrites its index
+func gitEnv() []string {
+	return commandEnv("GIT_TERMINAL_PROMPT=0", "GIT_OPTIONAL_LOCKS=0")
+}
+
+// commandEnv returns the environment of the tools repoark runs (git, hg and ssh): the caller's
+// without gitLocationEnv, with LC_ALL=C and extra
+func commandEnv(extra ...string) []string {
+	env := make([]string, 0, len(os.Environ())+1+len(extra))
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		drop := false
+		for _, location := range gitLocationEnv {
+			drop = drop || name == location
+		}
+		if !drop {
+			env = append(env, kv)
+		}
+	}
+	return append(append(env, "LC_ALL=C"), extra...)
+}
+
+// gitQuotePathOff makes git print non-ASCII names as they are instead of as quoted octal escapes
+// ("\346\227\245"), which are no file names. Names with newlines still need -z.
+var gitQuotePathOff = []string{"-c", "core.quotePath=false"}
+
+// gitCommand returns the command running git with args in dir, or in the current directory
+// when dir is empty, killed when ctx expires. Paths from the repository or the user that git
+// takes as arguments must come after a "--" so that names starting with a dash are not parsed
+// as options.
+func gitCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	args = append(append(append([]string{}, gitQuotePathOff...), gitPlatformConfig...), args...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = gitEnv()
+	return cmd
+}
+
+// runGit runs git with args in dir and returns its output, with git's message on failure
+func runGit(dir string, stdin io.Reader, args ...string) ([]byte, error) {
+	ctx, done := activeDeadline.gitPhase(args)
+	defer done()
+	cmd := gitCommand(ctx, dir, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, &gitError{Command: gitSubcommand(args), Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return output, nil
+}
+
+// splitNul splits the output of a git command run with -z into its records. Names are
+// written as they are, unlike line output, which quotes names with newlines or non-ASCII
+// characters and cannot be split on newlines anyway.
+func splitNul(output []byte) []string {
+	var records []string
+	for _, record := range strings.Split(string(output), "\x00") {
+		if record != "" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// gitError is a failed git command with what git printed on stderr
+type gitError struct {
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e *gitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("git %s failed: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("git %s failed: %v: %s", e.Command, e.Err, e.Stderr)
+}
+
+func (e *gitError) Unwrap() error {
+	return e.Err
+}
+
+// gitSubcommand returns the name of the git command args run, for messages
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-c" || arg == "--git-dir" || arg == "--work-tree":
+			i++ // skip the option's value
+		case !strings.HasPrefix(arg, "-"):
+			return arg
+		}
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,91 @@
+package repoark
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// gitState is what git said about the working tree when an archive with a chunk index was
+// written. The next --reuse-from run compares it with the state then to learn which files may
+// have changed without looking at every file.
+type gitState struct {
+	Head    string   `json:"head,omitempty"` // commit checked out, empty on an unborn branch
+	Dirty   []string `json:"dirty"`          // paths git status reported as differing from HEAD
+	Options string   `json:"options"`        // options and annotation rules of the run, chunks written with others differ
+}
+
+// readGitState runs git status on the repository at repoPath. Untracked files are left out:
+// only tracked files are ever trusted to be unchanged, and listing untracked ones is the slow
+// part of git status on large trees.
+func readGitState(repoPath string, opts ArchiveOptions) (*gitState, error) {
+	state := &gitState{Options: fmt.Sprintf("%s %x", chunkOptions(opts), sha256.Sum256([]byte(fmt.Sprint(opts.Annotations))))}
+	if output, err := runGit(repoPath, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		state.Head = strings.TrimSpace(string(output))
+	}
+	output, err := runGit(repoPath, nil, "status", "--porcelain=v2", "-z", "--untracked-files=no")
+	if err != nil {
+		return nil, fmt.Errorf("error reading git status: %v", err)
+	}
+	records := splitNul(output)
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		// Ordinary, renamed and unmerged entries differ in the number of fields before the path
+		fields := map[byte]int{'1': 9, '2': 10, 'u': 11}[record[0]]
+		if fields == 0 {
+			continue
+		}
+		parts := strings.SplitN(record, " ", fields)
+		if len(parts) != fields {
+			return nil, fmt.Errorf("unexpected git status output %q", record)
+		}
+		state.Dirty = append(state.Dirty, parts[fields-1])
+		// A rename is followed by the path it was renamed from
+		if record[0] == '2' && i+1 < len(records) {
+			i++
+			state.Dirty = append(state.Dirty, records[i])
+		}
+	}
+	return state, nil
+}
+
+// unchangedSince returns the tracked files of the repository at repoPath whose content is the
+// same as when prev was recorded: clean then and now, and not changed by the commits in between.
+// It returns nil when git cannot tell, and every file is compared with the previous archive.
+func (s *gitState) unchangedSince(repoPath string, prev *gitState, logger Logger) map[string]bool {
+	if prev == nil || prev.Options != s.Options {
+		return nil
+	}
+	changed := make(map[string]bool)
+	for _, name := range append(prev.Dirty, s.Dirty...) {
+		changed[name] = true
+	}
+	if prev.Head != s.Head {
+		if prev.Head == "" || s.Head == "" {
+			return nil
+		}
+		output, err := runGit(repoPath, nil, "diff-tree", "-r", "-z", "--name-only", "--no-renames", prev.Head, s.Head)
+		if err != nil {
+			// The commit of the previous archive is gone, after a rebase and gc for instance
+			logger.Infof("git cannot compare with the commit of the previous archive, comparing every file: %v", err)
+			return nil
+		}
+		for _, name := range splitNul(output) {
+			changed[name] = true
+		}
+	}
+	// Files git is told not to look at are left to the comparison
+	output, err := runGit(repoPath, nil, "ls-files", "-z", "-v", "--cached")
+	if err != nil {
+		logger.Infof("error listing tracked files, comparing every file: %v", err)
+		return nil
+	}
+	unchanged := make(map[string]bool)
+	for _, record := range splitNul(output) {
+		tag, name, ok := strings.Cut(record, " ")
+		if ok && tag == "H" && !changed[name] {
+			unchanged[name] = true
+		}
+	}
+	return unchanged
+}
@@ -0,0 +1,154 @@
+package repoark
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// configModes are the accepted --config values, the first one is the default
+var configModes = []string{"include", "exclude", "sanitized"}
+
+// checkConfigMode validates a --config value
+func checkConfigMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, m := range configModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported config mode %s, expected one of %s", mode, strings.Join(configModes, ", "))
+}
+
+// isRootGitConfig reports whether relativePath is the config of the repository itself, not of a submodule
+func isRootGitConfig(rootDir RootDir, relativePath string) bool {
+	return rootDir.Prefix == "" && filepath.ToSlash(relativePath) == ".git/config"
+}
+
+// isGitConfigPath reports whether archivePath is the config file of a git directory,
+// either a repository's .git/config or a submodule's .git/modules/<name>/config
+func isGitConfigPath(sourcePath, archivePath string) bool {
+	archivePath = filepath.ToSlash(archivePath)
+	if path.Base(archivePath) != "config" {
+		return false
+	}
+	dir := path.Dir(archivePath)
+	if path.Base(dir) == ".git" {
+		return true
+	}
+	if !strings.Contains("/"+dir+"/", "/.git/modules/") {
+		return false
+	}
+	// Submodule names may contain a "config" directory, only a git directory has a HEAD
+	_, err := os.Stat(filepath.Join(filepath.Dir(sourcePath), "HEAD"))
+	return err == nil
+}
+
+// strippedConfigKey reports whether a config key is removed by --config=sanitized:
+// credentials, identity overrides and settings that run code from outside the repository
+func strippedConfigKey(key string) bool {
+	section, _, _ := strings.Cut(key, ".")
+	switch {
+	case section == "credential", section == "user", section == "author", section == "committer":
+		return true
+	case key == "core.hookspath", key == "core.sshcommand", key == "core.askpass":
+		return true
+	case section == "http" && strings.HasSuffix(key, ".extraheader"):
+		return true
+	}
+	return false
+}
+
+// stripURLCredentials removes a password or token embedded in a remote URL
+func stripURLCredentials(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword && u.Scheme != "https" && u.Scheme != "http" {
+		// ssh://git@host/... carries a user name only
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// sanitizeGitConfig returns the content of a git config file with credentials and user overrides stripped
+// and remotes kept. git itself rewrites a temporary copy so that the file's syntax is preserved.
+func sanitizeGitConfig(configPath string) ([]byte, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp("", "repoark-config-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	gitConfig := func(args ...string) ([]byte, error) {
+		return runGit("", nil, append([]string{"config", "--file", tmpFile.Name()}, args...)...)
+	}
+	output, err := gitConfig("--list", "--name-only")
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", configPath, err)
+	}
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if strippedConfigKey(key) {
+			if _, err := gitConfig("--unset-all", key); err != nil {
+				return nil, fmt.Errorf("error removing %s from %s: %v", key, configPath, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "remote.") && (strings.HasSuffix(key, ".url") || strings.HasSuffix(key, ".pushurl")) {
+			values, err := gitConfig("--get-all", key)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range strings.Split(strings.TrimSpace(string(values)), "\n") {
+				if clean := stripURLCredentials(value); clean != value {
+					if _, err := gitConfig("--replace-all", key, clean, "^"+regexp.QuoteMeta(value)+"$"); err != nil {
+						return nil, fmt.Errorf("error rewriting %s in %s: %v", key, configPath, err)
+					}
+				}
+			}
+		}
+	}
+	return os.ReadFile(tmpFile.Name())
+}
+
+// regenerateGitConfig writes a minimal config for a restored repository whose archive did not contain one
+func regenerateGitConfig(repoPath string, opts RestoreOptions) error {
+	gitDir := filepath.Join(repoPath, ".git")
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "config")); err == nil {
+		return nil
+	}
+	// Re-running init on an existing repository only adds what is missing
+	if _, err := runGit(repoPath, nil, "init", "-q"); err != nil {
+		return fmt.Errorf("error regenerating git config: %v", err)
+	}
+	opts.Logger.Infof("Archive has no .git/config, created a minimal one")
+	return nil
+}
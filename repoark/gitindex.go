@@ -0,0 +1,122 @@
+package repoark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// gitIndex is what repoark needs from a git index file: the tracked paths
+type gitIndex struct {
+	Paths    map[string]bool
+	Gitlinks []string                 // submodule paths, their files are tracked by the submodule's own index
+	Entries  map[string]gitIndexEntry // merged (stage 0) entries by path
+}
+
+// gitIndexEntry is the blob an index entry records and the file state git saw when staging it
+type gitIndexEntry struct {
+	Object  string // hex object name
+	Mode    uint32
+	Size    uint32 // file size, truncated to 32 bits
+	ModTime time.Time
+}
+
+// symlinkMode is the index mode of a symbolic link, whose blob is the link target
+const symlinkMode = 0120000
+
+// gitlinkMode is the index mode of a submodule entry
+const gitlinkMode = 0160000
+
+// parseGitIndex decodes the entries of a version 2, 3 or 4 git index. hashSize is 20 for
+// SHA-1 repositories and 32 for SHA-256 ones.
+func parseGitIndex(data []byte, hashSize int) (*gitIndex, error) {
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, errors.New("not a git index file")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported git index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	index := &gitIndex{Paths: make(map[string]bool, count), Entries: make(map[string]gitIndexEntry, count)}
+	truncated := errors.New("truncated git index")
+	pos := 12
+	var previous []byte
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		// ctime, mtime, dev, ino, mode, uid, gid, size, object name, flags
+		fixed := 40 + hashSize + 2
+		if pos+fixed > len(data) {
+			return nil, truncated
+		}
+		mode := binary.BigEndian.Uint32(data[pos+24 : pos+28])
+		flags := binary.BigEndian.Uint16(data[pos+40+hashSize : pos+fixed])
+		pos += fixed
+		if flags&0x4000 != 0 && version >= 3 {
+			pos += 2
+		}
+
+		var name []byte
+		if version == 4 {
+			// The name is stored as the number of bytes to drop from the previous name and a suffix
+			strip, n := indexVarint(data[min(pos, len(data)):])
+			if n == 0 || strip > len(previous) {
+				return nil, truncated
+			}
+			pos += n
+			end := bytes.IndexByte(data[min(pos, len(data)):], 0)
+			if end < 0 {
+				return nil, truncated
+			}
+			name = append(append([]byte(nil), previous[:len(previous)-strip]...), data[pos:pos+end]...)
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[min(pos, len(data)):], 0)
+			if end < 0 {
+				return nil, truncated
+			}
+			name = data[pos : pos+end]
+			// Entries are NUL padded to a multiple of 8 bytes
+			pos = start + (pos-start+end+8)&^7
+		}
+		previous = name
+
+		if mode == gitlinkMode {
+			index.Gitlinks = append(index.Gitlinks, string(name))
+		}
+		if flags&0x3000 == 0 {
+			index.Entries[string(name)] = gitIndexEntry{
+				Object: hex.EncodeToString(data[start+40 : start+40+hashSize]),
+				Mode:   mode,
+				Size:   binary.BigEndian.Uint32(data[start+36 : start+40]),
+				ModTime: time.Unix(int64(binary.BigEndian.Uint32(data[start+8:start+12])),
+					int64(binary.BigEndian.Uint32(data[start+12:start+16]))),
+			}
+		}
+		index.Paths[string(name)] = true
+	}
+	return index, nil
+}
+
+// indexVarint decodes the offset encoding of index v4 names, returning the value and its length
+func indexVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	value := int(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(data) || n > 8 {
+			return 0, 0
+		}
+		c = data[n]
+		n++
+		value = (value+1)<<7 | int(c&0x7f)
+	}
+	return value, n
+}
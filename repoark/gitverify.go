@@ -0,0 +1,261 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// autocrlfEnabled detects repositories converting line endings on checkout from their .git/config
+var autocrlfEnabled = regexp.MustCompile(`(?im)^\s*autocrlf\s*=\s*(true|input)\s*$`)
+
+// archivedBlob is an archived file hashed the way git hashes blobs
+type archivedBlob struct {
+	SHA1    string
+	SHA256  string
+	Size    int64
+	ModTime time.Time
+	Symlink bool
+}
+
+// gitBlobChecker compares the files of an archive with the blobs its git index records
+type gitBlobChecker struct {
+	files      map[string]archivedBlob
+	index      []byte
+	indexTime  time.Time
+	config     []byte
+	attributes [][]byte // .gitattributes and .git/info/attributes
+}
+
+// add hashes one file; symlinks are added with their target as content, which is what git stores
+func (c *gitBlobChecker) add(name string, size int64, modTime time.Time, symlink bool, content io.Reader) error {
+	name = strings.TrimPrefix(name, "./")
+	if name == ".git/index" || name == ".git/config" || name == ".gitattributes" || name == ".git/info/attributes" {
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", name, err)
+		}
+		switch name {
+		case ".git/index":
+			c.index, c.indexTime = data, modTime
+		case ".git/config":
+			c.config = data
+		default:
+			c.attributes = append(c.attributes, data)
+		}
+		content = bytes.NewReader(data)
+	}
+	if strings.HasPrefix(name, ".git/") || isMetaEntry(name) {
+		return nil
+	}
+
+	sha1Hash, sha256Hash := sha1.New(), sha256.New()
+	// git hashes a blob as "blob <size>\0" followed by the content
+	header := "blob " + strconv.FormatInt(size, 10) + "\x00"
+	sha1Hash.Write([]byte(header))
+	sha256Hash.Write([]byte(header))
+	if _, err := io.Copy(io.MultiWriter(sha1Hash, sha256Hash), content); err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	c.files[name] = archivedBlob{
+		SHA1:    hex.EncodeToString(sha1Hash.Sum(nil)),
+		SHA256:  hex.EncodeToString(sha256Hash.Sum(nil)),
+		Size:    size,
+		ModTime: modTime,
+		Symlink: symlink,
+	}
+	return nil
+}
+
+// convertedPaths returns the patterns of .gitattributes lines that make git transform file
+// content on checkout, whose worktree content therefore legitimately differs from the blob
+func (c *gitBlobChecker) convertedPaths() pathPatterns {
+	var patterns pathPatterns
+	for _, data := range c.attributes {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, attr := range fields[1:] {
+				if attr == "text" || strings.HasPrefix(attr, "text=") || strings.HasPrefix(attr, "eol=") ||
+					strings.HasPrefix(attr, "filter=") || strings.HasPrefix(attr, "working-tree-encoding=") {
+					pattern := strings.TrimPrefix(fields[0], "/")
+					if !strings.Contains(strings.TrimSuffix(fields[0], "/"), "/") {
+						// Like .gitignore, a pattern without a slash matches at any depth
+						pattern = "**/" + pattern
+					}
+					// An invalid pattern leaves its files to be checked
+					patterns.Set(pattern)
+					break
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// verifyAgainstGit checks every tracked file of an archive against the blob object name the
+// archived git index records for it. A file whose content differs although git saw it
+// unchanged since staging (same size and modification time) was corrupted in the work tree.
+func verifyAgainstGit(archivePath string) error {
+	checker := &gitBlobChecker{files: make(map[string]archivedBlob)}
+	if err := checker.read(archivePath); err != nil {
+		return err
+	}
+	if checker.index == nil {
+		return fmt.Errorf("%s contains no git index, --against-git needs an archive of a git repository", archivePath)
+	}
+	hashSize := 20
+	if sha256ObjectFormat.Match(checker.config) {
+		hashSize = 32
+	}
+	index, err := parseGitIndex(checker.index, hashSize)
+	if err != nil {
+		return fmt.Errorf("error reading .git/index: %v", err)
+	}
+	converted := checker.convertedPaths()
+	autocrlf := autocrlfEnabled.Match(checker.config)
+
+	var corrupt, modified []string
+	matched, filtered, absent := 0, 0, 0
+	for name, entry := range index.Entries {
+		if entry.Mode == gitlinkMode || insideAny(name, index.Gitlinks) {
+			continue
+		}
+		file, ok := checker.files[name]
+		if !ok {
+			absent++
+			continue
+		}
+		if file.Symlink != (entry.Mode == symlinkMode) {
+			// A followed symlink was archived with the content of its target
+			continue
+		}
+		object := file.SHA1
+		if hashSize == 32 {
+			object = file.SHA256
+		}
+		switch {
+		case object == entry.Object:
+			matched++
+		case autocrlf || (len(converted) > 0 && converted.matches(name)):
+			filtered++
+		case !unchangedSinceStaged(file, entry, checker.indexTime):
+			modified = append(modified, name)
+		default:
+			corrupt = append(corrupt, name)
+		}
+	}
+
+	for _, group := range []struct {
+		label string
+		names []string
+	}{{"corrupt", corrupt}, {"modified", modified}} {
+		sort.Strings(group.names)
+		for _, name := range group.names {
+			fmt.Printf("%-8s %s\n", group.label, name)
+		}
+	}
+	fmt.Printf("%d tracked files match their git blobs, %d corrupt, %d modified since staged, %d converted by git, %d not archived\n",
+		matched, len(corrupt), len(modified), filtered, absent)
+
+	if len(corrupt) > 0 {
+		return &exitError{Code: exitCorrupt, Err: fmt.Errorf("%d files in %s do not match the content git recorded for them", len(corrupt), archivePath)}
+	}
+	fmt.Printf("Archive %s matches its git index\n", archivePath)
+	return nil
+}
+
+// unchangedSinceStaged reports whether git would consider the archived file clean: it has the
+// size and modification time the index recorded, and was not modified in the same second the
+// index was written (which git cannot tell from the timestamps either). Zip archives store
+// modification times with two seconds precision, tar archives round them to the second.
+func unchangedSinceStaged(file archivedBlob, entry gitIndexEntry, indexTime time.Time) bool {
+	if uint32(file.Size) != entry.Size {
+		return false
+	}
+	if diff := file.ModTime.Sub(entry.ModTime); diff <= -2*time.Second || diff >= 2*time.Second {
+		return false
+	}
+	return indexTime.IsZero() || entry.ModTime.Before(indexTime.Truncate(time.Second))
+}
+
+// read hashes every file and symlink of a tar or zip archive
+func (c *gitBlobChecker) read(archivePath string) error {
+	if archivePath != "-" {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return fmt.Errorf("error opening archive file: %v", err)
+		}
+		head := make([]byte, 4)
+		n, _ := io.ReadFull(file, head)
+		file.Close()
+		if isZipArchive(head[:n]) {
+			return c.readZip(archivePath)
+		}
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			err = c.add(header.Name, header.Size, header.ModTime, false, ar)
+		case tar.TypeSymlink:
+			err = c.add(header.Name, int64(len(header.Linkname)), header.ModTime, true, strings.NewReader(header.Linkname))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readZip is read for zip files, which store a symlink's target as its content
+func (c *gitBlobChecker) readZip(archivePath string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() && f.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		content, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", f.Name, err)
+		}
+		err = c.add(f.Name, int64(f.UncompressedSize64), f.Modified, f.Mode()&os.ModeSymlink != 0, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
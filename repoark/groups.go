@@ -0,0 +1,303 @@
+package repoark
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repoGroup is a named set of repositories, operated on together as @name
+type repoGroup struct {
+	Name   string
+	Repos  []string
+	Policy map[string]string // archive flag name (or "output") to value
+}
+
+// groupLine matches `group "name" = [...]` and `group "name" key = value`
+var groupLine = regexp.MustCompile(`^group\s+("(?:[^"\\]|\\.)*")\s*([\w-]*)\s*=\s*(.*)$`)
+
+// configPath returns the location of the repoark config file, REPOARK_CONFIG overrides the default
+func configPath() (string, error) {
+	if path := os.Getenv("REPOARK_CONFIG"); path != "" {
+		return path, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "repoark", "config"), nil
+}
+
+// loadGroup returns the group called name from the config file
+func loadGroup(name string) (*repoGroup, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, fmt.Errorf("error locating config file: %v", err)
+	}
+	groups, err := parseGroups(path)
+	if err != nil {
+		return nil, err
+	}
+	group, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("group %q is not defined in %s", name, path)
+	}
+	if len(group.Repos) == 0 {
+		return nil, fmt.Errorf("group %q in %s has no repositories", name, path)
+	}
+	return group, nil
+}
+
+// parseGroups reads the group definitions of the config file at path; a missing file defines none
+func parseGroups(path string) (map[string]*repoGroup, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return map[string]*repoGroup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	defer file.Close()
+
+	groups := make(map[string]*repoGroup)
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := groupLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%s:%d: expected `group \"name\" = [repos]` or `group \"name\" key = value`", path, lineNo)
+		}
+		name, err := strconv.Unquote(m[1])
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("%s:%d: invalid group name %s", path, lineNo, m[1])
+		}
+		group := groups[name]
+		if group == nil {
+			group = &repoGroup{Name: name, Policy: make(map[string]string)}
+			groups[name] = group
+		}
+
+		key, value := m[2], strings.TrimSpace(m[3])
+		if key != "" {
+			group.Policy[key] = value
+			if key == "output" || key == "catalog" {
+				group.Policy[key] = expandHome(value)
+			}
+			continue
+		}
+
+		// The repository list may continue over several lines until the closing bracket
+		start := lineNo
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && scanner.Scan() {
+			lineNo++
+			value += "," + strings.TrimSpace(scanner.Text())
+		}
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("%s:%d: repositories of group %q must be a [bracketed, list]", path, start, name)
+		}
+		for _, repo := range strings.Split(value[1:len(value)-1], ",") {
+			repo = strings.TrimSpace(repo)
+			if unquoted, err := strconv.Unquote(repo); err == nil {
+				repo = unquoted
+			}
+			if repo != "" {
+				group.Repos = append(group.Repos, expandHome(repo))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	return groups, nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// applyPolicy sets the archive flags named by the group policy, unless they were given on the command line
+func (g *repoGroup) applyPolicy(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(g.Policy))
+	for key := range g.Policy {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "output" || explicit[key] {
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("group %q: unknown policy %q", g.Name, key)
+		}
+		// Values such as passwords and tokens may be kept in the secret store as secret:<name>
+		value, err := resolveSecret(g.Policy[key])
+		if err != nil {
+			return fmt.Errorf("group %q: %s: %v", g.Name, key, err)
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("group %q: invalid %s %q: %v", g.Name, key, g.Policy[key], err)
+		}
+	}
+	return nil
+}
+
+// archiveGroup archives every repository of group into outputDir, carrying on past failures
+func archiveGroup(group *repoGroup, outputDir string, opts ArchiveOptions) error {
+	if outputDir == "-" {
+		return fmt.Errorf("the repositories of a group cannot be written to stdout")
+	}
+	ext, err := archiveExtension(opts)
+	if err != nil {
+		return err
+	}
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %v", err)
+		}
+	}
+
+	opts = opts.withDefaults()
+	failed := 0
+	var missed []missedEntry
+	for _, repo := range group.Repos {
+		if !opts.JSON {
+			opts.Logger.Infof("==> %s", repo)
+		}
+		outputFile, err := archiveOutputName(outputDir, repo, ext, opts)
+		if err == nil {
+			err = archiveGitRepo(repo, outputFile, opts)
+		}
+		if err != nil {
+			if !opts.JSON {
+				opts.Logger.Warnf("%s: %v", repo, err)
+			}
+			failed++
+			// A repository archived without some files is missed file by file
+			if files := missedIn(err); files != nil {
+				for _, file := range files {
+					missed = append(missed, missedEntry{Path: filepath.Join(repo, file.Path), Reason: file.Reason})
+				}
+			} else {
+				missed = append(missed, missedEntry{Path: repo, Reason: err.Error()})
+			}
+		}
+	}
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d repositories in @%s failed", failed, len(group.Repos), group.Name)
+		if failed < len(group.Repos) {
+			return newPartialError("archive-group", missed, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// verifyGroup verifies every repository of group against its newest archive, reporting to out
+func verifyGroup(group *repoGroup, archiveDir, catalogFile, nameTemplate string, out io.Writer) error {
+	failed := 0
+	for _, repo := range group.Repos {
+		fmt.Fprintf(out, "==> %s\n", repo)
+		archivePath, err := latestArchive(repo, archiveDir, catalogFile, nameTemplate)
+		if err == nil {
+			err = verifyArchive(archivePath, repo, catalogFile, out)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repositories in @%s failed verification", failed, len(group.Repos), group.Name)
+	}
+	return nil
+}
+
+// latestArchive finds the newest archive of repoPath: from the catalog when there is one,
+// otherwise among the names create would have picked in dir
+func latestArchive(repoPath, dir, catalogFile, nameTemplate string) (string, error) {
+	var newest string
+	var newestTime time.Time
+
+	if catalogFile != "" {
+		c, err := loadCatalog(catalogFile)
+		if err != nil {
+			return "", err
+		}
+		if absRepo, err := canonicalRoot(repoPath, false); err == nil {
+			for _, record := range c.Records {
+				if record.Repo == absRepo && record.Created.After(newestTime) && archiveExists(record.Destination) {
+					newest, newestTime = record.Destination, record.Created
+				}
+			}
+		}
+		if newest != "" {
+			return newest, nil
+		}
+	}
+
+	extensions := []string{".zip"}
+	for _, c := range compressors {
+		extensions = append(extensions, c.Extensions...)
+	}
+	baseName := filepath.Base(repoPath)
+	for _, ext := range extensions {
+		for i := 0; ; i++ {
+			name := baseName + ext
+			if i > 0 {
+				name = fmt.Sprintf("%s-%d%s", baseName, i, ext)
+			}
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				info, err = os.Stat(volumePath(path, 1))
+			}
+			if err != nil {
+				break
+			}
+			if info.ModTime().After(newestTime) {
+				newest, newestTime = path, info.ModTime()
+			}
+		}
+	}
+	if nameTemplate != "" {
+		matches, _ := filepath.Glob(filepath.Join(dir, nameTemplateGlob(nameTemplate, repoPath)))
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.ModTime().After(newestTime) {
+				newest, newestTime = path, info.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		if dir == "" {
+			dir = "."
+		}
+		return "", fmt.Errorf("no archive of %s found in %s", repoPath, dir)
+	}
+	return newest, nil
+}
@@ -0,0 +1,125 @@
+package repoark
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxHealthProblems bounds how many problems of one check are reported
+const maxHealthProblems = 5
+
+// checkRepoHealth runs the quick --require-healthy checks on the git repository at repoPath
+// and returns the problems found, none for a healthy repository
+func checkRepoHealth(repoPath string) []string {
+	var problems []string
+	git := func(args ...string) (string, error) {
+		ctx, done := activeDeadline.gitPhase(args)
+		defer done()
+		var stderr bytes.Buffer
+		cmd := gitCommand(ctx, repoPath, args...)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%v: %s", err, firstLine(stderr.String()))
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if _, err := git("ls-files", "--stage"); err != nil {
+		problems = append(problems, fmt.Sprintf("the index cannot be read (%v)", err))
+	}
+	if _, err := git("fsck", "--connectivity-only", "--no-dangling", "--no-progress"); err != nil {
+		problems = append(problems, fmt.Sprintf("git fsck --connectivity-only failed (%v)", err))
+	}
+
+	objectsDir, err := git("rev-parse", "--git-path", "objects")
+	if err != nil {
+		return append(problems, fmt.Sprintf("the object directory cannot be found (%v)", err))
+	}
+	if !filepath.IsAbs(objectsDir) {
+		objectsDir = filepath.Join(repoPath, objectsDir)
+	}
+	newHash := sha1.New
+	if format, err := git("rev-parse", "--show-object-format"); err == nil && format == "sha256" {
+		newHash = sha256.New
+	}
+	return append(problems, checkLooseObjects(objectsDir, newHash)...)
+}
+
+// checkLooseObjects inflates every loose object and compares its hash with its name,
+// which is what git fsck --connectivity-only leaves out
+func checkLooseObjects(objectsDir string, newHash func() hash.Hash) []string {
+	var problems []string
+	corrupt := 0
+	dirs, _ := os.ReadDir(objectsDir)
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("error reading %s: %v", dir.Name(), err))
+			continue
+		}
+		for _, file := range files {
+			id := dir.Name() + file.Name()
+			if _, err := hex.DecodeString(id); err != nil {
+				// Temporary files of a running git command
+				continue
+			}
+			if err := checkLooseObject(filepath.Join(objectsDir, dir.Name(), file.Name()), id, newHash()); err != nil {
+				corrupt++
+				if corrupt <= maxHealthProblems {
+					problems = append(problems, fmt.Sprintf("loose object %s is corrupt (%v)", id, err))
+				}
+			}
+		}
+	}
+	if corrupt > maxHealthProblems {
+		problems = append(problems, fmt.Sprintf("%d more loose objects are corrupt", corrupt-maxHealthProblems))
+	}
+	return problems
+}
+
+// checkLooseObject verifies that the loose object at path inflates to content hashing to id
+func checkLooseObject(path, id string, h hash.Hash) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	zr, err := zlib.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if _, err := io.Copy(h, zr); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != id {
+		return fmt.Errorf("content hashes to %s", sum)
+	}
+	return nil
+}
+
+// firstLine returns the first line of command output, for short error messages
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return line
+}
+
+// quarantineName returns where the archive of an unhealthy repository is kept instead of
+// outputPath, <name>.quarantined<ext> next to it, so that it does not replace a good backup
+func quarantineName(outputPath string) string {
+	base, ext := splitArchiveExtension(filepath.Base(outputPath))
+	return findAvailableArchiveName(filepath.Dir(outputPath), base+".quarantined", ext)
+}
@@ -0,0 +1,104 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// commonHeavyDirs are directories of dependencies and build output that most oversized
+// archives owe their size to when they are left untracked but not ignored
+var commonHeavyDirs = map[string]bool{
+	"node_modules": true,
+	"target":       true,
+	".venv":        true,
+	"build":        true,
+	".gradle":      true,
+}
+
+// heavyDirHintSize is the size from which an untracked heavy directory is worth a hint
+const heavyDirHintSize = 10 << 20
+
+// heavyDirs collects the untracked directories named in commonHeavyDirs found while listing
+// the files of an archive, and leaves them out with --auto-exclude-common
+type heavyDirs struct {
+	exclude bool
+	found   map[string]*excludeCandidate // by archive path
+}
+
+func newHeavyDirs(exclude bool) *heavyDirs {
+	return &heavyDirs{exclude: exclude, found: make(map[string]*excludeCandidate)}
+}
+
+// filter records the heavy directories among the untracked files of rootDir with their size and
+// returns untracked without their files when they are excluded
+func (h *heavyDirs) filter(rootDir RootDir, untracked []string) []string {
+	if h == nil {
+		return untracked
+	}
+	kept := untracked[:0:0]
+	for _, entry := range untracked {
+		dir := heavyDirOf(entry)
+		if dir == "" {
+			kept = append(kept, entry)
+			continue
+		}
+		archivePath := filepath.ToSlash(filepath.Join(rootDir.Prefix, dir))
+		candidate := h.found[archivePath]
+		if candidate == nil {
+			candidate = &excludeCandidate{Path: archivePath, IsDir: true}
+			h.found[archivePath] = candidate
+		}
+		if info, err := os.Lstat(filepath.Join(rootDir.Dir, entry)); err == nil && info.Mode().IsRegular() {
+			candidate.Size += info.Size()
+		}
+		if !h.exclude {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// heavyDirOf returns the outermost directory of the slash separated path named in
+// commonHeavyDirs, empty when there is none
+func heavyDirOf(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts[:len(parts)-1] {
+		if commonHeavyDirs[part] {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+// report lists the heavy directories found, as excluded with --auto-exclude-common and
+// otherwise as a hint when they are large enough to matter
+func (h *heavyDirs) report(logger Logger) {
+	if h == nil {
+		return
+	}
+	var dirs []excludeCandidate
+	var total int64
+	for _, candidate := range h.found {
+		if h.exclude || candidate.Size >= heavyDirHintSize {
+			dirs = append(dirs, *candidate)
+			total += candidate.Size
+		}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Size > dirs[j].Size })
+	if h.exclude {
+		for _, dir := range dirs {
+			logger.Infof("exclude %s/ (%s)", dir.Path, formatSize(dir.Size))
+		}
+		return
+	}
+	logger.Infof("Untracked dependency or build output directories make up %s of the archive:", formatSize(total))
+	for _, dir := range dirs {
+		logger.Infof("  %10s  %s/", formatSize(dir.Size), dir.Path)
+	}
+	logger.Infof("Leave them out with --auto-exclude-common, or add them to %s", repoarkIgnoreFile)
+}
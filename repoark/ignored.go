@@ -0,0 +1,85 @@
+package repoark
+
+import (
+	"fmt"
+	"strings"
+)
+
+// includeIgnored is the --include-ignored flag. Given alone it archives every file the VCS
+// ignores, --include-ignored=<glob> (repeatable) only the matching ones, such as .env files
+// and local config deliberately kept out of the repository.
+type includeIgnored struct {
+	All      bool
+	Patterns pathPatterns
+}
+
+// IsBoolFlag lets the flag be given without a value
+func (f *includeIgnored) IsBoolFlag() bool { return true }
+
+func (f *includeIgnored) String() string {
+	if f.All {
+		return "true"
+	}
+	return f.Patterns.String()
+}
+
+func (f *includeIgnored) Set(value string) error {
+	switch value {
+	case "true":
+		f.All = true
+		return nil
+	case "false":
+		*f = includeIgnored{}
+		return nil
+	}
+	return f.Patterns.Set(value)
+}
+
+// enabled reports whether any ignored file is archived
+func (f includeIgnored) enabled() bool {
+	return f.All || len(f.Patterns) > 0
+}
+
+// filter returns the ignored files of the repository at prefix in the archive that are archived
+func (f includeIgnored) filter(prefix string, ignored []string) []string {
+	if f.All {
+		return ignored
+	}
+	var kept []string
+	for _, entry := range ignored {
+		if f.Patterns.matches(strings.TrimPrefix(prefix+"/"+entry, "/")) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// listIgnoredFiles lists the untracked files .gitignore and the other exclude files of git
+// ignore in dir, relative to dir, except those .repoarkignore leaves out
+func listIgnoredFiles(dir string) ([]string, error) {
+	output, err := runGit(dir, nil, "ls-files", "-z", "--others", "--ignored", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("error listing ignored files in %s: %v", dir, err)
+	}
+	ignored := splitNul(output)
+	excludeArgs := repoarkIgnoreArgs(dir)
+	if excludeArgs == nil {
+		return ignored, nil
+	}
+	// With --ignored, the files matching .repoarkignore are the ones to leave out
+	output, err = runGit(dir, nil, append([]string{"ls-files", "-z", "--others", "--ignored"}, excludeArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing ignored files in %s: %v", dir, err)
+	}
+	excluded := make(map[string]bool)
+	for _, entry := range splitNul(output) {
+		excluded[entry] = true
+	}
+	kept := ignored[:0]
+	for _, entry := range ignored {
+		if !excluded[entry] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
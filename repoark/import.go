@@ -0,0 +1,191 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scannedArchive is what import learns about an existing archive
+type scannedArchive struct {
+	Manifest    manifest
+	Compression string
+	RootPrefix  string // common top-level directory, as created by "tar czf repo.tgz repo/"
+	HasGitDir   bool
+	Newest      time.Time
+}
+
+// scanArchive reads every file of a tar or zip archive, hashing its content
+func scanArchive(archivePath string) (*scannedArchive, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive file: %v", err)
+	}
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(file, head)
+	file.Close()
+	if isZipArchive(head[:n]) {
+		return scanZipArchive(archivePath)
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	scanned := &scannedArchive{Compression: ar.Compressor.Name}
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || isMetaEntry(header.Name) {
+			continue
+		}
+		if err := scanned.add(header.Name, header.Mode, header.ModTime, ar); err != nil {
+			return nil, err
+		}
+	}
+	scanned.finish()
+	return scanned, nil
+}
+
+// scanZipArchive is scanArchive for zip files
+func scanZipArchive(archivePath string) (*scannedArchive, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	scanned := &scannedArchive{Compression: "zip"}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() || isMetaEntry(f.Name) {
+			continue
+		}
+		content, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", f.Name, err)
+		}
+		err = scanned.add(f.Name, int64(f.Mode()), f.Modified, content)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	scanned.finish()
+	return scanned, nil
+}
+
+// add hashes one file of the archive into the manifest
+func (s *scannedArchive) add(name string, mode int64, modTime time.Time, content io.Reader) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return err
+	}
+	s.Manifest.Entries = append(s.Manifest.Entries, manifestEntry{
+		Path:    strings.TrimPrefix(name, "./"),
+		Size:    size,
+		Mode:    mode,
+		ModTime: modTime,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	})
+	if modTime.After(s.Newest) {
+		s.Newest = modTime
+	}
+	return nil
+}
+
+// finish detects the archive layout once all entries are known
+func (s *scannedArchive) finish() {
+	prefix := ""
+	for i, entry := range s.Manifest.Entries {
+		top, _, nested := strings.Cut(entry.Path, "/")
+		if !nested || (i > 0 && top != prefix) {
+			prefix = ""
+			break
+		}
+		prefix = top
+	}
+	if prefix == ".git" {
+		prefix = ""
+	}
+	s.RootPrefix = prefix
+
+	for _, entry := range s.Manifest.Entries {
+		if entry.Path == path.Join(prefix, ".git/HEAD") {
+			s.HasGitDir = true
+			break
+		}
+	}
+}
+
+// importArchive registers an archive that repoark did not create (or created before the
+// catalog existed) in the catalog, writing a manifest of its contents next to it
+func importArchive(archivePath, repoName, catalogFile string) error {
+	if catalogFile == "" {
+		return fmt.Errorf("no catalog configured, use --catalog or REPOARK_CATALOG")
+	}
+	absPath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("error accessing archive: %v", err)
+	}
+
+	scanned, err := scanArchive(absPath)
+	if err != nil {
+		return err
+	}
+	if !scanned.HasGitDir {
+		fmt.Printf("warning: %s contains no .git directory, it cannot be restored as a repository\n", archivePath)
+	}
+
+	if repoName == "" {
+		repoName = scanned.RootPrefix
+	}
+	if repoName == "" {
+		repoName = strings.SplitN(filepath.Base(absPath), ".", 2)[0]
+	}
+
+	manifestPath := manifestSidecarPath(absPath)
+	if err := writeManifestFile(manifestPath, &scanned.Manifest); err != nil {
+		return err
+	}
+
+	created := scanned.Newest
+	if created.IsZero() {
+		created = info.ModTime()
+	}
+	record := catalogRecord{
+		Repo:        repoName,
+		Destination: absPath,
+		Created:     created.UTC(),
+		Size:        info.Size(),
+		Compression: scanned.Compression,
+		Imported:    true,
+		Manifest:    manifestPath,
+	}
+	record.Host, _ = os.Hostname()
+	if err := registerArchive(catalogFile, record); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %s: %d files, repository %s, manifest %s\n", archivePath, len(scanned.Manifest.Entries), repoName, manifestPath)
+	return nil
+}
@@ -0,0 +1,50 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultIndexLockTimeout is how long archive waits for a running git command to release the index
+const defaultIndexLockTimeout = 10 * time.Second
+
+// indexLockPath returns the lock file git holds while it rewrites the index of repoPath
+func indexLockPath(repoPath string) string {
+	return filepath.Join(repoPath, ".git", "index.lock")
+}
+
+// indexLocked reports whether a git process currently holds the index lock
+func indexLocked(repoPath string) bool {
+	_, err := os.Stat(indexLockPath(repoPath))
+	return err == nil
+}
+
+// waitForIndexLock waits up to timeout for another git process to release the index.
+// It returns a warning for the manifest when the lock is still held, archiving then proceeds anyway.
+func waitForIndexLock(repoPath string, timeout time.Duration, logger Logger) string {
+	if !indexLocked(repoPath) {
+		return ""
+	}
+	logger.Infof("Waiting up to %s for another git process to release %s", timeout, indexLockPath(repoPath))
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if !indexLocked(repoPath) {
+			return ""
+		}
+	}
+	warning := fmt.Sprintf("git index was locked by another process for more than %s, the archived index may be outdated", timeout)
+	logger.Warnf("%s", warning)
+	return warning
+}
+
+// indexChanged compares the index before and after archiving to detect concurrent git activity
+func indexChanged(repoPath string, before os.FileInfo) bool {
+	after, err := os.Stat(filepath.Join(repoPath, ".git", "index"))
+	if before == nil || err != nil {
+		return (before == nil) != (err != nil)
+	}
+	return !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size()
+}
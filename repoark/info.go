@@ -0,0 +1,291 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// infoLargestFiles is how many of the largest files repoark info lists
+const infoLargestFiles = 5
+
+// maxInfoMetadataSize bounds the git and repoark metadata files read into memory
+const maxInfoMetadataSize = 16 << 20
+
+// archiveInfo summarizes an archive for repoark info
+type archiveInfo struct {
+	Compression      string
+	Entries          int
+	Files            int
+	UncompressedSize int64
+	CompressedSize   int64
+	Created          time.Time
+	Newest           time.Time
+	Largest          []listEntry
+	Branch           string
+	Commit           string
+	Warnings         []string
+	Conflicted       []string      // paths with merge conflicts in the archived index
+	WorktreeOnly     bool          // written with --no-git-dir
+	GitDirOnly       bool          // written with --git-dir-only
+	Metadata         *repoMetadata // nil for archives written before the metadata entry existed
+
+	head       string
+	looseRefs  map[string]string
+	packedRefs string
+}
+
+// add accounts for one entry; open is only called for the metadata files info reads
+func (info *archiveInfo) add(name string, size int64, regular bool, modTime time.Time, open func() (io.Reader, error)) error {
+	info.Entries++
+	if !regular {
+		return nil
+	}
+	name = strings.TrimPrefix(name, "./")
+	if name == archiveManifestName {
+		// Written when the archive is finished, unlike the file it survives copying
+		info.Created = modTime
+		// Not read with readMetadata, the checksums of a large repository exceed its limit
+		r, err := open()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", name, err)
+		}
+		m, err := readManifestEntry(r)
+		if err != nil {
+			return err
+		}
+		info.Warnings = m.Warnings
+		info.Conflicted = conflictedPaths(m.Unmerged)
+		info.WorktreeOnly = m.WorktreeOnly
+		info.GitDirOnly = m.GitDirOnly
+		return nil
+	}
+	if name == archiveMetadataName {
+		return info.readMetadata(name, open, func(data []byte) (err error) {
+			info.Metadata, err = decodeRepoMetadata(data)
+			return err
+		})
+	}
+	if isMetaEntry(name) {
+		return nil
+	}
+
+	info.Files++
+	info.UncompressedSize += size
+	if modTime.After(info.Newest) {
+		info.Newest = modTime
+	}
+	info.Largest = append(info.Largest, listEntry{Path: name, Size: size, ModTime: modTime})
+	sort.SliceStable(info.Largest, func(i, j int) bool {
+		return info.Largest[i].Size > info.Largest[j].Size
+	})
+	if len(info.Largest) > infoLargestFiles {
+		info.Largest = info.Largest[:infoLargestFiles]
+	}
+
+	switch {
+	case name == ".git/HEAD":
+		return info.readMetadata(name, open, func(data []byte) error {
+			info.head = strings.TrimSpace(string(data))
+			return nil
+		})
+	case name == ".git/packed-refs":
+		return info.readMetadata(name, open, func(data []byte) error {
+			info.packedRefs = string(data)
+			return nil
+		})
+	case strings.HasPrefix(name, ".git/refs/heads/"):
+		return info.readMetadata(name, open, func(data []byte) error {
+			info.looseRefs[strings.TrimPrefix(name, ".git/")] = strings.TrimSpace(string(data))
+			return nil
+		})
+	}
+	return nil
+}
+
+// readMetadata reads a small metadata file and hands its content to use
+func (info *archiveInfo) readMetadata(name string, open func() (io.Reader, error), use func([]byte) error) error {
+	r, err := open()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxInfoMetadataSize))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	return use(data)
+}
+
+// resolveHead fills in Branch and Commit from the metadata entry, or from the HEAD and refs
+// found in the archive
+func (info *archiveInfo) resolveHead() {
+	if info.Metadata != nil {
+		info.Branch, info.Commit = info.Metadata.Branch, info.Metadata.Commit
+		return
+	}
+	ref, symbolic := strings.CutPrefix(info.head, "ref: ")
+	if !symbolic {
+		info.Commit = info.head
+		return
+	}
+	info.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	if commit, ok := info.looseRefs[ref]; ok {
+		info.Commit = commit
+		return
+	}
+	scanner := bufio.NewScanner(strings.NewReader(info.packedRefs))
+	for scanner.Scan() {
+		if commit, name, ok := strings.Cut(scanner.Text(), " "); ok && name == ref {
+			info.Commit = commit
+			return
+		}
+	}
+}
+
+// readArchiveInfo reads a whole tar or zip archive to summarize it
+func readArchiveInfo(archivePath string) (*archiveInfo, error) {
+	info := &archiveInfo{looseRefs: make(map[string]string)}
+	if archivePath != "-" {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive file: %v", err)
+		}
+		head := make([]byte, 4)
+		n, _ := io.ReadFull(file, head)
+		stat, err := file.Stat()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive file: %v", err)
+		}
+		// Written last, the archive file's modification time is when it was created
+		info.Created = stat.ModTime()
+		if isZipArchive(head[:n]) {
+			info.CompressedSize = stat.Size()
+			return info, readZipInfo(archivePath, info)
+		}
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	info.Compression = ar.Compressor.Name
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		open := func() (io.Reader, error) { return ar, nil }
+		if err := info.add(header.Name, header.Size, header.Typeflag == tar.TypeReg, header.ModTime, open); err != nil {
+			return nil, err
+		}
+	}
+	info.CompressedSize = ar.InputSize
+	if archivePath == "-" {
+		// Count the rest of the stream, the padding after the last entry is never read
+		io.Copy(io.Discard, ar.input)
+		info.CompressedSize = ar.InputPosition()
+	}
+	info.resolveHead()
+	return info, nil
+}
+
+// readZipInfo is readArchiveInfo for zip files
+func readZipInfo(archivePath string, info *archiveInfo) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	info.Compression = "zip"
+	for _, f := range zr.File {
+		var content io.ReadCloser
+		open := func() (io.Reader, error) {
+			var err error
+			content, err = f.Open()
+			return content, err
+		}
+		err := info.add(f.Name, int64(f.UncompressedSize64), f.Mode().IsRegular(), f.Modified, open)
+		if content != nil {
+			content.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	info.resolveHead()
+	return nil
+}
+
+// printArchiveInfo prints the summary of each archive, separated by blank lines
+func printArchiveInfo(archivePaths []string) error {
+	for i, archivePath := range archivePaths {
+		info, err := readArchiveInfo(archivePath)
+		if err != nil {
+			return fmt.Errorf("%s: %v", archivePath, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("Archive:       %s\n", archivePath)
+		fmt.Printf("Compression:   %s\n", info.Compression)
+		fmt.Printf("Entries:       %d (%d files)\n", info.Entries, info.Files)
+		fmt.Printf("Uncompressed:  %s (%d bytes)\n", formatSize(info.UncompressedSize), info.UncompressedSize)
+		fmt.Printf("Compressed:    %s (%d bytes)\n", formatSize(info.CompressedSize), info.CompressedSize)
+		if info.CompressedSize > 0 {
+			fmt.Printf("Ratio:         %.2f\n", float64(info.UncompressedSize)/float64(info.CompressedSize))
+		}
+		if !info.Created.IsZero() {
+			fmt.Printf("Created:       %s\n", info.Created.Local().Format(time.RFC3339))
+		} else if !info.Newest.IsZero() {
+			fmt.Printf("Newest file:   %s\n", info.Newest.Local().Format(time.RFC3339))
+		}
+		if info.Branch != "" {
+			fmt.Printf("Branch:        %s\n", info.Branch)
+		}
+		if info.Commit != "" {
+			fmt.Printf("Commit:        %s\n", info.Commit)
+		}
+		if meta := info.Metadata; meta != nil {
+			fmt.Printf("Written by:    %s\n", meta.Tool)
+			fmt.Printf("Dirty:         %s\n", meta.Dirty)
+			for _, name := range sortedKeys(meta.Remotes) {
+				fmt.Printf("Remote:        %s %s\n", name, meta.Remotes[name])
+			}
+			for _, path := range sortedKeys(meta.Submodules) {
+				fmt.Printf("Submodule:     %s %s\n", path, meta.Submodules[path])
+			}
+		}
+		if info.WorktreeOnly {
+			fmt.Printf("Contents:      working tree only, without .git\n")
+		} else if info.GitDirOnly {
+			fmt.Printf("Contents:      .git only, without the working tree\n")
+		}
+		if len(info.Conflicted) > 0 {
+			fmt.Printf("Conflicted:    %s\n", strings.Join(info.Conflicted, ", "))
+		}
+		for _, warning := range info.Warnings {
+			fmt.Printf("Warning:       %s\n", warning)
+		}
+		if len(info.Largest) > 0 {
+			fmt.Println("Largest files:")
+			for _, entry := range info.Largest {
+				fmt.Printf("  %10s  %s\n", formatSize(entry.Size), entry.Path)
+			}
+		}
+	}
+	return nil
+}
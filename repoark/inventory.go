@@ -0,0 +1,237 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File classifications of an inventory
+const (
+	classTracked   = "tracked"   // in the git index
+	classUntracked = "untracked" // in the work tree but not in the index
+	classSubmodule = "submodule" // inside a submodule, tracked by its own index
+	classGit       = "git"       // git metadata under .git
+)
+
+// inventoryFile is one file of an archive inventory
+type inventoryFile struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+	Classification string `json:"classification"`
+	Commit         string `json:"commit,omitempty"` // HEAD commit the file is tracked at
+}
+
+// inventory describes exactly what an archive contains, for asset management and compliance tools
+type inventory struct {
+	Archive     string          `json:"archive"`
+	Created     time.Time       `json:"created"`
+	Compression string          `json:"compression"`
+	Branch      string          `json:"branch,omitempty"`
+	Commit      string          `json:"commit,omitempty"`
+	Files       []inventoryFile `json:"files"`
+}
+
+// sha256ObjectFormat detects repositories using SHA-256 object names from their .git/config
+var sha256ObjectFormat = regexp.MustCompile(`(?im)^\s*objectformat\s*=\s*sha256\s*$`)
+
+// inventoryBuilder collects an inventory while the archive is read
+type inventoryBuilder struct {
+	inv    inventory
+	info   *archiveInfo
+	index  []byte
+	config []byte
+}
+
+// add hashes one regular file; git metadata needed for the classification is kept in memory
+func (b *inventoryBuilder) add(name string, size int64, modTime time.Time, content io.Reader) error {
+	name = strings.TrimPrefix(name, "./")
+	if name == ".git/index" || name == ".git/config" || name == ".git/HEAD" || name == ".git/packed-refs" ||
+		strings.HasPrefix(name, ".git/refs/heads/") || name == archiveManifestName {
+		data, err := io.ReadAll(io.LimitReader(content, maxInfoMetadataSize))
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", name, err)
+		}
+		switch name {
+		case ".git/index":
+			b.index = data
+		case ".git/config":
+			b.config = data
+		}
+		open := func() (io.Reader, error) { return bytes.NewReader(data), nil }
+		if err := b.info.add(name, size, true, modTime, open); err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	if isMetaEntry(name) {
+		return nil
+	}
+	if modTime.After(b.info.Newest) {
+		b.info.Newest = modTime
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, content)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	b.inv.Files = append(b.inv.Files, inventoryFile{Path: name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+// finish classifies every file against the archived git index
+func (b *inventoryBuilder) finish() error {
+	b.info.resolveHead()
+	b.inv.Branch, b.inv.Commit = b.info.Branch, b.info.Commit
+	b.inv.Created = b.info.Created.UTC()
+	if b.info.Created.IsZero() {
+		b.inv.Created = b.info.Newest.UTC()
+	}
+
+	index := &gitIndex{Paths: map[string]bool{}}
+	if b.index != nil {
+		hashSize := 20
+		if sha256ObjectFormat.Match(b.config) {
+			hashSize = 32
+		}
+		var err error
+		if index, err = parseGitIndex(b.index, hashSize); err != nil {
+			return fmt.Errorf("error reading .git/index: %v", err)
+		}
+	}
+
+	for i := range b.inv.Files {
+		file := &b.inv.Files[i]
+		switch {
+		case strings.HasPrefix(file.Path, ".git/") || strings.HasPrefix(file.Path, jjDir+"/"):
+			file.Classification = classGit
+		case index.Paths[file.Path]:
+			file.Classification = classTracked
+			file.Commit = b.inv.Commit
+		case insideAny(file.Path, index.Gitlinks):
+			file.Classification = classSubmodule
+		default:
+			file.Classification = classUntracked
+		}
+	}
+	sort.Slice(b.inv.Files, func(i, j int) bool {
+		return b.inv.Files[i].Path < b.inv.Files[j].Path
+	})
+	if b.inv.Files == nil {
+		b.inv.Files = []inventoryFile{}
+	}
+	return nil
+}
+
+// insideAny reports whether path lies below one of dirs
+func insideAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readInventory reads a whole tar or zip archive, hashing and classifying every file
+func readInventory(archivePath string) (*inventory, error) {
+	b := &inventoryBuilder{
+		inv:  inventory{Archive: filepath.Base(archivePath)},
+		info: &archiveInfo{looseRefs: make(map[string]string)},
+	}
+	if archivePath != "-" {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive file: %v", err)
+		}
+		head := make([]byte, 4)
+		n, _ := io.ReadFull(file, head)
+		if stat, err := file.Stat(); err == nil {
+			b.info.Created = stat.ModTime()
+		}
+		file.Close()
+		if isZipArchive(head[:n]) {
+			return readZipInventory(archivePath, b)
+		}
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	b.inv.Compression = ar.Compressor.Name
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := b.add(header.Name, header.Size, header.ModTime, ar); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.finish(); err != nil {
+		return nil, err
+	}
+	return &b.inv, nil
+}
+
+// readZipInventory is readInventory for zip files
+func readZipInventory(archivePath string, b *inventoryBuilder) (*inventory, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	b.inv.Compression = "zip"
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		content, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", f.Name, err)
+		}
+		err = b.add(f.Name, int64(f.UncompressedSize64), f.Modified, content)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.finish(); err != nil {
+		return nil, err
+	}
+	return &b.inv, nil
+}
+
+// printInventory writes the inventory of an archive as JSON
+func printInventory(archivePath string) error {
+	inv, err := readInventory(archivePath)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(inv)
+}
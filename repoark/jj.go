@@ -0,0 +1,55 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// jjDir is where Jujutsu keeps its state next to .git in a colocated repository
+const jjDir = ".jj"
+
+// isColocatedJJRepo reports whether dir is a jj repository sharing its git store with .git.
+// jj ignores .jj through its own .gitignore, so git never lists it.
+func isColocatedJJRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, jjDir, "repo", "store", "git_target"))
+	return err == nil
+}
+
+// isVolatileJJFile reports whether name, a file inside .jj, belongs to a running jj command
+func isVolatileJJFile(name string) bool {
+	// Locks are plain "lock" files or end in .lock, unfinished writes are tempfiles named .tmp*
+	return name == "lock" || strings.HasSuffix(name, ".lock") || strings.HasPrefix(name, ".tmp")
+}
+
+// collectJJDir lists the files of the .jj directory of a colocated repository
+func collectJJDir(rootDir RootDir, opts ArchiveOptions) ([]archiveSource, error) {
+	var files []archiveSource
+	err := filepath.WalkDir(filepath.Join(rootDir.Dir, jjDir), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isVolatileJJFile(d.Name()) {
+			return nil
+		}
+		relativePath, err := filepath.Rel(rootDir.Dir, path)
+		if err != nil {
+			return err
+		}
+		archivePath := filepath.Join(rootDir.Prefix, relativePath)
+		if source, special := specialSource(path, archivePath, d.Type(), opts); special {
+			if source != nil {
+				files = append(files, *source)
+			}
+			return nil
+		}
+		opts.FeatureLoss.checkXattrs(path, archivePath)
+		files = append(files, archiveSource{Path: path, Name: archivePath})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking .jj directory: %v", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,76 @@
+package repoark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// jsonEvent is one line of the --json output. Event is the entry action (add, skip, restore,
+// clone, remove), info, warning, error, or summary as the last line of a run.
+type jsonEvent struct {
+	Event   string         `json:"event"`
+	Path    string         `json:"path,omitempty"`
+	Size    *int64         `json:"size,omitempty"`
+	Message string         `json:"message,omitempty"`
+	OK      *bool          `json:"ok,omitempty"`
+	Counts  map[string]int `json:"counts,omitempty"`
+	Bytes   *int64         `json:"bytes,omitempty"`
+	Elapsed *float64       `json:"elapsed_seconds,omitempty"`
+	Missed  []missedEntry  `json:"missed,omitempty"` // what a partially successful run did not archive or restore
+}
+
+// jsonEvents is a Logger and ProgressReporter that writes every message and entry as a JSON
+// object per line, for scripts and dashboards
+type jsonEvents struct {
+	started time.Time
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	counts  map[string]int
+	bytes   int64
+}
+
+func newJSONEvents(w io.Writer) *jsonEvents {
+	return &jsonEvents{started: time.Now(), encoder: json.NewEncoder(w), counts: make(map[string]int)}
+}
+
+func (j *jsonEvents) emit(event jsonEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encoder.Encode(event)
+}
+
+func (j *jsonEvents) Entry(action, path string, size int64) {
+	j.mu.Lock()
+	j.counts[action]++
+	if action != "skip" && action != "remove" {
+		j.bytes += size
+	}
+	j.mu.Unlock()
+	j.emit(jsonEvent{Event: action, Path: path, Size: &size})
+}
+
+func (j *jsonEvents) Infof(format string, args ...interface{}) {
+	j.emit(jsonEvent{Event: "info", Message: fmt.Sprintf(format, args...)})
+}
+
+func (j *jsonEvents) Warnf(format string, args ...interface{}) {
+	j.emit(jsonEvent{Event: "warning", Message: fmt.Sprintf(format, args...)})
+}
+
+// finish writes the error of a failed run, if any, and the summary
+func (j *jsonEvents) finish(err error) {
+	if err != nil {
+		j.emit(jsonEvent{Event: "error", Message: err.Error()})
+	}
+	j.mu.Lock()
+	ok := err == nil
+	bytes := j.bytes
+	elapsed := time.Since(j.started).Seconds()
+	summary := jsonEvent{Event: "summary", OK: &ok, Counts: j.counts, Bytes: &bytes, Elapsed: &elapsed, Missed: missedIn(err)}
+	j.mu.Unlock()
+	j.emit(summary)
+}
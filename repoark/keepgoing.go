@@ -0,0 +1,61 @@
+package repoark
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxSkippedInManifest bounds how many left out files the manifest warning names
+const maxSkippedInManifest = 10
+
+// unreadableError marks a source file that failed before any of its entry was written,
+// so the archive stays intact when --keep-going leaves the file out
+type unreadableError struct {
+	Err error
+}
+
+func (e *unreadableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *unreadableError) Unwrap() error {
+	return e.Err
+}
+
+// skippedFile is a file --keep-going left out of the archive
+type skippedFile struct {
+	Name string
+	Err  error
+}
+
+// skippedFilesWarning reports the left out files and returns the warning recorded in the manifest
+func skippedFilesWarning(skipped []skippedFile, logger Logger) string {
+	names := make([]string, 0, len(skipped))
+	for _, file := range skipped {
+		logger.Warnf("left out %s: %v", file.Name, file.Err)
+		if len(names) < maxSkippedInManifest {
+			names = append(names, file.Name)
+		}
+	}
+	if len(skipped) > len(names) {
+		names = append(names, "...")
+	}
+	return fmt.Sprintf("%d unreadable files were left out of the archive: %s", len(skipped), strings.Join(names, ", "))
+}
+
+// skippedFilesError is the result of an archive run that left files out, nil when none were
+func skippedFilesError(skipped []skippedFile) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	return newPartialError("archive", missedFiles(skipped), fmt.Errorf("%d unreadable files were left out of the archive", len(skipped)))
+}
+
+// missedFiles lists the left out files for the partial summary and the catalog
+func missedFiles(skipped []skippedFile) []missedEntry {
+	var missed []missedEntry
+	for _, file := range skipped {
+		missed = append(missed, missedEntry{Path: file.Name, Reason: file.Err.Error()})
+	}
+	return missed
+}
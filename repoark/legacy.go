@@ -0,0 +1,179 @@
+package repoark
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// archiveFormatVersion is the layout of the archives this repoark writes, recorded in the header
+// of the metadata entry. Archives without it were written by older versions, which stored the
+// entries of nested submodules below their parent's path twice (sub/sub/nested/file for
+// sub/nested/file) and modes with Go's setuid, setgid and sticky bits instead of tar's.
+const archiveFormatVersion = 2
+
+// paxFormatVersion is the PAX record holding archiveFormatVersion
+const paxFormatVersion = "REPOARK.format"
+
+// isCurrentFormat reports whether the first entry of an archive says it has the current layout
+func isCurrentFormat(first *tar.Header) bool {
+	if first.Name != archiveMetadataName {
+		return false
+	}
+	version, err := strconv.Atoi(first.PAXRecords[paxFormatVersion])
+	return err == nil && version >= archiveFormatVersion
+}
+
+// tarMode converts a file mode to the mode field of a tar header
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// legacyArchive undoes the quirks of an archive written by an older version while it is read,
+// so that every command sees the entries as the current version writes them
+type legacyArchive struct {
+	submodules map[string]string // stored path of each submodule seen so far to its real path
+	renamed    int               // entries moved to their real path
+}
+
+func newLegacyArchive() *legacyArchive {
+	return &legacyArchive{submodules: make(map[string]string)}
+}
+
+// fix rewrites the name and mode of header to the current layout
+func (l *legacyArchive) fix(header *tar.Header) {
+	stored := header.Name
+	// The parent of a nested submodule is always seen first, submodules are archived breadth first
+	var parents []string
+	for parent := range l.submodules {
+		parents = append(parents, parent)
+	}
+	sort.Slice(parents, func(i, j int) bool { return len(parents[i]) > len(parents[j]) })
+	for _, parent := range parents {
+		doubled := parent + "/" + parent + "/"
+		if strings.HasPrefix(stored, doubled) {
+			header.Name = l.submodules[parent] + "/" + stored[len(doubled):]
+			l.renamed++
+			break
+		}
+	}
+
+	// Remember where submodules were stored by their .git file or directory
+	if i := strings.Index(stored+"/", "/.git/"); i > 0 {
+		l.submodules[stored[:i]] = header.Name[:len(header.Name)-len(stored)+i]
+	}
+
+	// Go's mode bits above the permissions were written as they are, tar's are below 07777
+	if header.Mode&^07777 != 0 {
+		header.Mode = tarMode(os.FileMode(header.Mode)) | header.Mode&07000
+	}
+}
+
+// upgradeArchive rewrites the archive at archivePath, read like every other archive with the
+// quirks of older versions undone, as a current archive at outputPath compressed as its name says.
+// The metadata of the original is kept, the manifest is written anew since modes and names change.
+func upgradeArchive(archivePath, outputPath string, logger Logger) error {
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	first, err := ar.Next()
+	if err == io.EOF {
+		return fmt.Errorf("%s is empty", archivePath)
+	}
+	if err != nil {
+		return err
+	}
+	if isCurrentFormat(first) {
+		return fmt.Errorf("%s is already in the current format", archivePath)
+	}
+
+	comp, err := resolveCompressor("", "", outputPath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("error creating archive file: %v", err)
+	}
+	defer file.Close()
+	compWriter, err := comp.NewWriter(file, 0)
+	if err != nil {
+		return fmt.Errorf("error creating %s writer: %v", comp.Name, err)
+	}
+	defer compWriter.Close()
+	writer := newTarEntryWriter(compWriter)
+	defer writer.Close()
+
+	// The metadata entry comes first and carries the format version, older archives had none
+	var meta repoMetadata
+	header := first
+	if first.Name == archiveMetadataName {
+		if err := json.NewDecoder(ar).Decode(&meta); err != nil {
+			return fmt.Errorf("error reading %s: %v", archiveMetadataName, err)
+		}
+		if header, err = ar.Next(); err != nil && err != io.EOF {
+			return err
+		}
+	} else {
+		meta = repoMetadata{Tool: "unknown, upgraded by " + toolVersion(), Created: first.ModTime.UTC(), VCS: "git"}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeMetadataEntry(writer, data, ArchiveOptions{}); err != nil {
+		return err
+	}
+
+	var m manifest
+	for ; err == nil; header, err = ar.Next() {
+		switch strings.TrimPrefix(header.Name, "./") {
+		case archiveManifestName, archiveIndexName:
+			// Chunk offsets refer to the old file, the upgraded archive is not chunked
+			continue
+		}
+		hash := sha256.New()
+		if err := writer.WriteEntry(header, io.TeeReader(ar, hash)); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			m.add(header, hash.Sum(nil))
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	if err := writeArchiveManifest(writer, &m, ArchiveOptions{}); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error finishing archive: %v", err)
+	}
+	if err := compWriter.Close(); err != nil {
+		return fmt.Errorf("error finishing %s stream: %v", comp.Name, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing archive file: %v", err)
+	}
+	logger.Infof("Upgraded %s to %s, %d entries of nested submodules moved to their real paths", archivePath, outputPath, ar.legacy.renamed)
+	return nil
+}
@@ -0,0 +1,121 @@
+package repoark
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// listEntry is one line of repoark list
+type listEntry struct {
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	Mode        string            `json:"mode"`
+	ModTime     time.Time         `json:"mtime"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// readArchiveEntries returns the entries of a tar or zip archive without extracting them
+func readArchiveEntries(archivePath string) ([]listEntry, error) {
+	if archivePath != "-" {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive file: %v", err)
+		}
+		head := make([]byte, 4)
+		n, _ := io.ReadFull(file, head)
+		file.Close()
+		if isZipArchive(head[:n]) {
+			return readZipEntries(archivePath)
+		}
+	}
+
+	ar, err := openArchive(archivePath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	var entries []listEntry
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listEntry{
+			Path:        header.Name,
+			Size:        header.Size,
+			Mode:        header.FileInfo().Mode().String(),
+			ModTime:     header.ModTime,
+			Annotations: entryAnnotations(header),
+		})
+	}
+}
+
+// readZipEntries is readArchiveEntries for zip files
+func readZipEntries(archivePath string) ([]listEntry, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	var entries []listEntry
+	for _, f := range zr.File {
+		entries = append(entries, listEntry{
+			Path:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    f.Mode().String(),
+			ModTime: f.Modified,
+		})
+	}
+	return entries, nil
+}
+
+// listArchive prints the entries of an archive carrying the annotations of filter as a table or as JSON
+func listArchive(archivePath string, asJSON bool, filter annotationFilter) error {
+	all, err := readArchiveEntries(archivePath)
+	if err != nil {
+		return err
+	}
+	var entries []listEntry
+	annotated := false
+	for _, entry := range all {
+		if filter.matches(entry.Annotations) {
+			entries = append(entries, entry)
+			annotated = annotated || entry.Annotations != nil
+		}
+	}
+
+	if asJSON {
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	// The annotations column is only shown for archives that have any
+	if annotated {
+		fmt.Fprintln(w, "MODE\tSIZE\tMODIFIED\tPATH\tANNOTATIONS")
+	} else {
+		fmt.Fprintln(w, "MODE\tSIZE\tMODIFIED\tPATH")
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s", entry.Mode, entry.Size, entry.ModTime.Local().Format("2006-01-02 15:04:05"), entry.Path)
+		if annotated {
+			fmt.Fprintf(w, "\t%s", formatAnnotations(entry.Annotations))
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,64 @@
+package repoark
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// staleLockAge is how old a lock file must be before it is considered abandoned
+const staleLockAge = 10 * time.Minute
+
+// acquireLock creates path exclusively, waiting up to timeout for another holder to release it.
+// O_EXCL creation is used rather than flock because it also works on NFS and SMB shares,
+// where several machines may access the same files.
+func acquireLock(path string, timeout time.Duration) (func(), error) {
+	hostname, _ := os.Hostname()
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	owner := fmt.Sprintf("%s %d %s %x\n", hostname, os.Getpid(), time.Now().UTC().Format(time.RFC3339), token)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.WriteString(owner)
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("error writing lock %s: %v", path, err)
+			}
+			return func() { releaseLock(path, owner) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lock %s: %v", path, err)
+		}
+
+		// Break locks left behind by crashed processes
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			holder, _ := os.ReadFile(path)
+			return nil, fmt.Errorf("timed out waiting for lock %s held by %s", path, strings.TrimSpace(string(holder)))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// releaseLock removes the lock at path if it is still the one written for owner. A run that
+// outlived staleLockAge may have had its lock broken and taken by another process, whose lock
+// is left alone.
+func releaseLock(path, owner string) {
+	holder, err := os.ReadFile(path)
+	if err != nil || string(holder) != owner {
+		return
+	}
+	os.Remove(path)
+}
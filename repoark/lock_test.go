@@ -0,0 +1,37 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReleaseLockKeepsLockTakenOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.lock")
+	release, err := acquireLock(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another host broke the lock as stale and took it
+	other := "otherhost 42 2026-01-01T00:00:00Z 0123456789abcdef\n"
+	if err := os.WriteFile(path, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if data, err := os.ReadFile(path); err != nil || string(data) != other {
+		t.Fatalf("lock of the other host = %q, %v; want it left in place", data, err)
+	}
+}
+
+func TestReleaseLockRemovesOwnLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.lock")
+	release, err := acquireLock(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("lock still exists after release: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFile records every message and entry of a run as timestamped key=value lines, whatever the
+// console shows, and passes them on to the console Logger and ProgressReporter
+type logFile struct {
+	logger Logger
+	next   ProgressReporter
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openLogFile appends to the log at path and records the start of a run
+func openLogFile(path string, logger Logger, next ProgressReporter) (*logFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %v", err)
+	}
+	l := &logFile{logger: logger, next: next, f: f}
+	l.write("start", "args", strings.Join(os.Args[1:], " "), "pid", strconv.Itoa(os.Getpid()))
+	return l, nil
+}
+
+// write appends one line with the event and the key/value pairs of fields
+func (l *logFile) write(event string, fields ...string) {
+	var line strings.Builder
+	line.WriteString("time=" + time.Now().UTC().Format(time.RFC3339Nano) + " event=" + event)
+	for i := 0; i+1 < len(fields); i += 2 {
+		line.WriteString(" " + fields[i] + "=" + logValue(fields[i+1]))
+	}
+	line.WriteString("\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.f.WriteString(line.String())
+}
+
+// logValue quotes values that would not read back as a single value
+func logValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\") || strconv.Quote(value) != `"`+value+`"` {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func (l *logFile) Entry(action, path string, size int64) {
+	l.write(action, "path", path, "size", strconv.FormatInt(size, 10))
+	l.next.Entry(action, path, size)
+}
+
+func (l *logFile) Infof(format string, args ...interface{}) {
+	l.write("info", "message", fmt.Sprintf(format, args...))
+	l.logger.Infof(format, args...)
+}
+
+func (l *logFile) Warnf(format string, args ...interface{}) {
+	l.write("warning", "message", fmt.Sprintf(format, args...))
+	l.logger.Warnf(format, args...)
+}
+
+func (l *logFile) setTotals(files int, bytes int64) {
+	if tracker, ok := l.next.(progressTracker); ok {
+		tracker.setTotals(files, bytes)
+	}
+}
+
+func (l *logFile) setPosition(total int64, position func() int64) {
+	if tracker, ok := l.next.(progressTracker); ok {
+		tracker.setPosition(total, position)
+	}
+}
+
+// finish records how the run ended and closes the log
+func (l *logFile) finish(err error) {
+	if err != nil {
+		l.write("error", "message", err.Error())
+	} else {
+		l.write("done")
+	}
+	l.f.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package repoark
+
+// gitPlatformConfig has no settings outside Windows
+var gitPlatformConfig []string
+
+// longPath returns path, only Windows limits the length of paths
+func longPath(path string) string {
+	return path
+}
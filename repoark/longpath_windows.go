@@ -0,0 +1,33 @@
+//go:build windows
+
+package repoark
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// gitPlatformConfig lets git for Windows handle work tree paths beyond MAX_PATH, which deep
+// node_modules-style trees exceed
+var gitPlatformConfig = []string{"-c", "core.longpaths=true"}
+
+// longPathLimit is where Windows APIs stop accepting plain paths: MAX_PATH (260) less room for
+// an 8.3 file name, which directory creation reserves
+const longPathLimit = 248
+
+// longPath returns path in the \\?\ form Windows APIs accept beyond MAX_PATH, for the calls that
+// do not go through the os package, which does this itself. Relative paths are made absolute,
+// the form allows neither relative paths nor / separators.
+func longPath(path string) string {
+	if len(path) < longPathLimit || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if isUNCPath(abs) {
+		return `\\?\UNC\` + strings.TrimPrefix(abs, `\\`)
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,2150 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RootDir represents a directory to be archived with a prefix
+type RootDir struct {
+	Prefix string
+	Dir    string
+}
+
+// ArchiveOptions holds the options of an archive run, from the command line or an embedding application
+type ArchiveOptions struct {
+	Format             string          // tar or zip, empty to pick by output extension
+	Compression        string          // compressor name, empty to pick by output extension
+	CompressCmd        string          // external compressor command line, overrides Compression
+	Level              int             // compression level, 0 for the compressor's default
+	Jobs               int             // compression goroutines, 1 disables parallel compression
+	SplitSize          int64           // maximum size of each archive volume, 0 for a single file
+	Stdout             io.Writer       // destination of the "-" output, os.Stdout when nil
+	ProgressFile       string          // JSON status file for external UIs
+	ProgressBar        bool            // show a progress bar on stderr instead of one line per file
+	FSProfile          string          // auto, local or network: I/O tuning for the filesystem holding the repository
+	IndexLockTimeout   time.Duration   // how long to wait for another git process to release the index
+	Timeouts           runTimeouts     // --timeout and the per-phase timeouts, enforced by runWithTimeouts
+	Config             string          // .git/config handling: include, exclude or sanitized
+	BirthTime          bool            // record file creation times in PAX records
+	Reproducible       bool            // produce byte-identical archives for identical repository states
+	SourceDateEpoch    time.Time       // upper bound for mtimes in reproducible mode, zero for none
+	NoDereferenceRoot  bool            // use repoPath as given instead of resolving symlinks
+	NumericOwner       bool            // record owners and groups by id only, without their names
+	Xattrs             bool            // record extended attributes and POSIX ACLs as PAX records
+	Fifos              bool            // store FIFOs as FIFO entries instead of leaving them out
+	TmpDir             string          // directory for temporary data, the system's temp directory when empty
+	Dereference        bool            // store copies of symlink targets instead of the symlinks
+	SizeBudget         int64           // maximum estimated archive size, 0 for no limit
+	AutoExcludeLargest int             // exclude up to N of the largest untracked items when over budget
+	AutoExcludeCommon  bool            // leave out untracked node_modules, target and other dependency and build directories
+	IncludeIgnored     includeIgnored  // archive the files the VCS ignores, all of them or those matching patterns
+	HeavyDirs          *heavyDirs      // collects untracked dependency and build directories, nil to skip the check
+	Catalog            string          // catalog file to register the archive in, empty to skip
+	FeatureLoss        *featureLoss    // collects attributes the archive cannot store, nil to skip the checks
+	Manifest           *manifest       // collects the checksums of archived files, nil to skip them
+	Objects            string          // which git objects to include: all, or reachable-from=<rev-list arguments>
+	ObjectPack         *objectPack     // pack replacing .git/objects of the root repository, nil for all objects
+	History            string          // how the history of a git repository is stored: copy (default) or bundle
+	HistoryBundle      *historyBundle  // bundle replacing .git/objects and the refs of the root repository, nil to copy them
+	Verbosity          verbosity       // quiet, normal or verbose output of the default Logger and Progress
+	JSON               bool            // report every entry, message and the summary as NDJSON events
+	LogFile            string          // file to append a timestamped record of the run to
+	VCS                repoVCS         // version control system of the repository, detected when archiving
+	KeepGoing          bool            // leave unreadable files out instead of failing the archive
+	ReuseFrom          string          // previous archive to copy the compressed chunks of unchanged files from
+	FullScan           bool            // with ReuseFrom, compare every file with the previous archive instead of trusting git status
+	NoGitDir           bool            // archive the working tree only, without the .git (or .hg) directories
+	GitDirOnly         bool            // archive the .git (or .hg) directory only, without the working tree
+	NameTemplate       string          // name of archives written without an output file, e.g. {repo}-{date}.tar.zst
+	Annotations        annotationRules // .repoarkattributes of the repository, stored as PAX records
+	Checksum           bool            // write the SHA-256 of the archive to <archive>.sha256
+	EncryptRecipients  stringList      // age recipients, or files listing them, to encrypt the archive for
+	Password           bool            // encrypt the archive with a password
+	Force              bool            // migrate: replace an existing object at an explicitly named key
+	Sign               string          // gpg key to write a detached signature of the archive with, empty for none
+	RequireHealthy     bool            // refuse to archive a git repository failing the quick health checks
+	Quarantine         bool            // with RequireHealthy, write the archive under a quarantined name instead of refusing
+	Validators         []string        // built-in checks of the finished archive: structure, manifest or restore
+	ValidateCmds       stringList      // commands run with the path of the finished archive, failing the run when they fail
+	Logger             Logger
+	Progress           ProgressReporter
+}
+
+// withDefaults fills unset options with the stdout implementations
+func (opts ArchiveOptions) withDefaults() ArchiveOptions {
+	if opts.Logger == nil {
+		opts.Logger = newLogger(os.Stdout, opts.Verbosity)
+	}
+	if opts.Progress == nil {
+		opts.Progress = newProgress(os.Stdout, opts.Verbosity)
+	}
+	if opts.IndexLockTimeout == 0 {
+		opts.IndexLockTimeout = defaultIndexLockTimeout
+	}
+	return opts
+}
+
+// archiveGitRepo is the main function to create a compressed tar (or zip) archive of a Git repository
+func archiveGitRepo(repoPath string, outputPath string, opts ArchiveOptions) error {
+	toStdout := outputPath == "-"
+	if toStdout {
+		// stdout carries the archive, messages go to stderr
+		if opts.Logger == nil {
+			opts.Logger = newLogger(os.Stderr, opts.Verbosity)
+		}
+		if opts.Progress == nil {
+			opts.Progress = newProgress(os.Stderr, opts.Verbosity)
+		}
+	}
+	opts = opts.withDefaults()
+
+	if opts.JSON {
+		var w io.Writer = os.Stdout
+		if toStdout {
+			w = os.Stderr
+		}
+		events := newJSONEvents(w)
+		opts.Logger, opts.Progress, opts.JSON, opts.ProgressBar = events, events, false, false
+		err := archiveGitRepo(repoPath, outputPath, opts)
+		events.finish(err)
+		return err
+	}
+
+	if opts.ProgressBar {
+		bar := startProgressBar(os.Stderr, opts.Logger)
+		opts.Logger, opts.Progress, opts.ProgressBar = bar, bar, false
+		err := archiveGitRepo(repoPath, outputPath, opts)
+		bar.finish()
+		return err
+	}
+
+	if opts.ProgressFile != "" {
+		total, _ := estimateArchiveSize(repoPath)
+		tracker := startProgressFile(opts.ProgressFile, "archiving", total, opts.Progress)
+		opts.Progress, opts.ProgressFile = tracker, ""
+		err := archiveGitRepo(repoPath, outputPath, opts)
+		tracker.finish(err)
+		return err
+	}
+
+	if opts.LogFile != "" {
+		log, err := openLogFile(opts.LogFile, opts.Logger, opts.Progress)
+		if err != nil {
+			return err
+		}
+		opts.Logger, opts.Progress, opts.LogFile = log, log, ""
+		err = archiveGitRepo(repoPath, outputPath, opts)
+		log.finish(err)
+		return err
+	}
+
+	if opts.Timeouts != (runTimeouts{}) {
+		timeouts := opts.Timeouts
+		opts.Timeouts = runTimeouts{}
+		return runWithTimeouts(timeouts, func() error {
+			return archiveGitRepo(repoPath, outputPath, opts)
+		})
+	}
+
+	// Validate repository path
+	repoPath, err := canonicalRoot(repoPath, opts.NoDereferenceRoot)
+	if err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	}
+	if opts.FSProfile, err = resolveFSProfile(opts.FSProfile, repoPath, opts.Logger); err != nil {
+		return err
+	}
+	info, err := os.Stat(repoPath)
+	if err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", repoPath)
+	}
+
+	if opts.VCS, err = detectVCS(repoPath); err != nil {
+		return err
+	}
+
+	// The extension of an encrypted archive comes after the one selecting format and compressor
+	recipients, err := parseAgeRecipients(opts.EncryptRecipients)
+	if err != nil {
+		return err
+	}
+	if opts.Password {
+		if recipients != nil {
+			return fmt.Errorf("--password cannot be combined with --encrypt-recipient")
+		}
+		if recipients, err = passwordRecipients(); err != nil {
+			return err
+		}
+	}
+	plainPath := outputPath
+	if strings.HasSuffix(strings.ToLower(outputPath), ageExtension) {
+		if recipients == nil {
+			return fmt.Errorf("%s looks like an encrypted archive, use --encrypt-recipient or --password to encrypt it", outputPath)
+		}
+		plainPath = outputPath[:len(outputPath)-len(ageExtension)]
+	}
+	format, err := resolveFormat(opts.Format, plainPath)
+	if err != nil {
+		return err
+	}
+	comp, err := resolveCompressor(opts.Compression, opts.CompressCmd, plainPath)
+	if err != nil {
+		return err
+	}
+	if format == "zip" {
+		if opts.Compression != "" || opts.CompressCmd != "" {
+			return fmt.Errorf("zip archives are always deflate compressed, --compress cannot be used with --format=zip")
+		}
+		comp = compressor{Name: "zip", MinLevel: flate.BestSpeed, MaxLevel: flate.BestCompression,
+			NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+				return nopWriteCloser{w}, nil
+			}}
+	}
+	if err := comp.checkLevel(opts.Level); err != nil {
+		return err
+	}
+	if err := checkConfigMode(opts.Config); err != nil {
+		return err
+	}
+	if opts.Jobs < 0 {
+		return fmt.Errorf("invalid number of jobs %d", opts.Jobs)
+	}
+	revArgs, err := parseObjectsSpec(opts.Objects)
+	if err != nil {
+		return err
+	}
+	if _, ok := opts.VCS.(gitVCS); !ok && (revArgs != nil || opts.SizeBudget > 0) {
+		return fmt.Errorf("--objects and --size-budget are only supported for git repositories")
+	}
+	if opts.NoGitDir && (revArgs != nil || opts.Config != "") {
+		return fmt.Errorf("--objects and --config select what of .git to archive, they cannot be used with --no-git-dir")
+	}
+	if opts.NoGitDir && opts.GitDirOnly {
+		return fmt.Errorf("--no-git-dir and --git-dir-only leave nothing to archive together")
+	}
+	switch opts.History {
+	case "", "copy":
+	case "bundle":
+		if _, ok := opts.VCS.(gitVCS); !ok {
+			return fmt.Errorf("--history bundle is only supported for git repositories")
+		}
+		if revArgs != nil || opts.NoGitDir {
+			return fmt.Errorf("--history bundle cannot be used with --objects or --no-git-dir")
+		}
+		if format == "zip" {
+			return fmt.Errorf("--history bundle archives are restored with repoark restore, which does not read zip archives")
+		}
+	default:
+		return fmt.Errorf("invalid history mode %q, expected copy or bundle", opts.History)
+	}
+
+	if opts.Quarantine && !opts.RequireHealthy {
+		return fmt.Errorf("--quarantine only applies with --require-healthy")
+	}
+
+	// An archive of a damaged repository must not replace the last good backup
+	var quarantine string
+	if opts.RequireHealthy {
+		if _, ok := opts.VCS.(gitVCS); !ok {
+			return fmt.Errorf("--require-healthy is only supported for git repositories")
+		}
+		problems := checkRepoHealth(repoPath)
+		if len(problems) == 0 {
+			opts.Logger.Infof("Repository health checks passed")
+		} else {
+			for _, problem := range problems {
+				opts.Logger.Warnf("%s", problem)
+			}
+			if !opts.Quarantine || toStdout {
+				return fmt.Errorf("repository is not healthy (%s), refusing to archive it", problems[0])
+			}
+			outputPath = quarantineName(outputPath)
+			quarantine = fmt.Sprintf("the repository failed the health checks (%s), the archive was quarantined", problems[0])
+			opts.Logger.Warnf("writing the archive to %s instead", outputPath)
+		}
+	}
+
+	var session *interactiveSession
+	if opts.SizeBudget > 0 {
+		if session, err = enforceSizeBudget(repoPath, opts); err != nil {
+			return err
+		}
+	}
+
+	var reuse *reuseSource
+	if opts.ReuseFrom != "" {
+		if format != "tar" || comp.Name != "gzip" || opts.CompressCmd != "" || opts.SplitSize > 0 || recipients != nil {
+			return fmt.Errorf("--reuse-from only works for tar.gz archives that are not split or encrypted")
+		}
+		if prevInfo, err := os.Stat(opts.ReuseFrom); err == nil && !toStdout {
+			if outInfo, err := os.Stat(outputPath); err == nil && os.SameFile(prevInfo, outInfo) {
+				return fmt.Errorf("--reuse-from must name a different file than the output")
+			}
+		}
+		if reuse, err = openReuseSource(opts.ReuseFrom); err != nil {
+			return err
+		}
+		defer reuse.Close()
+		if len(reuse.chunks) == 0 {
+			opts.Logger.Infof("%s has no chunk index, every file is compressed again", opts.ReuseFrom)
+		}
+	}
+
+	// Create output archive file, or the first of its volumes
+	var archiveFile io.WriteCloser
+	if toStdout {
+		if opts.SplitSize > 0 {
+			return fmt.Errorf("--split-size cannot be used when writing to stdout")
+		}
+		if opts.Checksum || opts.Sign != "" {
+			return fmt.Errorf("--checksum and --sign cannot be used when writing to stdout")
+		}
+		if opts.Stdout == nil {
+			if isTerminal(os.Stdout) {
+				return fmt.Errorf("refusing to write the archive to a terminal, redirect stdout or pipe it into another command")
+			}
+			opts.Stdout = os.Stdout
+		}
+		archiveFile = nopWriteCloser{opts.Stdout}
+	} else if opts.SplitSize > 0 {
+		archiveFile, err = newSplitWriter(outputPath, opts.SplitSize)
+	} else {
+		archiveFile, err = os.Create(outputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating archive file: %v", err)
+	}
+	if recipients != nil {
+		encrypted, err := newEncryptedWriter(archiveFile, recipients)
+		if err != nil {
+			archiveFile.Close()
+			return err
+		}
+		archiveFile = encrypted
+	}
+	defer archiveFile.Close()
+
+	// Create compression writer, a chunked archive compresses each chunk on its own
+	var compWriter io.WriteCloser
+	if reuse != nil {
+		compWriter = nopWriteCloser{archiveFile}
+	} else if opts.Jobs > 1 && comp.NewParallelWriter != nil {
+		compWriter, err = comp.NewParallelWriter(archiveFile, opts.Level, opts.Jobs)
+	} else {
+		compWriter, err = comp.NewWriter(archiveFile, opts.Level)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating %s writer: %v", comp.Name, err)
+	}
+	defer compWriter.Close()
+
+	// Count the uncompressed stream for the progress file, it is what the size estimate measures
+	var archiveStream io.Writer = compWriter
+	if tracker, ok := opts.Progress.(progressTracker); ok && reuse == nil {
+		counter := &countingWriter{w: compWriter}
+		tracker.setPosition(0, counter.Count)
+		archiveStream = counter
+	}
+
+	// Create tar (or zip) writer
+	var writer entryWriter
+	if reuse != nil {
+		chunked := newChunkedArchive(archiveFile, comp, reuse, opts)
+		if tracker, ok := opts.Progress.(progressTracker); ok {
+			tracker.setPosition(0, chunked.uncompressed.Count)
+		}
+		writer = chunked
+	} else if format == "zip" {
+		writer = newZipEntryWriter(archiveStream, opts.Level)
+	} else {
+		writer = newTarEntryWriter(archiveStream)
+	}
+	defer writer.Close()
+
+	// Initialize directory list
+	dirList := []RootDir{
+		{
+			Prefix: "",
+			Dir:    repoPath,
+		},
+	}
+
+	// Give a running git command the chance to finish updating the index
+	var archiveManifest manifest
+	lockWarning := waitForIndexLock(repoPath, opts.IndexLockTimeout, opts.Logger)
+	if lockWarning != "" {
+		archiveManifest.Warnings = append(archiveManifest.Warnings, lockWarning)
+	}
+	indexBefore, _ := os.Stat(filepath.Join(repoPath, ".git", "index"))
+	archiveManifest.WorktreeOnly = opts.NoGitDir
+	archiveManifest.GitDirOnly = opts.GitDirOnly
+	if _, ok := opts.VCS.(gitVCS); ok && !opts.NoGitDir {
+		if archiveManifest.Unmerged, err = listUnmerged(repoPath); err != nil {
+			return err
+		}
+		if paths := conflictedPaths(archiveManifest.Unmerged); len(paths) > 0 {
+			opts.Logger.Infof("%d paths have merge conflicts, their index stages are recorded (e.g. %s)", len(paths), paths[0])
+		}
+	}
+	if quarantine != "" {
+		archiveManifest.Warnings = append(archiveManifest.Warnings, quarantine)
+	}
+
+	// Add entries to archive
+	if opts.Annotations, err = loadAnnotationRules(repoPath); err != nil {
+		return err
+	}
+	opts.FeatureLoss = &featureLoss{zipBirthTimes: format == "zip" && opts.BirthTime, zipAnnotations: format == "zip" && opts.Annotations != nil,
+		xattrsStored: format == "tar" && opts.Xattrs}
+	opts.HeavyDirs = newHeavyDirs(opts.AutoExcludeCommon)
+	if revArgs != nil {
+		if opts.ObjectPack, err = buildObjectPack(repoPath, revArgs, opts); err != nil {
+			return fmt.Errorf("error selecting objects: %v", err)
+		}
+		defer opts.ObjectPack.Close()
+		opts.Logger.Infof("Selected %d objects reachable from %s", opts.ObjectPack.Objects, strings.Join(revArgs, " "))
+		if missing := opts.ObjectPack.Missing; len(missing) > 0 {
+			warning := fmt.Sprintf("%d refs point to commits left out by --objects and will be broken after a restore (e.g. %s)", len(missing), missing[0])
+			opts.Logger.Warnf("%s", warning)
+			archiveManifest.Warnings = append(archiveManifest.Warnings, warning)
+		}
+	}
+	if opts.History == "bundle" {
+		if opts.HistoryBundle, err = createHistoryBundle(repoPath, opts); err != nil {
+			return err
+		}
+		defer opts.HistoryBundle.Close()
+		opts.Logger.Infof("Bundled the history of %d refs", opts.HistoryBundle.Refs)
+	}
+	// Recorded before any file is read, git status tells the next run which files may have changed
+	if chunked, ok := writer.(*chunkedArchive); ok {
+		if _, isGit := opts.VCS.(gitVCS); isGit {
+			state, err := readGitState(repoPath, opts)
+			if err != nil {
+				return err
+			}
+			chunked.index.Git = state
+			if !opts.FullScan && !opts.Dereference {
+				reuse.trusted = state.unchangedSince(repoPath, reuse.git, opts.Logger)
+			}
+			if reuse.trusted != nil {
+				opts.Logger.Infof("git reports %d tracked files unchanged since the previous archive, they are not read again", len(reuse.trusted))
+			}
+		}
+	}
+	// Listed before the metadata entry, which records how many files follow
+	files, err := collectSources(dirList, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeRepoMetadata(writer, repoPath, len(files), opts); err != nil {
+		return err
+	}
+	opts.Manifest = &archiveManifest
+	skipped, err := addEntry(writer, files, opts)
+	if err != nil {
+		return err
+	}
+	if opts.HistoryBundle != nil {
+		if err := opts.HistoryBundle.write(writer, opts); err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		archiveManifest.Warnings = append(archiveManifest.Warnings, skippedFilesWarning(skipped, opts.Logger))
+	}
+	// Say what could not be stored now, rather than leaving it to be noticed after a restore
+	for _, warning := range opts.FeatureLoss.warnings() {
+		opts.Logger.Warnf("%s", warning)
+		archiveManifest.Warnings = append(archiveManifest.Warnings, warning)
+	}
+
+	if !opts.NoGitDir && (indexChanged(repoPath, indexBefore) || (lockWarning == "" && indexLocked(repoPath))) {
+		warning := "git modified the index while the archive was written, the archived index may not match the working tree"
+		opts.Logger.Warnf("%s", warning)
+		archiveManifest.Warnings = append(archiveManifest.Warnings, warning)
+	}
+	if err := writeArchiveManifest(writer, &archiveManifest, opts); err != nil {
+		return err
+	}
+
+	// Flush the archive explicitly, errors from the deferred closes would go unnoticed
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error finishing archive: %v", err)
+	}
+	if err := compWriter.Close(); err != nil {
+		return fmt.Errorf("error finishing %s stream: %v", comp.Name, err)
+	}
+	if err := archiveFile.Close(); err != nil {
+		return fmt.Errorf("error closing archive file: %v", err)
+	}
+
+	// A failing validation leaves the archive in place for inspection but unsigned and uncatalogued
+	if len(opts.Validators) > 0 || len(opts.ValidateCmds) > 0 {
+		if toStdout {
+			opts.Logger.Warnf("archive written to stdout cannot be validated")
+		} else if err := validateArchive(validationTarget{Path: outputPath, Format: format, Manifest: &archiveManifest, Opts: opts}); err != nil {
+			return err
+		}
+	}
+
+	if opts.Checksum {
+		if err := writeChecksumSidecar(outputPath); err != nil {
+			return err
+		}
+		opts.Logger.Infof("Wrote checksum to %s", checksumSidecarPath(outputPath))
+	}
+	if opts.Sign != "" {
+		if err := signArchive(outputPath, opts.Sign); err != nil {
+			return err
+		}
+		opts.Logger.Infof("Wrote signature to %s", signaturePath(outputPath))
+	}
+
+	if opts.Catalog != "" && quarantine != "" {
+		opts.Logger.Warnf("quarantined archive is not registered in the catalog")
+	} else if opts.Catalog != "" && toStdout {
+		opts.Logger.Warnf("archive written to stdout is not registered in the catalog")
+	} else if opts.Catalog != "" {
+		record := newCatalogRecord(repoPath, outputPath, comp)
+		record.Encrypted = recipients != nil
+		record.Signed = opts.Sign != ""
+		record.Missed = missedFiles(skipped)
+		if err := registerArchive(opts.Catalog, record); err != nil {
+			return fmt.Errorf("archive created but not registered in catalog: %v", err)
+		}
+	}
+
+	// Answers of an interrupted run are only offered again until the archive has been written
+	session.complete()
+	if toStdout {
+		opts.Logger.Infof("Successfully wrote archive to stdout")
+	} else {
+		opts.Logger.Infof("Successfully created archive: %s", outputPath)
+	}
+	if quarantine != "" {
+		return fmt.Errorf("repository is not healthy, archive quarantined as %s", outputPath)
+	}
+	return skippedFilesError(skipped)
+}
+
+// addEntry adds the files collectSources listed to the archive.
+// With --keep-going it returns the files that could not be read instead of failing.
+func addEntry(writer entryWriter, files []archiveSource, opts ArchiveOptions) ([]skippedFile, error) {
+	opts.HeavyDirs.report(opts.Logger)
+	if tracker, ok := opts.Progress.(progressTracker); ok {
+		tracker.setTotals(len(files), progressTotalBytes(files))
+	}
+	if chunked, ok := writer.(*chunkedArchive); ok {
+		return addChunkedEntries(chunked, files, opts)
+	}
+	var skipped []skippedFile
+	for _, file := range files {
+		var err error
+		if file.Symlink != "" {
+			err = addSymlinkToArchive(writer, file.Path, file.Name, file.Symlink, opts)
+		} else if file.Fifo {
+			err = addFifoToArchive(writer, file.Path, file.Name, opts)
+		} else {
+			err = addFileToArchive(writer, file.Path, file.Name, opts)
+		}
+		var unreadable *unreadableError
+		if opts.KeepGoing && errors.As(err, &unreadable) {
+			skipped = append(skipped, skippedFile{Name: file.Name, Err: unreadable.Err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return skipped, nil
+}
+
+// collectSources lists the files an archive of dirList contains, in archive order, including submodules found on the way.
+// Directories are processed from a queue rather than by recursion, so nesting depth is not limited by the stack.
+func collectSources(dirList []RootDir, opts ArchiveOptions) ([]archiveSource, error) {
+	var sources []archiveSource
+	for len(dirList) > 0 {
+		// Pop the first RootDir
+		rootDir := dirList[0]
+		dirList = dirList[1:]
+
+		files, submodules, err := collectRootDir(rootDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, files...)
+		dirList = append(dirList, submodules...)
+	}
+	return sources, nil
+}
+
+// collectRootDir lists the files of a single repository and returns its submodules
+func collectRootDir(rootDir RootDir, opts ArchiveOptions) ([]archiveSource, []RootDir, error) {
+	// Submodules are always git repositories
+	repoVCS := opts.VCS
+	if repoVCS == nil || rootDir.Prefix != "" {
+		repoVCS = gitVCS{}
+	}
+
+	// Get tracked and untracked files, none when only .git is archived
+	var entries []string
+	if !opts.GitDirOnly {
+		tracked, err := repoVCS.ListTracked(rootDir.Dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		untracked, err := repoVCS.ListUntracked(rootDir.Dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if opts.IncludeIgnored.enabled() {
+			ignored, err := repoVCS.ListIgnored(rootDir.Dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			untracked = append(untracked, opts.IncludeIgnored.filter(filepath.ToSlash(rootDir.Prefix), ignored)...)
+		}
+		entries = append(tracked, opts.HeavyDirs.filter(rootDir, untracked)...)
+	}
+
+	var submodules []RootDir
+
+	var files []archiveSource
+	annex := isAnnexRepo(rootDir.Dir)
+
+	// Process each file/directory
+	for _, entry := range entries {
+		fullPath := filepath.Join(rootDir.Dir, entry)
+		archivePath := filepath.Join(rootDir.Prefix, entry)
+
+		// Symlinks are stored as symlinks, which is also how git tracks them
+		if !opts.Dereference {
+			if target, err := os.Readlink(fullPath); err == nil {
+				opts.FeatureLoss.checkSymlinkTarget(archivePath, target)
+				files = append(files, archiveSource{Path: fullPath, Name: archivePath, Symlink: target})
+				continue
+			}
+		}
+		// git-annex work trees are symlinks into .git/annex, which is archived with the rest of .git
+		if annex {
+			if target, ok := annexLinkTarget(fullPath); ok {
+				files = append(files, archiveSource{Path: fullPath, Name: archivePath, Symlink: target})
+				continue
+			}
+		}
+
+		// Skip non-existent paths
+		info, err := os.Stat(fullPath)
+		if os.IsNotExist(err) {
+			opts.FeatureLoss.checkSymlink(fullPath, archivePath, nil, err)
+			continue
+		} else if err != nil {
+			return nil, nil, explainPathError(fullPath, err)
+		}
+		opts.FeatureLoss.checkSymlink(fullPath, archivePath, info, nil)
+		if source, special := specialSource(fullPath, archivePath, info.Mode(), opts); special {
+			if source != nil {
+				files = append(files, *source)
+			}
+			continue
+		}
+
+		if info.IsDir() {
+			// A symlink to a directory with --dereference, reported as not archived
+			if link, err := os.Lstat(fullPath); err == nil && link.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			// Check if it's a submodule
+			if _, err := runGit(rootDir.Dir, nil, "submodule", "status", "--", entry); err == nil {
+				// Add submodule to directory list
+				submodules = append(submodules, RootDir{
+					Prefix: archivePath,
+					Dir:    fullPath,
+				})
+				continue
+			}
+		} else {
+			// Add file to archive
+			opts.FeatureLoss.checkXattrs(fullPath, archivePath)
+			files = append(files, archiveSource{Path: fullPath, Name: archivePath})
+		}
+	}
+
+	if opts.NoGitDir {
+		return files, submodules, nil
+	}
+
+	// Add the contents of the .git (or .hg) directory
+	metadataDir := filepath.Join(rootDir.Dir, repoVCS.MetadataDir())
+	if err := filepath.WalkDir(metadataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Create archive path relative to .git directory
+		relativePath, err := filepath.Rel(rootDir.Dir, path)
+		if err != nil {
+			return err
+		}
+		archivePath := filepath.Join(rootDir.Prefix, relativePath)
+		if opts.Config == "exclude" && isRootGitConfig(rootDir, relativePath) {
+			return nil
+		}
+		if !d.IsDir() && repoVCS.IsVolatile(d.Name()) {
+			return nil
+		}
+		// Selected objects come from a pack built for the archive
+		if rootDir.Prefix == "" && opts.ObjectPack != nil {
+			if relativePath == filepath.Join(".git", "objects") {
+				return filepath.SkipDir
+			}
+			if relativePath == filepath.Join(".git", "shallow") {
+				return nil
+			}
+		}
+		// Objects and refs come from the history bundle
+		if rootDir.Prefix == "" && opts.HistoryBundle != nil && bundledGitPaths[relativePath] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		mode := d.Type()
+		if mode&os.ModeSymlink != 0 {
+			if !opts.Dereference {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				opts.FeatureLoss.checkSymlinkTarget(archivePath, target)
+				files = append(files, archiveSource{Path: path, Name: archivePath, Symlink: target})
+				return nil
+			}
+			// Only copies of files can be stored
+			info, err := os.Stat(path)
+			opts.FeatureLoss.checkSymlink(path, archivePath, info, err)
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			mode = info.Mode()
+		}
+		// Sockets of daemons like git's fsmonitor live in .git
+		if source, special := specialSource(path, archivePath, mode, opts); special {
+			if source != nil {
+				files = append(files, *source)
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			opts.FeatureLoss.checkXattrs(path, archivePath)
+			files = append(files, archiveSource{Path: path, Name: archivePath})
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("error walking %s directory: %v", repoVCS.MetadataDir(), err)
+	}
+	if rootDir.Prefix == "" && opts.ObjectPack != nil {
+		files = append(files, opts.ObjectPack.Sources...)
+	}
+	if isColocatedJJRepo(rootDir.Dir) {
+		jjFiles, err := collectJJDir(rootDir, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, jjFiles...)
+	}
+
+	if opts.Reproducible {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name < files[j].Name
+		})
+	}
+	return files, submodules, nil
+}
+
+// archiveSource is a file on disk and the name it is stored under in the archive
+type archiveSource struct {
+	Path    string
+	Name    string
+	Symlink string // link target when the entry is stored as a symlink rather than a copy of its target
+	Fifo    bool   // stored as a FIFO entry, with --fifos
+}
+
+// addFileToArchive adds a single file to the archive
+func addFileToArchive(writer entryWriter, sourcePath, archivePath string, opts ArchiveOptions) error {
+	defer activeDeadline.filePhase(sourcePath)()
+	if opts.FSProfile == "network" {
+		return addNetworkFileToArchive(writer, sourcePath, archivePath, opts)
+	}
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return &unreadableError{Err: err}
+	}
+	defer file.Close()
+	return addOpenFileToArchive(writer, file, file, sourcePath, archivePath, opts)
+}
+
+// addNetworkFileToArchive reads the file in large sequential chunks and survives stale NFS handles
+func addNetworkFileToArchive(writer entryWriter, sourcePath, archivePath string, opts ArchiveOptions) error {
+	file, err := openRetryingStale(sourcePath)
+	if err != nil {
+		return &unreadableError{Err: err}
+	}
+	content := &staleRetryReader{file: file, path: sourcePath}
+	defer content.Close()
+	return addOpenFileToArchive(writer, file, bufio.NewReaderSize(content, networkReadSize), sourcePath, archivePath, opts)
+}
+
+// addOpenFileToArchive writes the header for file and then content, which reads the file
+func addOpenFileToArchive(writer entryWriter, file *os.File, content io.Reader, sourcePath, archivePath string, opts ArchiveOptions) error {
+	info, err := file.Stat()
+	if err != nil {
+		return &unreadableError{Err: err}
+	}
+
+	// Create tar header
+	header := &tar.Header{
+		Name:    archivePath,
+		Size:    info.Size(),
+		Mode:    tarMode(info.Mode()),
+		ModTime: info.ModTime(),
+	}
+	recordOwner(header, info, opts.NumericOwner)
+	opts.Annotations.annotate(header)
+	if opts.BirthTime {
+		recordBirthTime(header, sourcePath)
+	}
+	if opts.Xattrs {
+		if err := recordXattrs(header, sourcePath); err != nil {
+			return err
+		}
+	}
+
+	if opts.Reproducible {
+		makeReproducible(header, opts.SourceDateEpoch)
+	}
+
+	if opts.Config == "sanitized" && isGitConfigPath(sourcePath, archivePath) {
+		sanitized, err := sanitizeGitConfig(sourcePath)
+		if err != nil {
+			return &unreadableError{Err: err}
+		}
+		header.Size = int64(len(sanitized))
+		content = bytes.NewReader(sanitized)
+	}
+
+	opts.Progress.Entry("add", archivePath, header.Size)
+	hash := sha256.New()
+	// Write header and file contents
+	if err := writer.WriteEntry(header, io.TeeReader(content, hash)); err != nil {
+		return err
+	}
+	if opts.Manifest != nil {
+		opts.Manifest.add(header, hash.Sum(nil))
+	}
+	return nil
+}
+
+// RestoreOptions holds the options of a restore run, from the command line or an embedding application
+type RestoreOptions struct {
+	ReflinkFrom       string           // existing restore to clone unchanged files from
+	DecompressCmd     string           // external decompressor command line
+	NoDereferenceRoot bool             // use repoPath as given instead of resolving symlinks
+	ExecReport        bool             // report executables that git does not track as executable
+	FidelityReport    bool             // list entries whose mode, modification time or owner differ from the archive
+	Verify            bool             // re-read restored files and compare them with the archived content
+	VerifyExisting    float64          // probability of comparing a file skipped as up to date with the archive
+	ProgressFile      string           // JSON status file for external UIs
+	ProgressBar       bool             // show a progress bar on stderr instead of one line per file
+	FSProfile         string           // auto, local or network: I/O tuning for the filesystem holding the repository
+	Paths             pathPatterns     // restore only the entries matching one of these globs
+	Annotations       annotationFilter // restore only the entries carrying these annotations
+	VerifySig         bool             // refuse archives without a valid detached gpg signature
+	Identities        stringList       // age identity files to decrypt the archive with
+	DryRun            bool             // print what would be restored and deleted without changing anything
+	Verbosity         verbosity        // quiet, normal or verbose output of the default Logger and Progress
+	JSON              bool             // report every entry, message and the summary as NDJSON events
+	LogFile           string           // file to append a timestamped record of the run to
+	MangleNames       string           // auto, none, windows or apfs: renaming of names the filesystem cannot represent
+	PreserveOwner     bool             // give restored files the archived owner and group
+	NumericOwner      bool             // with PreserveOwner, use the archived ids and ignore the names
+	Xattrs            bool             // apply the archived extended attributes and POSIX ACLs
+	UserMap           idMap            // archived user id or name to local one, with PreserveOwner
+	GroupMap          idMap            // archived group id or name to local one, with PreserveOwner
+	OwnerMap          string           // file with further user and group mappings
+	Mangler           nameMangler      // resolved from MangleNames, nil to restore names unchanged
+	CaseCollisions    string           // rename, skip or overwrite: entries whose names differ only in case on a case-insensitive filesystem
+	TrustArchive      bool             // restore absolute names, names climbing out of the target and symlinks pointing out of it
+	Stdout            io.Writer        // destination of the reports and JSON events, os.Stdout when nil
+	Logger            Logger
+	Progress          ProgressReporter
+}
+
+// withDefaults fills unset options with the stdout implementations
+func (opts RestoreOptions) withDefaults() RestoreOptions {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Logger == nil {
+		opts.Logger = newLogger(os.Stdout, opts.Verbosity)
+	}
+	if opts.Progress == nil {
+		opts.Progress = newProgress(os.Stdout, opts.Verbosity)
+	}
+	return opts
+}
+
+// restoreGitRepo restores a Git repository from a compressed tar archive
+func restoreGitRepo(repoPath, archiveName string, opts RestoreOptions) error {
+	opts = opts.withDefaults()
+
+	if opts.JSON {
+		events := newJSONEvents(opts.Stdout)
+		opts.Logger, opts.Progress, opts.JSON, opts.ProgressBar = events, events, false, false
+		err := restoreGitRepo(repoPath, archiveName, opts)
+		events.finish(err)
+		return err
+	}
+
+	if opts.ProgressBar {
+		bar := startProgressBar(os.Stderr, opts.Logger)
+		opts.Logger, opts.Progress, opts.ProgressBar = bar, bar, false
+		err := restoreGitRepo(repoPath, archiveName, opts)
+		bar.finish()
+		return err
+	}
+
+	if opts.ProgressFile != "" {
+		tracker := startProgressFile(opts.ProgressFile, "restoring", 0, opts.Progress)
+		opts.Progress, opts.ProgressFile = tracker, ""
+		err := restoreGitRepo(repoPath, archiveName, opts)
+		tracker.finish(err)
+		return err
+	}
+
+	if opts.LogFile != "" {
+		log, err := openLogFile(opts.LogFile, opts.Logger, opts.Progress)
+		if err != nil {
+			return err
+		}
+		opts.Logger, opts.Progress, opts.LogFile = log, log, ""
+		err = restoreGitRepo(repoPath, archiveName, opts)
+		log.finish(err)
+		return err
+	}
+
+	// A damaged or forged archive is refused before anything in the repository is touched
+	if opts.VerifySig {
+		signer, err := verifyArchiveSignature(archiveName)
+		if err != nil {
+			return err
+		}
+		opts.Logger.Infof("Good signature from %s", signer)
+	}
+	if archiveName != "-" {
+		found, err := verifyChecksumSidecar(archiveName)
+		if err != nil {
+			return err
+		}
+		if found {
+			opts.Logger.Infof("Archive matches %s", checksumSidecarPath(archiveName))
+		}
+	}
+
+	// Open the archive file and create tar reader
+	tarReader, err := openDecryptedArchive(archiveName, opts.DecompressCmd, opts.Identities)
+	if err != nil {
+		return err
+	}
+	defer tarReader.Close()
+	if tracker, ok := opts.Progress.(progressTracker); ok && tarReader.InputSize > 0 {
+		tracker.setPosition(tarReader.InputSize, tarReader.InputPosition)
+	}
+	if opts.DryRun {
+		return dryRunRestore(repoPath, tarReader, opts)
+	}
+
+	// Nothing can need cleaning up in a new directory, which spares restores on machines without git
+	existing, _ := os.ReadDir(repoPath)
+	fresh := len(existing) == 0
+
+	// Ensure the repository directory exists
+	if err := os.MkdirAll(repoPath, 0755); err != nil {
+		return fmt.Errorf("error creating repository directory: %v", err)
+	}
+	repoPath, err = canonicalRoot(repoPath, opts.NoDereferenceRoot)
+	if err != nil {
+		return fmt.Errorf("error resolving repository directory: %v", err)
+	}
+	if opts.FSProfile, err = resolveFSProfile(opts.FSProfile, repoPath, opts.Logger); err != nil {
+		return err
+	}
+	if opts.Mangler, err = resolveMangler(opts.MangleNames, repoPath, opts.Logger); err != nil {
+		return err
+	}
+	collisions, err := newCaseCollisions(opts.CaseCollisions, repoPath)
+	if err != nil {
+		return err
+	}
+	if !opts.PreserveOwner && (len(opts.UserMap) > 0 || len(opts.GroupMap) > 0 || opts.OwnerMap != "" || opts.NumericOwner) {
+		return fmt.Errorf("--map-user, --map-group, --owner-map and --numeric-owner only apply with --preserve-owner")
+	}
+	if opts.OwnerMap != "" {
+		if err := loadOwnerMap(opts.OwnerMap, &opts.UserMap, &opts.GroupMap); err != nil {
+			return err
+		}
+	}
+	mangled := make(map[string]string)
+
+	// Create a set to store unique extracted file paths
+	extractedPaths := make(map[string]string) // by pathKey
+	var executables []executableEntry
+	var restored []fidelityEntry // with --fidelity-report
+	var annexLinks []string
+	var archivedManifest *manifest
+	var bundlePath string // extracted history bundle, removed once applied
+	stats := &restoreStats{}
+	guard := newRestoreGuard(repoPath, opts.TrustArchive)
+
+	// Extract files from the archive
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break // End of archive
+		}
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimPrefix(header.Name, "./") == archiveManifestName {
+			if archivedManifest, err = readManifestEntry(tarReader); err != nil {
+				opts.Logger.Warnf("%v, restored files are not checked against it", err)
+			}
+			continue
+		}
+		// A partial restore leaves the history alone
+		if strings.TrimPrefix(header.Name, "./") == historyBundleName && len(opts.Paths) == 0 && len(opts.Annotations) == 0 {
+			if bundlePath, err = extractHistoryBundle(repoPath, tarReader); err != nil {
+				return err
+			}
+			defer os.Remove(bundlePath)
+			continue
+		}
+		// Skip everything but regular files, symlinks and FIFOs, and repoark's own metadata
+		if (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeFifo) || isMetaEntry(header.Name) {
+			continue
+		}
+		if !opts.Paths.matches(header.Name) || !opts.Annotations.matches(entryAnnotations(header)) {
+			continue
+		}
+
+		// name is where the entry goes, header.Name unless the filesystem cannot represent it
+		name := header.Name
+		if opts.Mangler != nil {
+			if name = manglePath(opts.Mangler, header.Name); name != header.Name {
+				mangled[name] = header.Name
+			}
+		}
+		// A case-insensitive filesystem would store names differing only in case as one file
+		restoredName, ok := collisions.resolve(name, header.Name)
+		if !ok {
+			continue
+		}
+		if restoredName != name {
+			delete(mangled, name)
+			mangled[restoredName] = header.Name
+			name = restoredName
+		}
+
+		targetPath, err := guard.target(name)
+		if err != nil {
+			stats.fail(opts, name, "%v", err)
+			continue
+		}
+		extractedPaths[pathKey(name)] = name // notice name is relative path and always use slash as separator
+		if opts.FidelityReport {
+			restored = append(restored, fidelityEntry{Target: targetPath, Header: *header})
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			if strings.Contains(header.Linkname, annexObjectsDir) {
+				annexLinks = append(annexLinks, name)
+			}
+			if err := restoreSymlink(targetPath, header, guard, opts, stats); err != nil {
+				stats.fail(opts, targetPath, "%v", err)
+			}
+			guard.forget()
+			continue
+		}
+		if header.Typeflag == tar.TypeFifo {
+			if err := restoreFifo(targetPath, header, opts, stats); err != nil {
+				stats.fail(opts, targetPath, "%v", err)
+			}
+			continue
+		}
+		if isExecutableMode(header.Mode) {
+			executables = append(executables, executableEntry{Path: header.Name, Mode: header.FileInfo().Mode()})
+		}
+		if err := restoreEntry(name, targetPath, header, tarReader, opts, stats); err != nil {
+			// A broken archive stream cannot be recovered from, filesystem problems only fail the entry
+			var archiveErr *archiveError
+			if errors.As(err, &archiveErr) {
+				return err
+			}
+			stats.fail(opts, targetPath, "%v", err)
+		}
+	}
+
+	if archivedManifest != nil {
+		stats.checkManifest(archivedManifest, repoPath, opts)
+	}
+	if stats.XattrsLost > 0 {
+		opts.Logger.Warnf("%d extended attributes could not be restored (e.g. %s)", stats.XattrsLost, stats.xattrExample)
+	}
+	if len(annexLinks) > 0 {
+		checkAnnexContent(repoPath, annexLinks, opts)
+	}
+	collisions.report(opts.Logger)
+	if opts.Mangler != nil || len(mangled) > 0 {
+		scheme := "case"
+		if opts.Mangler != nil {
+			scheme = opts.Mangler.Name()
+		}
+		if n := len(mangled) - collisions.renamed(); n > 0 {
+			opts.Logger.Warnf("%d names cannot be represented on this filesystem and were mangled, run 'repoark unmangle' on a filesystem that can", n)
+		}
+		if err := recordMangledNames(repoPath, scheme, mangled); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Paths) > 0 || len(opts.Annotations) > 0 {
+		// A partial restore only adds files, everything else in the target is left alone
+		if opts.FidelityReport {
+			if err := printFidelityReport(opts.Stdout, restored, opts); err != nil {
+				return err
+			}
+		}
+		opts.Logger.Infof("%s", stats.summary(opts.Verify))
+		if err := stats.err(); err != nil {
+			return err
+		}
+		opts.Logger.Infof("Successfully restored selected paths to: %s", repoPath)
+		return nil
+	}
+
+	// An archive of the working tree or .git alone says nothing about the rest of the target, which is left as it is
+	worktreeOnly := archivedManifest != nil && archivedManifest.WorktreeOnly
+	gitDirOnly := archivedManifest != nil && archivedManifest.GitDirOnly
+	if bundlePath != "" {
+		if err := applyHistoryBundle(repoPath, bundlePath, opts.Logger); err != nil {
+			return err
+		}
+	}
+	if worktreeOnly {
+		opts.Logger.Infof("Archive holds the working tree only, other files in %s are left alone", repoPath)
+	} else if err := regenerateGitConfig(repoPath, opts); err != nil {
+		return err
+	}
+	if gitDirOnly {
+		opts.Logger.Infof("Archive holds .git only, the working tree in %s is left alone, 'git checkout -f' there rebuilds it from HEAD", repoPath)
+	}
+
+	// list untracked files and remove items not in extractedPaths
+	var untracked []string
+	if !fresh && !worktreeOnly && !gitDirOnly {
+		if untracked, err = listUntrackedForCleanup(repoPath); err != nil {
+			return err
+		}
+	}
+
+	// Process each file/directory
+	for _, entry := range untracked {
+		// entry is relative path and always use slash as separator
+		// Skip files that were just extracted, whichever normalization form git lists them in
+		if name, exists := extractedPaths[pathKey(entry)]; exists && isSamePath(repoPath, entry, name) {
+			continue
+		}
+		targetPath := filepath.Join(repoPath, entry)
+		opts.Progress.Entry("remove", targetPath, 0)
+		if err := removeExistingPath(targetPath); err != nil {
+			stats.fail(opts, targetPath, "%v", err)
+			continue
+		}
+		stats.Removed++
+	}
+	// A restore resumes an interrupted merge where it was, with the same paths in conflict
+	if archivedManifest != nil && len(archivedManifest.Unmerged) > 0 {
+		if err := restoreUnmerged(repoPath, archivedManifest.Unmerged, opts.Logger); err != nil {
+			stats.fail(opts, filepath.Join(repoPath, ".git", "index"), "%v", err)
+		}
+	}
+
+	if opts.ExecReport {
+		if err := printExecutableReport(opts.Stdout, repoPath, executables); err != nil {
+			return err
+		}
+	}
+	if opts.FidelityReport {
+		if err := printFidelityReport(opts.Stdout, restored, opts); err != nil {
+			return err
+		}
+	}
+
+	opts.Logger.Infof("%s", stats.summary(opts.Verify))
+	if err := stats.err(); err != nil {
+		return err
+	}
+	opts.Logger.Infof("Successfully restored repository to: %s", repoPath)
+	return nil
+}
+
+// restoreEntry materializes one regular file entry, restored as name, at targetPath
+func restoreEntry(name, targetPath string, header *tar.Header, tarReader *archiveReader, opts RestoreOptions, stats *restoreStats) error {
+	// check localfile first, if exist, and ModTime is the same with header.ModeTime, skip
+	if stat, err := os.Stat(targetPath); err == nil {
+		if isUpToDate(stat, header) {
+			if stat.Mode().IsRegular() && shouldCheckExisting(opts) {
+				if err := checkExistingFile(targetPath, header, tarReader, opts, stats); err != nil {
+					return err
+				}
+				return restoreOwner(targetPath, header, opts)
+			}
+			opts.Progress.Entry("skip", targetPath, header.Size)
+			stats.Skipped++
+			return restoreOwner(targetPath, header, opts)
+		}
+
+		// Try to remove first
+		if err := removeExistingPath(targetPath); err != nil {
+			return err
+		}
+	}
+
+	var archivedSum []byte
+	if cloned := opts.ReflinkFrom != "" && reflinkFromPrevious(opts.ReflinkFrom, name, targetPath, header, opts); cloned {
+		if opts.Verify {
+			sum, err := hashEntry(tarReader)
+			if err != nil {
+				return err
+			}
+			archivedSum = sum
+		}
+	} else {
+		sum, err := extractFile(targetPath, header, tarReader, opts)
+		if err != nil {
+			return err
+		}
+		archivedSum = sum
+		stats.BytesWritten += header.Size
+	}
+	if archivedSum != nil {
+		stats.recordSum(header.Name, archivedSum)
+	}
+	// restore the owner first, changing it clears setuid and setgid bits
+	if err := restoreOwner(targetPath, header, opts); err != nil {
+		return err
+	}
+	// before the permissions, which may not allow writing attributes
+	restoreXattrs(targetPath, header, opts, stats)
+	// restore file permission
+	if err := os.Chmod(targetPath, header.FileInfo().Mode()); err != nil {
+		return fmt.Errorf("error setting file permission: %v", err)
+	}
+	// restore header.ModTime
+	if err := os.Chtimes(targetPath, header.ModTime, header.ModTime); err != nil {
+		return fmt.Errorf("error setting file modification time: %v", err)
+	}
+	// restore the creation time where the platform allows it
+	if err := restoreBirthTime(targetPath, header); err != nil {
+		return err
+	}
+	stats.Restored++
+
+	if opts.Verify {
+		verifyRestoredFile(targetPath, archivedSum, stats, opts)
+	}
+	return nil
+}
+
+// isUpToDate reports whether the file on disk matches the entry closely enough for restore to skip it
+func isUpToDate(stat os.FileInfo, header *tar.Header) bool {
+	return stat.ModTime().Round(time.Second) == header.ModTime.Round(time.Second) && (stat.IsDir() == (header.Typeflag == tar.TypeDir))
+}
+
+// canonicalRoot resolves root to an absolute path without symlinks, so that paths joined
+// onto it agree with the paths git reports for the same work tree
+func canonicalRoot(root string, noDereference bool) (string, error) {
+	// Absolute either way, Windows only accepts paths beyond MAX_PATH when they are
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if noDereference {
+		return absRoot, nil
+	}
+	return filepath.EvalSymlinks(absRoot)
+}
+
+// remove existing file
+func removeExistingPath(targetPath string) error {
+	err := os.RemoveAll(targetPath)
+	if err != nil {
+		removed := false
+		// If removal failed, try changing permissions and remove again
+		if os.IsPermission(err) {
+			// Add write permission to all user bits
+			if err := os.Chmod(targetPath, 0666); err == nil {
+				// Try removal again after permission change
+				err = os.RemoveAll(targetPath)
+				removed = err == nil
+			}
+		}
+		if !removed {
+			return fmt.Errorf("error removing existing file: %v", err)
+		}
+	}
+	return nil
+}
+
+// ensureParentDir creates the parent directory of targetPath, replacing a file in its way
+func ensureParentDir(targetPath string) error {
+	dir := filepath.Dir(targetPath)
+	// Check if directory exists and is a file
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		if err := removeExistingPath(dir); err != nil {
+			return fmt.Errorf("error removing existing file at directory path: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", explainPathError(dir, err))
+	}
+	return nil
+}
+
+// explainPathError turns a name-too-long failure into an error that says how long and deep the path is
+func explainPathError(path string, err error) error {
+	if !errors.Is(err, syscall.ENAMETOOLONG) {
+		return err
+	}
+	depth := strings.Count(filepath.ToSlash(filepath.Clean(path)), "/")
+	return fmt.Errorf("path is too long for this platform (%d bytes, %d levels deep): %s", len(path), depth, path)
+}
+
+// extractFile writes the current entry to targetPath and returns the SHA-256 of its content
+func extractFile(targetPath string, header *tar.Header, tarReader *archiveReader, opts RestoreOptions) ([]byte, error) {
+	// Ensure the directory exists
+	if err := ensureParentDir(targetPath); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %v", explainPathError(targetPath, err))
+	}
+	defer file.Close()
+
+	opts.Progress.Entry("restore", targetPath, header.Size)
+
+	// Network filesystems get large writes instead of one round trip per read from the archive
+	var out io.Writer = file
+	var buffered *bufio.Writer
+	if opts.FSProfile == "network" {
+		buffered = bufio.NewWriterSize(file, networkReadSize)
+		out = buffered
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), tarReader)
+	if err == nil && buffered != nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		// Never leave a partially written file behind
+		file.Close()
+		os.Remove(targetPath)
+		var archiveErr *archiveError
+		if errors.As(err, &archiveErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error writing file content: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("error writing file content: %v", err)
+	}
+	return hash.Sum(nil), nil
+}
+
+// findAvailableArchiveName returns <baseName><ext> in dirName, or the first <baseName>-<n><ext> not taken
+func findAvailableArchiveName(dirName string, baseName string, ext string) string {
+	archiveName := filepath.Join(dirName, fmt.Sprintf("%s%s", baseName, ext))
+
+	// Check if the file exists
+	if !archiveExists(archiveName) {
+		// File does not exist, return the path
+		return archiveName
+	}
+
+	i := 1
+	for {
+		archiveName := filepath.Join(dirName, fmt.Sprintf("%s-%d%s", baseName, i, ext))
+		if !archiveExists(archiveName) {
+			return archiveName
+		}
+		i++
+	}
+}
+
+// archiveExtension returns the file extension of archives written with opts
+func archiveExtension(opts ArchiveOptions) (string, error) {
+	suffix := ""
+	if len(opts.EncryptRecipients) > 0 || opts.Password {
+		suffix = ageExtension
+	}
+	if opts.Format == "zip" {
+		return ".zip" + suffix, nil
+	}
+	comp, err := resolveCompressor(opts.Compression, opts.CompressCmd, "")
+	if err != nil {
+		return "", err
+	}
+	return comp.Extensions[0] + suffix, nil
+}
+
+// addRestoreFlags defines the restore options on fs, for restore and the entries of restore-all
+func addRestoreFlags(fs *flag.FlagSet, opts *RestoreOptions) {
+	fs.StringVar(&opts.ReflinkFrom, "reflink-from", "", "")
+	fs.BoolVar(&opts.NoDereferenceRoot, "no-dereference-root", false, "")
+	fs.StringVar(&opts.DecompressCmd, "decompress-cmd", "", "")
+	fs.BoolVar(&opts.ExecReport, "exec-report", false, "")
+	fs.BoolVar(&opts.FidelityReport, "fidelity-report", false, "")
+	fs.BoolVar(&opts.Verify, "verify", false, "")
+	fs.Func("verify-existing", "", func(s string) (err error) {
+		opts.VerifyExisting, err = parseProbability(s)
+		return err
+	})
+	fs.StringVar(&opts.ProgressFile, "progress-file", "", "")
+	fs.BoolVar(&opts.ProgressBar, "progress", false, "")
+	fs.StringVar(&opts.FSProfile, "fs-profile", "", "")
+	fs.Var(&opts.Paths, "path", "")
+	fs.Func("paths-from", "", opts.Paths.readFrom)
+	fs.Var(&opts.Annotations, "annotation", "")
+	fs.BoolVar(&opts.VerifySig, "verify-sig", false, "")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "")
+	addVerbosityFlags(fs, &opts.Verbosity)
+	fs.BoolVar(&opts.JSON, "json", false, "")
+	fs.StringVar(&opts.LogFile, "log-file", "", "")
+	fs.StringVar(&opts.MangleNames, "mangle-names", "auto", "")
+	fs.StringVar(&opts.CaseCollisions, "case-collisions", "rename", "")
+	fs.BoolVar(&opts.PreserveOwner, "preserve-owner", false, "")
+	fs.BoolVar(&opts.NumericOwner, "numeric-owner", false, "")
+	fs.BoolVar(&opts.Xattrs, "xattrs", false, "")
+	fs.Var(&opts.UserMap, "map-user", "")
+	fs.Var(&opts.GroupMap, "map-group", "")
+	fs.StringVar(&opts.OwnerMap, "owner-map", "", "")
+	fs.Var(&opts.Identities, "identity", "")
+	fs.BoolVar(&opts.TrustArchive, "trust-archive", false, "")
+}
+
+// print usage information
+func printUsage() {
+	fmt.Println(`Usage:
+repoark [create] [options] <repository-path> [<output-file>|-]
+repoark [create] [options] @<group> [<output-dir>]
+repoark restore [options] <archive-file>|- <repository-path>
+repoark restore-all [-j <n>] [--report <file>] <plan.yaml>
+repoark list [--json|--inventory|--metadata] [--annotation <key>[=<value>]] <archive-file>|-
+repoark info <archive-file>|-...
+repoark cat <archive-file>|- <path-in-archive>
+repoark share [--listen <[host]:port>] <archive-file>
+repoark diff [--content] <old-archive> <new-archive>
+repoark verify [--catalog <file>] <archive-file> <repository-path>
+repoark verify [--catalog <file>] @<group> [<archive-dir>]
+repoark verify --against-git <archive-file>|-
+repoark snapshot [--dereference] [-q|-v] <repository-path> <snapshot-dir>
+repoark analyze [--compress <name>] [--dereference] [-q|-v] <repository-path>
+repoark migrate [options] [--force] <repository-path> <[user@]host:path|ssh://host/path|s3://bucket/prefix>
+repoark remote ls <url>
+repoark remote rm [--permanent] [--undelete-window <duration>] <url>
+repoark undelete <url>
+repoark unmangle <repository-path>
+repoark make-restorer [--goos <os>] [--goarch <arch>] [--source <checkout>] <output-file>
+repoark upgrade-archive <archive-file> <output-file>
+repoark import [--catalog <file>] [--repo <name>] <archive-file>
+repoark catalog report [--catalog <file>] [--format csv|json] [--max-age <duration>]
+repoark secret set|get|delete <name>
+
+Options:
+  --format <format>      tar (default) or zip; inferred from the output extension when omitted
+  --compress <format>    gzip (default), zstd, xz, lz4 or none; inferred from the output extension when omitted
+  --level <n>            compression level: gzip, zip and lz4 1-9, zstd 1-19
+  -j, --jobs <n>         compress gzip and zstd archives on n cores (default: all)
+  --compress-cmd <cmd>   pipe the tar stream through an external compressor, e.g. 'xz -9 -T0'
+  --split-size <size>    write volumes of at most size (e.g. 2G) named <output>.001, .002, ...
+  --reproducible         byte-identical output for identical repository states (honours SOURCE_DATE_EPOCH)
+  --config <mode>        .git/config handling: include (default), exclude or sanitized
+  --objects <spec>       all (default) or reachable-from=<rev-list args>, e.g. 'reachable-from=main --since=6.months'
+  --history <mode>       copy (default) copies .git/objects and refs as they are, bundle stores them as a git bundle --all
+  --tmpdir <dir>         write temporary data there instead of $TMPDIR, falling back to the repository
+                         when it has too little space free (also for migrate to s3)
+  --birthtime            record file creation times (APFS, NTFS, recent Linux filesystems)
+  --size-budget <size>   refuse archives estimated above size (e.g. 2G) unless untracked content is excluded
+  --auto-exclude-largest <n>
+                         exclude up to n of the largest untracked items to fit the size budget
+  --auto-exclude-common  leave out untracked node_modules, target, .venv, build and .gradle directories
+  --include-ignored[=<glob>]
+                         archive the files .gitignore ignores too, or only those matching glob (repeatable),
+                         e.g. --include-ignored=.env (also for migrate)
+  --catalog <file>       register the archive in a catalog file (default $REPOARK_CATALOG)
+  --dereference          store copies of symlink targets instead of the symlinks
+  --numeric-owner        record file owners and groups by id only, without user and group names
+  --xattrs               record extended attributes and POSIX ACLs (tar only)
+  --fifos                store FIFOs as FIFO entries; sockets and device nodes are always left out
+  --no-dereference-root  use the repository path as given instead of resolving symlinks
+  --index-lock-timeout <duration>
+                         wait this long (default 10s) for a running git command to release the index
+  --timeout <duration>   fail the run when it takes longer, e.g. 2h; of a group, each repository
+  --git-timeout <duration>
+                         fail the run when one git or hg command takes longer
+  --file-timeout <duration>
+                         fail the run when reading one file takes longer, e.g. on a hung network mount
+  --upload-timeout <duration>
+                         migrate: fail the run when the upload, ssh transfer or one ssh check takes longer
+  --fs-profile <profile> auto (default), local or network: large sequential I/O and stale handle retries
+                         on NFS/SMB, also for restore
+  --progress             show a progress bar with files, bytes, throughput and ETA, also for restore
+  --progress-file <path> keep a JSON status file (phase, percent, ETA) updated, also for restore
+  -v, --verbose          print one line per file, also for restore
+  -q, --quiet            print errors only, also for restore
+  --json                 print one JSON object per line for every file, message, error and the summary,
+                         also for restore
+  --log-file <path>      append a timestamped record of every action to path, whatever -q/-v, also for restore
+  --keep-going           leave unreadable files out instead of failing, list them at the end and exit with 3
+  --reuse-from <archive> copy the compressed chunks of unchanged files from a previous tar.gz archive
+  --full-scan            with --reuse-from, compare every file with the previous archive instead of trusting
+                         git status
+  --no-git-dir           archive the tracked and untracked working files only, without .git and its history
+  --git-dir-only         archive the .git directory only (refs, objects, config, hooks), without the working tree
+  --name-template <tmpl> name archives written without an output file after a template with {repo}, {branch},
+                         {shortsha}, {date} and {time}, e.g. '{repo}-{branch}-{shortsha}-{date}.tar.zst'
+  --checksum             write the SHA-256 of the archive to <output>.sha256, checked by restore when present
+  --encrypt-recipient <r>
+                         encrypt the archive with age for an age1... or ssh- public key, or the keys listed in
+                         a file (repeatable); .age is appended to the default name
+  --password             encrypt the archive with a password from $REPOARK_PASSWORD or asked for on the
+                         terminal, restore asks for it in turn
+  --sign <keyid>         write a detached gpg signature of the archive to <output>.sig
+  --require-healthy      refuse to archive a git repository failing git fsck --connectivity-only, an index
+                         read or the loose object checksums
+  --quarantine           with --require-healthy, write the archive of an unhealthy repository as
+                         <name>.quarantined<ext> instead of refusing, and exit with 5
+  --validate <list>      check the finished archive before signing and cataloguing it, exit with 4 when it
+                         fails: structure, manifest, restore or all, comma separated
+  --validate-cmd <cmd>   run cmd with the archive path as its last argument, a non-zero exit fails the
+                         archive (repeatable)
+
+Restore options:
+  --reflink-from <dir>   clone unchanged files from an existing restore (Btrfs/XFS/APFS)
+  --path <glob>          restore only matching entries, e.g. 'src/' or '.git/refs/**' (repeatable)
+  --paths-from <file>    restore only the entries matching the patterns in file, one per line
+  --interactive          pick the files and directories to restore from a tree of the archive,
+                         the selection is saved to <archive>.paths for --paths-from
+  --annotation <key>[=<value>]
+                         restore only entries annotated so by .repoarkattributes (repeatable)
+  --verify               re-read restored files and compare them with the archive
+  --verify-sig           refuse to extract unless <archive>.sig is a valid gpg signature of the archive
+  --verify-existing <p>  compare files skipped as up to date with the archive at probability p (e.g. 0.1, 10%
+                         or 1 for all) and rewrite the ones that differ
+  --dry-run              print the files that would be created, overwritten, skipped and deleted, change nothing
+  --exec-report          list restored executables not tracked as executable by git, with hashes
+  --fidelity-report      list restored entries whose mode, modification time or owner differ from the archive
+  --mangle-names <mode>  auto (default), none, windows or apfs: rename what the filesystem cannot store,
+                         reversed by 'repoark unmangle'
+  --case-collisions <s>  rename (default), skip or overwrite: on a case-insensitive filesystem, entries whose
+                         names differ only in case, e.g. README.md and Readme.md; renames are reversed by
+                         'repoark unmangle'
+  --preserve-owner       give files the archived owner and group (needs root)
+  --xattrs               apply the archived extended attributes and POSIX ACLs
+  --numeric-owner        with --preserve-owner, use the archived ids and ignore user and group names
+  --map-user <old:new>   with --preserve-owner, map an archived uid or user name to a local one (repeatable)
+  --map-group <old:new>  the same for groups (repeatable)
+  --owner-map <file>     read further mappings from lines 'user old:new' and 'group old:new'
+  --decompress-cmd <cmd> pipe the archive through an external decompressor, e.g. 'xz -d'
+  --identity <file>      age identity or SSH private key to decrypt an encrypted archive (repeatable,
+                         default $REPOARK_AGE_IDENTITY, which list, info, cat and the other commands use)
+  --trust-archive        restore entries with absolute names, names leading out of the target directory
+                         and symlinks pointing out of it instead of refusing them`)
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	fs.Usage = printUsage
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			os.Exit(exitUsage)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Main runs the repoark command line with os.Args
+func Main() {
+	// Check for repository path argument
+	argsLen := len(os.Args)
+	if argsLen < 2 {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	checkRestorerCommand(os.Args[1])
+
+	if os.Args[1] == "restore-all" {
+		fs := flag.NewFlagSet("restore-all", flag.ContinueOnError)
+		var parallel int
+		fs.IntVar(&parallel, "jobs", 0, "")
+		fs.IntVar(&parallel, "j", 0, "")
+		report := fs.String("report", "", "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := restoreAll(args[0], parallel, *report, os.Stdout); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			printPartialSummary(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "make-restorer" {
+		fs := flag.NewFlagSet("make-restorer", flag.ContinueOnError)
+		goos := fs.String("goos", runtime.GOOS, "")
+		goarch := fs.String("goarch", runtime.GOARCH, "")
+		source := fs.String("source", "", "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := makeRestorer(*goos, *goarch, *source, args[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "remote" && argsLen > 2 && os.Args[2] == "rm" {
+		fs := flag.NewFlagSet("remote rm", flag.ContinueOnError)
+		permanent := fs.Bool("permanent", false, "")
+		window := fs.String("undelete-window", "30d", "")
+		args := parseArgs(fs, os.Args[3:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		undeleteWindow, err := parseAge(*window)
+		if err == nil {
+			err = softDeleteRemote(args[0], undeleteWindow, *permanent)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "remote" {
+		if argsLen != 4 || os.Args[2] != "ls" {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := listRemoteArchives(os.Args[3]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "share" {
+		fs := flag.NewFlagSet("share", flag.ContinueOnError)
+		listen := fs.String("listen", "localhost:8000", "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := shareArchive(args[0], *listen); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "snapshot" {
+		fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+		var opts ArchiveOptions
+		fs.BoolVar(&opts.Dereference, "dereference", false, "")
+		fs.BoolVar(&opts.NoDereferenceRoot, "no-dereference-root", false, "")
+		addVerbosityFlags(fs, &opts.Verbosity)
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := snapshotRepo(args[0], args[1], opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "analyze" {
+		fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+		var opts ArchiveOptions
+		compression := fs.String("compress", "", "")
+		fs.BoolVar(&opts.Dereference, "dereference", false, "")
+		fs.BoolVar(&opts.NoDereferenceRoot, "no-dereference-root", false, "")
+		addVerbosityFlags(fs, &opts.Verbosity)
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		names := []string{*compression}
+		if *compression == "" {
+			names = []string{"gzip", "zstd", "lz4", "xz"}
+		}
+		if err := analyzeRepo(args[0], names, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "upgrade-archive" {
+		if argsLen != 4 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := upgradeArchive(os.Args[2], os.Args[3], newLogger(os.Stdout, verbosityNormal)); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "import" {
+		fs := flag.NewFlagSet("import", flag.ContinueOnError)
+		catalogFile := fs.String("catalog", "", "")
+		repoName := fs.String("repo", "", "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := importArchive(args[0], *repoName, catalogPath(*catalogFile)); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "undelete" {
+		if argsLen != 3 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := undeleteRemote(os.Args[2]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "unmangle" {
+		if argsLen != 3 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := unmangleRepo(os.Args[2], newLogger(os.Stdout, verbosityNormal)); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "secret" {
+		if argsLen != 4 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := runSecretCommand(os.Args[2], os.Args[3]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "catalog" {
+		if argsLen < 3 || os.Args[2] != "report" {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		fs := flag.NewFlagSet("catalog report", flag.ContinueOnError)
+		catalogFile := fs.String("catalog", "", "")
+		format := fs.String("format", "csv", "")
+		maxAge := fs.String("max-age", "", "")
+		if args := parseArgs(fs, os.Args[3:]); len(args) != 0 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := reportCatalog(catalogPath(*catalogFile), *format, *maxAge); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "verify" {
+		fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+		catalogFile := fs.String("catalog", "", "")
+		againstGit := fs.Bool("against-git", false, "")
+		args := parseArgs(fs, os.Args[2:])
+		if *againstGit {
+			if len(args) != 1 {
+				printUsage()
+				os.Exit(exitUsage)
+			}
+			if err := verifyAgainstGit(args[0]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+		if len(args) >= 1 && len(args) <= 2 && strings.HasPrefix(args[0], "@") {
+			group, err := loadGroup(args[0][1:])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			archiveDir := group.Policy["output"]
+			if len(args) == 2 {
+				archiveDir = args[1]
+			}
+			if *catalogFile == "" {
+				*catalogFile = group.Policy["catalog"]
+			}
+			if err := verifyGroup(group, archiveDir, catalogPath(*catalogFile), group.Policy["name-template"], os.Stdout); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := verifyArchive(args[0], args[1], catalogPath(*catalogFile), os.Stdout); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "diff" {
+		fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+		content := fs.Bool("content", false, "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := diffArchives(args[0], args[1], *content); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "cat" {
+		fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := catArchive(args[0], args[1]); err != nil {
+			// stdout carries the file content
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "info" || os.Args[1] == "stats" {
+		fs := flag.NewFlagSet(os.Args[1], flag.ContinueOnError)
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) < 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := printArchiveInfo(args); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "list" {
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		asJSON := fs.Bool("json", false, "")
+		asInventory := fs.Bool("inventory", false, "")
+		asMetadata := fs.Bool("metadata", false, "")
+		var annotations annotationFilter
+		fs.Var(&annotations, "annotation", "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 1 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		var err error
+		if *asInventory {
+			err = printInventory(args[0])
+		} else if *asMetadata {
+			err = printRepoMetadata(args[0])
+		} else {
+			err = listArchive(args[0], *asJSON, annotations)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "migrate" {
+		fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		var opts ArchiveOptions
+		fs.StringVar(&opts.Compression, "compress", "", "")
+		fs.IntVar(&opts.Level, "level", 0, "")
+		fs.IntVar(&opts.Jobs, "jobs", runtime.NumCPU(), "")
+		fs.IntVar(&opts.Jobs, "j", runtime.NumCPU(), "")
+		fs.StringVar(&opts.Config, "config", "", "")
+		fs.BoolVar(&opts.Force, "force", false, "")
+		fs.StringVar(&opts.TmpDir, "tmpdir", "", "")
+		fs.Var(&opts.IncludeIgnored, "include-ignored", "")
+		addTimeoutFlags(fs, &opts.Timeouts)
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if err := migrateRepo(args[0], args[1], opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if os.Args[1] == "restore" {
+		fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+		var opts RestoreOptions
+		addRestoreFlags(fs, &opts)
+		interactive := fs.Bool("interactive", false, "")
+		args := parseArgs(fs, os.Args[2:])
+		if len(args) != 2 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+		if *interactive {
+			if len(opts.Paths) > 0 {
+				fmt.Println("Error: --interactive cannot be combined with --path or --paths-from")
+				os.Exit(exitUsage)
+			}
+			patterns, ok, err := pickRestorePaths(args[0])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			if !ok {
+				fmt.Println("Restore cancelled, nothing was written")
+				return
+			}
+			// Saved next to the archive, the same selection is restored again with --paths-from
+			selection := archiveBaseName(args[0]) + ".paths"
+			if err := patterns.writeTo(selection, "Selected from "+filepath.Base(args[0])); err != nil {
+				fmt.Printf("warning: could not save the selection: %v\n", err)
+			} else {
+				fmt.Printf("Saved the selection to %s, restore it again with --paths-from %s\n", selection, selection)
+			}
+			opts.Paths = patterns
+		}
+		if err := restoreGitRepo(args[1], args[0], opts); err != nil {
+			// --json already reported the error as an event
+			if !opts.JSON {
+				fmt.Printf("Error: %v\n", err)
+				printPartialSummary(os.Stderr, err)
+			}
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	// create is the explicit spelling of the default archive command
+	if os.Args[1] == "create" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	var opts ArchiveOptions
+	fs.StringVar(&opts.Format, "format", "", "")
+	fs.StringVar(&opts.Compression, "compress", "", "")
+	fs.StringVar(&opts.CompressCmd, "compress-cmd", "", "")
+	fs.IntVar(&opts.Level, "level", 0, "")
+	fs.IntVar(&opts.Jobs, "jobs", runtime.NumCPU(), "")
+	fs.IntVar(&opts.Jobs, "j", runtime.NumCPU(), "")
+	fs.Func("split-size", "", func(s string) (err error) {
+		opts.SplitSize, err = parseSize(s)
+		return err
+	})
+	fs.BoolVar(&opts.NoDereferenceRoot, "no-dereference-root", false, "")
+	fs.BoolVar(&opts.Dereference, "dereference", false, "")
+	fs.BoolVar(&opts.NumericOwner, "numeric-owner", false, "")
+	fs.BoolVar(&opts.Xattrs, "xattrs", false, "")
+	fs.BoolVar(&opts.Fifos, "fifos", false, "")
+	fs.StringVar(&opts.TmpDir, "tmpdir", "", "")
+	fs.Func("size-budget", "", func(s string) (err error) {
+		opts.SizeBudget, err = parseSize(s)
+		return err
+	})
+	fs.IntVar(&opts.AutoExcludeLargest, "auto-exclude-largest", 0, "")
+	fs.BoolVar(&opts.AutoExcludeCommon, "auto-exclude-common", false, "")
+	fs.Var(&opts.IncludeIgnored, "include-ignored", "")
+	fs.StringVar(&opts.Catalog, "catalog", "", "")
+	fs.BoolVar(&opts.Reproducible, "reproducible", false, "")
+	fs.BoolVar(&opts.BirthTime, "birthtime", false, "")
+	fs.StringVar(&opts.Config, "config", "", "")
+	fs.StringVar(&opts.Objects, "objects", "", "")
+	fs.StringVar(&opts.History, "history", "", "")
+	fs.StringVar(&opts.ProgressFile, "progress-file", "", "")
+	fs.BoolVar(&opts.ProgressBar, "progress", false, "")
+	fs.StringVar(&opts.FSProfile, "fs-profile", "", "")
+	fs.DurationVar(&opts.IndexLockTimeout, "index-lock-timeout", defaultIndexLockTimeout, "")
+	addTimeoutFlags(fs, &opts.Timeouts)
+	addVerbosityFlags(fs, &opts.Verbosity)
+	fs.BoolVar(&opts.JSON, "json", false, "")
+	fs.StringVar(&opts.LogFile, "log-file", "", "")
+	fs.BoolVar(&opts.KeepGoing, "keep-going", false, "")
+	fs.StringVar(&opts.ReuseFrom, "reuse-from", "", "")
+	fs.BoolVar(&opts.FullScan, "full-scan", false, "")
+	fs.BoolVar(&opts.NoGitDir, "no-git-dir", false, "")
+	fs.BoolVar(&opts.GitDirOnly, "git-dir-only", false, "")
+	fs.StringVar(&opts.NameTemplate, "name-template", "", "")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "")
+	fs.StringVar(&opts.Sign, "sign", "", "")
+	fs.Var(&opts.EncryptRecipients, "encrypt-recipient", "")
+	fs.BoolVar(&opts.Password, "password", false, "")
+	fs.BoolVar(&opts.RequireHealthy, "require-healthy", false, "")
+	fs.BoolVar(&opts.Quarantine, "quarantine", false, "")
+	fs.Func("validate", "", func(s string) error {
+		names, err := parseValidators(s)
+		opts.Validators = append(opts.Validators, names...)
+		return err
+	})
+	fs.Var(&opts.ValidateCmds, "validate-cmd", "")
+	args := parseArgs(fs, os.Args[1:])
+	if len(args) < 1 || len(args) > 2 {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	var group *repoGroup
+	if name, ok := strings.CutPrefix(args[0], "@"); ok {
+		var err error
+		if group, err = loadGroup(name); err == nil {
+			err = group.applyPolicy(fs)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	}
+	if opts.Reproducible {
+		epoch, err := sourceDateEpoch()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		opts.SourceDateEpoch = epoch
+	}
+	opts.Catalog = catalogPath(opts.Catalog)
+
+	if group != nil {
+		outputDir := group.Policy["output"]
+		if len(args) == 2 {
+			outputDir = args[1]
+		}
+		if err := archiveGroup(group, outputDir, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			printPartialSummary(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	repoPath := args[0]
+	var outputFile string
+	if len(args) == 2 {
+		outputFile = args[1]
+	} else {
+		ext, err := archiveExtension(opts)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		if outputFile, err = archiveOutputName("", repoPath, ext, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	if err := archiveGitRepo(repoPath, outputFile, opts); err != nil {
+		// --json already reported the error as an event
+		if opts.JSON {
+			os.Exit(exitCode(err))
+		}
+		if outputFile == "-" {
+			// Keep the error out of the archive stream
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else {
+			fmt.Printf("Error: %v\n", err)
+		}
+		printPartialSummary(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,211 @@
+package repoark
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// newTestRepo creates a git repository holding files, committed, and returns its path
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	dir := t.TempDir()
+	if _, err := runGit(dir, nil, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := runGit(dir, nil, "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(dir, nil, "commit", "-q", "-m", "initial"); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// quietArchiveOptions returns archive options that print nothing
+func quietArchiveOptions() ArchiveOptions {
+	return ArchiveOptions{Logger: discardLogger{}, Progress: discardProgress{}}
+}
+
+// quietRestoreOptions returns restore options that print nothing
+func quietRestoreOptions() RestoreOptions {
+	return RestoreOptions{Logger: discardLogger{}, Progress: discardProgress{}}
+}
+
+// checkRestoredFiles fails unless every file exists under dir with its content
+func checkRestoredFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s was not restored: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+}
+
+func TestArchiveRestoreDeepPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"shallow", "a/file.txt"},
+		{"longer than a ustar name", strings.Repeat("directory/", 12) + "file.txt"},
+		{"150 levels deep", strings.Repeat("d/", 150) + "file.txt"},
+		{"255 byte file name", "long/" + strings.Repeat("n", 251) + ".txt"},
+		{"255 byte directory names", strings.Repeat(strings.Repeat("x", 255)+"/", 8) + "file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{tt.path: "deep content", "top.txt": "top"}
+			repo := newTestRepo(t, files)
+			archive := filepath.Join(t.TempDir(), "deep.tar.gz")
+			if err := archiveGitRepo(repo, archive, quietArchiveOptions()); err != nil {
+				t.Fatalf("archive: %v", err)
+			}
+			target := filepath.Join(t.TempDir(), "restored")
+			if err := restoreGitRepo(target, archive, quietRestoreOptions()); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			checkRestoredFiles(t, target, files)
+		})
+	}
+}
+
+func TestArchiveRestoreUnicodeNames(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"Japanese", "\u65e5\u672c/\u65e5\u672c\u8a9e.txt"},
+		{"composed accent", "caf\u00e9.txt"},
+		{"decomposed accent", "re\u0301sume\u0301/notes.txt"},
+		{"emoji", "\U0001f600.md"},
+		{"right-to-left", "\u05e9\u05dc\u05d5\u05dd.txt"},
+		{"quote and backslash", "a\"b\\c.txt"},
+		{"tab and newline", "a\tb\nc.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{tt.path: "unicode content", "top.txt": "top"}
+			repo := newTestRepo(t, files)
+			// Quoted names are what git prints by default, repoark must not depend on the setting
+			if _, err := runGit(repo, nil, "config", "core.quotePath", "true"); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(t.TempDir(), "unicode.tar.gz")
+			if err := archiveGitRepo(repo, archive, quietArchiveOptions()); err != nil {
+				t.Fatalf("archive: %v", err)
+			}
+			target := filepath.Join(t.TempDir(), "restored")
+			if err := restoreGitRepo(target, archive, quietRestoreOptions()); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			checkRestoredFiles(t, target, files)
+			status, err := runGit(target, nil, "status", "--porcelain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(status) > 0 {
+				t.Errorf("restored repository is not clean:\n%s", status)
+			}
+		})
+	}
+}
+
+func TestRestoreReportsGoToStdout(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{"a.txt": "a"})
+	archive := filepath.Join(t.TempDir(), "reports.tar.gz")
+	if err := archiveGitRepo(repo, archive, quietArchiveOptions()); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	var stdout bytes.Buffer
+	opts := quietRestoreOptions()
+	opts.Stdout, opts.ExecReport, opts.FidelityReport = &stdout, true, true
+	if err := restoreGitRepo(filepath.Join(t.TempDir(), "restored"), archive, opts); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	for _, want := range []string{"No unexpected executables were restored", "restored entries have the archived"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("Stdout = %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+}
+
+func TestRestoreExplainsOverlongNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"file name over 255 bytes", "dir/" + strings.Repeat("f", 300)},
+		{"directory name over 255 bytes", strings.Repeat("d", 300) + "/file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "long.tar")
+			if err := os.WriteFile(archive, buildTar(t, testEntry{"ok.txt", "ok"}, testEntry{tt.entry, "x"}), 0644); err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(t.TempDir(), "restored")
+			err := restoreGitRepo(target, archive, quietRestoreOptions())
+			missed := missedIn(err)
+			if len(missed) != 1 {
+				t.Fatalf("restore = %v, want one missed entry", err)
+			}
+			if !strings.Contains(missed[0].Reason, "too long for this platform") {
+				t.Errorf("reason = %q, want it to explain the path is too long", missed[0].Reason)
+			}
+			checkRestoredFiles(t, target, map[string]string{"ok.txt": "ok"})
+		})
+	}
+}
+
+func TestExplainPathError(t *testing.T) {
+	deep := "/" + strings.Repeat("d/", 40) + "f"
+	tests := []struct {
+		name    string
+		path    string
+		err     error
+		wantMsg string
+	}{
+		{"name too long", deep, &os.PathError{Op: "mkdir", Path: deep, Err: syscall.ENAMETOOLONG}, "path is too long for this platform (82 bytes, 41 levels deep)"},
+		{"other errors unchanged", "/a/b", &os.PathError{Op: "open", Path: "/a/b", Err: syscall.EACCES}, "open /a/b: permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := explainPathError(tt.path, tt.err)
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("explainPathError() = %v, want it to contain %q", err, tt.wantMsg)
+			}
+			if errors.Is(tt.err, syscall.EACCES) && err != tt.err {
+				t.Errorf("explainPathError() changed an unrelated error")
+			}
+		})
+	}
+}
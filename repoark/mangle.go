@@ -0,0 +1,292 @@
+package repoark
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameManglers are the accepted --mangle-names values besides auto and none
+var nameManglers = map[string]nameMangler{
+	"windows": windowsMangler{},
+	"apfs":    apfsMangler{},
+}
+
+// nameMangler renames path components the destination filesystem cannot represent.
+// The mapping only has to avoid names real repositories use, restore records every
+// mangled path in the names map so that unmangle can put the original names back.
+type nameMangler interface {
+	// Name is the --mangle-names value selecting the mangler
+	Name() string
+	// Mangle returns component unchanged when the filesystem can store it
+	Mangle(component string) string
+}
+
+// resolveMangler turns the --mangle-names value into a mangler for the filesystem holding repoPath,
+// nil when names are restored unchanged. auto selects windows on Windows and on filesystems that
+// refuse Windows-unsafe names, such as FAT and exFAT drives mounted on Linux or macOS, and apfs
+// on other macOS filesystems, which only store valid UTF-8 names.
+func resolveMangler(scheme, repoPath string, logger Logger) (nameMangler, error) {
+	switch scheme {
+	case "", "auto":
+		if runtime.GOOS == "windows" {
+			return windowsMangler{}, nil
+		}
+		if !acceptsUnsafeNames(repoPath) {
+			logger.Infof("%s does not accept names such as 'a:b', mangling them", repoPath)
+			return windowsMangler{}, nil
+		}
+		if runtime.GOOS == "darwin" {
+			return apfsMangler{}, nil
+		}
+		return nil, nil
+	case "none":
+		return nil, nil
+	}
+	if mangler, ok := nameManglers[scheme]; ok {
+		return mangler, nil
+	}
+	return nil, fmt.Errorf("unsupported name mangling %s, expected auto, none, windows or apfs", scheme)
+}
+
+// acceptsUnsafeNames probes whether a file called like a Windows-unsafe name can be created in dir
+func acceptsUnsafeNames(dir string) bool {
+	probe := filepath.Join(dir, ".repoark-probe:name.")
+	file, err := os.OpenFile(probe, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return os.IsExist(err)
+	}
+	file.Close()
+	_, err = os.Lstat(probe)
+	os.Remove(probe)
+	return err == nil
+}
+
+// manglePath applies mangler to every component of the slash separated archive path name
+func manglePath(mangler nameMangler, name string) string {
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		if component != "" {
+			components[i] = mangler.Mangle(component)
+		}
+	}
+	return strings.Join(components, "/")
+}
+
+// windowsMangler follows Cygwin and WSL: characters Windows does not allow in names are
+// moved to the Unicode private use area at U+F000 plus their code
+type windowsMangler struct{}
+
+// windowsReservedNames are device names Windows does not allow as the stem of a file name
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func (windowsMangler) Name() string { return "windows" }
+
+func (windowsMangler) Mangle(component string) string {
+	// Names are UTF-16 on Windows, bytes that are not UTF-8 have no equivalent
+	runes := []rune(mangleInvalidUTF8(component))
+	for i, r := range runes {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) {
+			runes[i] = 0xF000 + r
+		}
+	}
+	// Windows drops trailing dots and spaces
+	for i := len(runes) - 1; i >= 0 && (runes[i] == '.' || runes[i] == ' '); i-- {
+		runes[i] = 0xF000 + runes[i]
+	}
+	stem, _, _ := strings.Cut(string(runes), ".")
+	if windowsReservedNames[strings.ToUpper(stem)] {
+		last := len([]rune(stem)) - 1
+		runes[last] = 0xF000 + runes[last]
+	}
+	return string(runes)
+}
+
+// apfsMangler moves the bytes of names that are not valid UTF-8, which APFS and HFS+ refuse,
+// to the Unicode private use area at U+F000 plus the byte, like windowsMangler does with characters
+type apfsMangler struct{}
+
+func (apfsMangler) Name() string { return "apfs" }
+
+func (apfsMangler) Mangle(component string) string {
+	return mangleInvalidUTF8(component)
+}
+
+// mangleInvalidUTF8 replaces every byte of s that is not part of a valid UTF-8 sequence by U+F000 plus the byte
+func mangleInvalidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(0xF000 + rune(s[i]))
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// mangledNamesFile is the names map of a restored repository, kept in its metadata directory
+// where neither git status nor the cleanup of a later restore sees it
+const mangledNamesFile = "repoark-mangled-names.json"
+
+// mangledNames maps mangled paths to the archived names, both slash separated and relative to the repository
+type mangledNames struct {
+	Scheme string            `json:"scheme"`
+	Names  map[string]string `json:"names"`
+	// Raw holds the archived names that are not valid UTF-8, which JSON strings cannot carry, base64 encoded
+	Raw map[string][]byte `json:"raw,omitempty"`
+}
+
+// mangledNamesPath returns where the names map of the repository at repoPath is stored
+func mangledNamesPath(repoPath string) string {
+	for _, dir := range []string{".git", ".hg"} {
+		if info, err := os.Stat(filepath.Join(repoPath, dir)); err == nil && info.IsDir() {
+			return filepath.Join(repoPath, dir, mangledNamesFile)
+		}
+	}
+	return filepath.Join(repoPath, "."+mangledNamesFile)
+}
+
+// loadMangledNames reads the names map at path, a missing map is empty
+func loadMangledNames(path string) (*mangledNames, error) {
+	names := &mangledNames{Names: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading names map: %v", err)
+	}
+	if err := json.Unmarshal(data, names); err != nil {
+		return nil, fmt.Errorf("error reading names map %s: %v", path, err)
+	}
+	if names.Names == nil {
+		names.Names = make(map[string]string)
+	}
+	for mangledName, original := range names.Raw {
+		names.Names[mangledName] = string(original)
+	}
+	names.Raw = nil
+	return names, nil
+}
+
+// save writes the names map to path, or removes it when no name is mangled any more
+func (m *mangledNames) save(path string) error {
+	if len(m.Names) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing names map: %v", err)
+		}
+		return nil
+	}
+	stored := mangledNames{Scheme: m.Scheme, Names: make(map[string]string)}
+	for mangledName, original := range m.Names {
+		if utf8.ValidString(original) {
+			stored.Names[mangledName] = original
+		} else {
+			if stored.Raw == nil {
+				stored.Raw = make(map[string][]byte)
+			}
+			stored.Raw[mangledName] = []byte(original)
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing names map: %v", err)
+	}
+	return nil
+}
+
+// recordMangledNames merges the names mangled by a restore into the map of repoPath,
+// dropping entries whose mangled file is gone
+func recordMangledNames(repoPath, scheme string, mangled map[string]string) error {
+	path := mangledNamesPath(repoPath)
+	names, err := loadMangledNames(path)
+	if err != nil {
+		return err
+	}
+	names.Scheme = scheme
+	for mangledName, original := range mangled {
+		names.Names[mangledName] = original
+	}
+	for mangledName := range names.Names {
+		if _, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(mangledName))); os.IsNotExist(err) {
+			delete(names.Names, mangledName)
+		}
+	}
+	return names.save(path)
+}
+
+// unmangleRepo renames the mangled files of a restored repository back to their archived names,
+// for use after moving it to a filesystem that can represent them
+func unmangleRepo(repoPath string, logger Logger) error {
+	path := mangledNamesPath(repoPath)
+	names, err := loadMangledNames(path)
+	if err != nil {
+		return err
+	}
+	if len(names.Names) == 0 {
+		logger.Infof("No mangled names recorded in %s", repoPath)
+		return nil
+	}
+
+	mangledPaths := make([]string, 0, len(names.Names))
+	for mangledName := range names.Names {
+		mangledPaths = append(mangledPaths, mangledName)
+	}
+	sort.Strings(mangledPaths)
+
+	renamed, failed := 0, 0
+	for _, mangledName := range mangledPaths {
+		from := filepath.Join(repoPath, filepath.FromSlash(mangledName))
+		to := filepath.Join(repoPath, filepath.FromSlash(names.Names[mangledName]))
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			logger.Warnf("error creating directory for %s: %v", to, err)
+			failed++
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			logger.Warnf("error renaming %s: %v", from, err)
+			failed++
+			continue
+		}
+		delete(names.Names, mangledName)
+		removeEmptyParents(repoPath, filepath.Dir(from))
+		renamed++
+	}
+	if err := names.save(path); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &exitError{Code: exitPartial, Err: fmt.Errorf("%d of %d names could not be restored", failed, failed+renamed)}
+	}
+	logger.Infof("Restored %d original names in %s", renamed, repoPath)
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents up to root while they are empty,
+// which clears the directories of mangled names once their files moved away
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
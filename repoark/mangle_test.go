@@ -0,0 +1,47 @@
+package repoark
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMangleInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name      string
+		mangler   nameMangler
+		component string
+		want      string
+	}{
+		{"valid UTF-8 unchanged", apfsMangler{}, "café.txt", "café.txt"},
+		{"Latin-1 byte", apfsMangler{}, "caf\xe9.txt", "caf\uf0e9.txt"},
+		{"truncated sequence", apfsMangler{}, "a\xc3", "a\uf0c3"},
+		{"windows mangles invalid bytes", windowsMangler{}, "caf\xe9:x", "caf\uf0e9\uf03ax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mangler.Mangle(tt.component); got != tt.want {
+				t.Errorf("Mangle(%q) = %q, want %q", tt.component, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMangledNamesKeepInvalidUTF8(t *testing.T) {
+	path := filepath.Join(t.TempDir(), mangledNamesFile)
+	names := &mangledNames{Scheme: "apfs", Names: map[string]string{
+		"caf\uf0e9.txt": "caf\xe9.txt",
+		"a\uf03ab":      "a:b",
+	}}
+	if err := names.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadMangledNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mangledName, original := range names.Names {
+		if loaded.Names[mangledName] != original {
+			t.Errorf("names[%q] = %q, want %q", mangledName, loaded.Names[mangledName], original)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// manifestEntry describes one file contained in an archive
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    int64     `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// manifest lists the files of an archive with their checksums. Every archive ends with one,
+// restore checks the content it extracts against it.
+type manifest struct {
+	Entries  []manifestEntry `json:"entries,omitempty"`
+	Warnings []string        `json:"warnings,omitempty"` // conditions that may make the archive inconsistent
+	Unmerged []unmergedEntry `json:"unmerged,omitempty"` // index stages of paths with merge conflicts
+
+	WorktreeOnly bool `json:"worktree_only,omitempty"` // written with --no-git-dir, restore leaves the rest of the target alone
+	GitDirOnly   bool `json:"git_dir_only,omitempty"`  // written with --git-dir-only, restore leaves the working tree alone
+}
+
+// archiveMetaDir holds the entries repoark adds to an archive about the archive itself,
+// they are never restored into the working tree
+const archiveMetaDir = ".repoark/"
+
+// archiveManifestName is the manifest entry written at the end of an archive
+const archiveManifestName = archiveMetaDir + "manifest.json"
+
+// isMetaEntry reports whether an archive entry is repoark metadata rather than a repository file
+func isMetaEntry(name string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(name, "./"), archiveMetaDir)
+}
+
+// add records an archived file with the SHA-256 of its content
+func (m *manifest) add(header *tar.Header, sum []byte) {
+	m.Entries = append(m.Entries, manifestEntry{
+		Path:    header.Name,
+		Size:    header.Size,
+		Mode:    header.Mode,
+		ModTime: header.ModTime,
+		SHA256:  hex.EncodeToString(sum),
+	})
+}
+
+// readManifestEntry decodes the manifest entry of an archive from r
+func readManifestEntry(r io.Reader) (*manifest, error) {
+	var m manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", archiveManifestName, err)
+	}
+	return &m, nil
+}
+
+// writeArchiveManifest adds m to the archive as its last entry
+func writeArchiveManifest(writer entryWriter, m *manifest, opts ArchiveOptions) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeMetaEntry(writer, archiveManifestName, data, opts)
+}
+
+// writeMetaEntry adds a repoark metadata entry called name to the archive
+func writeMetaEntry(writer entryWriter, name string, data []byte, opts ArchiveOptions) error {
+	return writer.WriteEntry(metaEntryHeader(name, data, opts), bytes.NewReader(data))
+}
+
+// metaEntryHeader returns the header of the .repoark/ entry name holding data
+func metaEntryHeader(name string, data []byte, opts ArchiveOptions) *tar.Header {
+	header := &tar.Header{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if opts.Reproducible {
+		header.ModTime = time.Unix(0, 0)
+		if !opts.SourceDateEpoch.IsZero() {
+			header.ModTime = opts.SourceDateEpoch
+		}
+		makeReproducible(header, opts.SourceDateEpoch)
+	}
+	return header
+}
+
+// manifestSidecarPath returns where the manifest of an imported archive is stored
+func manifestSidecarPath(archivePath string) string {
+	return archivePath + ".manifest.json"
+}
+
+// writeManifestFile stores m as indented JSON at path
+func writeManifestFile(path string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
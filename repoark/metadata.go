@@ -0,0 +1,284 @@
+package repoark
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveMetadataName is the entry describing the repository state an archive was made from,
+// written first so that readers of a stream see it before any file
+const archiveMetadataName = archiveMetaDir + "metadata.json"
+
+// repoMetadata describes where and from what an archive was made
+type repoMetadata struct {
+	Tool       string            `json:"tool"`
+	Created    time.Time         `json:"created"`
+	VCS        string            `json:"vcs"`
+	Branch     string            `json:"branch,omitempty"`
+	Commit     string            `json:"commit,omitempty"`
+	Remotes    map[string]string `json:"remotes,omitempty"`    // remote name to fetch URL, without credentials
+	Submodules map[string]string `json:"submodules,omitempty"` // path to checked out commit
+	Dirty      dirtyState        `json:"dirty"`
+	Entries    int               `json:"entries,omitempty"` // repository files listed for the archive, without repoark's own entries
+}
+
+// dirtyState tells which kinds of uncommitted changes the working tree had
+type dirtyState struct {
+	Staged    bool `json:"staged"`    // changes added to the index (Mercurial: added or removed files)
+	Modified  bool `json:"modified"`  // changes to tracked files that are not staged
+	Untracked bool `json:"untracked"` // files the VCS does not track or ignore
+}
+
+// toolVersion is the repoark version recorded in archives, from the module version of the build
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return "repoark " + info.Main.Version
+	}
+	return "repoark (devel)"
+}
+
+// writeRepoMetadata records the state of the repository at repoPath and the number of files
+// that follow as the metadata entry
+func writeRepoMetadata(writer entryWriter, repoPath string, entries int, opts ArchiveOptions) error {
+	meta := repoMetadata{Tool: toolVersion(), Created: time.Now().UTC(), VCS: opts.VCS.Name(), Entries: entries}
+	if opts.Reproducible {
+		meta.Created = time.Unix(0, 0).UTC()
+		if !opts.SourceDateEpoch.IsZero() {
+			meta.Created = opts.SourceDateEpoch.UTC()
+		}
+	}
+	if err := opts.VCS.Describe(repoPath, &meta); err != nil {
+		return fmt.Errorf("error describing repository: %v", err)
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeMetadataEntry(writer, data, opts)
+}
+
+// writeMetadataEntry writes the metadata entry holding data, with the format version of the archive
+func writeMetadataEntry(writer entryWriter, data []byte, opts ArchiveOptions) error {
+	header := metaEntryHeader(archiveMetadataName, data, opts)
+	header.PAXRecords = map[string]string{paxFormatVersion: strconv.Itoa(archiveFormatVersion)}
+	return writer.WriteEntry(header, bytes.NewReader(data))
+}
+
+// Describe fills in the branch, commit, remotes, submodules and dirty state of a git work tree
+func (gitVCS) Describe(dir string, meta *repoMetadata) error {
+	git := func(args ...string) (string, error) {
+		output, err := runGit(dir, nil, args...)
+		return strings.TrimSpace(string(output)), err
+	}
+	// Both are empty for a detached HEAD and a repository without commits
+	meta.Branch, _ = git("symbolic-ref", "--short", "-q", "HEAD")
+	meta.Commit, _ = git("rev-parse", "-q", "--verify", "HEAD")
+
+	// Fails when no remote is configured
+	if remotes, err := git("config", "--get-regexp", `^remote\..*\.url$`); err == nil {
+		for _, line := range strings.Split(remotes, "\n") {
+			key, value, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+			if meta.Remotes == nil {
+				meta.Remotes = make(map[string]string)
+			}
+			meta.Remotes[name] = redactURL(value)
+		}
+	}
+
+	// Not trimmed, the first column is the status of the submodule
+	submodules, err := runGit(dir, nil, "submodule", "status", "--recursive")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(submodules), "\n") {
+		// <status><commit> <path>[ (<describe>)]
+		if len(line) < 2 {
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) < 2 {
+			continue
+		}
+		if meta.Submodules == nil {
+			meta.Submodules = make(map[string]string)
+		}
+		meta.Submodules[fields[1]] = fields[0]
+	}
+
+	// Without optional locks git status leaves the index alone instead of refreshing it
+	status, err := runGit(dir, nil, "--no-optional-locks", "status", "--porcelain=v1", "-z")
+	if err != nil {
+		return err
+	}
+	for _, entry := range bytes.Split(status, []byte{0}) {
+		if len(entry) < 3 || entry[2] != ' ' {
+			// Empty, or the original name following a rename
+			continue
+		}
+		x, y := entry[0], entry[1]
+		switch {
+		case x == '?':
+			meta.Dirty.Untracked = true
+		default:
+			meta.Dirty.Staged = meta.Dirty.Staged || x != ' '
+			meta.Dirty.Modified = meta.Dirty.Modified || y != ' '
+		}
+	}
+	return nil
+}
+
+// Describe fills in the branch, revision, paths and dirty state of a Mercurial work tree
+func (hgVCS) Describe(dir string, meta *repoMetadata) error {
+	hg := func(args ...string) (string, error) {
+		output, err := runHgOutput(dir, args...)
+		return strings.TrimSpace(string(output)), err
+	}
+	head, err := hg("log", "-r", ".", "-T", `{branch}\n{node}`)
+	if err != nil {
+		return err
+	}
+	meta.Branch, meta.Commit, _ = strings.Cut(head, "\n")
+	if paths, err := hg("paths"); err == nil {
+		for _, line := range strings.Split(paths, "\n") {
+			if name, value, ok := strings.Cut(line, " = "); ok {
+				if meta.Remotes == nil {
+					meta.Remotes = make(map[string]string)
+				}
+				meta.Remotes[name] = redactURL(value)
+			}
+		}
+	}
+	status, err := hg("status")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(status, "\n") {
+		switch {
+		case strings.HasPrefix(line, "?"):
+			meta.Dirty.Untracked = true
+		case strings.HasPrefix(line, "A"), strings.HasPrefix(line, "R"):
+			meta.Dirty.Staged = true
+		case strings.HasPrefix(line, "M"), strings.HasPrefix(line, "!"):
+			meta.Dirty.Modified = true
+		}
+	}
+	return nil
+}
+
+// redactURL drops the password of a remote URL, which must not end up in every archive
+func redactURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// readArchiveMetadata returns the metadata entry of an archive, nil when it has none.
+// The entry comes first, so only the beginning of a tar archive is read.
+func readArchiveMetadata(archivePath string) (*repoMetadata, error) {
+	var data []byte
+	if zr, err := zip.OpenReader(archivePath); err == nil {
+		defer zr.Close()
+		f, err := zr.Open(archiveMetadataName)
+		if err != nil {
+			return nil, nil
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, maxInfoMetadataSize)); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", archiveMetadataName, err)
+		}
+	} else {
+		ar, err := openArchive(archivePath, "")
+		if err != nil {
+			return nil, err
+		}
+		defer ar.Close()
+		for data == nil {
+			header, err := ar.Next()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !isMetaEntry(header.Name) {
+				return nil, nil
+			}
+			if strings.TrimPrefix(header.Name, "./") == archiveMetadataName {
+				if data, err = io.ReadAll(io.LimitReader(ar, maxInfoMetadataSize)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return decodeRepoMetadata(data)
+}
+
+// decodeRepoMetadata parses the content of the metadata entry
+func decodeRepoMetadata(data []byte) (*repoMetadata, error) {
+	var meta repoMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", archiveMetadataName, err)
+	}
+	return &meta, nil
+}
+
+// printRepoMetadata prints the metadata of an archive as it is stored
+func printRepoMetadata(archivePath string) error {
+	meta, err := readArchiveMetadata(archivePath)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("%s has no %s, it was written by an older repoark", archivePath, archiveMetadataName)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(meta)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String lists the kinds of changes, or "no" for a clean working tree
+func (d dirtyState) String() string {
+	var kinds []string
+	if d.Staged {
+		kinds = append(kinds, "staged changes")
+	}
+	if d.Modified {
+		kinds = append(kinds, "unstaged changes")
+	}
+	if d.Untracked {
+		kinds = append(kinds, "untracked files")
+	}
+	if len(kinds) == 0 {
+		return "no"
+	}
+	return strings.Join(kinds, ", ")
+}
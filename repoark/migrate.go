@@ -0,0 +1,267 @@
+package repoark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// migrationTarget is where migrate sends a repository: a directory on an ssh host or an s3 location
+type migrationTarget struct {
+	Scheme string // ssh or s3
+	Host   string // [user@]host for ssh
+	Port   string
+	Path   string // directory on the ssh host
+	URL    string // s3 url
+}
+
+// parseMigrationTarget accepts [user@]host:path, ssh://[user@]host[:port]/path and s3://bucket/prefix
+func parseMigrationTarget(spec string) (migrationTarget, error) {
+	if strings.HasPrefix(spec, "s3://") {
+		return migrationTarget{Scheme: "s3", URL: spec}, nil
+	}
+	if strings.HasPrefix(spec, "ssh://") {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return migrationTarget{}, fmt.Errorf("invalid target %s: %v", spec, err)
+		}
+		host := u.Hostname()
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		// ssh://host/~/dir is relative to the home directory
+		path := strings.TrimPrefix(u.Path, "/~")
+		if path != u.Path {
+			path = "~" + path
+		}
+		if u.Hostname() == "" || path == "" || path == "/" {
+			return migrationTarget{}, fmt.Errorf("invalid target %s, expected ssh://[user@]host[:port]/path", spec)
+		}
+		return migrationTarget{Scheme: "ssh", Host: host, Port: u.Port(), Path: path}, nil
+	}
+	host, path, found := strings.Cut(spec, ":")
+	if !found || host == "" || path == "" || strings.Contains(host, "/") {
+		return migrationTarget{}, fmt.Errorf("unsupported target %s, expected [user@]host:path, ssh://[user@]host[:port]/path or s3://bucket/prefix", spec)
+	}
+	return migrationTarget{Scheme: "ssh", Host: host, Path: path}, nil
+}
+
+// sshCommand builds an ssh invocation running command on the target host, killed when ctx
+// expires. command is run by the remote shell, each of its parts must be quoted.
+func (t migrationTarget) sshCommand(ctx context.Context, command string) *exec.Cmd {
+	args := []string{}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	args = append(args, "--", t.Host, command)
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Env = commandEnv()
+	return cmd
+}
+
+// remotePath quotes the target directory for the remote shell. A leading ~ becomes "$HOME", which
+// the remote shell expands inside quotes too.
+func (t migrationTarget) remotePath() string {
+	if t.Path == "~" {
+		return `"$HOME"`
+	}
+	if rest, ok := strings.CutPrefix(t.Path, "~/"); ok {
+		return `"$HOME"/` + shellQuote(rest)
+	}
+	return shellQuote(t.Path)
+}
+
+// remoteCommand joins args into a command line for the remote shell, quoting each of them
+func remoteCommand(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// migrateRepo moves a repository to a new machine: it archives it, transfers the archive,
+// restores it on the other side when that is reachable over ssh and verifies the result
+func migrateRepo(repoPath, spec string, opts ArchiveOptions) error {
+	if opts.Timeouts != (runTimeouts{}) {
+		timeouts := opts.Timeouts
+		opts.Timeouts = runTimeouts{}
+		return runWithTimeouts(timeouts, func() error {
+			return migrateRepo(repoPath, spec, opts)
+		})
+	}
+	target, err := parseMigrationTarget(spec)
+	if err != nil {
+		return err
+	}
+	// The archive is only in transit, it does not belong in the catalog
+	opts.Catalog = ""
+	opts = opts.withDefaults()
+
+	if target.Scheme == "s3" {
+		return migrateToS3(repoPath, target, opts)
+	}
+	return migrateOverSSH(repoPath, target, opts)
+}
+
+// migrateOverSSH streams the archive into repoark restore on the target host
+func migrateOverSSH(repoPath string, target migrationTarget, opts ArchiveOptions) error {
+	opts.Logger.Infof("[1/3] Checking that repoark is installed on %s", target.Host)
+	ctx, done := activeDeadline.sshPhase(target.Host, "command -v repoark")
+	output, err := target.sshCommand(ctx, remoteCommand("command", "-v", "repoark")).CombinedOutput()
+	done()
+	if err != nil {
+		return fmt.Errorf("repoark was not found on %s, install it there first: %v %s", target.Host, err, strings.TrimSpace(string(output)))
+	}
+
+	opts.Logger.Infof("[2/3] Archiving %s and restoring it to %s:%s", repoPath, target.Host, target.Path)
+	pipeReader, pipeWriter := io.Pipe()
+	opts.Stdout = pipeWriter
+	archiveDone := make(chan error, 1)
+	go func() {
+		err := archiveGitRepo(repoPath, "-", opts)
+		pipeWriter.CloseWithError(err)
+		archiveDone <- err
+	}()
+
+	ctx, endTransfer := activeDeadline.uploadPhase(target.Host)
+	restore := target.sshCommand(ctx, remoteCommand("repoark", "restore", "--verify", "-")+" "+target.remotePath())
+	restore.Stdin = pipeReader
+	restore.Stdout = os.Stdout
+	restore.Stderr = os.Stderr
+	restoreErr := restore.Run()
+	endTransfer()
+	// Unblock the archiver if the remote side stopped reading early
+	pipeReader.CloseWithError(errors.New("remote restore exited"))
+	if err := <-archiveDone; err != nil {
+		return fmt.Errorf("error archiving %s: %v", repoPath, err)
+	}
+	if restoreErr != nil {
+		return fmt.Errorf("remote restore on %s failed: %v", target.Host, restoreErr)
+	}
+
+	opts.Logger.Infof("[3/3] Verifying the restored repository")
+	for _, check := range [][]string{{"rev-parse", "HEAD"}, {"status", "--porcelain"}} {
+		local, err := runGit(repoPath, nil, check...)
+		if err != nil {
+			// e.g. HEAD of a repository without commits
+			continue
+		}
+		// Quoted the same way on both sides, whatever either user configured
+		command := remoteCommand(append(append([]string{"git"}, gitQuotePathOff...), "-C")...) + " " + target.remotePath() + " " + remoteCommand(check...)
+		ctx, done := activeDeadline.sshPhase(target.Host, "git "+check[0])
+		remote, err := target.sshCommand(ctx, command).Output()
+		done()
+		if err != nil {
+			return fmt.Errorf("error verifying git %s on %s: %v", check[0], target.Host, err)
+		}
+		if !bytes.Equal(local, remote) {
+			return fmt.Errorf("verification failed: git %s differs between %s and %s:%s", strings.Join(check, " "), repoPath, target.Host, target.Path)
+		}
+	}
+	opts.Logger.Infof("Successfully migrated %s to %s:%s", repoPath, target.Host, target.Path)
+	return nil
+}
+
+// migrateToS3 uploads the archive and prints the command that restores it on the new machine
+func migrateToS3(repoPath string, target migrationTarget, opts ArchiveOptions) error {
+	backend, prefix, err := openRemote(target.URL)
+	if err != nil {
+		return err
+	}
+	comp, err := resolveCompressor(opts.Compression, opts.CompressCmd, "")
+	if err != nil {
+		return err
+	}
+	absRepo, err := filepath.Abs(repoPath)
+	if err != nil {
+		return err
+	}
+	key := prefix
+	if key == "" || strings.HasSuffix(key, "/") {
+		// A derived name never replaces an earlier upload, like archives on local disk
+		key, err = findAvailableRemoteKey(backend, prefix, filepath.Base(absRepo), comp.Extensions[0])
+		if err != nil {
+			return fmt.Errorf("error listing %s: %v", target.URL, err)
+		}
+	} else if !opts.Force {
+		objects, err := backend.List(key)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error listing %s: %v", target.URL, err)
+		}
+		for _, object := range objects {
+			if object.Key == key {
+				return fmt.Errorf("%s already exists, use --force to replace it", key)
+			}
+		}
+	}
+
+	// The archive is staged before the upload, next to the repository when the temp directory is too small
+	need, err := estimateArchiveSize(absRepo)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := stagingDir(opts.TmpDir, "repoark-migrate-", need, filepath.Dir(absRepo), opts.Logger)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	archivePath := filepath.Join(tmpDir, filepath.Base(key))
+
+	opts.Logger.Infof("[1/3] Archiving %s", repoPath)
+	if err := archiveGitRepo(repoPath, archivePath, opts); err != nil {
+		return err
+	}
+
+	opts.Logger.Infof("[2/3] Uploading to %s", target.URL)
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, endUpload := activeDeadline.uploadPhase(target.URL)
+	err = backend.Put(key, file, info.Size(), opts.Force)
+	endUpload()
+	if err != nil {
+		if errors.Is(err, errRemoteExists) {
+			return fmt.Errorf("%s was created by another upload meanwhile and was not replaced, use --force to replace it", key)
+		}
+		return fmt.Errorf("error uploading archive: %v", err)
+	}
+
+	opts.Logger.Infof("[3/3] Verifying the upload")
+	objects, err := backend.List(key)
+	if err != nil {
+		return fmt.Errorf("error verifying upload: %v", err)
+	}
+	uploaded := false
+	for _, object := range objects {
+		if object.Key == key && object.Size == info.Size() {
+			uploaded = true
+		}
+	}
+	if !uploaded {
+		return fmt.Errorf("verification failed: %s is missing or has the wrong size after the upload", key)
+	}
+
+	bucketURL := strings.TrimSuffix(target.URL, prefix)
+	opts.Logger.Infof("Uploaded %s (%s). On the new machine, restore it with:", key, formatSize(info.Size()))
+	opts.Logger.Infof("  aws s3 cp %s%s - | repoark restore --verify - %s", bucketURL, key, shellQuote(filepath.Base(absRepo)))
+	return nil
+}
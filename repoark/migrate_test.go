@@ -0,0 +1,34 @@
+package repoark
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestRemoteCommandQuoting(t *testing.T) {
+	tests := []struct {
+		path string
+		want string // the path the remote shell sees, with HOME=/home/u
+	}{
+		{"/srv/repo", "/srv/repo"},
+		{"~", "/home/u"},
+		{"~/repo", "/home/u/repo"},
+		{"~/it's; rm -rf ~", "/home/u/it's; rm -rf ~"},
+		{"/srv/$(id) `id` *", "/srv/$(id) `id` *"},
+		{"~user/repo", "~user/repo"},
+		{"-rf", "-rf"},
+	}
+	for _, tt := range tests {
+		target := migrationTarget{Scheme: "ssh", Host: "host", Path: tt.path}
+		command := remoteCommand("printf", "%s\n", "a b", "'") + " " + target.remotePath()
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = []string{"HOME=/home/u"}
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+		if want := "a b\n'\n" + tt.want + "\n"; string(output) != want {
+			t.Errorf("%s printed %q, want %q", command, output, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package repoark
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// namePlaceholder matches the {name} fields of a --name-template
+var namePlaceholder = regexp.MustCompile(`\{(\w*)\}`)
+
+// unsafeNameChars are replaced in values substituted into a file name, e.g. the slash of feature/x
+var unsafeNameChars = regexp.MustCompile(`[^\w.+-]+`)
+
+// expandNameTemplate fills in the placeholders of template for the repository at repoPath:
+// {repo}, {branch}, {shortsha}, {date} (2006-01-02) and {time} (150405, local time)
+func expandNameTemplate(template, repoPath string, now time.Time) (string, error) {
+	var branch, shortID string
+	var headErr error
+	headRead := false
+	readHead := func() error {
+		if !headRead {
+			headRead = true
+			vcs, err := detectVCS(repoPath)
+			if err != nil {
+				headErr = err
+			} else {
+				branch, shortID, headErr = vcs.Head(repoPath)
+			}
+		}
+		return headErr
+	}
+
+	var expandErr error
+	name := namePlaceholder.ReplaceAllStringFunc(template, func(field string) string {
+		var value string
+		switch key := field[1 : len(field)-1]; key {
+		case "repo":
+			value = repoName(repoPath)
+		case "branch":
+			if err := readHead(); err != nil {
+				expandErr = err
+			}
+			value = branch
+		case "shortsha":
+			if err := readHead(); err != nil {
+				expandErr = err
+			}
+			value = shortID
+		case "date":
+			value = now.Format("2006-01-02")
+		case "time":
+			value = now.Format("150405")
+		default:
+			if expandErr == nil {
+				expandErr = fmt.Errorf("unknown placeholder %s in name template, use {repo}, {branch}, {shortsha}, {date} or {time}", field)
+			}
+		}
+		return strings.Trim(unsafeNameChars.ReplaceAllString(value, "-"), "-")
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return name, nil
+}
+
+// repoName is the directory name of the repository, also for relative paths such as "."
+func repoName(repoPath string) string {
+	if absPath, err := filepath.Abs(repoPath); err == nil {
+		return filepath.Base(absPath)
+	}
+	return filepath.Base(repoPath)
+}
+
+// splitArchiveExtension splits a recognised archive extension off name, ext is empty when there is none
+func splitArchiveExtension(name string) (base, ext string) {
+	// Encrypted archives carry .age after the extension of the archive
+	if strings.HasSuffix(strings.ToLower(name), ageExtension) {
+		base, ext = splitArchiveExtension(name[:len(name)-len(ageExtension)])
+		return base, ext + name[len(name)-len(ageExtension):]
+	}
+	lower := strings.ToLower(name)
+	candidates := []string{".zip"}
+	for _, c := range compressors {
+		candidates = append(candidates, c.Extensions...)
+	}
+	for _, candidate := range candidates {
+		if strings.HasSuffix(lower, candidate) {
+			return name[:len(name)-len(candidate)], name[len(name)-len(candidate):]
+		}
+	}
+	return name, ""
+}
+
+// archiveOutputName picks the file an archive is written to when no output file is given:
+// <repo><ext>, or the expanded --name-template, with -1, -2, ... added to avoid existing archives.
+// A template ending in an archive extension keeps it, which selects the format and compressor.
+func archiveOutputName(dir, repoPath, ext string, opts ArchiveOptions) (string, error) {
+	if opts.NameTemplate == "" {
+		return findAvailableArchiveName(dir, filepath.Base(repoPath), ext), nil
+	}
+	name, err := expandNameTemplate(opts.NameTemplate, repoPath, time.Now())
+	if err != nil {
+		return "", err
+	}
+	base, nameExt := splitArchiveExtension(name)
+	if nameExt == "" {
+		nameExt = ext
+	}
+	if base == "" || strings.ContainsAny(base, `/\`) {
+		return "", fmt.Errorf("name template %q does not give a file name", opts.NameTemplate)
+	}
+	return findAvailableArchiveName(dir, base, nameExt), nil
+}
+
+// nameTemplateGlob returns a pattern matching every archive name of repoPath the template can produce
+func nameTemplateGlob(template, repoPath string) string {
+	pattern := namePlaceholder.ReplaceAllStringFunc(template, func(field string) string {
+		if field == "{repo}" {
+			return repoName(repoPath)
+		}
+		return "*"
+	})
+	base, ext := splitArchiveExtension(pattern)
+	if ext == "" {
+		ext = ".*"
+	}
+	// archiveOutputName adds -1, -2, ... before the extension
+	return base + "*" + ext
+}
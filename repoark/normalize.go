@@ -0,0 +1,31 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// pathKey returns the key a slash separated path is looked up by in sets of restored paths.
+// Archives written on Linux mostly hold composed names (NFC), while HFS+ stores names decomposed
+// (NFD) and git on macOS may list them so, and APFS finds a file by either form. Keys are
+// composed, so that one name matches in both forms.
+func pathKey(name string) string {
+	return norm.NFC.String(name)
+}
+
+// isSamePath reports whether the slash separated paths entry and name of repoPath, which share
+// a pathKey, are one file. Only filesystems ignoring the normalization form make them so,
+// elsewhere they are two files.
+func isSamePath(repoPath, entry, name string) bool {
+	if entry == name {
+		return true
+	}
+	a, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(entry)))
+	if err != nil {
+		return false
+	}
+	b, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(name)))
+	return err == nil && os.SameFile(a, b)
+}
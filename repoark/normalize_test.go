@@ -0,0 +1,70 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"composed and decomposed", "café.txt", "café.txt", true},
+		{"decomposed directory", "résumé/a.txt", "résumé/a.txt", true},
+		{"hangul syllable and jamo", "한.txt", "한.txt", true},
+		{"different letters", "café.txt", "cafe.txt", false},
+		{"case differs", "Café.txt", "café.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := pathKey(tt.a) == pathKey(tt.b); same != tt.same {
+				t.Errorf("pathKey(%q) == pathKey(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
+
+func TestIsSamePath(t *testing.T) {
+	dir := t.TempDir()
+	nfc, nfd := "café.txt", "café.txt"
+	if err := os.WriteFile(filepath.Join(dir, nfc), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A filesystem ignoring normalization opens the composed file by the decomposed name
+	_, err := os.Lstat(filepath.Join(dir, nfd))
+	insensitive := err == nil
+
+	if !isSamePath(dir, nfc, nfc) {
+		t.Errorf("isSamePath(%q, %q) = false for identical names", nfc, nfc)
+	}
+	if got := isSamePath(dir, nfc, nfd); got != insensitive {
+		t.Errorf("isSamePath(%q, %q) = %v, want %v on this filesystem", nfc, nfd, got, insensitive)
+	}
+	if isSamePath(dir, nfc, "missing.txt") {
+		t.Errorf("isSamePath() = true for a missing file")
+	}
+}
+
+func TestArchiveRestoreBothNormalizationForms(t *testing.T) {
+	// Linux filesystems keep the two forms apart, restore must not take one for the other
+	files := map[string]string{"caf\u00e9.txt": "composed", "cafe\u0301.txt": "decomposed"}
+	repo := newTestRepo(t, files)
+	archive := filepath.Join(t.TempDir(), "forms.tar.gz")
+	if err := archiveGitRepo(repo, archive, quietArchiveOptions()); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	target := filepath.Join(t.TempDir(), "restored")
+	if err := os.WriteFile(filepath.Join(filepath.Dir(target), "caf\u00e9.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(target), "cafe\u0301.txt")); err == nil {
+		t.Skip("the filesystem does not distinguish normalization forms")
+	}
+	if err := restoreGitRepo(target, archive, quietRestoreOptions()); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	checkRestoredFiles(t, target, files)
+}
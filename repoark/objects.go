@@ -0,0 +1,184 @@
+package repoark
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// objectsReachablePrefix introduces the rev-list arguments of --objects
+const objectsReachablePrefix = "reachable-from="
+
+// parseObjectsSpec returns the rev-list arguments of an --objects value, nil for all objects
+func parseObjectsSpec(spec string) ([]string, error) {
+	if spec == "" || spec == "all" {
+		return nil, nil
+	}
+	revArgs, ok := strings.CutPrefix(spec, objectsReachablePrefix)
+	if !ok || strings.TrimSpace(revArgs) == "" {
+		return nil, fmt.Errorf("invalid --objects value %q, expected all or %s<rev-list arguments>", spec, objectsReachablePrefix)
+	}
+	return strings.Fields(revArgs), nil
+}
+
+// gitObjectName matches the hex name of a SHA-1 or SHA-256 object
+var gitObjectName = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// objectPack is a pack of selected objects that replaces .git/objects in the archive
+type objectPack struct {
+	dir     string
+	Sources []archiveSource // the pack, its index and the shallow file, under their .git names
+	Objects int
+	Missing []string // refs whose commits are not in the pack
+}
+
+// Close removes the temporary pack files
+func (p *objectPack) Close() error {
+	if p == nil {
+		return nil
+	}
+	return os.RemoveAll(p.dir)
+}
+
+// buildObjectPack packs the objects reachable from revArgs, plus the blobs staged in the index so
+// the restored work tree stays clean. Commits whose parents are left out are listed in .git/shallow,
+// which makes the restored repository a shallow clone git can work with.
+func buildObjectPack(repoPath string, revArgs []string, opts ArchiveOptions) (*objectPack, error) {
+	revList := append([]string{"rev-list", "--objects"}, revArgs...)
+	output, err := runGit(repoPath, nil, revList...)
+	if err != nil {
+		return nil, err
+	}
+	var names bytes.Buffer
+	seen := make(map[string]bool)
+	addObject := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names.WriteString(name + "\n")
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// <object> <path>, with names that may contain newlines, so only object names are taken
+		if name, _, _ := strings.Cut(scanner.Text(), " "); gitObjectName.MatchString(name) {
+			addObject(name)
+		}
+	}
+
+	staged, err := runGit(repoPath, nil, "ls-files", "-z", "--stage")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range splitNul(staged) {
+		// <mode> <object> <stage>\t<path>; submodule commits live in the submodule
+		fields := strings.Fields(record)
+		if len(fields) >= 2 && fields[0] != "160000" {
+			addObject(fields[1])
+		}
+	}
+
+	// The pack is at most as large as the objects it is built from. Inside .git/objects, which the
+	// pack replaces in the archive, it is left out of the archive.
+	objectsDir := filepath.Join(repoPath, ".git", "objects")
+	fallback := ""
+	if info, err := os.Stat(objectsDir); err == nil && info.IsDir() {
+		fallback = objectsDir
+	}
+	dir, err := stagingDir(opts.TmpDir, "repoark-objects-", directorySize(objectsDir), fallback, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	pack := &objectPack{dir: dir, Objects: len(seen)}
+	packArgs := []string{"pack-objects", "-q"}
+	if opts.Reproducible {
+		// Delta search with several threads does not always produce the same pack
+		packArgs = append(packArgs, "--threads=1")
+	}
+	packArgs = append(packArgs, filepath.Join(dir, "pack"))
+	packName, err := runGit(repoPath, &names, packArgs...)
+	if err != nil {
+		pack.Close()
+		return nil, err
+	}
+	hash := strings.TrimSpace(string(packName))
+	for _, ext := range []string{".pack", ".idx"} {
+		pack.Sources = append(pack.Sources, archiveSource{
+			Path: filepath.Join(dir, "pack-"+hash+ext),
+			Name: filepath.Join(".git", "objects", "pack", "pack-"+hash+ext),
+		})
+	}
+
+	shallow, err := shallowCommits(repoPath, revArgs)
+	if err != nil {
+		pack.Close()
+		return nil, err
+	}
+	if len(shallow) > 0 {
+		shallowPath := filepath.Join(dir, "shallow")
+		if err := os.WriteFile(shallowPath, []byte(strings.Join(shallow, "\n")+"\n"), 0644); err != nil {
+			pack.Close()
+			return nil, err
+		}
+		pack.Sources = append(pack.Sources, archiveSource{Path: shallowPath, Name: filepath.Join(".git", "shallow")})
+	}
+
+	refs, err := runGit(repoPath, nil, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		pack.Close()
+		return nil, err
+	}
+	scanner = bufio.NewScanner(bytes.NewReader(refs))
+	for scanner.Scan() {
+		if object, ref, ok := strings.Cut(scanner.Text(), " "); ok && !seen[object] {
+			pack.Missing = append(pack.Missing, ref)
+		}
+	}
+	return pack, nil
+}
+
+// shallowCommits lists the selected commits that have a parent outside the selection,
+// keeping the boundary of a repository that already is a shallow clone
+func shallowCommits(repoPath string, revArgs []string) ([]string, error) {
+	output, err := runGit(repoPath, nil, append([]string{"rev-list", "--parents"}, revArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	included := make(map[string]bool)
+	var lines [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			included[fields[0]] = true
+			lines = append(lines, fields)
+		}
+	}
+
+	var shallow []string
+	boundary := make(map[string]bool)
+	for _, fields := range lines {
+		for _, parent := range fields[1:] {
+			if !included[parent] {
+				boundary[fields[0]] = true
+			}
+		}
+	}
+	// git reports no parents for the commits of an existing shallow boundary
+	if existing, err := os.ReadFile(filepath.Join(repoPath, ".git", "shallow")); err == nil {
+		for _, commit := range strings.Fields(string(existing)) {
+			if included[commit] {
+				boundary[commit] = true
+			}
+		}
+	}
+	for _, fields := range lines {
+		if boundary[fields[0]] {
+			shallow = append(shallow, fields[0])
+		}
+	}
+	return shallow, nil
+}
@@ -0,0 +1,94 @@
+package repoark
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Logger receives the human readable messages of an archive or restore run
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// ProgressReporter receives an event for every entry an archive or restore run processes.
+// action is one of add, reuse, skip, restore, clone or remove.
+type ProgressReporter interface {
+	Entry(action, path string, size int64)
+}
+
+// writerLogger writes log messages as plain lines
+type writerLogger struct {
+	w io.Writer
+}
+
+func (l writerLogger) Infof(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+func (l writerLogger) Warnf(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, "warning: "+format+"\n", args...)
+}
+
+// lineProgress prints one "<action> <path>" line per entry
+type lineProgress struct {
+	w io.Writer
+}
+
+func (p lineProgress) Entry(action, path string, size int64) {
+	fmt.Fprintf(p.w, "%s %s\n", action, path)
+}
+
+// discardLogger drops all messages, for --quiet
+type discardLogger struct{}
+
+func (discardLogger) Infof(format string, args ...interface{}) {}
+
+func (discardLogger) Warnf(format string, args ...interface{}) {}
+
+// discardProgress drops the per-entry events, only --verbose prints them
+type discardProgress struct{}
+
+func (discardProgress) Entry(action, path string, size int64) {}
+
+// verbosity selects how much an archive or restore run prints, errors are always reported
+type verbosity int
+
+const (
+	verbosityNormal  verbosity = iota // messages, warnings and the summary
+	verbosityQuiet                    // errors only
+	verbosityVerbose                  // also one line per file
+)
+
+// newLogger returns the logger writing to w for verbosity v
+func newLogger(w io.Writer, v verbosity) Logger {
+	if v == verbosityQuiet {
+		return discardLogger{}
+	}
+	return writerLogger{w: w}
+}
+
+// newProgress returns the reporter writing to w for verbosity v
+func newProgress(w io.Writer, v verbosity) ProgressReporter {
+	if v != verbosityVerbose {
+		return discardProgress{}
+	}
+	return lineProgress{w: w}
+}
+
+// addVerbosityFlags registers -q/--quiet and -v/--verbose on fs
+func addVerbosityFlags(fs *flag.FlagSet, v *verbosity) {
+	quiet := func(string) error {
+		*v = verbosityQuiet
+		return nil
+	}
+	verbose := func(string) error {
+		*v = verbosityVerbose
+		return nil
+	}
+	fs.BoolFunc("q", "", quiet)
+	fs.BoolFunc("quiet", "", quiet)
+	fs.BoolFunc("v", "", verbose)
+	fs.BoolFunc("verbose", "", verbose)
+}
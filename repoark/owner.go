@@ -0,0 +1,147 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// idMap is a repeatable command-line flag mapping archived user or group ids or names
+// to local ones, given as old:new
+type idMap map[string]string
+
+func (m *idMap) String() string {
+	var pairs []string
+	for _, old := range sortedKeys(*m) {
+		pairs = append(pairs, old+":"+(*m)[old])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *idMap) Set(value string) error {
+	old, mapped, ok := strings.Cut(value, ":")
+	if !ok || old == "" || mapped == "" {
+		return fmt.Errorf("invalid mapping %q, expected old:new", value)
+	}
+	if *m == nil {
+		*m = make(idMap)
+	}
+	(*m)[old] = mapped
+	return nil
+}
+
+// loadOwnerMap adds the mappings of an owner map file to users and groups. Each line is
+// "user old:new" or "group old:new", # starts a comment. Mappings already given on the
+// command line take precedence.
+func loadOwnerMap(path string, users, groups *idMap) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening owner map: %v", err)
+	}
+	defer file.Close()
+
+	fromFile := map[string]*idMap{"user": {}, "group": {}}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		mapping, ok := fromFile[fields[0]]
+		if len(fields) != 2 || !ok {
+			return fmt.Errorf("%s:%d: expected \"user old:new\" or \"group old:new\"", path, lineNo)
+		}
+		if err := mapping.Set(fields[1]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading owner map: %v", err)
+	}
+	for kind, target := range map[string]*idMap{"user": users, "group": groups} {
+		for old, mapped := range *fromFile[kind] {
+			if _, given := (*target)[old]; !given {
+				target.Set(old + ":" + mapped)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveID picks the local id for an archived owner or group: a mapping of its id or name first,
+// then the local account of the same name, then the archived id unchanged
+func resolveID(id int, name string, mapping idMap, lookup func(string) (int, error)) (int, error) {
+	mapped, ok := mapping[strconv.Itoa(id)]
+	if !ok && name != "" {
+		mapped, ok = mapping[name]
+	}
+	if ok {
+		if localID, err := strconv.Atoi(mapped); err == nil {
+			return localID, nil
+		}
+		return lookup(mapped)
+	}
+	if name != "" {
+		if localID, err := lookup(name); err == nil {
+			return localID, nil
+		}
+	}
+	return id, nil
+}
+
+func lookupUserID(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGroupID(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// restoredOwner returns the owner and group restoreOwner gives the entry, ok is false when it
+// leaves them alone
+func restoredOwner(header *tar.Header, opts RestoreOptions) (uid, gid int, ok bool, err error) {
+	if !opts.PreserveOwner || (header.Uid == 0 && header.Gid == 0 && header.Uname == "" && header.Gname == "") {
+		return 0, 0, false, nil
+	}
+	uname, gname := header.Uname, header.Gname
+	if opts.NumericOwner {
+		uname, gname = "", ""
+	}
+	if uid, err = resolveID(header.Uid, uname, opts.UserMap, lookupUserID); err != nil {
+		return 0, 0, false, err
+	}
+	if gid, err = resolveID(header.Gid, gname, opts.GroupMap, lookupGroupID); err != nil {
+		return 0, 0, false, err
+	}
+	return uid, gid, true, nil
+}
+
+// restoreOwner gives a restored file the archived (and mapped) owner and group with --preserve-owner.
+// Entries without ownership, from older, reproducible or zip archives, are left alone.
+// With --numeric-owner the archived names are ignored and only the ids and their mappings count.
+func restoreOwner(targetPath string, header *tar.Header, opts RestoreOptions) error {
+	uid, gid, ok, err := restoredOwner(header, opts)
+	if err != nil {
+		return fmt.Errorf("error mapping owner of %s: %v", targetPath, err)
+	}
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(targetPath, uid, gid); err != nil {
+		return fmt.Errorf("error setting owner: %v", err)
+	}
+	return nil
+}
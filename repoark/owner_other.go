@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package repoark
+
+import (
+	"archive/tar"
+	"os"
+)
+
+// fileOwner reports no owner on platforms without numeric file ownership
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// recordOwner does nothing on platforms without numeric file ownership
+func recordOwner(header *tar.Header, info os.FileInfo, numeric bool) {}
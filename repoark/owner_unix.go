@@ -0,0 +1,58 @@
+//go:build linux || darwin
+
+package repoark
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches user and group name lookups, every file of a repository usually has the same owner
+var ownerNames = struct {
+	sync.Mutex
+	users, groups map[int]string
+}{users: make(map[int]string), groups: make(map[int]string)}
+
+// fileOwner returns the numeric owner and group of info
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// recordOwner stores the numeric and, unless numeric is set, the named owner and group of info in header
+func recordOwner(header *tar.Header, info os.FileInfo, numeric bool) {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return
+	}
+	header.Uid, header.Gid = uid, gid
+	if numeric {
+		return
+	}
+
+	ownerNames.Lock()
+	defer ownerNames.Unlock()
+	name, ok := ownerNames.users[header.Uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.Itoa(header.Uid)); err == nil {
+			name = u.Username
+		}
+		ownerNames.users[header.Uid] = name
+	}
+	header.Uname = name
+	name, ok = ownerNames.groups[header.Gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.Itoa(header.Gid)); err == nil {
+			name = g.Name
+		}
+		ownerNames.groups[header.Gid] = name
+	}
+	header.Gname = name
+}
@@ -0,0 +1,64 @@
+package repoark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// missedEntry is a file, or for group and plan runs a repository, that a run which otherwise
+// succeeded did not archive or restore
+type missedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// partialSummary says what a partially successful run missed, for scripts deciding whether to
+// retry or alert. It is printed as one JSON line on stderr and with the --json summary.
+type partialSummary struct {
+	Operation string        `json:"operation"` // archive, restore, archive-group or restore-all
+	Missed    []missedEntry `json:"missed"`
+}
+
+// partialError is the result of a run that finished but missed entries, exit code 3
+type partialError struct {
+	partialSummary
+	Err error
+}
+
+func (e *partialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *partialError) Unwrap() error {
+	return e.Err
+}
+
+// newPartialError returns the error of a run that finished but missed entries
+func newPartialError(operation string, missed []missedEntry, err error) error {
+	return &partialError{partialSummary: partialSummary{Operation: operation, Missed: missed}, Err: err}
+}
+
+// missedIn returns what the run that ended with err missed, nil unless it finished partially
+func missedIn(err error) []missedEntry {
+	var partial *partialError
+	if errors.As(err, &partial) {
+		return partial.Missed
+	}
+	return nil
+}
+
+// printPartialSummary writes the summary of a partially successful run to w as one JSON line,
+// nothing for other errors
+func printPartialSummary(w io.Writer, err error) {
+	var partial *partialError
+	if !errors.As(err, &partial) {
+		return
+	}
+	data, marshalErr := json.Marshal(partial.partialSummary)
+	if marshalErr != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
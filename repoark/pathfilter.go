@@ -0,0 +1,99 @@
+package repoark
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// pathPatterns is a repeatable command-line flag of archive path globs.
+// "*" and "?" match within one path segment, "**" matches any number of segments,
+// and a pattern naming a directory matches everything below it.
+type pathPatterns []string
+
+func (p *pathPatterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathPatterns) Set(value string) error {
+	pattern := strings.Trim(strings.ReplaceAll(value, `\`, "/"), "/")
+	if pattern == "" {
+		return fmt.Errorf("empty path pattern")
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return fmt.Errorf("invalid path pattern %s: %v", value, err)
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// readFrom adds the patterns of a file written by restore --interactive or by hand, one per line.
+// Blank lines and lines starting with # are skipped.
+func (p *pathPatterns) readFrom(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := p.Set(text); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// writeTo saves the patterns to file for --paths-from, with comment naming the selection
+func (p pathPatterns) writeTo(file, comment string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n", comment)
+	for _, pattern := range p {
+		sb.WriteString(pattern + "\n")
+	}
+	return os.WriteFile(file, []byte(sb.String()), 0644)
+}
+
+// matches reports whether name is selected; no patterns select everything
+func (p pathPatterns) matches(name string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	nameParts := strings.Split(normalizeEntryName(name), "/")
+	for _, pattern := range p {
+		if matchSegments(strings.Split(pattern, "/"), nameParts) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches a pattern against a path segment by segment.
+// Running out of pattern with name segments left is a match, the pattern named a parent directory.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every possible number of segments for the wildcard
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return true
+}
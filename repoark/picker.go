@@ -0,0 +1,374 @@
+package repoark
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// pickerNode is a file or directory of the archive in the restore picker
+type pickerNode struct {
+	name     string
+	path     string
+	dir      bool
+	size     int64
+	parent   *pickerNode
+	children []*pickerNode
+	expanded bool
+	files    int // files at or below the node
+	selected int // selected files at or below the node
+}
+
+// restorePicker is the state of the interactive restore selection
+type restorePicker struct {
+	root    *pickerNode
+	cursor  int // index of the highlighted line among the visible ones
+	scroll  int // index of the first line shown
+	archive string
+}
+
+// pickRestorePaths lets the user tick the files and directories of archivePath to restore on the
+// terminal and returns them as path patterns. ok is false when the user cancelled.
+func pickRestorePaths(archivePath string) (patterns pathPatterns, ok bool, err error) {
+	if archivePath == "-" {
+		return nil, false, fmt.Errorf("--interactive needs an archive file, not stdin")
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return nil, false, fmt.Errorf("--interactive needs a terminal")
+	}
+	entries, err := readArchiveEntries(archivePath)
+	if err != nil {
+		return nil, false, err
+	}
+	picker := &restorePicker{root: buildPickerTree(entries), archive: archivePath}
+	if picker.root.files == 0 {
+		return nil, false, fmt.Errorf("%s has no files to restore", archivePath)
+	}
+	picker.root.expanded = true
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, false, err
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+	// The alternate screen keeps the terminal's scrollback as it was
+	fmt.Print("\033[?1049h\033[?25l")
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	keys := bufio.NewReader(os.Stdin)
+	for {
+		picker.render()
+		switch readPickerKey(keys) {
+		case "up":
+			picker.move(-1)
+		case "down":
+			picker.move(1)
+		case "pgup":
+			picker.move(-picker.pageSize())
+		case "pgdown":
+			picker.move(picker.pageSize())
+		case "right":
+			if node := picker.current(); node.dir {
+				node.expanded = true
+			}
+		case "left":
+			picker.collapse()
+		case "toggle":
+			picker.current().toggle()
+		case "all":
+			picker.root.toggle()
+		case "confirm":
+			if picker.root.selected == 0 {
+				continue
+			}
+			return picker.root.patterns(), true, nil
+		case "cancel":
+			return nil, false, nil
+		}
+	}
+}
+
+// buildPickerTree arranges the files of the archive as a tree below an unnamed root. Entries
+// restore never writes, and directory entries, are left out.
+func buildPickerTree(entries []listEntry) *pickerNode {
+	root := &pickerNode{dir: true}
+	dirs := map[string]*pickerNode{"": root}
+	var dirFor func(dirPath string) *pickerNode
+	dirFor = func(dirPath string) *pickerNode {
+		if node, ok := dirs[dirPath]; ok {
+			return node
+		}
+		parentPath, name := "", dirPath
+		if i := strings.LastIndex(dirPath, "/"); i >= 0 {
+			parentPath, name = dirPath[:i], dirPath[i+1:]
+		}
+		parent := dirFor(parentPath)
+		node := &pickerNode{name: name, path: dirPath, dir: true, parent: parent}
+		parent.children = append(parent.children, node)
+		dirs[dirPath] = node
+		return node
+	}
+	for _, entry := range entries {
+		name := normalizeEntryName(entry.Path)
+		if name == "" || strings.HasPrefix(entry.Mode, "d") || isMetaEntry(name) {
+			continue
+		}
+		dirPath, base := "", name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			dirPath, base = name[:i], name[i+1:]
+		}
+		parent := dirFor(dirPath)
+		parent.children = append(parent.children, &pickerNode{name: base, path: name, size: entry.Size, parent: parent})
+		for dir := parent; dir != nil; dir = dir.parent {
+			dir.files++
+			dir.size += entry.Size
+		}
+	}
+	for _, dir := range dirs {
+		// Directories first, then files, each by name
+		sort.Slice(dir.children, func(i, j int) bool {
+			a, b := dir.children[i], dir.children[j]
+			if a.dir != b.dir {
+				return a.dir
+			}
+			return a.name < b.name
+		})
+	}
+	for _, dir := range dirs {
+		for _, child := range dir.children {
+			if !child.dir {
+				child.files = 1
+			}
+		}
+	}
+	return root
+}
+
+// toggle selects everything at or below the node, or deselects it when all of it is selected
+func (n *pickerNode) toggle() {
+	n.setSelected(n.selected < n.files)
+}
+
+// setSelected selects or deselects everything at or below the node
+func (n *pickerNode) setSelected(selected bool) {
+	want := 0
+	if selected {
+		want = n.files
+	}
+	delta := want - n.selected
+	if delta == 0 {
+		return
+	}
+	var mark func(node *pickerNode)
+	mark = func(node *pickerNode) {
+		if selected {
+			node.selected = node.files
+		} else {
+			node.selected = 0
+		}
+		for _, child := range node.children {
+			mark(child)
+		}
+	}
+	mark(n)
+	for parent := n.parent; parent != nil; parent = parent.parent {
+		parent.selected += delta
+	}
+}
+
+// patterns returns the fewest patterns selecting what is ticked: a fully selected directory is
+// one pattern for everything below it
+func (n *pickerNode) patterns() pathPatterns {
+	if n.selected == 0 {
+		return nil
+	}
+	if n.selected == n.files {
+		if n.parent == nil {
+			return pathPatterns{"**"}
+		}
+		return pathPatterns{escapePathPattern(n.path)}
+	}
+	var patterns pathPatterns
+	for _, child := range n.children {
+		patterns = append(patterns, child.patterns()...)
+	}
+	return patterns
+}
+
+// escapePathPattern turns an archive path into a pattern matching only that path, the glob
+// characters of names become character classes matching themselves
+func escapePathPattern(name string) string {
+	var sb strings.Builder
+	for _, c := range name {
+		switch c {
+		case '*', '?', '[':
+			sb.WriteString("[" + string(c) + "]")
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// visible returns the lines of the tree as shown: the children of expanded directories, in order
+func (p *restorePicker) visible() []*pickerNode {
+	var lines []*pickerNode
+	var walk func(node *pickerNode)
+	walk = func(node *pickerNode) {
+		for _, child := range node.children {
+			lines = append(lines, child)
+			if child.dir && child.expanded {
+				walk(child)
+			}
+		}
+	}
+	walk(p.root)
+	return lines
+}
+
+// current returns the highlighted node
+func (p *restorePicker) current() *pickerNode {
+	lines := p.visible()
+	if p.cursor >= len(lines) {
+		p.cursor = len(lines) - 1
+	}
+	return lines[p.cursor]
+}
+
+// move moves the cursor by delta lines, within the tree
+func (p *restorePicker) move(delta int) {
+	p.cursor = max(0, min(p.cursor+delta, len(p.visible())-1))
+}
+
+// collapse closes the highlighted directory, or moves to the parent of anything else
+func (p *restorePicker) collapse() {
+	node := p.current()
+	if node.dir && node.expanded {
+		node.expanded = false
+		return
+	}
+	if node.parent == p.root {
+		return
+	}
+	node.parent.expanded = false
+	for i, line := range p.visible() {
+		if line == node.parent {
+			p.cursor = i
+		}
+	}
+}
+
+// pageSize is the number of tree lines fitting on the terminal
+func (p *restorePicker) pageSize() int {
+	_, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height < 6 {
+		height = 24
+	}
+	return height - 3
+}
+
+// render draws the visible part of the tree with a title and the key help
+func (p *restorePicker) render() {
+	lines := p.visible()
+	page := p.pageSize()
+	if p.cursor < p.scroll {
+		p.scroll = p.cursor
+	} else if p.cursor >= p.scroll+page {
+		p.scroll = p.cursor - page + 1
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&sb, "Restore from %s: %d of %d files selected\r\n\r\n", p.archive, p.root.selected, p.root.files)
+	for i := p.scroll; i < len(lines) && i < p.scroll+page; i++ {
+		node := lines[i]
+		box := "[ ]"
+		if node.selected == node.files {
+			box = "[x]"
+		} else if node.selected > 0 {
+			box = "[-]"
+		}
+		depth := strings.Count(node.path, "/")
+		name := node.name
+		if node.dir {
+			arrow := "+ "
+			if node.expanded {
+				arrow = "- "
+			}
+			name = arrow + name + "/"
+		} else {
+			name = "  " + name
+		}
+		line := fmt.Sprintf("%s %s%s  %s", box, strings.Repeat("  ", depth), name, formatSize(node.size))
+		if text := []rune(line); len(text) >= width {
+			line = string(text[:width-1])
+		}
+		if i == p.cursor {
+			line = "\033[7m" + line + "\033[0m"
+		}
+		sb.WriteString(line + "\r\n")
+	}
+	sb.WriteString(fmt.Sprintf("\033[%d;1H", page+3))
+	sb.WriteString("arrows/hjkl move, space select, a all, enter restore, q cancel")
+	fmt.Print(sb.String())
+}
+
+// readPickerKey reads one key press and names what it does in the picker
+func readPickerKey(keys *bufio.Reader) string {
+	c, err := keys.ReadByte()
+	if err != nil {
+		return "cancel"
+	}
+	switch c {
+	case 'k':
+		return "up"
+	case 'j':
+		return "down"
+	case 'l':
+		return "right"
+	case 'h':
+		return "left"
+	case ' ':
+		return "toggle"
+	case 'a':
+		return "all"
+	case '\r', '\n':
+		return "confirm"
+	case 'q', 3: // Ctrl-C arrives as a byte in raw mode
+		return "cancel"
+	case 0x1b:
+		// Escape sequences of the arrow and page keys, a lone Escape cancels
+		if keys.Buffered() == 0 {
+			return "cancel"
+		}
+		if next, _ := keys.ReadByte(); next != '[' {
+			return ""
+		}
+		seq, _ := keys.ReadByte()
+		switch seq {
+		case 'A':
+			return "up"
+		case 'B':
+			return "down"
+		case 'C':
+			return "right"
+		case 'D':
+			return "left"
+		case '5', '6':
+			keys.ReadByte() // the closing ~
+			if seq == '5' {
+				return "pgup"
+			}
+			return "pgdown"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressBarInterval is how often the progress bar is redrawn
+const progressBarInterval = 200 * time.Millisecond
+
+// progressBarWidth is the number of cells of the bar itself
+const progressBarWidth = 24
+
+// progressTracker is implemented by reporters that show overall progress rather than single entries
+type progressTracker interface {
+	// setTotals announces how many files and bytes the run will process
+	setTotals(files int, bytes int64)
+	// setPosition measures progress with position instead of summing entry sizes
+	setPosition(total int64, position func() int64)
+}
+
+// progressBar replaces the per-entry lines with a single redrawn status line showing files,
+// bytes, throughput and ETA. It also implements Logger, so messages do not garble the line.
+type progressBar struct {
+	w       *os.File
+	logger  Logger
+	started time.Time
+
+	mu         sync.Mutex
+	files      int
+	totalFiles int
+	bytes      int64
+	totalBytes int64
+	position   func() int64
+	current    string
+	drawn      bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// startProgressBar starts redrawing the bar on w, messages go through logger
+func startProgressBar(w *os.File, logger Logger) *progressBar {
+	b := &progressBar{
+		w:       w,
+		logger:  logger,
+		started: time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *progressBar) loop() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(progressBarInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			b.draw()
+			b.mu.Unlock()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *progressBar) setTotals(files int, bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.totalFiles, b.totalBytes = files, bytes
+}
+
+func (b *progressBar) setPosition(total int64, position func() int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if total > 0 {
+		b.totalBytes = total
+	}
+	b.position = position
+}
+
+func (b *progressBar) Entry(action, path string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current = path
+	if action == "remove" {
+		return
+	}
+	b.files++
+	if b.position == nil {
+		b.bytes += size
+	}
+}
+
+func (b *progressBar) Infof(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.logger.Infof(format, args...)
+}
+
+func (b *progressBar) Warnf(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.logger.Warnf(format, args...)
+}
+
+// finish stops redrawing and erases the bar, the summary messages of the run remain
+func (b *progressBar) finish() {
+	close(b.stop)
+	<-b.stopped
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+}
+
+// clear erases the bar so a message can be printed in its place, the next tick redraws it
+func (b *progressBar) clear() {
+	if b.drawn {
+		fmt.Fprint(b.w, "\r\033[K")
+		b.drawn = false
+	}
+}
+
+// draw renders the status line, the caller holds b.mu
+func (b *progressBar) draw() {
+	done := b.bytes
+	if b.position != nil {
+		done = b.position()
+	}
+	total := b.totalBytes
+	if total > 0 && done > total {
+		// Totals can be estimates, never report more than 100%
+		total = done
+	}
+	elapsed := time.Since(b.started)
+
+	var line strings.Builder
+	if total > 0 {
+		filled := int(float64(done) / float64(total) * progressBarWidth)
+		fmt.Fprintf(&line, "[%s%s] %3.0f%% ", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+			float64(done)*100/float64(total))
+	}
+	if b.totalFiles > 0 {
+		fmt.Fprintf(&line, "%d/%d files", b.files, b.totalFiles)
+	} else {
+		fmt.Fprintf(&line, "%d files", b.files)
+	}
+	if total > 0 {
+		fmt.Fprintf(&line, "  %s/%s", formatSize(done), formatSize(total))
+	} else {
+		fmt.Fprintf(&line, "  %s", formatSize(done))
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		rate := float64(done) / seconds
+		fmt.Fprintf(&line, "  %s/s", formatSize(int64(rate)))
+		if total > 0 && rate > 0 && b.current != "" {
+			eta := time.Duration(float64(total-done) / rate * float64(time.Second))
+			fmt.Fprintf(&line, "  ETA %s", eta.Round(time.Second))
+		}
+	}
+	if b.current != "" {
+		line.WriteString("  " + b.current)
+	}
+
+	width := 80
+	if w, _, err := term.GetSize(int(b.w.Fd())); err == nil && w > 0 {
+		width = w
+	}
+	text := []rune(line.String())
+	if len(text) >= width {
+		text = text[:width-1]
+	}
+	fmt.Fprint(b.w, "\r\033[K"+string(text))
+	b.drawn = true
+}
+
+// progressTotalBytes sums the sizes of the files an archive will contain, for the progress display
+func progressTotalBytes(files []archiveSource) int64 {
+	var total int64
+	for _, file := range files {
+		if file.Symlink != "" {
+			continue
+		}
+		if info, err := os.Stat(file.Path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,189 @@
+package repoark
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressUpdateInterval is how often the progress file is rewritten while work is going on
+const progressUpdateInterval = 250 * time.Millisecond
+
+// progressStatus is the content of the --progress-file
+type progressStatus struct {
+	Phase      string   `json:"phase"` // archiving, restoring, cleanup, done or failed
+	Percent    *float64 `json:"percent"`
+	Current    string   `json:"current"`
+	BytesDone  int64    `json:"bytes_done"`
+	BytesTotal int64    `json:"bytes_total"`
+	Elapsed    float64  `json:"elapsed_seconds"`
+	ETA        *float64 `json:"eta_seconds"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// progressFile is a ProgressReporter that mirrors the run into a small JSON status file
+// for external UIs, and passes every entry on to the regular reporter
+type progressFile struct {
+	path     string
+	next     ProgressReporter
+	started  time.Time
+	position func() int64 // overrides the sum of entry sizes, e.g. bytes read from the archive
+
+	mu      sync.Mutex
+	status  progressStatus
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startProgressFile writes the initial status and starts rewriting it periodically
+func startProgressFile(path, phase string, total int64, next ProgressReporter) *progressFile {
+	p := &progressFile{
+		path:    path,
+		next:    next,
+		started: time.Now(),
+		status:  progressStatus{Phase: phase, BytesTotal: total},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	p.write()
+	go p.loop()
+	return p
+}
+
+func (p *progressFile) loop() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.write()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// setPosition measures progress with position instead of summing entry sizes,
+// so that large files advance the status while they are being copied
+func (p *progressFile) setPosition(total int64, position func() int64) {
+	if tracker, ok := p.next.(progressTracker); ok {
+		tracker.setPosition(total, position)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if total > 0 {
+		p.status.BytesTotal = total
+	}
+	p.position = position
+}
+
+// setTotals replaces the size estimate with the exact total once the file list is known
+func (p *progressFile) setTotals(files int, bytes int64) {
+	if tracker, ok := p.next.(progressTracker); ok {
+		tracker.setTotals(files, bytes)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bytes > 0 {
+		p.status.BytesTotal = bytes
+	}
+}
+
+func (p *progressFile) Entry(action, path string, size int64) {
+	p.next.Entry(action, path, size)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Current = path
+	switch action {
+	case "add":
+		p.status.Phase = "archiving"
+	case "remove":
+		p.status.Phase = "cleanup"
+	default:
+		p.status.Phase = "restoring"
+	}
+	if p.position == nil && action != "remove" {
+		p.status.BytesDone += size
+	}
+}
+
+// finish stops the periodic updates and records the outcome
+func (p *progressFile) finish(err error) {
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	p.status.Phase = "done"
+	p.status.Current = ""
+	if err != nil {
+		p.status.Phase = "failed"
+		p.status.Error = err.Error()
+	} else if p.status.BytesTotal > 0 {
+		p.status.BytesDone = p.status.BytesTotal
+	}
+	p.mu.Unlock()
+	p.write()
+}
+
+// write replaces the status file atomically, readers never see a partial file
+func (p *progressFile) write() {
+	p.mu.Lock()
+	status := p.status
+	if p.position != nil {
+		status.BytesDone = p.position()
+		p.status.BytesDone = status.BytesDone
+	}
+	p.mu.Unlock()
+
+	status.Elapsed = time.Since(p.started).Seconds()
+	if status.BytesDone > status.BytesTotal && status.BytesTotal > 0 {
+		// The archive total is an estimate, never report more than 100%
+		status.BytesTotal = status.BytesDone
+	}
+	if status.BytesTotal > 0 {
+		percent := float64(status.BytesDone) * 100 / float64(status.BytesTotal)
+		status.Percent = &percent
+		if status.BytesDone > 0 && status.Phase != "done" && status.Phase != "failed" {
+			eta := status.Elapsed * (100 - percent) / percent
+			status.ETA = &eta
+		}
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".repoark-progress-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), p.path) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// countingWriter counts the bytes written through it, safe to read from another goroutine
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingWriter) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
@@ -0,0 +1,41 @@
+package repoark
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errReflinkUnsupported is returned by cloneFile on platforms without copy-on-write clones
+var errReflinkUnsupported = errors.New("reflink is not supported on this platform")
+
+// reflinkFromPrevious clones an entry from a previous restore when that copy is unchanged.
+// name is where the entry is restored, after mangling and case collisions, so the copy is
+// found where the previous restore put it. It reports whether targetPath was materialized;
+// otherwise the caller extracts the entry.
+func reflinkFromPrevious(previousRoot, name, targetPath string, header *tar.Header, opts RestoreOptions) bool {
+	sourcePath := filepath.Join(previousRoot, filepath.FromSlash(name))
+	stat, err := os.Lstat(sourcePath)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() != header.Size {
+		return false
+	}
+	if stat.ModTime().Round(time.Second) != header.ModTime.Round(time.Second) {
+		return false
+	}
+
+	if err := ensureParentDir(targetPath); err != nil {
+		return false
+	}
+	if err := cloneFile(sourcePath, targetPath); err != nil {
+		// Cross-device clones and filesystems without reflink support fall back to extraction
+		if !errors.Is(err, errReflinkUnsupported) {
+			opts.Logger.Warnf("reflink %s failed, extracting instead: %v", targetPath, err)
+		}
+		return false
+	}
+
+	opts.Progress.Entry("clone", targetPath, header.Size)
+	return true
+}
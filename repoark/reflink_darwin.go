@@ -0,0 +1,16 @@
+//go:build darwin
+
+package repoark
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates targetPath as an APFS clone of sourcePath
+func cloneFile(sourcePath, targetPath string) error {
+	err := unix.Clonefile(sourcePath, targetPath, unix.CLONE_NOFOLLOW)
+	if err == unix.ENOTSUP || err == unix.EXDEV {
+		return errReflinkUnsupported
+	}
+	return err
+}
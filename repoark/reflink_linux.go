@@ -0,0 +1,32 @@
+//go:build linux
+
+package repoark
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates targetPath sharing sourcePath's data blocks via the FICLONE ioctl
+func cloneFile(sourcePath, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(target.Fd()), int(source.Fd())); err != nil {
+		target.Close()
+		os.Remove(targetPath)
+		if err == unix.EOPNOTSUPP || err == unix.EXDEV || err == unix.EINVAL {
+			return errReflinkUnsupported
+		}
+		return err
+	}
+	return target.Close()
+}
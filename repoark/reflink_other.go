@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package repoark
+
+// cloneFile is not available on this platform
+func cloneFile(sourcePath, targetPath string) error {
+	return errReflinkUnsupported
+}
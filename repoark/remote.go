@@ -0,0 +1,289 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// remoteObject describes an archive stored on a remote backend
+type remoteObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// remoteBackend is implemented by every supported archive storage location
+type remoteBackend interface {
+	// List returns the objects whose key starts with prefix
+	List(prefix string) ([]remoteObject, error)
+	// ReadRange returns up to length bytes of key starting at offset
+	ReadRange(key string, offset, length int64) ([]byte, error)
+	// Move renames src to dst, replacing dst
+	Move(src, dst string) error
+	// Delete removes key permanently
+	Delete(key string) error
+	// Put stores size bytes read from r as key. Unless replace is set, it fails with
+	// errRemoteExists instead of overwriting an existing key.
+	Put(key string, r io.Reader, size int64, replace bool) error
+}
+
+// errRemoteExists is returned by Put when the key exists and may not be replaced
+var errRemoteExists = errors.New("already exists")
+
+// findAvailableRemoteKey returns the first key of prefix+baseName+ext, prefix+baseName-1+ext, ...
+// not present on backend, the same names findAvailableArchiveName picks for local files
+func findAvailableRemoteKey(backend remoteBackend, prefix, baseName, ext string) (string, error) {
+	objects, err := backend.List(prefix + baseName)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	taken := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		taken[object.Key] = true
+	}
+	key := prefix + baseName + ext
+	for i := 1; taken[key]; i++ {
+		key = fmt.Sprintf("%s%s-%d%s", prefix, baseName, i, ext)
+	}
+	return key, nil
+}
+
+// openRemote returns the backend for rawURL and the key prefix within it
+func openRemote(rawURL string) (remoteBackend, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid remote url %s: %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("missing bucket in %s", rawURL)
+		}
+		backend, err := newS3Backend(u.Host)
+		if err != nil {
+			return nil, "", err
+		}
+		return backend, strings.TrimPrefix(u.Path, "/"), nil
+	case "file", "":
+		return fileBackend{}, u.Path, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported remote backend: %s", u.Scheme)
+	}
+}
+
+// fileBackend serves archives from a local or mounted directory
+type fileBackend struct{}
+
+func (fileBackend) List(prefix string) ([]remoteObject, error) {
+	dir, namePrefix := prefix, ""
+	if info, err := os.Stat(prefix); err != nil || !info.IsDir() {
+		dir, namePrefix = filepath.Dir(prefix), filepath.Base(prefix)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var objects []remoteObject
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), namePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, remoteObject{
+			Key:     filepath.Join(dir, entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return objects, nil
+}
+
+func (fileBackend) ReadRange(key string, offset, length int64) ([]byte, error) {
+	file, err := os.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (fileBackend) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	removeEmptyTrashFolder(src)
+	return nil
+}
+
+func (fileBackend) Delete(key string) error {
+	if err := os.Remove(key); err != nil {
+		return err
+	}
+	removeEmptyTrashFolder(key)
+	return nil
+}
+
+func (fileBackend) Put(key string, r io.Reader, size int64, replace bool) error {
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !replace {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	file, err := os.OpenFile(key, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s: %w", key, errRemoteExists)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(key)
+		return err
+	}
+	return file.Close()
+}
+
+// removeEmptyTrashFolder drops the per-deletion folder of a trash entry once it is empty
+func removeEmptyTrashFolder(key string) {
+	if _, _, trashed := parseTrashKey(key); trashed {
+		os.Remove(filepath.Dir(key))
+	}
+}
+
+// summaryProbeSize is how much of each archive remote ls fetches to summarize it
+const summaryProbeSize = 64 * 1024
+
+// summarizeRemoteArchive describes an archive from its first bytes, with the branch, commit and
+// number of files from the metadata entry repoark writes first
+func summarizeRemoteArchive(key string, head []byte) string {
+	name, data, ok := headMetadata(key, head)
+	if !ok {
+		return "not a repoark archive"
+	}
+	parts := []string{name}
+	if meta, err := decodeRepoMetadata(data); err == nil {
+		if meta.Branch != "" {
+			parts = append(parts, meta.Branch)
+		}
+		if len(meta.Commit) >= 7 {
+			parts = append(parts, meta.Commit[:7])
+		}
+		if meta.Entries > 0 {
+			parts = append(parts, fmt.Sprintf("%d entries", meta.Entries))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// headMetadata returns the compression, or zip, of an archive starting with head, and the
+// metadata entry when it lies within head
+func headMetadata(key string, head []byte) (string, []byte, bool) {
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return "zip", zipHeadMetadata(head), true
+	}
+	comp, detected := detectCompressor(head)
+	if !detected {
+		comp = compressorForPath(key)
+	}
+	compReader, err := comp.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return "", nil, false
+	}
+	defer compReader.Close()
+	// The head ends mid-stream, so only entries within it can be read
+	tarReader := tar.NewReader(compReader)
+	header, err := tarReader.Next()
+	if err != nil {
+		return "", nil, false
+	}
+	if strings.TrimPrefix(header.Name, "./") != archiveMetadataName {
+		return comp.Name, nil, true
+	}
+	data, err := io.ReadAll(tarReader)
+	if err != nil {
+		return comp.Name, nil, true
+	}
+	return comp.Name, data, true
+}
+
+// zipHeadMetadata reads the metadata entry from the first local file header of a zip archive.
+// repoark deflates every zip entry, with the sizes in a data descriptor after the data, so the
+// entry is read until its deflate stream ends.
+func zipHeadMetadata(head []byte) []byte {
+	const localHeaderSize = 30
+	if len(head) < localHeaderSize || binary.LittleEndian.Uint16(head[8:]) != zip.Deflate {
+		return nil
+	}
+	nameLen := int(binary.LittleEndian.Uint16(head[26:]))
+	extraLen := int(binary.LittleEndian.Uint16(head[28:]))
+	if len(head) < localHeaderSize+nameLen+extraLen || string(head[localHeaderSize:localHeaderSize+nameLen]) != archiveMetadataName {
+		return nil
+	}
+	content := flate.NewReader(bytes.NewReader(head[localHeaderSize+nameLen+extraLen:]))
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// listRemoteArchives prints the archives found under rawURL
+func listRemoteArchives(rawURL string) error {
+	backend, prefix, err := openRemote(rawURL)
+	if err != nil {
+		return err
+	}
+
+	objects, err := backend.List(prefix)
+	if err != nil {
+		return fmt.Errorf("error listing %s: %v", rawURL, err)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime.Before(objects[j].ModTime)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tMODIFIED\tSUMMARY\tNAME")
+	for _, object := range objects {
+		if _, _, trashed := parseTrashKey(object.Key); trashed {
+			continue
+		}
+		summary := "unreadable"
+		if head, err := backend.ReadRange(object.Key, 0, summaryProbeSize); err == nil {
+			summary = summarizeRemoteArchive(object.Key, head)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatSize(object.Size), object.ModTime.Local().Format("2006-01-02 15:04"), summary, object.Key)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,25 @@
+// Package repoark archives git (and Mercurial) repositories with their untracked files and
+// restores them, the library behind the repoark command.
+//
+// Applications embedding it pass their own Logger and ProgressReporter in the options to
+// receive the messages and per-entry events a run would otherwise print:
+//
+//	err := repoark.Archive("/src/project", "/backups/project.tar.zst", repoark.ArchiveOptions{
+//		Logger:   myLogger,
+//		Progress: myProgress,
+//	})
+package repoark
+
+// Archive writes an archive of the repository at repoPath to outputPath, "-" for stdout.
+// Unset options take the command's defaults, and a nil Logger or ProgressReporter prints to
+// stdout like the command does.
+func Archive(repoPath, outputPath string, opts ArchiveOptions) error {
+	return archiveGitRepo(repoPath, outputPath, opts)
+}
+
+// Restore restores the archive at archivePath, "-" for stdin, into repoPath. Unset options take
+// the command's defaults, and a nil Logger or ProgressReporter prints to stdout like the command
+// does.
+func Restore(repoPath, archivePath string, opts RestoreOptions) error {
+	return restoreGitRepo(repoPath, archivePath, opts)
+}
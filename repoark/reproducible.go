@@ -0,0 +1,36 @@
+package repoark
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sourceDateEpoch returns the time given by SOURCE_DATE_EPOCH, or the zero time when unset
+func sourceDateEpoch() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %s", value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// makeReproducible strips the header fields that vary between otherwise identical runs:
+// sub-second mtimes (clamped to epoch when set) and ownership
+func makeReproducible(header *tar.Header, epoch time.Time) {
+	header.ModTime = header.ModTime.Truncate(time.Second).UTC()
+	if !epoch.IsZero() && header.ModTime.After(epoch) {
+		header.ModTime = epoch
+	}
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	delete(header.PAXRecords, paxCreationTime)
+}
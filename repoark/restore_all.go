@@ -0,0 +1,280 @@
+package repoark
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// restorePlan is a restore-all plan: the archives of a backup set and where they go
+type restorePlan struct {
+	Parallel int         // restores running at the same time
+	Defaults planOptions // restore options of every entry
+	Restores []planRestore
+}
+
+// planRestore is one archive of a restore plan
+type planRestore struct {
+	Archive string // resolved against the directory of the plan
+	To      string
+	Options planOptions
+	Line    int
+}
+
+// planOptions are restore options by flag name, a list sets a repeatable flag several times
+type planOptions map[string][]string
+
+// planOnlyOptions are restore flags that make no sense for one entry of several running at once
+var planOnlyOptions = map[string]bool{"json": true, "progress": true, "progress-file": true, "exec-report": true, "fidelity-report": true}
+
+// planResult is the outcome of one restore of a plan, as shown in the report
+type planResult struct {
+	Archive string        `json:"archive"`
+	To      string        `json:"to"`
+	Status  string        `json:"status"` // ok, partial or failed
+	Error   string        `json:"error,omitempty"`
+	Seconds float64       `json:"seconds"`
+	Missed  []missedEntry `json:"missed,omitempty"` // entries a partial restore did not restore
+}
+
+// loadRestorePlan reads a restore-all plan, a YAML document such as:
+//
+//	parallel: 4
+//	defaults:
+//	  verify: true
+//	restores:
+//	  - archive: api.tar.gz
+//	    to: ~/src/api
+//	    path: [src/, docs/]
+func loadRestorePlan(path string) (*restorePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan: %v", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: the plan is empty", path)
+	}
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected parallel, defaults and restores", path, top.Line)
+	}
+
+	plan := &restorePlan{Parallel: 1}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key, node := top.Content[i], top.Content[i+1]
+		if seen[key.Value] {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", path, key.Line, key.Value)
+		}
+		seen[key.Value] = true
+		switch key.Value {
+		case "parallel":
+			if plan.Parallel, err = strconv.Atoi(node.Value); node.Kind != yaml.ScalarNode || err != nil || plan.Parallel < 1 {
+				return nil, fmt.Errorf("%s:%d: parallel must be a positive number", path, node.Line)
+			}
+		case "defaults":
+			if plan.Defaults, err = planOptionsOf(node, path); err != nil {
+				return nil, err
+			}
+		case "restores":
+			if node.Kind != yaml.SequenceNode {
+				return nil, fmt.Errorf("%s:%d: restores must be a list", path, node.Line)
+			}
+			for _, entry := range node.Content {
+				options, err := planOptionsOf(entry, path)
+				if err != nil {
+					return nil, err
+				}
+				restore := planRestore{Options: options, Line: entry.Line}
+				for _, field := range []struct {
+					name string
+					dest *string
+				}{{"archive", &restore.Archive}, {"to", &restore.To}} {
+					values := options[field.name]
+					if len(values) != 1 || values[0] == "" {
+						return nil, fmt.Errorf("%s:%d: restore needs one %s", path, entry.Line, field.name)
+					}
+					*field.dest = expandHome(values[0])
+					delete(options, field.name)
+				}
+				// Archives usually sit next to the plan describing them
+				if !filepath.IsAbs(restore.Archive) {
+					restore.Archive = filepath.Join(filepath.Dir(path), restore.Archive)
+				}
+				plan.Restores = append(plan.Restores, restore)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q, expected parallel, defaults or restores", path, key.Line, key.Value)
+		}
+	}
+	if len(plan.Restores) == 0 {
+		return nil, fmt.Errorf("%s: the plan has no restores", path)
+	}
+
+	// Two restores into one directory would remove each other's files
+	destinations := make(map[string]int)
+	for _, restore := range plan.Restores {
+		to, _ := filepath.Abs(restore.To)
+		if line, ok := destinations[to]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is also the destination of the restore on line %d", path, restore.Line, restore.To, line)
+		}
+		destinations[to] = restore.Line
+	}
+	return plan, nil
+}
+
+// planOptionsOf converts a mapping of a plan into options
+func planOptionsOf(node *yaml.Node, path string) (planOptions, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of restore options", path, node.Line)
+	}
+	options := make(planOptions)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, child := node.Content[i], node.Content[i+1]
+		if _, ok := options[key.Value]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", path, key.Line, key.Value)
+		}
+		switch child.Kind {
+		case yaml.ScalarNode:
+			options[key.Value] = []string{child.Value}
+		case yaml.SequenceNode:
+			options[key.Value] = []string{}
+			for _, item := range child.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s:%d: %s must be a list of values", path, item.Line, key.Value)
+				}
+				options[key.Value] = append(options[key.Value], item.Value)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: %s must be a value or a list of values", path, child.Line, key.Value)
+		}
+	}
+	return options, nil
+}
+
+// options builds the restore options of the entry from the plan defaults and its own settings
+func (r planRestore) options(defaults planOptions) (RestoreOptions, error) {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	var opts RestoreOptions
+	addRestoreFlags(fs, &opts)
+	for _, options := range []planOptions{defaults, r.Options} {
+		keys := make([]string, 0, len(options))
+		for key := range options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if planOnlyOptions[key] || fs.Lookup(key) == nil {
+				return opts, fmt.Errorf("%d: %s is not a restore option a plan can set", r.Line, key)
+			}
+			for _, value := range options[key] {
+				if err := fs.Set(key, value); err != nil {
+					return opts, fmt.Errorf("%d: invalid %s %q: %v", r.Line, key, value, err)
+				}
+			}
+		}
+	}
+	return opts, nil
+}
+
+// restoreAll runs the restores of the plan at planPath, at most parallel at a time (0 for the
+// plan's setting), and prints a report to out. The output of each restore is printed once it finished.
+func restoreAll(planPath string, parallel int, reportPath string, out io.Writer) error {
+	plan, err := loadRestorePlan(planPath)
+	if err != nil {
+		return err
+	}
+	if parallel > 0 {
+		plan.Parallel = parallel
+	}
+	// Options are checked for every entry before anything is restored
+	entryOptions := make([]RestoreOptions, len(plan.Restores))
+	for i, restore := range plan.Restores {
+		if entryOptions[i], err = restore.options(plan.Defaults); err != nil {
+			return fmt.Errorf("%s:%v", planPath, err)
+		}
+	}
+
+	results := make([]planResult, len(plan.Restores))
+	var printing sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, plan.Parallel)
+	for i, restore := range plan.Restores {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, restore planRestore, opts RestoreOptions) {
+			defer func() { <-slots; wg.Done() }()
+			var output bytes.Buffer
+			opts.Stdout, opts.Logger, opts.Progress = &output, newLogger(&output, opts.Verbosity), newProgress(&output, opts.Verbosity)
+			start := time.Now()
+			err := restoreGitRepo(restore.To, restore.Archive, opts)
+			results[i] = planResult{Archive: restore.Archive, To: restore.To, Status: "ok", Seconds: time.Since(start).Seconds()}
+			if err != nil {
+				results[i].Status, results[i].Error = "failed", err.Error()
+				if exitCode(err) == exitPartial {
+					results[i].Status, results[i].Missed = "partial", missedIn(err)
+				}
+				fmt.Fprintf(&output, "Error: %v\n", err)
+			}
+
+			printing.Lock()
+			defer printing.Unlock()
+			fmt.Fprintf(out, "==> %s -> %s\n", restore.Archive, restore.To)
+			out.Write(output.Bytes())
+		}(i, restore, entryOptions[i])
+	}
+	wg.Wait()
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDURATION\tARCHIVE\tDESTINATION")
+	failed := 0
+	for _, result := range results {
+		duration := time.Duration(result.Seconds * float64(time.Second)).Round(100 * time.Millisecond)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Status, duration, result.Archive, result.To)
+		if result.Status != "ok" {
+			failed++
+		}
+	}
+	w.Flush()
+	if reportPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(reportPath, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("error writing report: %v", err)
+		}
+	}
+
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d restores failed or were incomplete", failed, len(results))
+		if failed < len(results) {
+			var missed []missedEntry
+			for _, result := range results {
+				if result.Status == "failed" {
+					missed = append(missed, missedEntry{Path: result.To, Reason: result.Error})
+				}
+				missed = append(missed, result.Missed...)
+			}
+			return newPartialError("restore-all", missed, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package repoark
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRestorePlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		plan     string
+		parallel int
+		defaults planOptions
+		restores []planOptions // options of each restore, with archive and to
+		wantErr  string
+	}{
+		{
+			name: "block and flow lists",
+			plan: `parallel: 3
+defaults:
+  verify: true
+restores:
+  - archive: a.tar.gz
+    to: /dst/a
+    path: [src/, docs/]
+  - archive: b.tar.gz
+    to: /dst/b
+    path:
+      - one
+      - two
+`,
+			parallel: 3,
+			defaults: planOptions{"verify": {"true"}},
+			restores: []planOptions{
+				{"archive": {"a.tar.gz"}, "to": {"/dst/a"}, "path": {"src/", "docs/"}},
+				{"archive": {"b.tar.gz"}, "to": {"/dst/b"}, "path": {"one", "two"}},
+			},
+		},
+		{
+			name: "quoted commas and colons",
+			plan: `restores:
+  - archive: "a,b.tar.gz"
+    to: '/dst/x: y'
+    path: ["a,b", c, 'd, e']
+`,
+			parallel: 1,
+			restores: []planOptions{
+				{"archive": {"a,b.tar.gz"}, "to": {"/dst/x: y"}, "path": {"a,b", "c", "d, e"}},
+			},
+		},
+		{
+			name: "comments",
+			plan: `# the backup set of the laptop
+parallel: 2 # at once
+restores:
+  # the api service
+  - archive: "a#1.tar.gz" # quoted hash
+    to: /dst/a#b
+`,
+			parallel: 2,
+			restores: []planOptions{
+				{"archive": {"a#1.tar.gz"}, "to": {"/dst/a#b"}},
+			},
+		},
+		{
+			name: "list indented like its key",
+			plan: `restores:
+- archive: a.tar.gz
+  to: /dst/a
+`,
+			parallel: 1,
+			restores: []planOptions{{"archive": {"a.tar.gz"}, "to": {"/dst/a"}}},
+		},
+		{
+			name:    "bad indentation",
+			plan:    "restores:\n  - archive: a.tar.gz\n      to: /dst/a\n",
+			wantErr: "line 3",
+		},
+		{
+			name:    "tab indentation",
+			plan:    "restores:\n\t- archive: a.tar.gz\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "unknown key",
+			plan:    "parallel: 1\n\nrestore:\n  - archive: a.tar.gz\n",
+			wantErr: ":3: unknown key \"restore\"",
+		},
+		{
+			name:    "key set twice",
+			plan:    "restores:\n  - archive: a.tar.gz\n    to: /dst/a\n    to: /dst/b\n",
+			wantErr: "to",
+		},
+		{
+			name:    "parallel not a number",
+			plan:    "restores:\n  - archive: a.tar.gz\n    to: /dst/a\nparallel: many\n",
+			wantErr: ":4: parallel must be a positive number",
+		},
+		{
+			name:    "restores not a list",
+			plan:    "restores:\n  archive: a.tar.gz\n",
+			wantErr: ":2: restores must be a list",
+		},
+		{
+			name:    "nested mapping as an option",
+			plan:    "restores:\n  - archive: a.tar.gz\n    to: /dst/a\n    path:\n      src: yes\n",
+			wantErr: ":5: path must be a value or a list of values",
+		},
+		{
+			name:    "restore without destination",
+			plan:    "restores:\n  - archive: a.tar.gz\n  - archive: b.tar.gz\n    to: /dst/b\n",
+			wantErr: ":2: restore needs one to",
+		},
+		{
+			name:    "shared destination",
+			plan:    "restores:\n  - archive: a.tar.gz\n    to: /dst/a\n  - archive: b.tar.gz\n    to: /dst/a\n",
+			wantErr: ":4: /dst/a is also the destination of the restore on line 2",
+		},
+		{
+			name:    "empty plan",
+			plan:    "# nothing yet\n",
+			wantErr: "the plan is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "plan.yaml")
+			if err := os.WriteFile(path, []byte(tt.plan), 0644); err != nil {
+				t.Fatal(err)
+			}
+			plan, err := loadRestorePlan(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadRestorePlan() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadRestorePlan(): %v", err)
+			}
+			if plan.Parallel != tt.parallel {
+				t.Errorf("parallel = %d, want %d", plan.Parallel, tt.parallel)
+			}
+			if len(tt.defaults) > 0 && !reflect.DeepEqual(plan.Defaults, tt.defaults) {
+				t.Errorf("defaults = %v, want %v", plan.Defaults, tt.defaults)
+			}
+			if len(plan.Restores) != len(tt.restores) {
+				t.Fatalf("%d restores, want %d", len(plan.Restores), len(tt.restores))
+			}
+			for i, restore := range plan.Restores {
+				want := planOptions{}
+				for key, values := range tt.restores[i] {
+					want[key] = values
+				}
+				if archive := filepath.Join(dir, want["archive"][0]); restore.Archive != archive {
+					t.Errorf("restore %d archive = %q, want %q", i, restore.Archive, archive)
+				}
+				if restore.To != want["to"][0] {
+					t.Errorf("restore %d to = %q, want %q", i, restore.To, want["to"][0])
+				}
+				delete(want, "archive")
+				delete(want, "to")
+				if !reflect.DeepEqual(restore.Options, want) {
+					t.Errorf("restore %d options = %v, want %v", i, restore.Options, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanRestoreOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults planOptions
+		options  planOptions
+		wantErr  string
+	}{
+		{"defaults and own options", planOptions{"verify": {"true"}}, planOptions{"path": {"src", "docs"}}, ""},
+		{"option only the command line has", nil, planOptions{"json": {"true"}}, "json is not a restore option a plan can set"},
+		{"unknown option", planOptions{"colour": {"red"}}, nil, "colour is not a restore option"},
+		{"invalid value", nil, planOptions{"verify": {"maybe"}}, "invalid verify"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restore := planRestore{Options: tt.options, Line: 7}
+			opts, err := restore.options(tt.defaults)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "7: ") {
+					t.Fatalf("options() = %v, want error on line 7 containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("options(): %v", err)
+			}
+			if !opts.Verify || !reflect.DeepEqual([]string(opts.Paths), []string{"src", "docs"}) {
+				t.Errorf("options() = verify %v, paths %v; want both applied", opts.Verify, opts.Paths)
+			}
+		})
+	}
+}
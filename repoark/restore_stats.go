@@ -0,0 +1,110 @@
+package repoark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// restoreStats counts what a restore did to the target directory
+type restoreStats struct {
+	Restored     int
+	Skipped      int
+	Removed      int
+	Failed       int
+	Verified     int
+	Mismatched   int
+	Checked      int // up-to-date files compared with the archive by --verify-existing
+	Repaired     int // checked files that differed and were rewritten
+	Corrupt      int // restored files whose content does not match the archive's manifest
+	BytesWritten int64
+	XattrsLost   int // archived extended attributes that could not be applied with --xattrs
+
+	xattrExample string        // the first attribute that could not be applied, and why
+	missed       []missedEntry // failed and mismatched entries, for the partial summary
+
+	sums map[string][]byte // SHA-256 of the content extracted for each entry
+}
+
+// recordSum remembers the hash of an extracted entry for the check against the manifest
+func (s *restoreStats) recordSum(name string, sum []byte) {
+	if s.sums == nil {
+		s.sums = make(map[string][]byte)
+	}
+	s.sums[name] = sum
+}
+
+// checkManifest compares the content extracted from the archive with the checksums recorded
+// when it was written, which catches corruption the compression format did not detect
+func (s *restoreStats) checkManifest(m *manifest, repoPath string, opts RestoreOptions) {
+	for _, entry := range m.Entries {
+		sum, ok := s.sums[entry.Path]
+		if !ok || hex.EncodeToString(sum) == entry.SHA256 {
+			continue
+		}
+		s.Corrupt++
+		opts.Logger.Warnf("%s does not match the checksum recorded when the archive was written, the archive is corrupt", filepath.Join(repoPath, entry.Path))
+	}
+}
+
+// fail records a per-entry problem that did not stop the restore, path is what was not restored
+func (s *restoreStats) fail(opts RestoreOptions, path, format string, args ...interface{}) {
+	s.Failed++
+	message := fmt.Sprintf(format, args...)
+	s.missed = append(s.missed, missedEntry{Path: path, Reason: message})
+	opts.Logger.Warnf("%s", message)
+}
+
+// summary renders the counters for the end of a restore
+func (s *restoreStats) summary(verify bool) string {
+	text := fmt.Sprintf("%d restored, %d skipped, %d removed, %d failed, %s written",
+		s.Restored, s.Skipped, s.Removed, s.Failed, formatSize(s.BytesWritten))
+	if verify {
+		text += fmt.Sprintf(", %d verified, %d hash mismatches", s.Verified, s.Mismatched)
+	}
+	if s.Corrupt > 0 {
+		text += fmt.Sprintf(", %d corrupt", s.Corrupt)
+	}
+	if s.Checked > 0 {
+		text += fmt.Sprintf(", %d existing files checked, %d repaired", s.Checked, s.Repaired)
+	}
+	return text
+}
+
+// err returns an error when any entry failed or did not verify
+func (s *restoreStats) err() error {
+	if s.Corrupt > 0 {
+		return &exitError{Code: exitCorrupt, Err: fmt.Errorf("restore incomplete: %d files do not match the archive's checksums", s.Corrupt)}
+	}
+	if s.Failed > 0 || s.Mismatched > 0 {
+		return newPartialError("restore", s.missed, fmt.Errorf("restore incomplete: %d failed entries, %d hash mismatches", s.Failed, s.Mismatched))
+	}
+	return nil
+}
+
+// verifyRestoredFile compares the SHA-256 of a written file with the hash of the archived content
+func verifyRestoredFile(targetPath string, archivedSum []byte, stats *restoreStats, opts RestoreOptions) {
+	sum, err := fileSHA256(targetPath)
+	if err != nil {
+		stats.fail(opts, targetPath, "error verifying %s: %v", targetPath, err)
+		return
+	}
+	if sum != hex.EncodeToString(archivedSum) {
+		stats.Mismatched++
+		stats.missed = append(stats.missed, missedEntry{Path: targetPath, Reason: "hash mismatch"})
+		opts.Logger.Warnf("hash mismatch for %s", targetPath)
+		return
+	}
+	stats.Verified++
+}
+
+// hashEntry returns the SHA-256 of everything read from the current entry
+func hashEntry(tarReader *archiveReader) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, tarReader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
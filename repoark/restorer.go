@@ -0,0 +1,83 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// modulePath is the import path repoark is built from
+const modulePath = "github.com/likang/RepoArk"
+
+// restoreOnly is set in binaries built by make-restorer, which refuse every command that is
+// not about reading archives
+var restoreOnly bool
+
+// restorerCommands are the commands a restore-only binary accepts
+var restorerCommands = map[string]bool{"restore": true, "verify": true, "list": true, "info": true, "cat": true}
+
+// checkRestorerCommand exits when a restore-only binary is asked for anything else
+func checkRestorerCommand(command string) {
+	if restoreOnly && !restorerCommands[command] {
+		fmt.Printf("Error: this is a restore-only repoark, it only runs restore, verify, list, info and cat\n")
+		os.Exit(exitUsage)
+	}
+}
+
+// makeRestorer cross-compiles a restore-only, statically linked repoark for goos and goarch to out.
+// It needs the Go toolchain and builds either the checkout at source or, for a released repoark,
+// the same module version, which go fetches unless it is in the module cache.
+func makeRestorer(goos, goarch, source, out string) error {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		return fmt.Errorf("make-restorer needs the Go toolchain: %v", err)
+	}
+	if out, err = filepath.Abs(out); err != nil {
+		return err
+	}
+
+	dir, pkg := source, "."
+	if source == "" {
+		info, ok := debug.ReadBuildInfo()
+		if !ok || info.Main.Path != modulePath || info.Main.Version == "" || info.Main.Version == "(devel)" ||
+			strings.HasSuffix(info.Main.Version, "+dirty") {
+			return fmt.Errorf("this repoark was not installed from a released version, use --source <checkout> to build from the source")
+		}
+		if dir, err = os.MkdirTemp("", "repoark-restorer-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		goMod := fmt.Sprintf("module repoark-restorer\n\ngo 1.22\n\nrequire %s %s\n", modulePath, info.Main.Version)
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+			return err
+		}
+		if output, err := runGo(goTool, dir, nil, "get", modulePath+"@"+info.Main.Version); err != nil {
+			return fmt.Errorf("error fetching %s %s, use --source <checkout> to build from the source: %v: %s", modulePath, info.Main.Version, err, output)
+		}
+		pkg = modulePath
+	}
+
+	// Without cgo the binary only depends on the kernel, -s -w drops the symbol tables
+	env := []string{"GOOS=" + goos, "GOARCH=" + goarch, "CGO_ENABLED=0"}
+	if output, err := runGo(goTool, dir, env, "build", "-tags", "restorer", "-trimpath", "-ldflags=-s -w", "-o", out, pkg); err != nil {
+		return fmt.Errorf("error building restorer: %v: %s", err, output)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote restore-only repoark for %s/%s to %s (%s)\n", goos, goarch, out, formatSize(info.Size()))
+	return nil
+}
+
+// runGo runs the go command in dir with env added to the environment and returns its trimmed output
+func runGo(goTool, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command(goTool, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
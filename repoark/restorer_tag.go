@@ -0,0 +1,7 @@
+//go:build restorer
+
+package repoark
+
+func init() {
+	restoreOnly = true
+}
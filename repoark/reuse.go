@@ -0,0 +1,483 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// archiveIndexName is the entry listing the chunks of an archive written with --reuse-from
+const archiveIndexName = archiveMetaDir + "index.json"
+
+// chunkBoundaryModulus makes a chunk end after roughly every 32nd file. Boundaries depend on
+// file names only, so a changed file does not move the chunks around it.
+const chunkBoundaryModulus = 32
+
+// maxChunkSize ends a chunk early once it holds this much file content
+const maxChunkSize = 8 << 20
+
+// footerSearchSize is how much of the end of an archive is searched for the index footer
+const footerSearchSize = 64 << 10
+
+// footerSubfield identifies the gzip extra field of the last member, which holds the
+// offset and length of the index member
+var footerSubfield = [2]byte{'R', 'A'}
+
+// archiveIndex lists the gzip members of a chunked archive
+type archiveIndex struct {
+	Chunks []indexChunk `json:"chunks"`
+	Git    *gitState    `json:"git,omitempty"` // state of the git working tree when the archive was written
+}
+
+// indexChunk is one gzip member holding the tar entries of a group of files
+type indexChunk struct {
+	Key     string   `json:"key,omitempty"` // identifies the source files by name, size, mtime and mode, empty when not reusable
+	Offset  int64    `json:"offset"`        // of the member in the archive file
+	Length  int64    `json:"length"`        // compressed
+	Size    int64    `json:"size"`          // of the tar stream it holds
+	Entries []string `json:"entries"`
+}
+
+// reuseSource is a previous archive whose unchanged chunks are copied instead of compressed again
+type reuseSource struct {
+	file     *os.File
+	chunks   map[string]indexChunk
+	manifest map[string]manifestEntry // checksums of the previous archive's files, for the new manifest
+	byFiles  map[string]indexChunk    // reusable chunks by the names of their entries
+	git      *gitState                // git state recorded with the previous archive, nil when there is none
+	trusted  map[string]bool          // files git reports unchanged since the previous archive, taken as they are
+}
+
+// openReuseSource reads the index of a previous archive. An archive without index is no error,
+// nothing can be reused from it.
+func openReuseSource(path string) (*reuseSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening previous archive: %v", err)
+	}
+	index, err := readArchiveIndex(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading index of %s: %v", path, err)
+	}
+	source := &reuseSource{file: file, chunks: make(map[string]indexChunk), manifest: make(map[string]manifestEntry),
+		byFiles: make(map[string]indexChunk)}
+	if index != nil {
+		source.git = index.Git
+		for _, chunk := range index.Chunks {
+			if _, seen := source.chunks[chunk.Key]; chunk.Key != "" && !seen {
+				source.chunks[chunk.Key] = chunk
+				source.byFiles[strings.Join(chunk.Entries, "\x00")] = chunk
+			}
+		}
+		m, err := readChunkedManifest(file, index)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading manifest of %s: %v", path, err)
+		}
+		for _, entry := range m.Entries {
+			source.manifest[entry.Path] = entry
+		}
+	}
+	return source, nil
+}
+
+// readChunkedManifest reads the manifest of a chunked archive from the member holding it,
+// an empty manifest when the archive has none
+func readChunkedManifest(file *os.File, index *archiveIndex) (*manifest, error) {
+	for _, chunk := range index.Chunks {
+		for _, entry := range chunk.Entries {
+			if entry != archiveManifestName {
+				continue
+			}
+			gz, err := gzip.NewReader(io.NewSectionReader(file, chunk.Offset, chunk.Length))
+			if err != nil {
+				return nil, err
+			}
+			tr := tar.NewReader(gz)
+			for {
+				header, err := tr.Next()
+				if err != nil {
+					return nil, err
+				}
+				if header.Name == archiveManifestName {
+					return readManifestEntry(tr)
+				}
+			}
+		}
+	}
+	return &manifest{}, nil
+}
+
+// manifestEntries returns the previous archive's manifest entries of the regular files of chunk,
+// false when one is missing and the chunk cannot be copied
+func (s *reuseSource) manifestEntries(chunk []archiveSource) ([]manifestEntry, bool) {
+	var entries []manifestEntry
+	for _, file := range chunk {
+		if file.Symlink != "" {
+			continue
+		}
+		entry, ok := s.manifest[file.Name]
+		if !ok {
+			return nil, false
+		}
+		entries = append(entries, entry)
+	}
+	return entries, true
+}
+
+// trustedChunk returns the chunk of the previous archive holding exactly the files of chunk when
+// git reports all of them unchanged, which is then copied without looking at the files
+func (s *reuseSource) trustedChunk(chunk []archiveSource) (indexChunk, bool) {
+	names := make([]string, len(chunk))
+	for i, file := range chunk {
+		if !s.trusted[file.Name] {
+			return indexChunk{}, false
+		}
+		names[i] = file.Name
+	}
+	prev, ok := s.byFiles[strings.Join(names, "\x00")]
+	return prev, ok
+}
+
+// fileSize returns the size of the file from the previous archive's manifest when git reports
+// it unchanged, and from the file otherwise
+func (s *reuseSource) fileSize(file archiveSource) int64 {
+	if entry, ok := s.manifest[file.Name]; ok && s.trusted[file.Name] {
+		return entry.Size
+	}
+	if info, err := os.Lstat(file.Path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (s *reuseSource) Close() error {
+	return s.file.Close()
+}
+
+// readArchiveIndex finds the footer member at the end of file and reads the index it points to,
+// nil when the archive has none
+func readArchiveIndex(file *os.File) (*archiveIndex, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tailSize := min(info.Size(), footerSearchSize)
+	tail := make([]byte, tailSize)
+	if _, err := file.ReadAt(tail, info.Size()-tailSize); err != nil {
+		return nil, err
+	}
+
+	// gzip header: magic, deflate, FEXTRA flag, mtime, xfl, os, XLEN, then our subfield of 16 bytes
+	for i := len(tail) - 32; i >= 0; i-- {
+		if tail[i] != 0x1f || tail[i+1] != 0x8b || tail[i+2] != 8 || tail[i+3]&4 == 0 ||
+			tail[i+12] != footerSubfield[0] || tail[i+13] != footerSubfield[1] || binary.LittleEndian.Uint16(tail[i+14:]) != 16 {
+			continue
+		}
+		offset := int64(binary.LittleEndian.Uint64(tail[i+16:]))
+		length := int64(binary.LittleEndian.Uint64(tail[i+24:]))
+		if offset < 0 || length <= 0 || offset+length > info.Size() {
+			continue
+		}
+		gz, err := gzip.NewReader(io.NewSectionReader(file, offset, length))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		header, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if header.Name != archiveIndexName {
+			return nil, fmt.Errorf("unexpected index entry %s", header.Name)
+		}
+		var index archiveIndex
+		if err := json.NewDecoder(tr).Decode(&index); err != nil {
+			return nil, err
+		}
+		return &index, nil
+	}
+	return nil, nil
+}
+
+// chunkedArchive is a tar.gz writer that compresses groups of files into separate gzip members,
+// which a later run can copy when the files have not changed. Concatenated gzip members are a
+// regular gzip stream, so the archive stays readable by every tool.
+type chunkedArchive struct {
+	out          *countingWriter // compressed archive
+	uncompressed *countingWriter // tar stream, for progress
+	comp         compressor
+	opts         ArchiveOptions
+	prev         *reuseSource
+
+	member  io.WriteCloser
+	tar     *tarEntryWriter
+	start   int64 // uncompressed position where the current member started
+	current indexChunk
+	index   archiveIndex
+	reused  int
+	closed  bool
+}
+
+func newChunkedArchive(w io.Writer, comp compressor, prev *reuseSource, opts ArchiveOptions) *chunkedArchive {
+	return &chunkedArchive{
+		out:          &countingWriter{w: w},
+		uncompressed: &countingWriter{},
+		comp:         comp,
+		opts:         opts,
+		prev:         prev,
+	}
+}
+
+// startChunk begins a gzip member for files with the given key and total size
+func (c *chunkedArchive) startChunk(key string, size int64) error {
+	if err := c.finishChunk(); err != nil {
+		return err
+	}
+	var member io.WriteCloser
+	var err error
+	if c.opts.Jobs > 1 && size > pgzipBlockSize && c.comp.NewParallelWriter != nil {
+		member, err = c.comp.NewParallelWriter(c.out, c.opts.Level, c.opts.Jobs)
+	} else {
+		member, err = c.comp.NewWriter(c.out, c.opts.Level)
+	}
+	if err != nil {
+		return err
+	}
+	c.member = member
+	c.uncompressed.w = member
+	c.tar = newTarEntryWriter(c.uncompressed)
+	c.start = c.uncompressed.Count()
+	c.current = indexChunk{Key: key, Offset: c.out.Count()}
+	return nil
+}
+
+// finishChunk completes the current gzip member and adds it to the index
+func (c *chunkedArchive) finishChunk() error {
+	if c.member == nil {
+		return nil
+	}
+	if err := c.tar.tw.Flush(); err != nil {
+		return err
+	}
+	if err := c.member.Close(); err != nil {
+		return err
+	}
+	c.current.Length = c.out.Count() - c.current.Offset
+	c.current.Size = c.uncompressed.Count() - c.start
+	c.index.Chunks = append(c.index.Chunks, c.current)
+	c.member = nil
+	return nil
+}
+
+// copyChunk copies an unchanged member of the previous archive
+func (c *chunkedArchive) copyChunk(chunk indexChunk) error {
+	if err := c.finishChunk(); err != nil {
+		return err
+	}
+	offset := c.out.Count()
+	if _, err := io.Copy(c.out, io.NewSectionReader(c.prev.file, chunk.Offset, chunk.Length)); err != nil {
+		return fmt.Errorf("error copying from previous archive: %v", err)
+	}
+	atomic.AddInt64(&c.uncompressed.n, chunk.Size)
+	chunk.Offset = offset
+	c.index.Chunks = append(c.index.Chunks, chunk)
+	c.reused++
+	return nil
+}
+
+func (c *chunkedArchive) WriteEntry(header *tar.Header, r io.Reader) error {
+	// Entries outside of a planned chunk, such as the manifest, are never reused
+	if c.member == nil {
+		if err := c.startChunk("", 0); err != nil {
+			return err
+		}
+	}
+	c.current.Entries = append(c.current.Entries, filepath.ToSlash(header.Name))
+	return c.tar.WriteEntry(header, r)
+}
+
+// Close writes the index member and the footer member pointing to it, which also ends the tar stream
+func (c *chunkedArchive) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if err := c.finishChunk(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	modTime := time.Now()
+	if c.opts.Reproducible {
+		modTime = time.Unix(0, 0)
+		if !c.opts.SourceDateEpoch.IsZero() {
+			modTime = c.opts.SourceDateEpoch
+		}
+	}
+	indexOffset := c.out.Count()
+	gz := gzip.NewWriter(c.out)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: archiveIndexName, Size: int64(len(data)), Mode: 0644, ModTime: modTime, Format: tar.FormatPAX}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	var extra bytes.Buffer
+	extra.Write(footerSubfield[:])
+	binary.Write(&extra, binary.LittleEndian, uint16(16))
+	binary.Write(&extra, binary.LittleEndian, uint64(indexOffset))
+	binary.Write(&extra, binary.LittleEndian, uint64(c.out.Count()-indexOffset))
+	gz = gzip.NewWriter(c.out)
+	gz.Extra = extra.Bytes()
+	if err := tar.NewWriter(gz).Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addChunkedEntries adds files in chunks, copying the chunks whose files have not changed since
+// the previous archive
+func addChunkedEntries(c *chunkedArchive, files []archiveSource, opts ArchiveOptions) ([]skippedFile, error) {
+	var skipped []skippedFile
+	chunks := planChunks(files, c.prev)
+	for _, chunk := range chunks {
+		if prev, ok := c.prev.trustedChunk(chunk); ok {
+			if prevEntries, inManifest := c.prev.manifestEntries(chunk); inManifest {
+				if err := c.copyChunk(prev); err != nil {
+					return nil, err
+				}
+				if opts.Manifest != nil {
+					opts.Manifest.Entries = append(opts.Manifest.Entries, prevEntries...)
+				}
+				for _, file := range chunk {
+					opts.Progress.Entry("reuse", file.Name, c.prev.fileSize(file))
+				}
+				continue
+			}
+		}
+		key, sizes := chunkKey(chunk, opts)
+		prev, ok := c.prev.chunks[key]
+		prevEntries, inManifest := c.prev.manifestEntries(chunk)
+		if ok && key != "" && len(prev.Entries) == len(chunk) && inManifest {
+			if err := c.copyChunk(prev); err != nil {
+				return nil, err
+			}
+			if opts.Manifest != nil {
+				opts.Manifest.Entries = append(opts.Manifest.Entries, prevEntries...)
+			}
+			for i, file := range chunk {
+				opts.Progress.Entry("reuse", file.Name, sizes[i])
+			}
+			continue
+		}
+
+		var total int64
+		for _, size := range sizes {
+			total += size
+		}
+		if err := c.startChunk(key, total); err != nil {
+			return nil, err
+		}
+		for _, file := range chunk {
+			var err error
+			if file.Symlink != "" {
+				err = addSymlinkToArchive(c, file.Path, file.Name, file.Symlink, opts)
+			} else if file.Fifo {
+				err = addFifoToArchive(c, file.Path, file.Name, opts)
+			} else {
+				err = addFileToArchive(c, file.Path, file.Name, opts)
+			}
+			var unreadable *unreadableError
+			if opts.KeepGoing && errors.As(err, &unreadable) {
+				skipped = append(skipped, skippedFile{Name: file.Name, Err: unreadable.Err})
+				// The next run must not take the chunk for one that holds the file
+				c.current.Key = ""
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := c.finishChunk(); err != nil {
+		return nil, err
+	}
+	opts.Logger.Infof("Reused %d of %d chunks from the previous archive", c.reused, len(chunks))
+	return skipped, nil
+}
+
+// planChunks groups files into chunks, ending a chunk after files whose name hashes to a
+// boundary or once the chunk holds maxChunkSize bytes
+func planChunks(files []archiveSource, prev *reuseSource) [][]archiveSource {
+	var chunks [][]archiveSource
+	var chunk []archiveSource
+	var size int64
+	for _, file := range files {
+		chunk = append(chunk, file)
+		size += prev.fileSize(file)
+		h := fnv.New32a()
+		h.Write([]byte(file.Name))
+		if h.Sum32()%chunkBoundaryModulus == 0 || size >= maxChunkSize {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// chunkKey identifies the content of a chunk by the name, size, mtime, mode and annotations of its files and
+// the options that change their entries. It is empty when a file cannot be examined.
+func chunkKey(chunk []archiveSource, opts ArchiveOptions) (string, []int64) {
+	h := sha256.New()
+	fmt.Fprintln(h, chunkOptions(opts))
+	sizes := make([]int64, len(chunk))
+	for i, file := range chunk {
+		var info os.FileInfo
+		var err error
+		if file.Symlink != "" {
+			info, err = os.Lstat(file.Path)
+		} else {
+			info, err = os.Stat(file.Path)
+		}
+		if err != nil {
+			return "", sizes
+		}
+		sizes[i] = info.Size()
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%o\x00%s\x00%s\n", file.Name, info.Size(), info.ModTime().UnixNano(), info.Mode(), file.Symlink,
+			formatAnnotations(opts.Annotations.forPath(file.Name)))
+	}
+	return hex.EncodeToString(h.Sum(nil)), sizes
+}
+
+// chunkOptions describes the options that change the entries of a chunk
+func chunkOptions(opts ArchiveOptions) string {
+	return fmt.Sprintf("%t %d %t %s", opts.Reproducible, opts.SourceDateEpoch.Unix(), opts.BirthTime, opts.Config)
+}
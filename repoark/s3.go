@@ -0,0 +1,567 @@
+package repoark
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload is used instead of a payload hash for streamed uploads
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Objects up to s3PartSize are uploaded in a single PUT, which S3 limits to 5 GiB, larger ones
+// in parts of at least that size, of which S3 allows s3MaxParts
+const (
+	s3PartSize = 64 << 20
+	s3MaxParts = 10000
+)
+
+// s3MaxCopySize is the largest object a single CopyObject copies, larger ones are copied in parts
+const s3MaxCopySize = 5 << 30
+
+// s3Credentials are the AWS keys used to sign requests
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero for long-term keys
+	Source          string    // the credential provider they came from
+}
+
+// s3Backend talks to S3 (or a compatible service) using Signature Version 4
+type s3Backend struct {
+	bucket    string
+	region    string
+	endpoint  string // custom endpoint, addressed path-style
+	creds     s3Credentials
+	chain     []credentialProvider // where creds came from, consulted again when they expire
+	client    *http.Client
+	pathStyle bool
+	partSize  int64 // bodies larger than this are uploaded in parts of at least this size
+	copySize  int64 // objects larger than this are copied in parts
+}
+
+func newS3Backend(bucket string) (*s3Backend, error) {
+	profile, err := loadAWSProfile()
+	if err != nil {
+		return nil, err
+	}
+	chain := defaultCredentialChain(profile)
+	creds, err := retrieveCredentials(chain)
+	if err != nil {
+		return nil, fmt.Errorf("%v (s3://%s)", err, bucket)
+	}
+
+	region := awsRegion(profile.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/")
+	return &s3Backend{
+		bucket:    bucket,
+		region:    region,
+		endpoint:  endpoint,
+		creds:     creds,
+		chain:     chain,
+		client:    &http.Client{},
+		pathStyle: endpoint != "",
+		partSize:  s3PartSize,
+		copySize:  s3MaxCopySize,
+	}, nil
+}
+
+// objectURL returns the URL of key (or of the bucket when key is empty)
+func (b *s3Backend) objectURL(key string, query url.Values) string {
+	var base, path string
+	if b.pathStyle {
+		base = b.endpoint
+		path = "/" + b.bucket + "/" + key
+	} else {
+		base = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", b.bucket, b.region)
+		path = "/" + key
+	}
+	u := base + s3URIEncode(path, false)
+	if len(query) > 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	return u
+}
+
+// do signs and sends a request, returning the response if its status is 2xx
+func (b *s3Backend) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	now := time.Now().UTC()
+	if b.creds.expiresSoon(now) {
+		creds, err := retrieveCredentials(b.chain)
+		if err != nil {
+			return nil, err
+		}
+		b.creds = creds
+	}
+	b.sign(req, payloadHash, now)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusPreconditionFailed && req.Header.Get("If-None-Match") == "*" {
+			return nil, fmt.Errorf("s3 %s %s: %w", req.Method, req.URL.Path, errRemoteExists)
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("s3 %s %s: %s (credentials from %s): %s", req.Method, req.URL.Path, resp.Status, b.creds.Source, strings.TrimSpace(string(body)))
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// sign adds SigV4 authentication headers to req
+func (b *s3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if b.creds.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "if-none-match" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.creds.SecretAccessKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response we use
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (b *s3Backend) List(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, b.objectURL("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding bucket listing: %v", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, remoteObject{
+				Key:     content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+			})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (b *s3Backend) ReadRange(key string, offset, length int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, b.objectURL(key, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, length))
+}
+
+// Move copies src to dst server side and deletes src. Objects beyond what CopyObject
+// accepts are copied part by part.
+func (b *s3Backend) Move(src, dst string) error {
+	size, err := b.objectSize(src)
+	if err != nil {
+		return err
+	}
+	if size > b.copySize {
+		if err := b.copyMultipart(src, dst, size); err != nil {
+			return err
+		}
+		return b.Delete(src)
+	}
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(dst, nil), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-copy-source", b.copySource(src))
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Like completions, copies can fail in the body of a 200 response
+	if err := s3ErrorBody(resp.Body); err != nil {
+		return fmt.Errorf("s3 copying %s: %v", src, err)
+	}
+	return b.Delete(src)
+}
+
+// objectSize returns the size of the object at key
+func (b *s3Backend) objectSize(key string) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, b.objectURL(key, nil), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// copySource returns the x-amz-copy-source header naming key
+func (b *s3Backend) copySource(key string) string {
+	return s3URIEncode("/"+b.bucket+"/"+key, false)
+}
+
+func (b *s3Backend) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, b.objectURL(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Put uploads an object, in a single request up to the part size and in parts beyond it
+func (b *s3Backend) Put(key string, r io.Reader, size int64, replace bool) error {
+	if size > b.partSize {
+		return b.putMultipart(key, r, size, replace)
+	}
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(key, nil), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if !replace {
+		// A conditional write, S3 refuses it with 412 when another upload created key meanwhile
+		req.Header.Set("If-None-Match", "*")
+	}
+	// Hashing the body up front would mean reading it twice, TLS protects it in transit
+	resp, err := b.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// initiateMultipartUploadResult is the response to CreateMultipartUpload
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// completedPart is a part listed in CompleteMultipartUpload
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// completeMultipartUpload is the body of CompleteMultipartUpload
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// multipartPartSize returns the part size for an object of size, at least b.partSize and
+// large enough to stay within s3MaxParts
+func (b *s3Backend) multipartPartSize(size int64) int64 {
+	partSize := b.partSize
+	if minSize := (size + s3MaxParts - 1) / s3MaxParts; partSize < minSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+// putMultipart uploads r in parts. The upload is aborted when a part fails, so S3 does not keep
+// (and bill) the parts already uploaded. Without replace the completion is a conditional write,
+// refused when another upload created key meanwhile.
+func (b *s3Backend) putMultipart(key string, r io.Reader, size int64, replace bool) error {
+	partSize := b.multipartPartSize(size)
+	uploadID, err := b.createMultipart(key)
+	if err != nil {
+		return err
+	}
+	complete := completeMultipartUpload{}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		length := min(partSize, size-offset)
+		etag, err := b.uploadPart(key, uploadID, number, io.LimitReader(r, length), length)
+		if err != nil {
+			b.abortMultipart(key, uploadID)
+			return fmt.Errorf("error uploading part %d of %d: %v", number, (size+partSize-1)/partSize, err)
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	return b.completeMultipart(key, uploadID, complete, replace)
+}
+
+// copyMultipart copies the object src of size to dst in parts (UploadPartCopy), aborting the
+// upload when a part fails, like putMultipart
+func (b *s3Backend) copyMultipart(src, dst string, size int64) error {
+	partSize := b.multipartPartSize(size)
+	uploadID, err := b.createMultipart(dst)
+	if err != nil {
+		return err
+	}
+	complete := completeMultipartUpload{}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		length := min(partSize, size-offset)
+		etag, err := b.copyPart(src, dst, uploadID, number, offset, length)
+		if err != nil {
+			b.abortMultipart(dst, uploadID)
+			return fmt.Errorf("error copying part %d of %d: %v", number, (size+partSize-1)/partSize, err)
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	return b.completeMultipart(dst, uploadID, complete, true)
+}
+
+// createMultipart starts a multipart upload to key and returns its id
+func (b *s3Backend) createMultipart(key string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, b.objectURL(key, url.Values{"uploads": {""}}), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("error decoding multipart upload: %v", err)
+	}
+	return initiated.UploadID, nil
+}
+
+// completeMultipart assembles the uploaded parts into key, aborting the upload when that fails.
+// Without replace the completion is refused when key exists.
+func (b *s3Backend) completeMultipart(key, uploadID string, complete completeMultipartUpload, replace bool) error {
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		b.abortMultipart(key, uploadID)
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.objectURL(key, url.Values{"uploadId": {uploadID}}), bytes.NewReader(body))
+	if err != nil {
+		b.abortMultipart(key, uploadID)
+		return err
+	}
+	if !replace {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := b.do(req, sha256Hex(body))
+	if err != nil {
+		b.abortMultipart(key, uploadID)
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 reports some failures of the completion in the body of a 200 response
+	if err := s3ErrorBody(resp.Body); err != nil {
+		b.abortMultipart(key, uploadID)
+		return fmt.Errorf("s3 completing upload of %s: %v", key, err)
+	}
+	return nil
+}
+
+// s3ErrorBody returns the error S3 reported in the body of a successful response, nil when there is none
+func s3ErrorBody(body io.Reader) error {
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("%s: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// uploadPart uploads one part of a multipart upload and returns its ETag
+func (b *s3Backend) uploadPart(key, uploadID string, number int, r io.Reader, size int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(key, query), r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	resp, err := b.do(req, unsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("s3 returned no ETag for part %d", number)
+	}
+	return etag, nil
+}
+
+// copyPartResult is the response to UploadPartCopy
+type copyPartResult struct {
+	ETag string
+}
+
+// copyPart copies length bytes of src from offset as one part of a multipart upload to dst and returns its ETag
+func (b *s3Backend) copyPart(src, dst, uploadID string, number int, offset, length int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(dst, query), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("x-amz-copy-source", b.copySource(src))
+	req.Header.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.do(req, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// A failed copy can be a 200 response with an Error body, which has no ETag
+	var result copyPartResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.ETag == "" {
+		return "", fmt.Errorf("s3 returned no ETag for part %d", number)
+	}
+	return result.ETag, nil
+}
+
+// abortMultipart discards the parts of an unfinished upload
+func (b *s3Backend) abortMultipart(key, uploadID string) {
+	req, err := http.NewRequest(http.MethodDelete, b.objectURL(key, url.Values{"uploadId": {uploadID}}), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := b.do(req, emptyPayloadHash); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// s3URIEncode escapes s as required by SigV4, optionally keeping slashes
+func s3URIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3CanonicalQuery renders query parameters sorted and escaped for signing
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,495 @@
+package repoark
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// credentialRefreshMargin is how long before they expire temporary credentials are replaced
+const credentialRefreshMargin = 5 * time.Minute
+
+// metadataTimeout bounds requests to the instance and container metadata services, which
+// do not answer at all outside of EC2, ECS and EKS
+const metadataTimeout = 2 * time.Second
+
+// stsTimeout bounds the exchange of a web identity token with STS, a regular internet service
+const stsTimeout = 30 * time.Second
+
+// credentialProvider is one source of AWS credentials
+type credentialProvider interface {
+	// Name identifies the source in error messages
+	Name() string
+	// Retrieve returns the credentials, ok is false when the source is not configured
+	Retrieve() (creds s3Credentials, ok bool, err error)
+}
+
+// defaultCredentialChain lists the credential sources in the order the AWS SDKs consult them
+func defaultCredentialChain(profile awsProfile) []credentialProvider {
+	return []credentialProvider{
+		envCredentials{},
+		webIdentityCredentials{region: profile.Region},
+		profileCredentials{profile: profile},
+		processCredentials{command: profile.CredentialProcess},
+		containerCredentials{},
+		instanceCredentials{},
+	}
+}
+
+// retrieveCredentials returns the credentials of the first configured source of chain
+func retrieveCredentials(chain []credentialProvider) (s3Credentials, error) {
+	for _, provider := range chain {
+		creds, ok, err := provider.Retrieve()
+		if err != nil {
+			return s3Credentials{}, fmt.Errorf("error getting AWS credentials from %s: %v", provider.Name(), err)
+		}
+		if ok {
+			// Keys may be kept in the secret store, e.g. aws_secret_access_key = secret:aws-backup
+			for _, field := range []*string{&creds.AccessKeyID, &creds.SecretAccessKey, &creds.SessionToken} {
+				if *field, err = resolveSecret(*field); err != nil {
+					return s3Credentials{}, fmt.Errorf("error getting AWS credentials from %s: %v", provider.Name(), err)
+				}
+			}
+			creds.Source = provider.Name()
+			return creds, nil
+		}
+	}
+	return s3Credentials{}, fmt.Errorf("no AWS credentials found: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, " +
+		"AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, or configure a profile in ~/.aws")
+}
+
+// expiresSoon reports whether temporary credentials must be refreshed before signing at now
+func (c s3Credentials) expiresSoon(now time.Time) bool {
+	return !c.Expires.IsZero() && now.Add(credentialRefreshMargin).After(c.Expires)
+}
+
+// envCredentials reads the standard AWS environment variables
+type envCredentials struct{}
+
+func (envCredentials) Name() string { return "environment" }
+
+func (envCredentials) Retrieve() (s3Credentials, bool, error) {
+	creds := s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" && creds.SecretAccessKey == "" {
+		return s3Credentials{}, false, nil
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return s3Credentials{}, false, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+	}
+	return creds, true, nil
+}
+
+// webIdentityCredentials exchanges an OIDC token, as provided to GitHub Actions, GitLab CI and
+// EKS service accounts, for temporary credentials of AWS_ROLE_ARN
+type webIdentityCredentials struct {
+	region string
+}
+
+func (webIdentityCredentials) Name() string { return "web identity token" }
+
+// assumeRoleWithWebIdentityResponse is the subset of the STS response we use
+type assumeRoleWithWebIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+func (p webIdentityCredentials) Retrieve() (s3Credentials, bool, error) {
+	tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return s3Credentials{}, false, nil
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("repoark-%d", time.Now().Unix())
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if region := awsRegion(p.region); region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	client := &http.Client{Timeout: stsTimeout}
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return s3Credentials{}, false, fmt.Errorf("sts AssumeRoleWithWebIdentity: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result assumeRoleWithWebIdentityResponse
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return s3Credentials{}, false, fmt.Errorf("error parsing sts response: %v", err)
+	}
+	c := result.Credentials
+	return s3Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken,
+		Expires: c.Expiration}, true, nil
+}
+
+// profileCredentials uses the static keys of the selected profile in the shared files
+type profileCredentials struct {
+	profile awsProfile
+}
+
+func (p profileCredentials) Name() string { return "profile " + p.profile.Name }
+
+func (p profileCredentials) Retrieve() (s3Credentials, bool, error) {
+	if p.profile.AccessKeyID == "" {
+		return s3Credentials{}, false, nil
+	}
+	return s3Credentials{
+		AccessKeyID:     p.profile.AccessKeyID,
+		SecretAccessKey: p.profile.SecretAccessKey,
+		SessionToken:    p.profile.SessionToken,
+	}, true, nil
+}
+
+// processCredentials runs the credential_process of the selected profile, e.g. a vault or SSO helper
+type processCredentials struct {
+	command string
+}
+
+func (processCredentials) Name() string { return "credential_process" }
+
+// credentialProcessOutput is the JSON document a credential process prints
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+func (p processCredentials) Retrieve() (s3Credentials, bool, error) {
+	if p.command == "" {
+		return s3Credentials{}, false, nil
+	}
+	args, err := splitCommandLine(p.command)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	if len(args) == 0 {
+		return s3Credentials{}, false, fmt.Errorf("empty credential_process")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return s3Credentials{}, false, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var result credentialProcessOutput
+	if err := json.Unmarshal(output, &result); err != nil {
+		return s3Credentials{}, false, fmt.Errorf("error parsing output: %v", err)
+	}
+	if result.Version != 1 || result.AccessKeyID == "" || result.SecretAccessKey == "" {
+		return s3Credentials{}, false, fmt.Errorf("output is not a version 1 credential document")
+	}
+	return s3Credentials{AccessKeyID: result.AccessKeyID, SecretAccessKey: result.SecretAccessKey,
+		SessionToken: result.SessionToken, Expires: result.Expiration}, true, nil
+}
+
+// metadataCredentials is the JSON document of the container and instance metadata services
+type metadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (m metadataCredentials) credentials() s3Credentials {
+	return s3Credentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey, SessionToken: m.Token,
+		Expires: m.Expiration}
+}
+
+// containerCredentials asks the ECS (or EKS Pod Identity) agent for the task's role credentials
+type containerCredentials struct{}
+
+func (containerCredentials) Name() string { return "container credentials endpoint" }
+
+func (containerCredentials) Retrieve() (s3Credentials, bool, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	} else if endpoint == "" {
+		return s3Credentials{}, false, nil
+	} else if err := checkContainerEndpoint(endpoint); err != nil {
+		return s3Credentials{}, false, err
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return s3Credentials{}, false, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var result metadataCredentials
+	if err := fetchMetadataJSON(req, &result); err != nil {
+		return s3Credentials{}, false, err
+	}
+	return result.credentials(), true, nil
+}
+
+// checkContainerEndpoint refuses to send the authorization token over plain http to anything but
+// an agent on this machine or the link-local address of the ECS or EKS agent, as the AWS SDKs do
+func checkContainerEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	if u.Scheme != "http" {
+		return fmt.Errorf("AWS_CONTAINER_CREDENTIALS_FULL_URI %s must be an http or https URL", endpoint)
+	}
+	ips := []net.IP{net.ParseIP(u.Hostname())}
+	if ips[0] == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			return err
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		// fd00:ec2::23 is the EKS Pod Identity agent over IPv6
+		if !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.Equal(net.ParseIP("fd00:ec2::23")) {
+			return fmt.Errorf("AWS_CONTAINER_CREDENTIALS_FULL_URI %s must use https or a loopback or link-local host", endpoint)
+		}
+	}
+	return nil
+}
+
+// instanceCredentials asks the EC2 instance metadata service (IMDSv2) for the instance role's credentials
+type instanceCredentials struct{}
+
+func (instanceCredentials) Name() string { return "EC2 instance metadata" }
+
+func (instanceCredentials) Retrieve() (s3Credentials, bool, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return s3Credentials{}, false, nil
+	}
+	base := strings.TrimSuffix(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base+"/latest/api/token", nil)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Not running on EC2
+		return s3Credentials{}, false, nil
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return s3Credentials{}, false, nil
+	}
+
+	rolesURL := base + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequest(http.MethodGet, rolesURL, nil)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	var role string
+	if err := fetchMetadata(req, func(body []byte) error {
+		role, _, _ = strings.Cut(strings.TrimSpace(string(body)), "\n")
+		return nil
+	}); err != nil {
+		return s3Credentials{}, false, err
+	}
+	if role == "" {
+		// The instance has no role attached
+		return s3Credentials{}, false, nil
+	}
+
+	req, err = http.NewRequest(http.MethodGet, rolesURL+role, nil)
+	if err != nil {
+		return s3Credentials{}, false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	var result metadataCredentials
+	if err := fetchMetadataJSON(req, &result); err != nil {
+		return s3Credentials{}, false, err
+	}
+	return result.credentials(), true, nil
+}
+
+// fetchMetadata sends a request to a metadata service and passes the body of the response to parse
+func fetchMetadata(req *http.Request, parse func([]byte) error) error {
+	ctx, cancel := context.WithTimeout(req.Context(), metadataTimeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return parse(body)
+}
+
+// fetchMetadataJSON is fetchMetadata for JSON responses
+func fetchMetadataJSON(req *http.Request, v interface{}) error {
+	return fetchMetadata(req, func(body []byte) error {
+		return json.Unmarshal(body, v)
+	})
+}
+
+// awsProfile is the selected profile of the shared ~/.aws/credentials and ~/.aws/config files
+type awsProfile struct {
+	Name              string
+	AccessKeyID       string
+	SecretAccessKey   string
+	SessionToken      string
+	CredentialProcess string
+	Region            string
+}
+
+// loadAWSProfile reads the profile named by AWS_PROFILE (default "default") from the shared files.
+// Missing files are not an error, the profile is then empty.
+func loadAWSProfile() (awsProfile, error) {
+	profile := awsProfile{Name: os.Getenv("AWS_PROFILE")}
+	if profile.Name == "" {
+		profile.Name = "default"
+	}
+	home, _ := os.UserHomeDir()
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+
+	// The config file names sections "profile <name>", except for the default profile
+	configSection := "profile " + profile.Name
+	if profile.Name == "default" {
+		configSection = "default"
+	}
+	for _, file := range []struct{ path, section string }{{configFile, configSection}, {credentialsFile, profile.Name}} {
+		values, err := readINISection(file.path, file.section)
+		if err != nil {
+			return profile, err
+		}
+		for key, value := range values {
+			switch key {
+			case "aws_access_key_id":
+				profile.AccessKeyID = value
+			case "aws_secret_access_key":
+				profile.SecretAccessKey = value
+			case "aws_session_token":
+				profile.SessionToken = value
+			case "credential_process":
+				profile.CredentialProcess = value
+			case "region":
+				profile.Region = value
+			}
+		}
+	}
+	return profile, nil
+}
+
+// readINISection returns the key = value pairs of section in the INI file at path
+func readINISection(path, section string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	inSection := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.Join(strings.Fields(line[1:len(line)-1]), " ") == section
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inSection {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// awsRegion returns the region from the environment, falling back to the profile's region
+func awsRegion(profileRegion string) string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return profileRegion
+}
@@ -0,0 +1,295 @@
+package repoark
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 stores the objects of one bucket, with single and multipart uploads
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte // upload id to parts
+	aborted   int
+	singlePut int
+	failPart  int // part number answered with an error, 0 for none
+	maxPart   int // highest part number uploaded
+	copies    int // single CopyObject requests
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("x-amz-copy-source"), "/bucket/"))
+	switch {
+	case r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+	case r.Method == http.MethodPut && query.Has("uploadId") && source != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == s.failPart {
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		var first, last int
+		fmt.Sscanf(r.Header.Get("x-amz-copy-source-range"), "bytes=%d-%d", &first, &last)
+		s.uploads[query.Get("uploadId")][number] = s.objects[source][first : last+1]
+		s.maxPart = max(s.maxPart, number)
+		fmt.Fprintf(w, `<CopyPartResult><ETag>"etag-%d"</ETag></CopyPartResult>`, number)
+	case r.Method == http.MethodPut && source != "":
+		s.copies++
+		s.objects[key] = s.objects[source]
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodDelete && !query.Has("uploadId"):
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+		s.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == s.failPart {
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		s.uploads[query.Get("uploadId")][number] = body
+		s.maxPart = max(s.maxPart, number)
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		if r.Header.Get("If-None-Match") == "*" && s.objects[key] != nil {
+			http.Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		var complete completeMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := s.uploads[query.Get("uploadId")]
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, part.PartNumber) {
+				http.Error(w, "InvalidPart", http.StatusBadRequest)
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		s.objects[key] = object
+		delete(s.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && s.objects[key] != nil {
+			http.Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		s.singlePut++
+		s.objects[key] = body
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// newFakeS3Backend returns a backend for bucket on a fake S3 uploading in parts of partSize
+func newFakeS3Backend(t *testing.T, s3 *fakeS3, partSize int64) *s3Backend {
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+	return &s3Backend{
+		bucket:    "bucket",
+		region:    "us-east-1",
+		endpoint:  server.URL,
+		creds:     s3Credentials{AccessKeyID: "key", SecretAccessKey: "secret"},
+		client:    server.Client(),
+		pathStyle: true,
+		partSize:  partSize,
+		copySize:  s3MaxCopySize,
+	}
+}
+
+func TestS3Put(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		partSize   int64
+		wantSingle bool
+	}{
+		{"smaller than a part", 100, 1024, true},
+		{"exactly one part", 1024, 1024, true},
+		{"several parts", 3*1024 + 17, 1024, false},
+		{"more parts than S3 allows", s3MaxParts*2 + 5, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+			backend := newFakeS3Backend(t, s3, tt.partSize)
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			if err := backend.Put("repo.tar.zst", bytes.NewReader(data), int64(len(data)), false); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if !bytes.Equal(s3.objects["repo.tar.zst"], data) {
+				t.Fatalf("stored %d bytes, want the %d uploaded", len(s3.objects["repo.tar.zst"]), len(data))
+			}
+			if single := s3.singlePut == 1; single != tt.wantSingle {
+				t.Errorf("single PUT = %v, want %v", single, tt.wantSingle)
+			}
+			if s3.maxPart > s3MaxParts {
+				t.Errorf("uploaded %d parts, S3 allows %d", s3.maxPart, s3MaxParts)
+			}
+		})
+	}
+}
+
+func TestS3PutMultipartFailures(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	t.Run("failed part aborts the upload", func(t *testing.T) {
+		s3 := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte), failPart: 2}
+		backend := newFakeS3Backend(t, s3, 1024)
+		if err := backend.Put("repo.tar.zst", bytes.NewReader(data), int64(len(data)), false); err == nil {
+			t.Fatal("Put succeeded with a failing part")
+		}
+		if s3.aborted != 1 || len(s3.uploads) != 0 {
+			t.Errorf("aborted %d uploads, %d left open; want the upload aborted", s3.aborted, len(s3.uploads))
+		}
+		if s3.objects["repo.tar.zst"] != nil {
+			t.Error("object was created from a failed upload")
+		}
+	})
+
+	t.Run("existing object is not replaced", func(t *testing.T) {
+		s3 := &fakeS3{objects: map[string][]byte{"repo.tar.zst": []byte("old")}, uploads: make(map[string]map[int][]byte)}
+		backend := newFakeS3Backend(t, s3, 1024)
+		err := backend.Put("repo.tar.zst", bytes.NewReader(data), int64(len(data)), false)
+		if !errors.Is(err, errRemoteExists) {
+			t.Fatalf("Put = %v, want errRemoteExists", err)
+		}
+		if string(s3.objects["repo.tar.zst"]) != "old" {
+			t.Error("existing object was replaced")
+		}
+	})
+
+	t.Run("replace overwrites", func(t *testing.T) {
+		s3 := &fakeS3{objects: map[string][]byte{"repo.tar.zst": []byte("old")}, uploads: make(map[string]map[int][]byte)}
+		backend := newFakeS3Backend(t, s3, 1024)
+		if err := backend.Put("repo.tar.zst", bytes.NewReader(data), int64(len(data)), true); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if !bytes.Equal(s3.objects["repo.tar.zst"], data) {
+			t.Error("object was not replaced")
+		}
+	})
+}
+
+func TestS3Move(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300)
+	tests := []struct {
+		name       string
+		copySize   int64
+		failPart   int
+		wantSingle bool
+		wantErr    bool
+	}{
+		{"single copy", s3MaxCopySize, 0, true, false},
+		{"copied in parts beyond the copy limit", 1024, 0, false, false},
+		{"failed part copy aborts", 1024, 2, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := &fakeS3{objects: map[string][]byte{"repo.tar.zst": data}, uploads: make(map[string]map[int][]byte), failPart: tt.failPart}
+			backend := newFakeS3Backend(t, s3, 1024)
+			backend.copySize = tt.copySize
+			err := backend.Move("repo.tar.zst", "trash/repo.tar.zst")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Move succeeded with a failing part")
+				}
+				if s3.aborted != 1 || len(s3.uploads) != 0 {
+					t.Errorf("aborted %d uploads, %d left open; want the upload aborted", s3.aborted, len(s3.uploads))
+				}
+				if !bytes.Equal(s3.objects["repo.tar.zst"], data) || s3.objects["trash/repo.tar.zst"] != nil {
+					t.Error("a failed move changed the objects")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Move: %v", err)
+			}
+			if !bytes.Equal(s3.objects["trash/repo.tar.zst"], data) {
+				t.Errorf("moved %d bytes, want %d", len(s3.objects["trash/repo.tar.zst"]), len(data))
+			}
+			if _, ok := s3.objects["repo.tar.zst"]; ok {
+				t.Error("source was not deleted")
+			}
+			if single := s3.copies == 1; single != tt.wantSingle {
+				t.Errorf("single copy = %v, want %v", single, tt.wantSingle)
+			}
+		})
+	}
+}
+
+func TestContainerCredentials(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"AccessKeyId": "AKIAEXAMPLE", "SecretAccessKey": "wJalrXUtnFEMI", "Token": "session"}`)
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{server.URL + "/creds", false},
+		{"http://localhost" + port + "/creds", false},
+		{"http://192.0.2.1/creds", true},
+		{"ftp://127.0.0.1/creds", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", tt.endpoint)
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "token")
+		authorization = ""
+		creds, ok, err := containerCredentials{}.Retrieve()
+		if tt.wantErr {
+			if err == nil || authorization != "" {
+				t.Errorf("%s: Retrieve() = %v, token sent %v; want it refused", tt.endpoint, err, authorization != "")
+			}
+			continue
+		}
+		if err != nil || !ok {
+			t.Fatalf("%s: Retrieve() = %v, %v", tt.endpoint, ok, err)
+		}
+		if creds.AccessKeyID != "AKIAEXAMPLE" || authorization != "token" {
+			t.Errorf("%s: got key %q with authorization %q", tt.endpoint, creds.AccessKeyID, authorization)
+		}
+	}
+
+	if err := checkContainerEndpoint("https://credentials.example.com/creds"); err != nil {
+		t.Errorf("https endpoint refused: %v", err)
+	}
+	if err := checkContainerEndpoint("http://169.254.170.23/v1/credentials"); err != nil {
+		t.Errorf("EKS Pod Identity agent refused: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// restoreGuard keeps restored entries inside the target directory. A crafted archive could
+// otherwise write anywhere the user can: through absolute names, names climbing out with "..",
+// or names below a directory of the target that is a symlink leading elsewhere.
+type restoreGuard struct {
+	root     string
+	resolved string          // root with symlinks resolved, what symlinks in it are compared with
+	trust    bool            // --trust-archive: restore every name as it is
+	checked  map[string]bool // directories known to stay inside root
+}
+
+// newRestoreGuard returns the guard for restores into the canonical directory root
+func newRestoreGuard(root string, trust bool) *restoreGuard {
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		resolved = root
+	}
+	return &restoreGuard{root: root, resolved: resolved, trust: trust, checked: make(map[string]bool)}
+}
+
+// target returns where the entry name goes, or an error when that is outside the target directory
+func (g *restoreGuard) target(name string) (string, error) {
+	local := filepath.FromSlash(name)
+	targetPath := filepath.Join(g.root, local)
+	if g.trust {
+		return targetPath, nil
+	}
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("refusing entry %s: the name is absolute or leads outside the target directory, use --trust-archive to restore it anyway", name)
+	}
+
+	var visited []string
+	for dir := filepath.Dir(targetPath); dir != g.root && !g.checked[dir]; dir = filepath.Dir(dir) {
+		visited = append(visited, dir)
+		info, err := os.Lstat(dir)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// A missing directory is created by the restore
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", fmt.Errorf("refusing entry %s: cannot resolve symlink %s: %v", name, dir, err)
+		}
+		if rel, err := filepath.Rel(g.resolved, resolved); err != nil || !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("refusing entry %s: %s is a symlink leading outside the target directory, use --trust-archive to restore it anyway", name, dir)
+		}
+	}
+	for _, dir := range visited {
+		g.checked[dir] = true
+	}
+	return targetPath, nil
+}
+
+// leavesRoot reports whether a symlink entry called name pointing to linkname is refused
+func (g *restoreGuard) leavesRoot(name, linkname string) bool {
+	return !g.trust && symlinkLeavesRoot(name, linkname)
+}
+
+// symlinkLeavesRoot reports whether a symlink called name, relative to the repository root,
+// points out of the repository. Links are resolved lexically, like the names of entries.
+func symlinkLeavesRoot(name, linkname string) bool {
+	resolved := filepath.Join(filepath.Dir(filepath.FromSlash(name)), filepath.FromSlash(linkname))
+	return filepath.IsAbs(linkname) || !filepath.IsLocal(resolved)
+}
+
+// forget drops what the guard knows about directories, a restored symlink may have replaced one
+func (g *restoreGuard) forget() {
+	clear(g.checked)
+}
@@ -0,0 +1,189 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildTarHeaders returns an uncompressed tar stream of headers, regular files
+// holding as many 'x' as their size
+func buildTarHeaders(t *testing.T, headers ...tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		header.ModTime = time.Unix(1700000000, 0)
+		header.Format = tar.FormatPAX
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(strings.Repeat("x", int(header.Size)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRestoreGuardTarget(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(root, "in")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		trust   bool
+		wantErr string
+	}{
+		{"plain name", "a/b.txt", false, ""},
+		{"dot dot staying inside", "a/../b.txt", false, ""},
+		{"dot dot climbing out", "../b.txt", false, "leads outside the target directory"},
+		{"dot dot deep inside the name", "a/../../b.txt", false, "leads outside the target directory"},
+		{"absolute name", "/etc/passwd", false, "is absolute"},
+		{"symlink leading outside", "out/b.txt", false, "is a symlink leading outside"},
+		{"symlink staying inside", "in/b.txt", false, ""},
+		{"trusted dot dot", "../b.txt", true, ""},
+		{"trusted absolute name", "/etc/passwd", true, ""},
+		{"trusted symlink leading outside", "out/b.txt", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := newRestoreGuard(root, tt.trust).target(tt.entry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("target(%q) = %q, %v; want error containing %q", tt.entry, target, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("target(%q): %v", tt.entry, err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.entry)); target != want {
+				t.Errorf("target(%q) = %q, want %q", tt.entry, target, want)
+			}
+		})
+	}
+}
+
+func TestSymlinkLeavesRoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		linkname string
+		want     bool
+	}{
+		{"link", "target.txt", false},
+		{"dir/link", "../target.txt", false},
+		{"dir/link", "../../target.txt", true},
+		{"link", "..", true},
+		{"link", "/etc", true},
+		{"a/b/link", "../../c/../d", false},
+	}
+	for _, tt := range tests {
+		if got := symlinkLeavesRoot(tt.name, tt.linkname); got != tt.want {
+			t.Errorf("symlinkLeavesRoot(%q, %q) = %v, want %v", tt.name, tt.linkname, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreRefusesEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers func(outside string) []tar.Header
+		escaped func(outside string) string // the file written outside the target when trusted
+		wantErr string
+	}{
+		{
+			"dot dot name",
+			func(string) []tar.Header {
+				return []tar.Header{{Name: "../escaped.txt", Typeflag: tar.TypeReg, Size: 1}}
+			},
+			func(outside string) string { return filepath.Join(outside, "escaped.txt") },
+			"leads outside the target directory",
+		},
+		{
+			"symlink pointing outside",
+			func(outside string) []tar.Header {
+				return []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777}}
+			},
+			func(outside string) string { return filepath.Join(outside, "restored", "link") },
+			"pointing outside the repository",
+		},
+		{
+			"file below a symlink pointing outside",
+			func(outside string) []tar.Header {
+				return []tar.Header{
+					{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+					{Name: "link/escaped.txt", Typeflag: tar.TypeReg, Size: 1},
+				}
+			},
+			func(outside string) string { return filepath.Join(outside, "escaped.txt") },
+			"pointing outside the repository",
+		},
+	}
+	for _, tt := range tests {
+		for _, trust := range []bool{false, true} {
+			name := tt.name
+			if trust {
+				name += " with --trust-archive"
+			}
+			t.Run(name, func(t *testing.T) {
+				// The target is a subdirectory of outside, so that ../ leads there
+				outside := t.TempDir()
+				headers := append([]tar.Header{{Name: "ok.txt", Typeflag: tar.TypeReg, Size: 2}}, tt.headers(outside)...)
+				archive := filepath.Join(t.TempDir(), "escape.tar")
+				if err := os.WriteFile(archive, buildTarHeaders(t, headers...), 0644); err != nil {
+					t.Fatal(err)
+				}
+				target := filepath.Join(outside, "restored")
+				opts := quietRestoreOptions()
+				opts.TrustArchive = trust
+				err := restoreGitRepo(target, archive, opts)
+				checkRestoredFiles(t, target, map[string]string{"ok.txt": "xx"})
+
+				_, statErr := os.Lstat(tt.escaped(outside))
+				if trust {
+					if err != nil {
+						t.Fatalf("trusted restore: %v", err)
+					}
+					if statErr != nil {
+						t.Errorf("trusted entry was not restored: %v", statErr)
+					}
+					return
+				}
+				missed := missedIn(err)
+				if len(missed) == 0 {
+					t.Fatalf("restore = %v, want the escaping entry missed", err)
+				}
+				for _, entry := range missed {
+					if !strings.Contains(entry.Reason, tt.wantErr) || !strings.Contains(entry.Reason, "--trust-archive") {
+						t.Errorf("reason = %q, want it to contain %q and mention --trust-archive", entry.Reason, tt.wantErr)
+					}
+				}
+				if statErr == nil {
+					t.Errorf("%s was written outside the target directory", tt.escaped(outside))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package repoark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// secretRefPrefix marks config values and REPOARK_PASSWORD read from the secret store
+const secretRefPrefix = "secret:"
+
+// secretStoreEnv selects the secret store: auto (default), keychain, secret-service, dpapi or file
+const secretStoreEnv = "REPOARK_SECRET_STORE"
+
+// secretPassphraseEnv holds the master passphrase of the file store, which is asked for otherwise
+const secretPassphraseEnv = "REPOARK_SECRET_PASSPHRASE"
+
+// secretService is the service name secrets are stored under in the OS keychains
+const secretService = "repoark"
+
+// secretStore keeps named secrets, such as keys and credentials, out of plaintext config files
+type secretStore interface {
+	// Name describes the store in messages
+	Name() string
+	// Get returns the secret called name, ok is false when there is none
+	Get(name string) (value string, ok bool, err error)
+	// Set stores value as the secret called name, replacing it
+	Set(name, value string) error
+	// Delete removes the secret called name, ok is false when there was none
+	Delete(name string) (ok bool, err error)
+}
+
+// openSecretStore returns the store selected by REPOARK_SECRET_STORE. auto picks the keychain of
+// the OS, Keychain on macOS, Secret Service on Linux desktops and DPAPI on Windows, and the
+// passphrase encrypted file where there is none.
+func openSecretStore() (secretStore, error) {
+	switch name := os.Getenv(secretStoreEnv); name {
+	case "", "auto":
+		switch runtime.GOOS {
+		case "darwin":
+			if _, err := exec.LookPath("security"); err == nil {
+				return keychainStore{}, nil
+			}
+		case "windows":
+			return newDPAPIStore()
+		default:
+			if _, err := exec.LookPath("secret-tool"); err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+				return secretServiceStore{}, nil
+			}
+		}
+		return newFileSecretStore()
+	case "keychain":
+		return keychainStore{}, nil
+	case "secret-service":
+		return secretServiceStore{}, nil
+	case "dpapi":
+		return newDPAPIStore()
+	case "file":
+		return newFileSecretStore()
+	default:
+		return nil, fmt.Errorf("unsupported %s %s, expected auto, keychain, secret-service, dpapi or file", secretStoreEnv, name)
+	}
+}
+
+// resolveSecret returns value, or the secret it names when it is a secret:<name> reference
+func resolveSecret(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, secretRefPrefix)
+	if !ok {
+		return value, nil
+	}
+	store, err := openSecretStore()
+	if err != nil {
+		return "", err
+	}
+	secret, ok, err := store.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret %q from %s: %v", name, store.Name(), err)
+	}
+	if !ok {
+		return "", fmt.Errorf("secret %q is not set in %s, store it with 'repoark secret set %s'", name, store.Name(), name)
+	}
+	return secret, nil
+}
+
+// runSecretCommand runs `repoark secret set|get|delete <name>`. set reads the value from stdin,
+// or asks for it twice when stdin is a terminal.
+func runSecretCommand(action, name string) error {
+	if name == "" || strings.ContainsAny(name, "\x00\n") {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	store, err := openSecretStore()
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "set":
+		var value string
+		if isTerminal(os.Stdin) {
+			if value, err = askPassword("Secret "+name+": ", true, "the secret on stdin"); err != nil {
+				return err
+			}
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("error reading secret: %v", err)
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if value == "" {
+			return fmt.Errorf("empty secret")
+		}
+		if err := store.Set(name, value); err != nil {
+			return fmt.Errorf("error storing secret %q in %s: %v", name, store.Name(), err)
+		}
+		fmt.Fprintf(os.Stderr, "Stored secret %q in %s, refer to it as %s%s\n", name, store.Name(), secretRefPrefix, name)
+	case "get":
+		value, err := resolveSecret(secretRefPrefix + name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+	case "delete":
+		ok, err := store.Delete(name)
+		if err != nil {
+			return fmt.Errorf("error deleting secret %q from %s: %v", name, store.Name(), err)
+		}
+		if !ok {
+			return fmt.Errorf("secret %q is not set in %s", name, store.Name())
+		}
+		fmt.Fprintf(os.Stderr, "Deleted secret %q from %s\n", name, store.Name())
+	default:
+		return fmt.Errorf("unknown secret action %s, expected set, get or delete", action)
+	}
+	return nil
+}
+
+// keychainStore keeps secrets as generic passwords of the macOS login keychain
+type keychainStore struct{}
+
+func (keychainStore) Name() string { return "the macOS keychain" }
+
+func (keychainStore) Get(name string) (string, bool, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", secretService, "-a", name, "-w").Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 { // errSecItemNotFound
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, commandError(err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
+
+func (keychainStore) Set(name, value string) error {
+	// Given to security's interactive mode on stdin, the value never shows in the process list
+	var args []string
+	for _, arg := range []string{secretService, name, value} {
+		quoted, err := securityQuote(arg)
+		if err != nil {
+			return err
+		}
+		args = append(args, quoted)
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", args[0], args[1], args[2]))
+	out, err := cmd.CombinedOutput()
+	if err != nil || len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("security: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (keychainStore) Delete(name string) (bool, error) {
+	_, err := exec.Command("security", "delete-generic-password", "-s", secretService, "-a", name).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+		return false, nil
+	}
+	return err == nil, commandError(err)
+}
+
+// securityQuote quotes s for a command line of security -i. Commands are read one per line
+// and a quoted line break cannot be escaped, so it would end the command and run the rest
+// of s as another one: values holding line breaks are refused.
+func securityQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("the macOS keychain cannot store values with line breaks")
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`, nil
+}
+
+// secretServiceStore keeps secrets in the Secret Service of the desktop session, GNOME Keyring
+// or KWallet, through libsecret's secret-tool
+type secretServiceStore struct{}
+
+func (secretServiceStore) Name() string { return "the Secret Service" }
+
+func (secretServiceStore) Get(name string) (string, bool, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", secretService, "account", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// A missing secret fails without a message
+	if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("secret-tool: %s", strings.TrimSpace(stderr.String()))
+	}
+	return string(out), true, nil
+}
+
+func (secretServiceStore) Set(name, value string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=repoark "+name, "service", secretService, "account", name)
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s secretServiceStore) Delete(name string) (bool, error) {
+	if _, ok, err := s.Get(name); !ok || err != nil {
+		return false, err
+	}
+	if out, err := exec.Command("secret-tool", "clear", "service", secretService, "account", name).CombinedOutput(); err != nil {
+		return false, fmt.Errorf("secret-tool: %s", strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// commandError adds the output of a failed helper command to its error
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// secretsPath returns where the file and DPAPI stores keep their secrets, next to the config file
+func secretsPath(name string) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", fmt.Errorf("error locating config file: %v", err)
+	}
+	return filepath.Join(filepath.Dir(path), name), nil
+}
+
+// fileSecretStore keeps secrets in one age file encrypted with a master passphrase, for
+// machines without an OS keychain such as servers and containers
+type fileSecretStore struct {
+	path       string
+	passphrase string // asked for on first use
+}
+
+func newFileSecretStore() (*fileSecretStore, error) {
+	path, err := secretsPath("secrets.age")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSecretStore{path: path}, nil
+}
+
+func (s *fileSecretStore) Name() string { return s.path }
+
+// unlock returns the master passphrase, from REPOARK_SECRET_PASSPHRASE or the terminal. A new
+// file gets its passphrase asked for twice.
+func (s *fileSecretStore) unlock(create bool) (string, error) {
+	if s.passphrase == "" {
+		if passphrase := os.Getenv(secretPassphraseEnv); passphrase != "" {
+			s.passphrase = passphrase
+		} else {
+			passphrase, err := askPassword("Master passphrase of "+s.path+": ", create, secretPassphraseEnv)
+			if err != nil {
+				return "", err
+			}
+			s.passphrase = passphrase
+		}
+	}
+	return s.passphrase, nil
+}
+
+// load decrypts the secrets, a missing file holds none
+func (s *fileSecretStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	passphrase, err := s.unlock(false)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := age.Decrypt(file, identity)
+	if err != nil {
+		return nil, fmt.Errorf("wrong master passphrase or damaged file: %v", err)
+	}
+	if err := json.NewDecoder(reader).Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", s.path, err)
+	}
+	return secrets, nil
+}
+
+// save encrypts secrets to the file, replacing it only once written completely
+func (s *fileSecretStore) save(secrets map[string]string) error {
+	_, statErr := os.Stat(s.path)
+	passphrase, err := s.unlock(os.IsNotExist(statErr))
+	if err != nil {
+		return err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.path), ".secrets-*.age")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	writer, err := age.Encrypt(temp, recipient)
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	if err := json.NewEncoder(writer).Encode(secrets); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
+
+func (s *fileSecretStore) Get(name string) (string, bool, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := secrets[name]
+	return value, ok, nil
+}
+
+func (s *fileSecretStore) Set(name, value string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return s.save(secrets)
+}
+
+func (s *fileSecretStore) Delete(name string) (bool, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := secrets[name]; !ok {
+		return false, nil
+	}
+	delete(secrets, name)
+	return true, s.save(secrets)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package repoark
+
+import "fmt"
+
+// newDPAPIStore is only available on Windows
+func newDPAPIStore() (secretStore, error) {
+	return nil, fmt.Errorf("the dpapi secret store is only available on Windows")
+}
@@ -0,0 +1,72 @@
+package repoark
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// useFileSecretStore makes the secret store a file store in a temporary directory holding secrets
+func useFileSecretStore(t *testing.T, secrets map[string]string) {
+	t.Helper()
+	t.Setenv("REPOARK_CONFIG", filepath.Join(t.TempDir(), "config"))
+	t.Setenv(secretStoreEnv, "file")
+	t.Setenv(secretPassphraseEnv, "test passphrase")
+	store, err := openSecretStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range secrets {
+		if err := store.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSecurityQuote(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "s3cret", `"s3cret"`, false},
+		{"spaces", "two words", `"two words"`, false},
+		{"quotes and backslashes", `a"b\c`, `"a\"b\\c"`, false},
+		{"newline", "pass\ndelete-keychain login.keychain", "", true},
+		{"carriage return", "pass\rword", "", true},
+		{"trailing newline", "pass\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := securityQuote(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("securityQuote(%q) = %s, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("securityQuote(%q) = %s, %v; want %s", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetrieveCredentialsResolvesSecrets(t *testing.T) {
+	useFileSecretStore(t, map[string]string{"aws-backup": "wJalrXUtnFEMI"})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret:aws-backup")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	creds, err := retrieveCredentials([]credentialProvider{envCredentials{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SecretAccessKey != "wJalrXUtnFEMI" {
+		t.Errorf("credentials = %q, %q; want the secret key read from the store", creds.AccessKeyID, creds.SecretAccessKey)
+	}
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret:missing")
+	if _, err := retrieveCredentials([]credentialProvider{envCredentials{}}); err == nil {
+		t.Error("a missing secret was not reported")
+	}
+}
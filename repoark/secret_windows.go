@@ -0,0 +1,117 @@
+//go:build windows
+
+package repoark
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiStore keeps secrets in a file, each encrypted with DPAPI for the current Windows user,
+// so that neither other users nor a copy of the file on another machine can read them
+type dpapiStore struct {
+	path string
+}
+
+func newDPAPIStore() (secretStore, error) {
+	path, err := secretsPath("secrets.dpapi.json")
+	if err != nil {
+		return nil, err
+	}
+	return &dpapiStore{path: path}, nil
+}
+
+func (s *dpapiStore) Name() string { return s.path }
+
+// load reads the encrypted secrets, a missing file holds none
+func (s *dpapiStore) load() (map[string][]byte, error) {
+	secrets := make(map[string][]byte)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", s.path, err)
+	}
+	return secrets, nil
+}
+
+func (s *dpapiStore) save(secrets map[string][]byte) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s *dpapiStore) Get(name string) (string, bool, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", false, err
+	}
+	encrypted, ok := secrets[name]
+	if !ok {
+		return "", false, nil
+	}
+	value, err := dpapiCrypt(encrypted, false)
+	if err != nil {
+		return "", false, fmt.Errorf("error decrypting: %v", err)
+	}
+	return string(value), true, nil
+}
+
+func (s *dpapiStore) Set(name, value string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	encrypted, err := dpapiCrypt([]byte(value), true)
+	if err != nil {
+		return fmt.Errorf("error encrypting: %v", err)
+	}
+	secrets[name] = encrypted
+	return s.save(secrets)
+}
+
+func (s *dpapiStore) Delete(name string) (bool, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := secrets[name]; !ok {
+		return false, nil
+	}
+	delete(secrets, name)
+	return true, s.save(secrets)
+}
+
+// dpapiCrypt encrypts or decrypts data with the key DPAPI keeps for the current user
+func dpapiCrypt(data []byte, encrypt bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data")
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	var err error
+	if encrypt {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,98 @@
+package repoark
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sessionMaxAge is how long the answers of an interrupted interactive run are offered for reuse
+const sessionMaxAge = 7 * 24 * time.Hour
+
+// interactiveSession keeps the answers given to the --size-budget exclusion prompts on disk until the
+// command completes, so that re-running an interrupted command can resume with them. Secrets are never
+// stored in a session, passphrase prompts are always asked again.
+type interactiveSession struct {
+	path    string
+	Command string            `json:"command"`
+	Started time.Time         `json:"started"`
+	Answers map[string]string `json:"answers"`
+}
+
+// sessionDir returns where session files are kept
+func sessionDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "repoark", "sessions"), nil
+}
+
+// openSession returns the session of the command identified by key, offering to resume
+// a previous interrupted run of the same command. A nil session (no persistence) is returned
+// when the session directory is unavailable.
+func openSession(stdin *bufio.Reader, command string, key ...string) *interactiveSession {
+	dir, err := sessionDir()
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(command + "\x00" + strings.Join(key, "\x00")))
+	session := &interactiveSession{
+		path:    filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"),
+		Command: command,
+		Started: time.Now(),
+		Answers: make(map[string]string),
+	}
+
+	var previous interactiveSession
+	if data, err := os.ReadFile(session.path); err == nil && json.Unmarshal(data, &previous) == nil &&
+		len(previous.Answers) > 0 && time.Since(previous.Started) < sessionMaxAge {
+		fmt.Printf("An interrupted %s session from %s was found. Resume with its %d answer(s)? [Y/n]: ",
+			command, previous.Started.Local().Format("2006-01-02 15:04"), len(previous.Answers))
+		line, _ := stdin.ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(line)); answer == "" || answer == "y" || answer == "yes" {
+			session.Started = previous.Started
+			session.Answers = previous.Answers
+		}
+	}
+	return session
+}
+
+// recall returns the answer given to question in a resumed session
+func (s *interactiveSession) recall(question string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	answer, ok := s.Answers[question]
+	return answer, ok
+}
+
+// remember stores the answer to question, immediately so that it survives an interruption
+func (s *interactiveSession) remember(question, answer string) {
+	if s == nil {
+		return
+	}
+	s.Answers[question] = answer
+	data, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return
+	}
+	os.WriteFile(s.path, data, 0600)
+}
+
+// complete discards the session once the command has finished
+func (s *interactiveSession) complete() {
+	if s == nil {
+		return
+	}
+	os.Remove(s.path)
+}
@@ -0,0 +1,294 @@
+package repoark
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveShare serves the entries of one archive read-only over HTTP
+type archiveShare struct {
+	archivePath string
+	entries     map[string]listEntry  // by normalized name
+	dirs        map[string][]string   // directory to the names of its files and subdirectories
+	chunks      map[string]indexChunk // chunk holding each entry of an archive written with --reuse-from
+}
+
+// shareListing is the page showing one directory of the archive
+var shareListing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Archive}}: /{{.Dir}}</title>
+<style>body{font-family:sans-serif} td{padding:0 1em 0 0} .num{text-align:right}</style></head>
+<body><h1>{{.Archive}}: /{{.Dir}}</h1>
+<p><a href="/archive">Download the whole archive</a> ({{.ArchiveSize}})</p>
+<table>
+{{if .Dir}}<tr><td><a href="{{.Parent}}">../</a></td></tr>{{end}}
+{{range .Rows}}<tr><td>{{if .Link}}<a href="{{.Link}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td><td>{{.Mode}}</td><td class="num">{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table></body></html>
+`))
+
+// shareRow is one line of a directory listing
+type shareRow struct {
+	Name, Link, Mode, Size, Modified string
+}
+
+// shareArchive serves the archive at archivePath on listen until interrupted: a listing of every
+// directory, downloads of single files, and a download of the whole archive. Nothing is extracted,
+// and nothing can be changed.
+func shareArchive(archivePath, listen string) error {
+	if archivePath == "-" {
+		return fmt.Errorf("share needs an archive file, not stdin")
+	}
+	share, err := newArchiveShare(archivePath)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           share,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Sharing %s (%d files) read-only on http://%s/, press Ctrl-C to stop\n", archivePath, len(share.entries), shareDisplayAddr(listen))
+	return server.ListenAndServe()
+}
+
+// shareDisplayAddr turns a listen address like ":8000" into one to open in a browser
+func shareDisplayAddr(listen string) string {
+	if strings.HasPrefix(listen, ":") {
+		return "localhost" + listen
+	}
+	return listen
+}
+
+// newArchiveShare reads the entry index of the archive: every entry and directory, and where
+// in the file each chunk of an archive written with --reuse-from starts
+func newArchiveShare(archivePath string) (*archiveShare, error) {
+	entries, err := readArchiveEntries(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	share := &archiveShare{
+		archivePath: archivePath,
+		entries:     make(map[string]listEntry, len(entries)),
+		dirs:        map[string][]string{"": nil},
+		chunks:      make(map[string]indexChunk),
+	}
+	for _, entry := range entries {
+		name := normalizeEntryName(entry.Path)
+		if name == "" || strings.HasSuffix(entry.Path, "/") {
+			continue
+		}
+		share.entries[name] = entry
+		// Add the name to its directory, and every directory above to its parent
+		for child := name; child != ""; {
+			dir := path.Dir(child)
+			if dir == "." {
+				dir = ""
+			}
+			_, known := share.dirs[dir]
+			share.dirs[dir] = append(share.dirs[dir], child)
+			if known {
+				break
+			}
+			child = dir
+		}
+	}
+	for dir := range share.dirs {
+		sort.Strings(share.dirs[dir])
+	}
+
+	// Entries of a chunked archive are read from their chunk instead of from the start
+	if len(archiveVolumes(archivePath)) == 1 {
+		if file, err := os.Open(archivePath); err == nil {
+			index, err := readArchiveIndex(file)
+			file.Close()
+			if err == nil && index != nil {
+				for _, chunk := range index.Chunks {
+					for _, name := range chunk.Entries {
+						share.chunks[normalizeEntryName(name)] = chunk
+					}
+				}
+			}
+		}
+	}
+	return share, nil
+}
+
+func (s *archiveShare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "this archive is shared read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.URL.Path == "/archive":
+		s.serveArchive(w, r)
+	case strings.HasPrefix(r.URL.Path, "/file/"):
+		s.serveFile(w, r, normalizeEntryName(strings.TrimPrefix(r.URL.Path, "/file/")))
+	case r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/browse/"):
+		s.serveListing(w, normalizeEntryName(strings.TrimPrefix(r.URL.Path, "/browse/")))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveListing shows the files and subdirectories of dir
+func (s *archiveShare) serveListing(w http.ResponseWriter, dir string) {
+	children, ok := s.dirs[dir]
+	if !ok {
+		http.Error(w, "no such directory in the archive", http.StatusNotFound)
+		return
+	}
+	var rows []shareRow
+	for _, child := range children {
+		if _, isDir := s.dirs[child]; isDir {
+			rows = append(rows, shareRow{Name: path.Base(child) + "/", Link: shareLink("/browse/", child) + "/"})
+			continue
+		}
+		entry := s.entries[child]
+		row := shareRow{Name: path.Base(child), Mode: entry.Mode, Size: formatSize(entry.Size),
+			Modified: entry.ModTime.Local().Format("2006-01-02 15:04:05")}
+		if strings.HasPrefix(entry.Mode, "-") {
+			row.Link = shareLink("/file/", child)
+		}
+		rows = append(rows, row)
+	}
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	parentLink := "/"
+	if parent != "" {
+		parentLink = shareLink("/browse/", parent) + "/"
+	}
+
+	size := int64(0)
+	for _, volume := range archiveVolumes(s.archivePath) {
+		if info, err := os.Stat(volume); err == nil {
+			size += info.Size()
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	shareListing.Execute(w, struct {
+		Archive, Dir, Parent, ArchiveSize string
+		Rows                              []shareRow
+	}{filepath.Base(archiveBaseName(s.archivePath)), dir, parentLink, formatSize(size), rows})
+}
+
+// shareLink returns the escaped URL of name below prefix
+func shareLink(prefix, name string) string {
+	return (&url.URL{Path: prefix + name}).EscapedPath()
+}
+
+// serveFile sends the content of one regular file of the archive as a download
+func (s *archiveShare) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	entry, ok := s.entries[name]
+	if !ok || !strings.HasPrefix(entry.Mode, "-") {
+		http.Error(w, "no such file in the archive", http.StatusNotFound)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Downloaded rather than shown, content from the archive never runs in the browser
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Printf("download %s (%s) by %s\n", name, formatSize(entry.Size), r.RemoteAddr)
+	if err := s.copyEntry(w, entry.Path); err != nil {
+		fmt.Printf("warning: error sending %s: %v\n", name, err)
+	}
+}
+
+// copyEntry writes the content of the entry called name to w, from its chunk when the archive
+// has an index and otherwise by reading the archive up to it
+func (s *archiveShare) copyEntry(w io.Writer, name string) error {
+	chunk, ok := s.chunks[normalizeEntryName(name)]
+	if !ok {
+		return catArchiveFile(s.archivePath, name, w)
+	}
+	file, err := os.Open(s.archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(io.NewSectionReader(file, chunk.Offset, chunk.Length))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return err
+		}
+		if header.Name == name {
+			_, err = io.Copy(w, tr)
+			return err
+		}
+	}
+}
+
+// serveArchive sends the archive file itself, all volumes of a split archive in order
+func (s *archiveShare) serveArchive(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(archiveBaseName(s.archivePath))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	volumes := archiveVolumes(s.archivePath)
+	fmt.Printf("download %s by %s\n", name, r.RemoteAddr)
+	if len(volumes) == 1 {
+		file, err := os.Open(volumes[0])
+		if err != nil {
+			http.Error(w, "the archive cannot be read", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, "the archive cannot be read", http.StatusInternalServerError)
+			return
+		}
+		// Supports resuming interrupted downloads with range requests
+		http.ServeContent(w, r, name, info.ModTime(), file)
+		return
+	}
+
+	size := int64(0)
+	for _, volume := range volumes {
+		info, err := os.Stat(volume)
+		if err != nil {
+			http.Error(w, "the archive cannot be read", http.StatusInternalServerError)
+			return
+		}
+		size += info.Size()
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	reader, err := openVolumes(s.archivePath)
+	if err != nil {
+		http.Error(w, "the archive cannot be read", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	if _, err := io.Copy(w, reader); err != nil {
+		fmt.Printf("warning: error sending %s: %v\n", name, err)
+	}
+}
@@ -0,0 +1,73 @@
+package repoark
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// signaturePath returns where the detached signature of the archive at archivePath is stored,
+// one signature covering all volumes of a split archive in order
+func signaturePath(archivePath string) string {
+	return archiveBaseName(archivePath) + ".sig"
+}
+
+// signArchive writes a detached gpg signature of the archive made with keyID next to it
+func signArchive(archivePath, keyID string) error {
+	volumes, err := openVolumes(archivePath)
+	if err != nil {
+		return err
+	}
+	defer volumes.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--batch", "--yes", "--local-user", keyID, "--detach-sign", "--output", signaturePath(archivePath))
+	cmd.Stdin = volumes
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(signaturePath(archivePath))
+		return fmt.Errorf("error signing archive with key %s: %v: %s", keyID, err, firstLine(stderr.String()))
+	}
+	return nil
+}
+
+// verifyArchiveSignature checks the archive at archivePath against its detached signature and
+// returns the signer as gpg describes it. A missing or bad signature is an error.
+func verifyArchiveSignature(archivePath string) (string, error) {
+	if archivePath == "-" {
+		return "", fmt.Errorf("--verify-sig cannot be used when reading the archive from stdin")
+	}
+	sig := signaturePath(archivePath)
+	if _, err := os.Stat(sig); err != nil {
+		return "", fmt.Errorf("--verify-sig: no signature found for %s: %v", archivePath, err)
+	}
+	volumes, err := openVolumes(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer volumes.Close()
+
+	var status, stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--batch", "--status-fd", "1", "--verify", sig, "-")
+	cmd.Stdin = volumes
+	cmd.Stdout = &status
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", &exitError{Code: exitCorrupt, Err: fmt.Errorf("signature %s does not validate, refusing to extract: %s", sig, lastLine(stderr.String()))}
+	}
+	// [GNUPG:] GOODSIG <long key id> <user id>
+	for _, line := range bytes.Split(status.Bytes(), []byte("\n")) {
+		if signer, ok := bytes.CutPrefix(line, []byte("[GNUPG:] GOODSIG ")); ok {
+			return string(signer), nil
+		}
+	}
+	return "", &exitError{Code: exitCorrupt, Err: fmt.Errorf("gpg did not report a good signature in %s, refusing to extract", sig)}
+}
+
+// lastLine returns the last line of gpg's messages, which gives the verdict
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,43 @@
+package repoark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// formatSize renders a byte count in human readable binary units
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// parseSize parses sizes such as 512, 100K, 1.5G or 2GiB using binary units
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "IB"), "B")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if exp := strings.IndexByte("KMGTPE", value[n-1]); exp >= 0 {
+			for i := 0; i <= exp; i++ {
+				multiplier *= 1024
+			}
+			value = value[:n-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(number * float64(multiplier)), nil
+}
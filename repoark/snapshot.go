@@ -0,0 +1,197 @@
+package repoark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotTimeFormat names the dated directories of a snapshot directory, they sort by age
+const snapshotTimeFormat = "2006-01-02-150405"
+
+// snapshotRepo copies the files an archive of repoPath would contain into a new dated directory
+// of snapDir. Files unchanged since the previous snapshot are hard links to its copy, so every
+// snapshot is a complete tree to browse or copy back, and costs only the space of what changed.
+// Hard-linked copies share their content, editing a file inside a snapshot changes it in all.
+// Run as root, for repositories of several users on a shared server, copies keep their owner.
+func snapshotRepo(repoPath, snapDir string, opts ArchiveOptions) error {
+	opts = opts.withDefaults()
+	repoPath, err := canonicalRoot(repoPath, opts.NoDereferenceRoot)
+	if err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	}
+	if info, err := os.Stat(repoPath); err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", repoPath)
+	}
+	if opts.VCS, err = detectVCS(repoPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(snapDir, 0755); err != nil {
+		return fmt.Errorf("error creating snapshot directory: %v", err)
+	}
+	previous, err := latestSnapshot(snapDir)
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().Format(snapshotTimeFormat)
+	snapshotPath := filepath.Join(snapDir, name)
+	if _, err := os.Lstat(snapshotPath); err == nil {
+		return fmt.Errorf("snapshot %s already exists", snapshotPath)
+	}
+	// Written under a hidden name first, an interrupted snapshot never becomes the previous one
+	partial := filepath.Join(snapDir, "."+name+".partial")
+	if err := os.RemoveAll(partial); err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			os.RemoveAll(partial)
+		}
+	}()
+
+	preserveOwner := os.Geteuid() == 0
+	opts.FeatureLoss = &featureLoss{}
+	sources, err := collectSources([]RootDir{{Prefix: "", Dir: repoPath}}, opts)
+	if err != nil {
+		return err
+	}
+	var copied, linked int
+	var copiedBytes, linkedBytes int64
+	for _, source := range sources {
+		targetPath := filepath.Join(partial, filepath.FromSlash(source.Name))
+		if err := ensureParentDir(targetPath); err != nil {
+			return err
+		}
+		if source.Symlink != "" {
+			opts.Progress.Entry("add", source.Name, 0)
+			if err := os.Symlink(filepath.FromSlash(source.Symlink), targetPath); err != nil {
+				return fmt.Errorf("error creating symlink: %v", explainPathError(targetPath, err))
+			}
+			if info, err := os.Lstat(source.Path); err == nil && preserveOwner {
+				if err := copyOwner(targetPath, info); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		info, err := os.Stat(source.Path)
+		if err != nil {
+			return err
+		}
+		if previous != "" && linkUnchanged(filepath.Join(previous, filepath.FromSlash(source.Name)), targetPath, info, preserveOwner) {
+			opts.Progress.Entry("link", source.Name, info.Size())
+			linked++
+			linkedBytes += info.Size()
+			continue
+		}
+		opts.Progress.Entry("add", source.Name, info.Size())
+		if err := copySnapshotFile(source.Path, targetPath, info); err != nil {
+			return err
+		}
+		if preserveOwner {
+			if err := copyOwner(targetPath, info); err != nil {
+				return err
+			}
+		}
+		copied++
+		copiedBytes += info.Size()
+	}
+	for _, warning := range opts.FeatureLoss.warnings() {
+		opts.Logger.Warnf("%s", warning)
+	}
+
+	if err := os.Rename(partial, snapshotPath); err != nil {
+		return fmt.Errorf("error finishing snapshot: %v", err)
+	}
+	done = true
+	if previous == "" {
+		opts.Logger.Infof("Created snapshot %s: %d files (%s)", snapshotPath, copied, formatSize(copiedBytes))
+	} else {
+		opts.Logger.Infof("Created snapshot %s: %d files copied (%s), %d unchanged files linked to %s (%s)",
+			snapshotPath, copied, formatSize(copiedBytes), linked, filepath.Base(previous), formatSize(linkedBytes))
+	}
+	return nil
+}
+
+// latestSnapshot returns the newest dated directory of snapDir, empty when there is none
+func latestSnapshot(snapDir string) (string, error) {
+	entries, err := os.ReadDir(snapDir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, err := time.Parse(snapshotTimeFormat, entry.Name()); err == nil && entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	return filepath.Join(snapDir, names[len(names)-1]), nil
+}
+
+// linkUnchanged hard-links targetPath to previousPath when that copy has the size, modification
+// time, mode and, with sameOwner, owner of the file described by info, and reports whether it did
+func linkUnchanged(previousPath, targetPath string, info os.FileInfo, sameOwner bool) bool {
+	prev, err := os.Lstat(previousPath)
+	if err != nil || !prev.Mode().IsRegular() || prev.Size() != info.Size() ||
+		!prev.ModTime().Equal(info.ModTime()) || prev.Mode().Perm() != info.Mode().Perm() {
+		return false
+	}
+	if sameOwner {
+		prevUID, prevGID, _ := fileOwner(prev)
+		uid, gid, _ := fileOwner(info)
+		if prevUID != uid || prevGID != gid {
+			return false
+		}
+	}
+	// A filesystem without hard links, or a file at its link limit, gets a copy instead
+	return os.Link(previousPath, targetPath) == nil
+}
+
+// copySnapshotFile copies sourcePath to targetPath with the mode and modification time of info
+func copySnapshotFile(sourcePath, targetPath string, info os.FileInfo) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", explainPathError(targetPath, err))
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return fmt.Errorf("error copying %s: %v", sourcePath, err)
+	}
+	if err := target.Close(); err != nil {
+		return fmt.Errorf("error copying %s: %v", sourcePath, err)
+	}
+	// Unaffected by the umask, or the mode would differ from the next snapshot's file
+	if err := os.Chmod(targetPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	// The copy must keep the modification time, the next snapshot compares it to link unchanged files
+	return os.Chtimes(targetPath, info.ModTime(), info.ModTime())
+}
+
+// copyOwner gives targetPath the numeric owner and group of info
+func copyOwner(targetPath string, info os.FileInfo) error {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(targetPath, uid, gid); err != nil {
+		return fmt.Errorf("error setting owner: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package repoark
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+)
+
+// specialFileKind names the kind of a socket, FIFO or device node, empty for files, directories
+// and symlinks. Reading a special file blocks or returns what some other program sends, so they
+// are never archived as files.
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "FIFO"
+	case mode&os.ModeDevice != 0:
+		return "device node"
+	case mode&os.ModeIrregular != 0:
+		return "special file"
+	}
+	return ""
+}
+
+// specialSource decides what to archive for the file at path with mode. special reports whether
+// it is a special file; only a FIFO with --fifos has a source, stored as a FIFO entry, the others
+// are recorded as left out.
+func specialSource(path, archivePath string, mode os.FileMode, opts ArchiveOptions) (source *archiveSource, special bool) {
+	kind := specialFileKind(mode)
+	if kind == "" {
+		return nil, false
+	}
+	if kind == "FIFO" && opts.Fifos {
+		return &archiveSource{Path: path, Name: archivePath, Fifo: true}, true
+	}
+	opts.FeatureLoss.checkSpecial(archivePath, kind)
+	return nil, true
+}
+
+// addFifoToArchive stores sourcePath as a FIFO entry, which has a mode and owner but no content
+func addFifoToArchive(writer entryWriter, sourcePath, archivePath string, opts ArchiveOptions) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return &unreadableError{Err: err}
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeFifo,
+		Name:     archivePath,
+		Mode:     tarMode(info.Mode()),
+		ModTime:  info.ModTime(),
+	}
+	recordOwner(header, info, opts.NumericOwner)
+	opts.Annotations.annotate(header)
+	if opts.Reproducible {
+		makeReproducible(header, opts.SourceDateEpoch)
+	}
+	opts.Progress.Entry("add", archivePath, 0)
+	return writer.WriteEntry(header, nil)
+}
+
+// restoreFifo creates the FIFO entry at targetPath, replacing whatever is there unless it is a FIFO already
+func restoreFifo(targetPath string, header *tar.Header, opts RestoreOptions, stats *restoreStats) error {
+	if info, err := os.Lstat(targetPath); err == nil {
+		if info.Mode()&os.ModeNamedPipe != 0 {
+			opts.Progress.Entry("skip", targetPath, 0)
+			stats.Skipped++
+			return restoreOwner(targetPath, header, opts)
+		}
+		if err := removeExistingPath(targetPath); err != nil {
+			return err
+		}
+	}
+	if err := ensureParentDir(targetPath); err != nil {
+		return err
+	}
+	opts.Progress.Entry("restore", targetPath, 0)
+	if err := makeFifo(targetPath, header.FileInfo().Mode().Perm()); err != nil {
+		return fmt.Errorf("error creating FIFO: %v", explainPathError(targetPath, err))
+	}
+	// The mode given to mkfifo is reduced by the umask
+	if err := os.Chmod(targetPath, header.FileInfo().Mode().Perm()); err != nil {
+		return err
+	}
+	stats.Restored++
+	return restoreOwner(targetPath, header, opts)
+}
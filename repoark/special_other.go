@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package repoark
+
+import (
+	"errors"
+	"os"
+)
+
+// makeFifo fails on platforms without FIFOs
+func makeFifo(path string, perm os.FileMode) error {
+	return errors.New("FIFOs are not supported on this platform")
+}
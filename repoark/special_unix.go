@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package repoark
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeFifo creates a FIFO at path
+func makeFifo(path string, perm os.FileMode) error {
+	return unix.Mkfifo(path, uint32(perm))
+}
@@ -0,0 +1,159 @@
+package repoark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// volumeSuffix matches the numeric suffix of a split archive part
+var volumeSuffix = regexp.MustCompile(`\.\d{3}$`)
+
+// volumePath returns the name of the n-th (1 based) part of a split archive
+func volumePath(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// splitWriter writes a stream across fixed-size volume files
+type splitWriter struct {
+	base    string
+	size    int64
+	current *os.File
+	written int64
+	parts   int
+	closed  bool
+}
+
+func newSplitWriter(base string, size int64) (*splitWriter, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("split size must be positive")
+	}
+	return &splitWriter{base: base, size: size}, nil
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.current == nil || w.written == w.size {
+			if err := w.nextVolume(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if remaining := w.size - w.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.current.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// nextVolume closes the current part and starts the next one
+func (w *splitWriter) nextVolume() error {
+	if w.current != nil {
+		if err := w.current.Close(); err != nil {
+			return err
+		}
+	}
+	w.parts++
+	file, err := os.Create(volumePath(w.base, w.parts))
+	if err != nil {
+		return fmt.Errorf("error creating archive volume: %v", err)
+	}
+	w.current = file
+	w.written = 0
+	return nil
+}
+
+func (w *splitWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.current == nil {
+		// An empty stream still produces one (empty) volume
+		if err := w.nextVolume(); err != nil {
+			return err
+		}
+	}
+	return w.current.Close()
+}
+
+// archiveVolumes returns the files making up the archive at path: the file itself,
+// or the .001, .002, ... parts when path names a split archive or its first part
+func archiveVolumes(path string) []string {
+	base := path
+	if volumeSuffix.MatchString(path) {
+		base = path[:len(path)-4]
+	} else if _, err := os.Stat(path); err == nil {
+		return []string{path}
+	}
+
+	var volumes []string
+	for n := 1; ; n++ {
+		part := volumePath(base, n)
+		if _, err := os.Stat(part); err != nil {
+			break
+		}
+		volumes = append(volumes, part)
+	}
+	if len(volumes) == 0 {
+		return []string{path}
+	}
+	return volumes
+}
+
+// archiveBaseName strips the volume suffix from a split archive part
+func archiveBaseName(path string) string {
+	if volumeSuffix.MatchString(path) {
+		return path[:len(path)-4]
+	}
+	return path
+}
+
+// archiveExists reports whether an archive, or the first volume of a split one, exists at path
+func archiveExists(path string) bool {
+	for _, candidate := range []string{path, volumePath(path, 1)} {
+		if _, err := os.Stat(candidate); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// multiFileReader reads a sequence of files as one stream
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+// openVolumes opens all volumes of the archive at path as a single stream
+func openVolumes(path string) (*multiFileReader, error) {
+	m := &multiFileReader{}
+	var readers []io.Reader
+	for _, volume := range archiveVolumes(path) {
+		file, err := os.Open(volume)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("error opening archive file: %v", err)
+		}
+		m.files = append(m.files, file)
+		readers = append(readers, file)
+	}
+	m.Reader = io.MultiReader(readers...)
+	return m, nil
+}
+
+func (m *multiFileReader) Close() error {
+	for _, file := range m.files {
+		file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package repoark
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// runTimeouts are the limits set with --timeout and the per-phase timeouts, zero for none
+type runTimeouts struct {
+	Total  time.Duration // the whole run
+	Git    time.Duration // each git command
+	File   time.Duration // reading and storing one file
+	Upload time.Duration // the upload or transfer of migrate
+}
+
+// addTimeoutFlags registers the timeout flags shared by archive and migrate
+func addTimeoutFlags(fs *flag.FlagSet, timeouts *runTimeouts) {
+	fs.DurationVar(&timeouts.Total, "timeout", 0, "")
+	fs.DurationVar(&timeouts.Git, "git-timeout", 0, "")
+	fs.DurationVar(&timeouts.File, "file-timeout", 0, "")
+	fs.DurationVar(&timeouts.Upload, "upload-timeout", 0, "")
+}
+
+// runDeadline enforces the timeouts of a run. A read from a hung network mount cannot be
+// interrupted, so instead of waiting for it the run fails as soon as the context of the phase
+// it is stuck in expires, with an error naming that phase.
+type runDeadline struct {
+	ctx      context.Context // expires after the timeout of the whole run
+	timeouts runTimeouts
+	once     sync.Once
+	expired  chan error
+}
+
+// activeDeadline is the deadline of the running command. git is run from everywhere, so its
+// commands find the deadline here rather than in every caller's arguments. nil without timeouts.
+var activeDeadline *runDeadline
+
+// runWithTimeouts runs work under timeouts and returns its error, or the error of the first
+// phase that timed out. The stuck work is left behind, the command exits right after.
+func runWithTimeouts(timeouts runTimeouts, work func() error) error {
+	if timeouts == (runTimeouts{}) {
+		return work()
+	}
+	for _, limit := range []time.Duration{timeouts.Total, timeouts.Git, timeouts.File, timeouts.Upload} {
+		if limit < 0 {
+			return fmt.Errorf("invalid timeout %s", limit)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeouts.Total > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeouts.Total)
+	}
+	defer cancel()
+	d := &runDeadline{ctx: ctx, timeouts: timeouts, expired: make(chan error, 1)}
+	stop := context.AfterFunc(ctx, func() {
+		d.expire(fmt.Errorf("the run timed out after %s (--timeout)", timeouts.Total))
+	})
+	defer stop()
+	activeDeadline = d
+	defer func() { activeDeadline = nil }()
+
+	done := make(chan error, 1)
+	go func() { done <- work() }()
+	select {
+	case err := <-done:
+		return err
+	case err := <-d.expired:
+		return err
+	}
+}
+
+// expire ends the run with err, only the first phase to time out is reported
+func (d *runDeadline) expire(err error) {
+	d.once.Do(func() { d.expired <- err })
+}
+
+// phase starts the phase called name, limited to limit as set with flag, and returns the context
+// it runs under and the function ending it. Without a deadline the phase is unlimited.
+func (d *runDeadline) phase(name string, limit time.Duration, flag string) (context.Context, func()) {
+	if d == nil {
+		return context.Background(), func() {}
+	}
+	if limit <= 0 {
+		return d.ctx, func() {}
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, limit)
+	stop := context.AfterFunc(ctx, func() {
+		// The timeout of the whole run is reported on its own
+		if d.ctx.Err() == nil {
+			d.expire(fmt.Errorf("%s timed out after %s (%s)", name, limit, flag))
+		}
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// gitPhase starts the phase running git with args
+func (d *runDeadline) gitPhase(args []string) (context.Context, func()) {
+	if d == nil {
+		return context.Background(), func() {}
+	}
+	return d.phase("git "+gitSubcommand(args), d.timeouts.Git, "--git-timeout")
+}
+
+// hgPhase starts the phase running hg with args, limited like a git command
+func (d *runDeadline) hgPhase(args []string) (context.Context, func()) {
+	if d == nil {
+		return context.Background(), func() {}
+	}
+	return d.phase("hg "+args[0], d.timeouts.Git, "--git-timeout")
+}
+
+// filePhase starts the phase reading and storing the file at path
+func (d *runDeadline) filePhase(path string) func() {
+	if d == nil {
+		return func() {}
+	}
+	_, done := d.phase("reading "+path, d.timeouts.File, "--file-timeout")
+	return done
+}
+
+// uploadPhase starts the phase sending the archive to destination
+func (d *runDeadline) uploadPhase(destination string) (context.Context, func()) {
+	if d == nil {
+		return context.Background(), func() {}
+	}
+	return d.phase("uploading to "+destination, d.timeouts.Upload, "--upload-timeout")
+}
+
+// sshPhase starts the phase running the command called name on host, limited like the transfer
+func (d *runDeadline) sshPhase(host, name string) (context.Context, func()) {
+	if d == nil {
+		return context.Background(), func() {}
+	}
+	return d.phase(name+" on "+host, d.timeouts.Upload, "--upload-timeout")
+}
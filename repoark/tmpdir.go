@@ -0,0 +1,38 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+)
+
+// stagingDir creates a temporary directory named after pattern for about need bytes of data
+// written while a command runs: in tmpDir, set with --tmpdir, or the system's temp directory.
+// A small /tmp on tmpfs cannot hold the archive of a large repository, so when that filesystem
+// has less than need free the directory is created in fallback instead, on the filesystem the
+// data comes from. Without room in either the command fails before writing anything.
+func stagingDir(tmpDir, pattern string, need int64, fallback string, logger Logger) (string, error) {
+	base := tmpDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	free, known := freeSpace(base)
+	if !known || free >= need {
+		dir, err := os.MkdirTemp(base, pattern)
+		if err != nil {
+			return "", fmt.Errorf("error creating temporary directory: %v", err)
+		}
+		return dir, nil
+	}
+	if fallback != "" {
+		if fallbackFree, known := freeSpace(fallback); !known || fallbackFree >= need {
+			logger.Warnf("%s has %s free but about %s is needed, using %s instead", base, formatSize(free), formatSize(need), fallback)
+			dir, err := os.MkdirTemp(fallback, pattern)
+			if err != nil {
+				return "", fmt.Errorf("error creating temporary directory: %v", err)
+			}
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("%s has %s free but about %s of temporary data is needed, use --tmpdir to choose a larger filesystem",
+		base, formatSize(free), formatSize(need))
+}
@@ -0,0 +1,133 @@
+package repoark
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashDir is the folder (or key prefix) soft-deleted archives are moved to, next to the original.
+// Each deletion goes to trashDir/<unix seconds>/<name>, so the deletion time survives on
+// backends that cannot store it as metadata.
+const trashDir = ".repoark-trash"
+
+// trashKey returns where key is moved when it is soft-deleted at t
+func trashKey(key string, t time.Time) string {
+	dir, name := path.Split(filepath.ToSlash(key))
+	return filepath.FromSlash(dir + trashDir + "/" + strconv.FormatInt(t.Unix(), 10) + "/" + name)
+}
+
+// parseTrashKey returns the original key and deletion time of a trash entry
+func parseTrashKey(key string) (string, time.Time, bool) {
+	slashKey := filepath.ToSlash(key)
+	marker := trashDir + "/"
+	i := strings.LastIndex(slashKey, marker)
+	if i < 0 || (i > 0 && slashKey[i-1] != '/') {
+		return "", time.Time{}, false
+	}
+	stamp, name, found := strings.Cut(slashKey[i+len(marker):], "/")
+	seconds, err := strconv.ParseInt(stamp, 10, 64)
+	if !found || err != nil || strings.Contains(name, "/") {
+		return "", time.Time{}, false
+	}
+	return filepath.FromSlash(slashKey[:i] + name), time.Unix(seconds, 0), true
+}
+
+// trashPrefix returns the listing prefix of the trash folder next to key
+func trashPrefix(key string) string {
+	dir, _ := path.Split(filepath.ToSlash(key))
+	return filepath.FromSlash(dir + trashDir + "/")
+}
+
+// listTrash returns the trash entries stored next to key
+func listTrash(backend remoteBackend, key string) ([]remoteObject, error) {
+	prefix := trashPrefix(key)
+	if _, ok := backend.(fileBackend); ok {
+		// The directory backend lists a single folder, so look into the per-deletion folders here
+		matches, _ := filepath.Glob(filepath.Join(prefix, "*", "*"))
+		var objects []remoteObject
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				objects = append(objects, remoteObject{Key: match, Size: info.Size(), ModTime: info.ModTime()})
+			}
+		}
+		return objects, nil
+	}
+	return backend.List(prefix)
+}
+
+// softDeleteRemote moves the archive at rawURL to the trash and purges trash entries older than window
+func softDeleteRemote(rawURL string, window time.Duration, permanent bool) error {
+	backend, key, err := openRemote(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if permanent {
+		if err := backend.Delete(key); err != nil {
+			return fmt.Errorf("error deleting %s: %v", rawURL, err)
+		}
+		fmt.Printf("deleted %s\n", key)
+	} else {
+		dst := trashKey(key, time.Now())
+		if err := backend.Move(key, dst); err != nil {
+			return fmt.Errorf("error moving %s to trash: %v", rawURL, err)
+		}
+		fmt.Printf("moved %s to %s, restore it with: repoark undelete %s\n", key, dst, rawURL)
+	}
+
+	trashed, err := listTrash(backend, key)
+	if err != nil {
+		return fmt.Errorf("error listing trash: %v", err)
+	}
+	for _, object := range trashed {
+		if _, deleted, ok := parseTrashKey(object.Key); ok && time.Since(deleted) > window {
+			if err := backend.Delete(object.Key); err != nil {
+				return fmt.Errorf("error purging %s: %v", object.Key, err)
+			}
+			fmt.Printf("purged %s\n", object.Key)
+		}
+	}
+	return nil
+}
+
+// undeleteRemote moves the most recently soft-deleted copy of the archive at rawURL back in place
+func undeleteRemote(rawURL string) error {
+	backend, key, err := openRemote(rawURL)
+	if err != nil {
+		return err
+	}
+
+	trashed, err := listTrash(backend, key)
+	if err != nil {
+		return fmt.Errorf("error listing trash: %v", err)
+	}
+	var candidates []remoteObject
+	for _, object := range trashed {
+		if original, _, ok := parseTrashKey(object.Key); ok && original == key {
+			candidates = append(candidates, object)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no deleted copy of %s found in trash", rawURL)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		_, ti, _ := parseTrashKey(candidates[i].Key)
+		_, tj, _ := parseTrashKey(candidates[j].Key)
+		return ti.After(tj)
+	})
+
+	if existing, err := backend.ReadRange(key, 0, 1); err == nil && len(existing) > 0 {
+		return fmt.Errorf("%s exists, remove it before undeleting", rawURL)
+	}
+	if err := backend.Move(candidates[0].Key, key); err != nil {
+		return fmt.Errorf("error restoring %s: %v", rawURL, err)
+	}
+	fmt.Printf("restored %s from %s\n", key, candidates[0].Key)
+	return nil
+}
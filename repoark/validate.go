@@ -0,0 +1,288 @@
+package repoark
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// archiveValidator checks a finished archive. A failing validator fails the run before the
+// archive is signed, registered in the catalog and relied upon to replace older backups.
+type archiveValidator interface {
+	// Name is the --validate value selecting the validator
+	Name() string
+	// Validate returns why the archive cannot be relied upon, nil when it can
+	Validate(target validationTarget) error
+}
+
+// validationTarget is the finished archive and what writing it recorded
+type validationTarget struct {
+	Path     string    // archive file, the base name of split volumes
+	Format   string    // tar or zip
+	Manifest *manifest // files written with their checksums
+	Opts     ArchiveOptions
+}
+
+// archiveValidators are the built-in validators by --validate name, in the order they run
+var archiveValidators = []archiveValidator{
+	structureValidator{},
+	manifestValidator{},
+	restoreValidator{},
+}
+
+// parseValidators turns the comma separated --validate value into validators, all selects
+// every built-in one
+func parseValidators(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			for _, v := range archiveValidators {
+				names = append(names, v.Name())
+			}
+			continue
+		}
+		known := false
+		for _, v := range archiveValidators {
+			known = known || v.Name() == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown validator %q, expected structure, manifest, restore or all", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// validateArchive runs the selected built-in validators and the --validate-cmd commands
+// against the finished archive and returns the first failure
+func validateArchive(target validationTarget) error {
+	opts := target.Opts
+	selected := make(map[string]bool)
+	for _, name := range opts.Validators {
+		selected[name] = true
+	}
+	var validators []archiveValidator
+	for _, v := range archiveValidators {
+		if selected[v.Name()] {
+			validators = append(validators, v)
+		}
+	}
+	// Reading the archive back needs the means to decode it
+	skip := ""
+	switch {
+	case len(opts.EncryptRecipients) > 0 || opts.Password:
+		skip = "encrypted archives"
+	case opts.CompressCmd != "":
+		skip = "archives written with --compress-cmd"
+	case target.Format == "zip" && opts.SplitSize > 0:
+		skip = "split zip archives"
+	}
+	if len(validators) > 0 && skip != "" {
+		opts.Logger.Warnf("the built-in validators cannot read %s, skipping %s", skip, strings.Join(opts.Validators, ", "))
+		validators = nil
+	}
+	if target.Format == "zip" && selected["restore"] {
+		opts.Logger.Warnf("restore reads tar archives only, skipping the restore validation")
+		validators = slices.DeleteFunc(validators, func(v archiveValidator) bool { return v.Name() == "restore" })
+	}
+	for _, command := range opts.ValidateCmds {
+		validators = append(validators, commandValidator{command: command})
+	}
+	for _, v := range validators {
+		if err := v.Validate(target); err != nil {
+			return &exitError{Code: exitCorrupt, Err: fmt.Errorf("archive %s failed the %s validation: %v", target.Path, v.Name(), err)}
+		}
+		opts.Logger.Infof("Validated %s: %s", target.Path, v.Name())
+	}
+	return nil
+}
+
+// readBackArchive reads every entry of the finished archive to its end, decompressing the whole
+// stream, and calls visit with each regular file
+func readBackArchive(target validationTarget, visit func(name string, content io.Reader) error) error {
+	if target.Format == "zip" {
+		zr, err := zip.OpenReader(target.Path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			content, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.Name, err)
+			}
+			// The zip reader checks the CRC once the content is read to its end
+			if f.Mode().IsRegular() {
+				err = visit(f.Name, content)
+			}
+			if err == nil {
+				_, err = io.Copy(io.Discard, content)
+			}
+			content.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.Name, err)
+			}
+		}
+		return nil
+	}
+	ar, err := openArchive(target.Path, "")
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if err := visit(header.Name, ar); err != nil {
+				return fmt.Errorf("%s: %v", header.Name, err)
+			}
+		}
+		// The rest of the entry is read by Next, where damage shows as an error
+	}
+}
+
+// structureValidator reads the archive back from start to end: the compressed stream, every
+// entry header and every file
+type structureValidator struct{}
+
+func (structureValidator) Name() string { return "structure" }
+
+func (structureValidator) Validate(target validationTarget) error {
+	return readBackArchive(target, func(string, io.Reader) error { return nil })
+}
+
+// manifestValidator checks that the archive holds exactly the files of the manifest, with
+// their checksums
+type manifestValidator struct{}
+
+func (manifestValidator) Name() string { return "manifest" }
+
+func (manifestValidator) Validate(target validationTarget) error {
+	if target.Manifest == nil {
+		return nil
+	}
+	expected := make(map[string]string, len(target.Manifest.Entries))
+	for _, entry := range target.Manifest.Entries {
+		expected[entry.Path] = entry.SHA256
+	}
+	var differs, extra []string
+	hasManifest := false
+	err := readBackArchive(target, func(name string, content io.Reader) error {
+		if strings.TrimPrefix(name, "./") == archiveManifestName {
+			hasManifest = true
+		}
+		if isMetaEntry(name) {
+			return nil
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, content); err != nil {
+			return err
+		}
+		sum, ok := expected[name]
+		switch {
+		case !ok:
+			extra = append(extra, name)
+		case sum != hex.EncodeToString(hash.Sum(nil)):
+			differs = append(differs, name)
+		}
+		delete(expected, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	missing := sortedKeys(expected)
+	sort.Strings(differs)
+	sort.Strings(extra)
+	var problems []string
+	for _, list := range []struct {
+		names []string
+		what  string
+	}{{differs, "differ from the manifest"}, {missing, "are in the manifest but not in the archive"}, {extra, "are not in the manifest"}} {
+		if len(list.names) > 0 {
+			problems = append(problems, fmt.Sprintf("%d files %s (e.g. %s)", len(list.names), list.what, list.names[0]))
+		}
+	}
+	if !hasManifest {
+		problems = append(problems, "the archive has no "+archiveManifestName)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// restoreValidator restores the archive into a scratch directory, comparing every file with
+// the archive, and checks that git can read the restored repository
+type restoreValidator struct{}
+
+func (restoreValidator) Name() string { return "restore" }
+
+func (restoreValidator) Validate(target validationTarget) error {
+	var need int64
+	if target.Manifest != nil {
+		for _, entry := range target.Manifest.Entries {
+			need += entry.Size
+		}
+	}
+	dir, err := stagingDir(target.Opts.TmpDir, "repoark-validate-", need, "", target.Opts.Logger)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	opts := RestoreOptions{Verify: true, Logger: discardLogger{}, Progress: discardProgress{}}
+	if err := restoreGitRepo(dir, target.Path, opts); err != nil {
+		return err
+	}
+	if _, ok := target.Opts.VCS.(gitVCS); ok && !target.Opts.NoGitDir {
+		if _, err := runGit(dir, nil, "status", "--porcelain"); err != nil {
+			return fmt.Errorf("git cannot read the restored repository: %v", err)
+		}
+	}
+	return nil
+}
+
+// commandValidator runs a command given with --validate-cmd with the archive path as its last
+// argument, a non-zero exit fails the validation
+type commandValidator struct {
+	command string
+}
+
+func (v commandValidator) Name() string { return v.command }
+
+func (v commandValidator) Validate(target validationTarget) error {
+	args, err := splitCommandLine(v.command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty validator command")
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(args[0], append(args[1:], target.Path)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if text := strings.TrimSpace(output.String()); text != "" {
+			return fmt.Errorf("%v: %s", err, text)
+		}
+		return err
+	}
+	return nil
+}
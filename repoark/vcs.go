@@ -0,0 +1,168 @@
+package repoark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// repoVCS is the version control system of a repository, it decides which files an archive contains
+type repoVCS interface {
+	// Name is the name shown to users
+	Name() string
+	// MetadataDir is the directory, relative to the work tree, holding the repository itself
+	MetadataDir() string
+	// ListTracked lists the tracked files of the work tree at dir, relative to dir
+	ListTracked(dir string) ([]string, error)
+	// ListUntracked lists the untracked files the VCS does not ignore, relative to dir
+	ListUntracked(dir string) ([]string, error)
+	// ListIgnored lists the untracked files the VCS ignores, relative to dir
+	ListIgnored(dir string) ([]string, error)
+	// IsVolatile reports whether a file of the metadata directory belongs to a running command
+	IsVolatile(name string) bool
+	// Head returns the current branch and the abbreviated id of the checked out commit
+	Head(dir string) (branch, shortID string, err error)
+	// Describe fills in the VCS state recorded in the metadata entry of an archive
+	Describe(dir string, meta *repoMetadata) error
+}
+
+// detectVCS returns the version control system of the repository at dir
+func detectVCS(dir string) (repoVCS, error) {
+	if _, err := runGit(dir, nil, "rev-parse", "--is-inside-work-tree"); err == nil {
+		return gitVCS{}, nil
+	}
+	if info, err := os.Stat(filepath.Join(dir, ".hg")); err == nil && info.IsDir() {
+		return hgVCS{}, nil
+	}
+	return nil, &exitError{Code: exitNotRepository, Err: fmt.Errorf("%s is not a valid Git or Mercurial repository", dir)}
+}
+
+// gitVCS lists files with git ls-files, honouring .repoarkignore
+type gitVCS struct{}
+
+func (gitVCS) Name() string { return "git" }
+
+func (gitVCS) MetadataDir() string { return ".git" }
+
+func (gitVCS) ListTracked(dir string) ([]string, error) {
+	return listRepoFiles(dir, "--cached")
+}
+
+func (gitVCS) ListUntracked(dir string) ([]string, error) {
+	return listRepoFiles(dir, "--others", "--exclude-standard")
+}
+
+func (gitVCS) ListIgnored(dir string) ([]string, error) {
+	return listIgnoredFiles(dir)
+}
+
+// IsVolatile reports lock files, restoring one would block git in the restored repository
+func (gitVCS) IsVolatile(name string) bool {
+	return strings.HasSuffix(name, ".lock")
+}
+
+// Head reports "detached" as the branch of a detached HEAD
+func (gitVCS) Head(dir string) (string, string, error) {
+	branch := "detached"
+	if output, err := runGit(dir, nil, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		branch = strings.TrimSpace(string(output))
+	}
+	output, err := runGit(dir, nil, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", "", fmt.Errorf("error reading the current commit of %s, it may have no commits yet: %v", dir, err)
+	}
+	return branch, strings.TrimSpace(string(output)), nil
+}
+
+// hgVCS lists files of Mercurial repositories with hg files and hg status
+type hgVCS struct{}
+
+func (hgVCS) Name() string { return "Mercurial" }
+
+func (hgVCS) MetadataDir() string { return ".hg" }
+
+func (hgVCS) ListTracked(dir string) ([]string, error) {
+	return runHg(dir, "files", "--print0")
+}
+
+func (hgVCS) ListUntracked(dir string) ([]string, error) {
+	return runHg(dir, "status", "--unknown", "--no-status", "--print0")
+}
+
+func (hgVCS) ListIgnored(dir string) ([]string, error) {
+	return runHg(dir, "status", "--ignored", "--no-status", "--print0")
+}
+
+// IsVolatile reports the locks and transaction journals of a running hg command
+func (hgVCS) IsVolatile(name string) bool {
+	return name == "lock" || name == "wlock" || strings.HasPrefix(name, "journal")
+}
+
+func (hgVCS) Head(dir string) (string, string, error) {
+	output, err := runHgOutput(dir, "log", "-r", ".", "-T", "{branch}\\n{node|short}")
+	if err != nil {
+		return "", "", fmt.Errorf("error reading the current revision of %s: %v", dir, err)
+	}
+	branch, shortID, _ := strings.Cut(string(output), "\n")
+	return branch, strings.TrimSpace(shortID), nil
+}
+
+// runHg runs an hg command listing files in dir and returns the NUL separated paths it prints
+func runHg(dir string, args ...string) ([]string, error) {
+	output, err := runHgOutput(dir, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in %s: %v", dir, err)
+	}
+	var entries []string
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if entry != "" {
+			entries = append(entries, filepath.ToSlash(entry))
+		}
+	}
+	return entries, nil
+}
+
+// listUntrackedForCleanup lists the untracked files of a restored repository, which restore removes.
+// .repoarkignore does not apply, files it excluded from the archive are removed as well.
+func listUntrackedForCleanup(repoPath string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); os.IsNotExist(err) {
+		if info, err := os.Stat(filepath.Join(repoPath, ".hg")); err == nil && info.IsDir() {
+			return hgVCS{}.ListUntracked(repoPath)
+		}
+	}
+	// -z keeps non-ASCII names, such as mangled ones, unquoted
+	output, err := runGit(repoPath, nil, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("error listing files in %s: %v", repoPath, err)
+	}
+	return splitNul(output), nil
+}
+
+// hgCommand returns the command running hg with args in dir, killed when ctx expires. HGPLAIN
+// keeps user configuration such as aliases and color out of the output.
+func hgCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "hg", append([]string{"--cwd", dir}, args...)...)
+	cmd.Env = commandEnv("HGPLAIN=1")
+	return cmd
+}
+
+// runHgOutput runs hg with args in dir and returns its output, with hg's message on failure
+func runHgOutput(dir string, args ...string) ([]byte, error) {
+	ctx, done := activeDeadline.hgPhase(args)
+	defer done()
+	cmd := hgCommand(ctx, dir, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return output, fmt.Errorf("%v: %s", err, message)
+		}
+		return output, err
+	}
+	return output, nil
+}
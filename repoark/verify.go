@@ -0,0 +1,109 @@
+package repoark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// verifyArchive compares an archive with a working tree and reports files that differ,
+// are missing from the tree or are extra in it. A clean result is recorded in the catalog.
+func verifyArchive(archivePath, repoPath, catalogFile string, out io.Writer) error {
+	repoPath, err := canonicalRoot(repoPath, false)
+	if err != nil {
+		return fmt.Errorf("error accessing path: %v", err)
+	}
+	scanned, err := scanArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	sources, err := collectSources([]RootDir{{Dir: repoPath}}, ArchiveOptions{})
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string]string, len(sources))
+	for _, source := range sources {
+		// Only file contents are compared, symlinks are archived as links
+		if source.Symlink == "" {
+			local[filepath.ToSlash(source.Name)] = source.Path
+		}
+	}
+
+	var differs, missing, extra []string
+	matched := 0
+	for _, entry := range scanned.Manifest.Entries {
+		name := strings.TrimPrefix(entry.Path, scanned.RootPrefix)
+		sourcePath, ok := local[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		delete(local, name)
+
+		info, err := os.Stat(sourcePath)
+		if err != nil {
+			missing = append(missing, name)
+			continue
+		}
+		if info.Size() != entry.Size {
+			differs = append(differs, name)
+			continue
+		}
+		sum, err := fileSHA256(sourcePath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", sourcePath, err)
+		}
+		if sum != entry.SHA256 {
+			differs = append(differs, name)
+			continue
+		}
+		matched++
+	}
+	for name := range local {
+		extra = append(extra, name)
+	}
+
+	for _, group := range []struct {
+		label string
+		names []string
+	}{{"differs", differs}, {"missing", missing}, {"extra", extra}} {
+		sort.Strings(group.names)
+		for _, name := range group.names {
+			fmt.Fprintf(out, "%-8s %s\n", group.label, name)
+		}
+	}
+	fmt.Fprintf(out, "%d files match, %d differ, %d missing, %d extra\n", matched, len(differs), len(missing), len(extra))
+
+	if len(differs)+len(missing)+len(extra) > 0 {
+		return &exitError{Code: exitMismatch, Err: fmt.Errorf("%s does not match %s", repoPath, archivePath)}
+	}
+	fmt.Fprintf(out, "Archive %s is current\n", archivePath)
+
+	if catalogFile != "" {
+		if err := markVerified(catalogFile, archivePath, time.Now().UTC()); err != nil {
+			return fmt.Errorf("verified but not recorded in catalog: %v", err)
+		}
+	}
+	return nil
+}
+
+// markVerified records a successful verification on the catalog records of archivePath
+func markVerified(catalogFile, archivePath string, now time.Time) error {
+	absPath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return err
+	}
+	return updateCatalog(catalogFile, func(c *catalog) error {
+		for i := range c.Records {
+			if c.Records[i].Destination == absPath {
+				c.Records[i].Verified = now
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package repoark
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseProbability reads a --verify-existing value, a fraction such as 0.1 or a percentage such as 10%
+func parseProbability(s string) (float64, error) {
+	value, scale := s, 1.0
+	if strings.HasSuffix(s, "%") {
+		value, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil || p/scale <= 0 || p/scale > 1 {
+		return 0, fmt.Errorf("invalid probability %q, expected a fraction in (0, 1] or a percentage", s)
+	}
+	return p / scale, nil
+}
+
+// shouldCheckExisting decides whether an up-to-date file is compared with the archive
+func shouldCheckExisting(opts RestoreOptions) bool {
+	return opts.VerifyExisting >= 1 || (opts.VerifyExisting > 0 && rand.Float64() < opts.VerifyExisting)
+}
+
+// checkExistingFile compares a file restore would skip with the archived content of the current
+// entry. When they differ, the file is rewritten from the first differing byte, so a corrupted
+// block costs no more than the rest of the file.
+func checkExistingFile(targetPath string, header *tar.Header, tarReader *archiveReader, opts RestoreOptions, stats *restoreStats) error {
+	file, err := os.OpenFile(targetPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error checking %s: %v", targetPath, err)
+	}
+	defer file.Close()
+
+	archived := make([]byte, 32*1024)
+	local := make([]byte, len(archived))
+	var offset int64
+	rewriteFrom := int64(-1)
+	for rewriteFrom < 0 {
+		n, err := io.ReadFull(tarReader, archived)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return err
+			}
+			break
+		}
+		m, _ := io.ReadFull(file, local[:n])
+		if i := mismatchIndex(archived[:n], local[:m]); i >= 0 {
+			rewriteFrom = offset + int64(i)
+			if _, err := file.WriteAt(archived[i:n], offset+int64(i)); err != nil {
+				return fmt.Errorf("error rewriting %s: %v", targetPath, err)
+			}
+			if _, err := file.Seek(offset+int64(n), io.SeekStart); err != nil {
+				return fmt.Errorf("error rewriting %s: %v", targetPath, err)
+			}
+			if _, err := io.Copy(file, tarReader); err != nil {
+				var archiveErr *archiveError
+				if errors.As(err, &archiveErr) {
+					return err
+				}
+				return fmt.Errorf("error rewriting %s: %v", targetPath, err)
+			}
+		}
+		offset += int64(n)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if info, err := file.Stat(); err == nil && info.Size() != header.Size && rewriteFrom < 0 {
+		rewriteFrom = min(info.Size(), header.Size)
+	}
+	stats.Checked++
+	if rewriteFrom < 0 {
+		opts.Progress.Entry("skip", targetPath, header.Size)
+		stats.Skipped++
+		return nil
+	}
+
+	if err := file.Truncate(header.Size); err != nil {
+		return fmt.Errorf("error rewriting %s: %v", targetPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error rewriting %s: %v", targetPath, err)
+	}
+	opts.Progress.Entry("restore", targetPath, header.Size)
+	opts.Logger.Warnf("%s differed from the archive although its modification time matches, rewrote it", targetPath)
+	if err := os.Chmod(targetPath, header.FileInfo().Mode()); err != nil {
+		return fmt.Errorf("error setting file permission: %v", err)
+	}
+	if err := os.Chtimes(targetPath, header.ModTime, header.ModTime); err != nil {
+		return fmt.Errorf("error setting file modification time: %v", err)
+	}
+	stats.Repaired++
+	stats.BytesWritten += header.Size - rewriteFrom
+	return nil
+}
+
+// mismatchIndex returns the first index where local differs from archived, -1 when it does not
+func mismatchIndex(archived, local []byte) int {
+	if bytes.Equal(archived, local) {
+		return -1
+	}
+	for i := range archived {
+		if i >= len(local) || archived[i] != local[i] {
+			return i
+		}
+	}
+	return -1
+}
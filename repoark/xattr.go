@@ -0,0 +1,55 @@
+package repoark
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// paxXattrPrefix starts the PAX records of extended attributes, as GNU tar, bsdtar and star write them
+const paxXattrPrefix = "SCHILY.xattr."
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// recordXattrs stores the extended attributes of sourcePath in header with --xattrs. POSIX ACLs
+// are included, Linux keeps them in the system.posix_acl_access and system.posix_acl_default
+// attributes.
+func recordXattrs(header *tar.Header, sourcePath string) error {
+	xattrs, err := readXattrs(sourcePath)
+	if err != nil {
+		return &unreadableError{Err: fmt.Errorf("%s: %v", sourcePath, err)}
+	}
+	for name, value := range xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords[paxXattrPrefix+name] = string(value)
+	}
+	return nil
+}
+
+// restoreXattrs applies the extended attributes recorded in header to targetPath with --xattrs.
+// Attributes the filesystem or the user's privileges do not allow, such as SELinux labels
+// without root or macOS attributes on Linux, are counted in stats rather than failing the file.
+func restoreXattrs(targetPath string, header *tar.Header, opts RestoreOptions, stats *restoreStats) {
+	if !opts.Xattrs {
+		return
+	}
+	var names []string
+	for record := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(record, paxXattrPrefix); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeXattr(targetPath, name, []byte(header.PAXRecords[paxXattrPrefix+name])); err != nil {
+			if stats.XattrsLost == 0 {
+				stats.xattrExample = fmt.Sprintf("%s of %s: %v", name, header.Name, err)
+			}
+			stats.XattrsLost++
+		}
+	}
+}
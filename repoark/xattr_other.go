@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package repoark
+
+// listXattrs is not available on this platform
+func listXattrs(path string) []string {
+	return nil
+}
+
+// readXattrs is not available on this platform, files have no extended attributes
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattr is not available on this platform
+func writeXattr(path, name string, value []byte) error {
+	return errXattrUnsupported
+}
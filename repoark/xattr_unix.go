@@ -0,0 +1,63 @@
+//go:build linux || darwin
+
+package repoark
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattrs returns the names of the extended attributes of path
+func listXattrs(path string) []string {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+	return strings.FieldsFunc(string(buf[:size]), func(r rune) bool { return r == 0 })
+}
+
+// readXattrs returns the extended attributes of path, of the symlink itself rather than its target
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil, ignoreUnsupportedXattr(err)
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.FieldsFunc(string(buf[:size]), func(r rune) bool { return r == 0 }) {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading extended attribute %s: %v", name, err)
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("error reading extended attribute %s: %v", name, err)
+		}
+		xattrs[name] = value[:size]
+	}
+	return xattrs, nil
+}
+
+// writeXattr sets the extended attribute name of path, of the symlink itself rather than its target
+func writeXattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
+
+// ignoreUnsupportedXattr treats a filesystem without extended attributes as a file without any
+func ignoreUnsupportedXattr(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
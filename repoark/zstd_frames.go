@@ -0,0 +1,321 @@
+package repoark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdFrameSize is the amount of tar stream each zstd frame holds. Frames are compressed
+// independently, so they can be compressed and decompressed on separate goroutines.
+const zstdFrameSize = 4 << 20
+
+// maxParallelFrameSize is the largest frame content size the parallel reader decodes in memory,
+// larger frames (from other zstd tools) are decoded as a stream
+const maxParallelFrameSize = 64 << 20
+
+const (
+	zstdFrameMagic          = 0xFD2FB528
+	zstdSkippableMagicMask  = 0xFFFFFFF0
+	zstdSkippableMagicValue = 0x184D2A50
+)
+
+// zstdFrameWriter cuts its input into zstdFrameSize pieces and compresses each one as an
+// independent zstd frame on up to jobs goroutines. Frames are written in input order, so the
+// output is one valid zstd stream and does not depend on the number of jobs.
+type zstdFrameWriter struct {
+	w       io.Writer
+	encoder *zstd.Encoder
+	jobs    int
+	buf     []byte
+	pending []chan []byte
+	err     error
+}
+
+func newZstdFrameWriter(w io.Writer, level, jobs int) (*zstdFrameWriter, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	encoderOpts := []zstd.EOption{zstd.WithEncoderConcurrency(jobs)}
+	if level > 0 {
+		encoderOpts = append(encoderOpts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	encoder, err := zstd.NewWriter(nil, encoderOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdFrameWriter{w: w, encoder: encoder, jobs: jobs, buf: make([]byte, 0, zstdFrameSize)}, nil
+}
+
+func (z *zstdFrameWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	written := len(p)
+	for len(p) > 0 {
+		n := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+n]
+		p = p[n:]
+		if len(z.buf) == cap(z.buf) {
+			if err := z.flushFrame(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushFrame hands the buffered input to a compression goroutine, waiting for the oldest
+// frame first when jobs frames are already in flight
+func (z *zstdFrameWriter) flushFrame() error {
+	for len(z.pending) >= z.jobs {
+		if err := z.writeOldest(); err != nil {
+			return err
+		}
+	}
+	frame := z.buf
+	z.buf = make([]byte, 0, zstdFrameSize)
+	result := make(chan []byte, 1)
+	go func() {
+		result <- z.encoder.EncodeAll(frame, nil)
+	}()
+	z.pending = append(z.pending, result)
+	return nil
+}
+
+func (z *zstdFrameWriter) writeOldest() error {
+	compressed := <-z.pending[0]
+	z.pending = z.pending[1:]
+	if _, err := z.w.Write(compressed); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// Close compresses the remaining input and writes all frames still in flight
+func (z *zstdFrameWriter) Close() error {
+	if z.err == nil && len(z.buf) > 0 {
+		z.flushFrame()
+	}
+	for len(z.pending) > 0 {
+		if z.err != nil {
+			<-z.pending[0]
+			z.pending = z.pending[1:]
+			continue
+		}
+		z.writeOldest()
+	}
+	z.encoder.Close()
+	return z.err
+}
+
+// zstdFrameReader decompresses the frames of a zstd stream on up to jobs goroutines. It finds
+// frame boundaries from the frame and block headers without decompressing, and returns the
+// output in stream order. When a frame does not record a small enough content size it falls
+// back to the streaming decoder for the rest of the input.
+type zstdFrameReader struct {
+	src     *bufio.Reader
+	decoder *zstd.Decoder
+	jobs    int
+	pending []chan frameResult
+	cur     []byte
+	err     error
+	rest    *zstd.Decoder
+}
+
+type frameResult struct {
+	data []byte
+	err  error
+}
+
+func newZstdFrameReader(r io.Reader, jobs int) (*zstdFrameReader, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(jobs))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdFrameReader{src: bufio.NewReaderSize(r, 1<<16), decoder: decoder, jobs: jobs}, nil
+}
+
+func (z *zstdFrameReader) Read(p []byte) (int, error) {
+	for len(z.cur) == 0 {
+		if z.rest != nil {
+			return z.rest.Read(p)
+		}
+		for z.err == nil && len(z.pending) < z.jobs {
+			z.readFrame()
+		}
+		if len(z.pending) == 0 {
+			if z.err == errStreamFallback {
+				rest, err := zstd.NewReader(z.src)
+				if err != nil {
+					return 0, err
+				}
+				z.rest = rest
+				continue
+			}
+			return 0, z.err
+		}
+		result := <-z.pending[0]
+		z.pending = z.pending[1:]
+		if result.err != nil {
+			z.err = result.err
+			z.pending = nil
+			return 0, result.err
+		}
+		z.cur = result.data
+	}
+	n := copy(p, z.cur)
+	z.cur = z.cur[n:]
+	return n, nil
+}
+
+// errStreamFallback stops frame splitting, the rest of the input is decoded as a stream
+var errStreamFallback = errors.New("zstd frame too large to decode in parallel")
+
+// readFrame reads the next frame and starts decompressing it, skippable frames are dropped.
+// It sets z.err at the end of the input or when the frame should be decoded as a stream.
+func (z *zstdFrameReader) readFrame() {
+	for {
+		magic, err := z.src.Peek(4)
+		if len(magic) == 0 && err == io.EOF {
+			z.err = io.EOF
+			return
+		} else if err != nil {
+			z.err = fmt.Errorf("error reading zstd frame: %v", io.ErrUnexpectedEOF)
+			return
+		}
+		if binary.LittleEndian.Uint32(magic)&zstdSkippableMagicMask == zstdSkippableMagicValue {
+			header := make([]byte, 8)
+			if _, err := io.ReadFull(z.src, header); err != nil {
+				z.err = fmt.Errorf("error reading zstd frame: %v", err)
+				return
+			}
+			if _, err := z.src.Discard(int(binary.LittleEndian.Uint32(header[4:]))); err != nil {
+				z.err = fmt.Errorf("error reading zstd frame: %v", err)
+				return
+			}
+			continue
+		}
+		break
+	}
+
+	headerSize, contentSize, err := z.peekFrameHeader()
+	if err != nil {
+		z.err = err
+		return
+	}
+	if contentSize < 0 || contentSize > maxParallelFrameSize {
+		z.err = errStreamFallback
+		return
+	}
+	frame := make([]byte, headerSize)
+	if _, err := io.ReadFull(z.src, frame); err != nil {
+		z.err = fmt.Errorf("error reading zstd frame: %v", err)
+		return
+	}
+	checksum := frame[4]&0x04 != 0
+	for last := false; !last; {
+		blockHeader := make([]byte, 3)
+		if _, err := io.ReadFull(z.src, blockHeader); err != nil {
+			z.err = fmt.Errorf("error reading zstd frame: %v", err)
+			return
+		}
+		frame = append(frame, blockHeader...)
+		header := uint32(blockHeader[0]) | uint32(blockHeader[1])<<8 | uint32(blockHeader[2])<<16
+		last = header&1 != 0
+		size := int(header >> 3)
+		switch (header >> 1) & 3 {
+		case 1: // RLE block, the size is the repeat count of a single byte
+			size = 1
+		case 3:
+			z.err = fmt.Errorf("error reading zstd frame: reserved block type")
+			return
+		}
+		start := len(frame)
+		frame = append(frame, make([]byte, size)...)
+		if _, err := io.ReadFull(z.src, frame[start:]); err != nil {
+			z.err = fmt.Errorf("error reading zstd frame: %v", err)
+			return
+		}
+	}
+	if checksum {
+		start := len(frame)
+		frame = append(frame, 0, 0, 0, 0)
+		if _, err := io.ReadFull(z.src, frame[start:]); err != nil {
+			z.err = fmt.Errorf("error reading zstd frame: %v", err)
+			return
+		}
+	}
+
+	result := make(chan frameResult, 1)
+	go func() {
+		data, err := z.decoder.DecodeAll(frame, make([]byte, 0, contentSize))
+		result <- frameResult{data: data, err: err}
+	}()
+	z.pending = append(z.pending, result)
+}
+
+// peekFrameHeader parses the header of the frame at the read position without consuming it.
+// contentSize is -1 when the frame does not record its decompressed size.
+func (z *zstdFrameReader) peekFrameHeader() (headerSize int, contentSize int64, err error) {
+	head, _ := z.src.Peek(6)
+	if len(head) < 6 || binary.LittleEndian.Uint32(head) != zstdFrameMagic {
+		return 0, 0, fmt.Errorf("error reading zstd frame: invalid magic number")
+	}
+	descriptor := head[4]
+	singleSegment := descriptor&0x20 != 0
+	headerSize = 5
+	if !singleSegment {
+		headerSize++ // window descriptor
+	}
+	headerSize += []int{0, 1, 2, 4}[descriptor&3] // dictionary ID
+	fcsStart := headerSize
+	fcsSize := []int{0, 2, 4, 8}[descriptor>>6]
+	if fcsSize == 0 && singleSegment {
+		fcsSize = 1
+	}
+	headerSize += fcsSize
+
+	full, err := z.src.Peek(headerSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading zstd frame: %v", io.ErrUnexpectedEOF)
+	}
+	fcs := full[fcsStart:headerSize]
+	switch fcsSize {
+	case 0:
+		contentSize = -1
+	case 1:
+		contentSize = int64(fcs[0])
+	case 2:
+		contentSize = int64(binary.LittleEndian.Uint16(fcs)) + 256
+	case 4:
+		contentSize = int64(binary.LittleEndian.Uint32(fcs))
+	case 8:
+		contentSize = int64(binary.LittleEndian.Uint64(fcs))
+		if contentSize < 0 {
+			contentSize = maxParallelFrameSize + 1
+		}
+	}
+	return headerSize, contentSize, nil
+}
+
+// Close stops the decoders, frames still being decompressed are dropped
+func (z *zstdFrameReader) Close() error {
+	for _, result := range z.pending {
+		<-result
+	}
+	z.pending = nil
+	z.decoder.Close()
+	if z.rest != nil {
+		z.rest.Close()
+	}
+	return nil
+}